@@ -40,3 +40,18 @@ func (c *Client) UploadBlock(ctx context.Context, bareURL, token string, block r
 			Post(bareURL)
 	})
 }
+
+// RequestThumbnailUpload requests upload links for the thumbnails listed in the request.
+func (c *Client) RequestThumbnailUpload(ctx context.Context, req BlockUploadReq) ([]BlockUploadLink, error) {
+	var res struct {
+		ThumbnailLinks []BlockUploadLink
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).SetBody(req).Post("/drive/blocks")
+	}); err != nil {
+		return nil, err
+	}
+
+	return res.ThumbnailLinks, nil
+}
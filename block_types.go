@@ -20,7 +20,8 @@ type BlockUploadReq struct {
 	LinkID     string
 	RevisionID string
 
-	BlockList []BlockUploadInfo
+	BlockList     []BlockUploadInfo
+	ThumbnailList []ThumbnailUploadInfo `json:",omitempty"`
 }
 
 type BlockUploadInfo struct {
@@ -30,6 +31,12 @@ type BlockUploadInfo struct {
 	Hash         string
 }
 
+type ThumbnailUploadInfo struct {
+	Size         int64
+	EncSignature string
+	Hash         string
+}
+
 type BlockUploadLink struct {
 	Token   string
 	BareURL string
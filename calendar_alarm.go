@@ -0,0 +1,409 @@
+package proton
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gluon/async"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/emersion/go-ical"
+	"golang.org/x/exp/slices"
+)
+
+// calendarAlarmWindow is how far from the current time occurrences are looked for alarms.
+// Alarms triggered further than this from their occurrence are not fired.
+const calendarAlarmWindow = 30 * 24 * time.Hour
+
+// NewCalendarAlarmStream returns a stream of the alarms of the events of the user's calendars, each sent when it is due.
+// The calendars are read by the members of the user's addresses, whose keyrings are given in addrKRs keyed by address ID,
+// and their changes are polled every period, as well as the calendars the user gains or loses.
+// Calendars that can't be unlocked or read are skipped until the next poll. The time is given by the clock, which defaults to the system clock,
+// and the fired alarms are recorded in the store, which defaults to an in-memory store.
+// Alarms that were due before the stream is started are only sent if their occurrence hasn't ended yet.
+func (c *Client) NewCalendarAlarmStream(
+	ctx context.Context,
+	addrKRs map[string]*crypto.KeyRing,
+	period time.Duration,
+	clock CalendarAlarmClock,
+	store CalendarAlarmStore,
+) (<-chan CalendarAlarm, error) {
+	if period <= 0 {
+		return nil, fmt.Errorf("invalid poll period %v", period)
+	}
+
+	if clock == nil {
+		clock = systemCalendarAlarmClock{}
+	}
+
+	if store == nil {
+		store = NewCalendarAlarmMemoryStore()
+	}
+
+	followed, err := c.followCalendarAlarmCalendars(ctx, addrKRs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	alarmCh := make(chan CalendarAlarm)
+
+	go func() {
+		defer async.HandlePanic(c.m.panicHandler)
+
+		defer close(alarmCh)
+
+		startTime := clock.Now()
+		pollTime := startTime.Add(period)
+
+		for {
+			now := clock.Now()
+
+			if !now.Before(pollTime) {
+				if calendars, err := c.followCalendarAlarmCalendars(ctx, addrKRs, followed); err != nil {
+					log.WithError(err).Warn("Failed to update followed calendars")
+				} else {
+					followed = calendars
+				}
+
+				for _, alarmCal := range followed {
+					if err := alarmCal.poll(ctx, c); err != nil {
+						log.WithError(err).WithField("calendarID", alarmCal.calendarID).Warn("Failed to poll calendar events")
+					}
+				}
+
+				pollTime = now.Add(period)
+			}
+
+			next := pollTime
+
+			for _, alarmCal := range followed {
+				for _, alarm := range alarmCal.getAlarms(now) {
+					if alarm.Time.After(now) {
+						if alarm.Time.Before(next) {
+							next = alarm.Time
+						}
+
+						continue
+					}
+
+					// Alarms missed while not running are only relevant until the end of their occurrence.
+					if alarm.Time.Before(startTime) && !alarm.Occurrence.End.After(now) {
+						continue
+					}
+
+					if fired, err := store.IsFired(alarm.ID); err != nil || fired {
+						continue
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+
+					case alarmCh <- alarm:
+						if err := store.SetFired(alarm.ID); err != nil {
+							log.WithError(err).WithField("alarmID", alarm.ID).Warn("Failed to record fired calendar alarm")
+						}
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-clock.After(next.Sub(now)):
+				// ...
+			}
+		}
+	}()
+
+	return alarmCh, nil
+}
+
+// followCalendarAlarmCalendars returns the calendars of the user to follow, keeping those already followed
+// and loading the new ones. Calendars that can't be unlocked or read are logged and left out.
+func (c *Client) followCalendarAlarmCalendars(
+	ctx context.Context,
+	addrKRs map[string]*crypto.KeyRing,
+	followed []*calendarAlarmCalendar,
+) ([]*calendarAlarmCalendar, error) {
+	calendars, err := c.GetCalendars(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := c.GetAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var following []*calendarAlarmCalendar
+
+	for _, cal := range calendars {
+		if idx := xslices.IndexFunc(followed, func(alarmCal *calendarAlarmCalendar) bool {
+			return alarmCal.calendarID == cal.ID
+		}); idx >= 0 {
+			following = append(following, followed[idx])
+			continue
+		}
+
+		member, calKR, addrKR, err := c.unlockCalendar(ctx, cal.ID, addresses, addrKRs)
+		if err != nil {
+			log.WithError(err).WithField("calendarID", cal.ID).Warn("Failed to unlock calendar")
+			continue
+		}
+
+		alarmCal := &calendarAlarmCalendar{
+			calendarID: cal.ID,
+			memberID:   member.ID,
+			calKR:      calKR,
+			addrKR:     addrKR,
+		}
+
+		if err := alarmCal.load(ctx, c); err != nil {
+			log.WithError(err).WithField("calendarID", cal.ID).Warn("Failed to load calendar events")
+			continue
+		}
+
+		following = append(following, alarmCal)
+	}
+
+	return following, nil
+}
+
+// calendarAlarmCalendar is a calendar whose events are followed by the alarm stream.
+type calendarAlarmCalendar struct {
+	calendarID string
+	memberID   string
+	calKR      *crypto.KeyRing
+	addrKR     *crypto.KeyRing
+
+	lastEventID string
+	events      map[string]decodedCalendarEvent
+}
+
+// load reads all the events of the calendar.
+func (cal *calendarAlarmCalendar) load(ctx context.Context, c *Client) error {
+	lastEventID, err := c.GetLatestCalendarModelEventID(ctx, cal.calendarID)
+	if err != nil {
+		return err
+	}
+
+	events, err := c.GetAllCalendarEvents(ctx, cal.calendarID, nil)
+	if err != nil {
+		return err
+	}
+
+	authorKRs, err := c.GetCalendarAuthorKeyRings(ctx, events, cal.addrKR)
+	if err != nil {
+		return err
+	}
+
+	// Events that can't be decrypted are logged and left out of the alarms.
+	decoded, _ := decodeCalendarEvents(events, cal.memberID, cal.calKR, cal.addrKR, authorKRs)
+
+	cal.lastEventID = lastEventID
+	cal.events = make(map[string]decodedCalendarEvent, len(decoded))
+
+	for _, event := range decoded {
+		cal.events[event.eventID] = event
+	}
+
+	return nil
+}
+
+// poll applies the changes to the events of the calendar since it was last read.
+// Events that can't be decrypted are logged and dropped, so that they don't block the following changes.
+func (cal *calendarAlarmCalendar) poll(ctx context.Context, c *Client) error {
+	modelEvent, err := c.GetCalendarModelEvent(ctx, cal.calendarID, cal.lastEventID)
+	if err != nil {
+		return err
+	}
+
+	if modelEvent.Refresh {
+		return cal.load(ctx, c)
+	}
+
+	for _, item := range modelEvent.Events {
+		if item.Action == EventDelete {
+			delete(cal.events, item.ID)
+			continue
+		}
+
+		merged, err := c.mergeCalendarEvent(ctx, item.Event, cal.memberID, cal.calKR, cal.addrKR)
+		if err != nil {
+			log.WithError(err).WithField("eventID", item.ID).Warn("Failed to decrypt calendar event")
+			delete(cal.events, item.ID)
+
+			continue
+		}
+
+		cal.events[item.ID] = decodedCalendarEvent{eventID: item.ID, event: merged}
+	}
+
+	cal.lastEventID = modelEvent.CalendarModelEventID
+
+	return nil
+}
+
+// getAlarms returns the alarms of the occurrences happening around the given time, sorted by time.
+// Floating times and all-day events are read in the location of the given time.
+// Events that can't be expanded are logged and have no alarms.
+func (cal *calendarAlarmCalendar) getAlarms(now time.Time) []CalendarAlarm {
+	events := make([]decodedCalendarEvent, 0, len(cal.events))
+
+	for _, event := range cal.events {
+		events = append(events, event)
+	}
+
+	occurrences, _ := expandCalendarEvents(events, now.Add(-calendarAlarmWindow), now.Add(calendarAlarmWindow), now.Location())
+
+	var alarms []CalendarAlarm
+
+	for _, occurrence := range occurrences {
+		occurrenceAlarms, err := getCalendarOccurrenceAlarms(cal.calendarID, occurrence)
+		if err != nil {
+			log.WithError(err).WithField("eventID", occurrence.EventID).Warn("Failed to read calendar event alarms")
+			continue
+		}
+
+		for _, alarm := range occurrenceAlarms {
+			// Absolute alarms of recurring events are the same for all their occurrences.
+			if !slices.ContainsFunc(alarms, func(other CalendarAlarm) bool { return other.ID == alarm.ID }) {
+				alarms = append(alarms, alarm)
+			}
+		}
+	}
+
+	slices.SortFunc(alarms, func(a, b CalendarAlarm) bool {
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+
+		return a.ID < b.ID
+	})
+
+	return alarms
+}
+
+// getCalendarOccurrenceAlarms returns the alarms of the occurrence, including their repetitions.
+// Relative triggers are relative to the start of the occurrence, or to its end if RELATED=END.
+func getCalendarOccurrenceAlarms(calendarID string, occurrence CalendarOccurrence) ([]CalendarAlarm, error) {
+	// The occurrence is identified by its original start, which doesn't change if it's moved.
+	occurrenceTime := occurrence.Start
+
+	if !occurrence.RecurrenceID.IsZero() {
+		occurrenceTime = occurrence.RecurrenceID
+	}
+
+	summary, err := occurrence.Event.Props.Text(ical.PropSummary)
+	if err != nil {
+		return nil, err
+	}
+
+	var alarms []CalendarAlarm
+
+	// Alarms are identified by their position among the alarms of the event, so that two alarms with the same trigger are both fired.
+	index := -1
+
+	for _, child := range occurrence.Event.Children {
+		if child.Name != ical.CompAlarm {
+			continue
+		}
+
+		index++
+
+		trigger := child.Props.Get(ical.PropTrigger)
+		if trigger == nil {
+			continue
+		}
+
+		var (
+			triggerTime time.Time
+			alarmID     string
+		)
+
+		if trigger.ValueType() == ical.ValueDateTime {
+			if triggerTime, err = trigger.DateTime(time.UTC); err != nil {
+				return nil, err
+			}
+
+			alarmID = fmt.Sprintf("%v/%v/%v/%v", calendarID, occurrence.UID, index, triggerTime.Unix())
+		} else {
+			offset, err := trigger.Duration()
+			if err != nil {
+				return nil, err
+			}
+
+			if strings.EqualFold(trigger.Params.Get(ical.ParamRelated), "END") {
+				triggerTime = occurrence.End.Add(offset)
+			} else {
+				triggerTime = occurrence.Start.Add(offset)
+			}
+
+			alarmID = fmt.Sprintf("%v/%v/%v/%v/%v", calendarID, occurrence.UID, occurrenceTime.Unix(), index, triggerTime.Unix())
+		}
+
+		action, err := child.Props.Text(ical.PropAction)
+		if err != nil {
+			return nil, err
+		}
+
+		description, err := child.Props.Text(ical.PropDescription)
+		if err != nil {
+			return nil, err
+		} else if description == "" {
+			description = summary
+		}
+
+		repeat, interval, err := getCalendarAlarmRepetitions(child)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i <= repeat; i++ {
+			alarm := CalendarAlarm{
+				ID:          alarmID,
+				CalendarID:  calendarID,
+				Occurrence:  occurrence,
+				Time:        triggerTime.Add(time.Duration(i) * interval),
+				Action:      strings.ToUpper(action),
+				Description: description,
+			}
+
+			if i > 0 {
+				alarm.ID = fmt.Sprintf("%v/%v", alarmID, i)
+			}
+
+			alarms = append(alarms, alarm)
+		}
+	}
+
+	return alarms, nil
+}
+
+// getCalendarAlarmRepetitions returns how many times the alarm is repeated after it is first triggered, and the delay between repetitions.
+func getCalendarAlarmRepetitions(alarm *ical.Component) (int, time.Duration, error) {
+	repeat, duration := alarm.Props.Get(ical.PropRepeat), alarm.Props.Get(ical.PropDuration)
+	if repeat == nil || duration == nil {
+		return 0, 0, nil
+	}
+
+	count, err := repeat.Int()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	interval, err := duration.Duration()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if count < 0 || interval <= 0 {
+		return 0, 0, nil
+	}
+
+	return count, interval, nil
+}
@@ -0,0 +1,42 @@
+package proton_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendarAlarmFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms")
+
+	store, err := proton.NewCalendarAlarmFileStore(path)
+	require.NoError(t, err)
+
+	require.NoError(t, store.SetFired("calendar/event/0/1672617600"))
+
+	// A record left incomplete by a crash is ignored, and doesn't hide the records written after it.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+
+	_, err = file.WriteString(`"calendar/event/1/16726`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	require.NoError(t, store.SetFired("calendar/event/2/1672617600"))
+
+	store, err = proton.NewCalendarAlarmFileStore(path)
+	require.NoError(t, err)
+
+	for alarmID, want := range map[string]bool{
+		"calendar/event/0/1672617600": true,
+		"calendar/event/1/1672617600": false,
+		"calendar/event/2/1672617600": true,
+	} {
+		fired, err := store.IsFired(alarmID)
+		require.NoError(t, err)
+		require.Equal(t, want, fired, alarmID)
+	}
+}
@@ -0,0 +1,168 @@
+package proton
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// CalendarAlarm is an alarm (VALARM) of an occurrence of a calendar event that is due.
+type CalendarAlarm struct {
+	// ID identifies the alarm of the occurrence; it is the key under which the alarm is recorded as fired.
+	// It is made of the calendar ID, the event UID, the original start of the occurrence for relative triggers,
+	// the index of the VALARM in the event and the trigger time.
+	ID string
+
+	CalendarID string
+	Occurrence CalendarOccurrence
+
+	// Time is the time at which the alarm is triggered.
+	Time time.Time
+
+	// Action is the action of the alarm (DISPLAY, AUDIO or EMAIL).
+	Action string
+
+	// Description is the text to show, which defaults to the summary of the event.
+	Description string
+}
+
+// CalendarAlarmClock gives the time to the alarm stream.
+type CalendarAlarmClock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemCalendarAlarmClock struct{}
+
+func (systemCalendarAlarmClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemCalendarAlarmClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// CalendarAlarmStore records which alarms were fired, so that an alarm is fired only once, also across restarts.
+type CalendarAlarmStore interface {
+	IsFired(alarmID string) (bool, error)
+	SetFired(alarmID string) error
+}
+
+// NewCalendarAlarmMemoryStore returns a store that keeps the fired alarms in memory.
+func NewCalendarAlarmMemoryStore() CalendarAlarmStore {
+	return &calendarAlarmMemoryStore{fired: make(map[string]struct{})}
+}
+
+type calendarAlarmMemoryStore struct {
+	fired     map[string]struct{}
+	firedLock sync.RWMutex
+}
+
+func (store *calendarAlarmMemoryStore) IsFired(alarmID string) (bool, error) {
+	store.firedLock.RLock()
+	defer store.firedLock.RUnlock()
+
+	_, ok := store.fired[alarmID]
+
+	return ok, nil
+}
+
+func (store *calendarAlarmMemoryStore) SetFired(alarmID string) error {
+	store.firedLock.Lock()
+	defer store.firedLock.Unlock()
+
+	store.fired[alarmID] = struct{}{}
+
+	return nil
+}
+
+// NewCalendarAlarmFileStore returns a store that keeps the fired alarms in the file at the given path,
+// so that they are not fired again after a restart. Fired alarms are appended to the file, one JSON string per line;
+// a line left incomplete by a crash is ignored. The file is created when the first alarm is fired.
+func NewCalendarAlarmFileStore(path string) (CalendarAlarmStore, error) {
+	store := &calendarAlarmFileStore{path: path, fired: make(map[string]struct{})}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		var alarmID string
+
+		if err := json.Unmarshal(scanner.Bytes(), &alarmID); err != nil {
+			continue
+		}
+
+		store.fired[alarmID] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+type calendarAlarmFileStore struct {
+	path string
+
+	fired     map[string]struct{}
+	firedLock sync.RWMutex
+}
+
+func (store *calendarAlarmFileStore) IsFired(alarmID string) (bool, error) {
+	store.firedLock.RLock()
+	defer store.firedLock.RUnlock()
+
+	_, ok := store.fired[alarmID]
+
+	return ok, nil
+}
+
+func (store *calendarAlarmFileStore) SetFired(alarmID string) error {
+	store.firedLock.Lock()
+	defer store.firedLock.Unlock()
+
+	if _, ok := store.fired[alarmID]; ok {
+		return nil
+	}
+
+	b, err := json.Marshal(alarmID)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(store.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	// A previous write may have been interrupted before its newline; starting a new line keeps this record readable.
+	if _, err := file.Write(append(append([]byte("\n"), b...), '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	store.fired[alarmID] = struct{}{}
+
+	return nil
+}
@@ -2,9 +2,13 @@ package proton
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
 
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/emersion/go-ical"
 	"github.com/go-resty/resty/v2"
 )
 
@@ -64,3 +68,125 @@ func (c *Client) GetCalendarEvent(ctx context.Context, calendarID, eventID strin
 
 	return res.Event, nil
 }
+
+func (c *Client) CreateCalendarEvent(ctx context.Context, calendarID string, req CreateCalendarEventReq) (CalendarEvent, error) {
+	return c.syncCalendarEvent(ctx, calendarID, req.MemberID, struct {
+		Overwrite Bool
+		Event     CalendarEventData
+	}{
+		Event: req.Event,
+	})
+}
+
+func (c *Client) UpdateCalendarEvent(ctx context.Context, calendarID, eventID string, req UpdateCalendarEventReq) (CalendarEvent, error) {
+	return c.syncCalendarEvent(ctx, calendarID, req.MemberID, struct {
+		ID    string
+		Event CalendarEventData
+	}{
+		ID:    eventID,
+		Event: req.Event,
+	})
+}
+
+func (c *Client) DeleteCalendarEvent(ctx context.Context, calendarID, eventID, memberID string) error {
+	_, err := c.syncCalendarEvent(ctx, calendarID, memberID, struct {
+		ID string
+	}{
+		ID: eventID,
+	})
+
+	return err
+}
+
+func (c *Client) UpdateCalendarAttendee(ctx context.Context, calendarID, eventID, attendeeID string, req UpdateCalendarAttendeeReq) (CalendarAttendee, error) {
+	var res struct {
+		Attendee CalendarAttendee
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetBody(req).SetResult(&res).Put("/calendar/v1/" + calendarID + "/events/" + eventID + "/attendees/" + attendeeID)
+	}); err != nil {
+		return CalendarAttendee{}, err
+	}
+
+	return res.Attendee, nil
+}
+
+// GetCalendarAuthorKeyRings returns the keyrings with which to verify the parts of the given events written by
+// other addresses than those of addrKR, keyed by lowercase email address. The public keys of the authors are fetched.
+func (c *Client) GetCalendarAuthorKeyRings(ctx context.Context, events []CalendarEvent, addrKR *crypto.KeyRing) (map[string]*crypto.KeyRing, error) {
+	authorKRs := make(map[string]*crypto.KeyRing)
+
+	for _, event := range events {
+		for _, parts := range [][]CalendarEventPart{event.SharedEvents, event.CalendarEvents, event.PersonalEvents, event.AttendeesEvents} {
+			for _, part := range parts {
+				author := strings.ToLower(part.Author)
+
+				if _, ok := authorKRs[author]; ok || part.isWrittenBy(addrKR) {
+					continue
+				}
+
+				keys, _, err := c.GetPublicKeys(ctx, part.Author)
+				if err != nil {
+					return nil, err
+				}
+
+				kr, err := keys.GetKeyRing()
+				if err != nil {
+					return nil, err
+				}
+
+				authorKRs[author] = kr
+			}
+		}
+	}
+
+	return authorKRs, nil
+}
+
+// mergeCalendarEvent merges the event, verifying the parts written by other members with their public keys.
+func (c *Client) mergeCalendarEvent(ctx context.Context, event CalendarEvent, memberID string, calKR, addrKR *crypto.KeyRing) (*ical.Event, error) {
+	authorKRs, err := c.GetCalendarAuthorKeyRings(ctx, []CalendarEvent{event}, addrKR)
+	if err != nil {
+		return nil, err
+	}
+
+	return event.MergeWithAuthors(memberID, calKR, addrKR, authorKRs)
+}
+
+// syncCalendarEvent creates, updates or deletes a single event through the calendar sync route.
+func (c *Client) syncCalendarEvent(ctx context.Context, calendarID, memberID string, event any) (CalendarEvent, error) {
+	req := struct {
+		MemberID string
+		Events   []any
+	}{
+		MemberID: memberID,
+		Events:   []any{event},
+	}
+
+	var res struct {
+		Responses []struct {
+			Index    int
+			Response struct {
+				APIError
+				Event CalendarEvent
+			}
+		}
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetBody(req).SetResult(&res).Put("/calendar/v1/" + calendarID + "/events/sync")
+	}); err != nil {
+		return CalendarEvent{}, err
+	}
+
+	if len(res.Responses) != 1 {
+		return CalendarEvent{}, fmt.Errorf("unexpected number of responses: %d", len(res.Responses))
+	}
+
+	if res := res.Responses[0].Response; res.Code != SuccessCode {
+		return CalendarEvent{}, fmt.Errorf("failed to sync calendar event: %w", res.APIError)
+	}
+
+	return res.Responses[0].Response.Event, nil
+}
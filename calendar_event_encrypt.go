@@ -0,0 +1,405 @@
+package proton
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/emersion/go-ical"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// CalendarProductID is the product identifier of the iCalendar objects built by this package.
+const CalendarProductID = "-//Proton AG//go-proton-api//EN"
+
+// FieldPMToken is the attendee parameter holding the token the API uses to identify the attendee.
+const FieldPMToken = "X-PM-TOKEN"
+
+// The properties stored in each part of an event, besides UID and DTSTAMP which are stored in every part.
+// Properties that are not listed are stored in the shared encrypted part.
+var (
+	calendarEventSharedSignedProps = []string{
+		ical.PropDateTimeStart,
+		ical.PropDateTimeEnd,
+		ical.PropDuration,
+		ical.PropRecurrenceID,
+		ical.PropRecurrenceRule,
+		ical.PropRecurrenceDates,
+		ical.PropExceptionDates,
+		ical.PropOrganizer,
+		ical.PropSequence,
+	}
+
+	calendarEventCalendarSignedProps = []string{
+		ical.PropStatus,
+		ical.PropTransparency,
+	}
+
+	calendarEventCalendarEncryptedProps = []string{
+		ical.PropComment,
+	}
+
+	calendarEventAttendeesProps = []string{
+		ical.PropAttendee,
+	}
+)
+
+func NewCreateCalendarEventReq(event *ical.Event, memberID string, calKR, addrKR *crypto.KeyRing) (CreateCalendarEventReq, error) {
+	data, err := newCalendarEventData(event, calendarEventKeys{}, calKR, addrKR)
+	if err != nil {
+		return CreateCalendarEventReq{}, err
+	}
+
+	return CreateCalendarEventReq{
+		MemberID: memberID,
+		Event:    data,
+	}, nil
+}
+
+// NewUpdateCalendarEventReq returns a request replacing the existing event with the given one.
+// The session keys of the existing event are reused, so that their key packets remain valid.
+func NewUpdateCalendarEventReq(event *ical.Event, existing CalendarEvent, memberID string, calKR, addrKR *crypto.KeyRing) (UpdateCalendarEventReq, error) {
+	keys, err := getCalendarEventKeys(existing, calKR)
+	if err != nil {
+		return UpdateCalendarEventReq{}, err
+	}
+
+	data, err := newCalendarEventData(event, keys, calKR, addrKR)
+	if err != nil {
+		return UpdateCalendarEventReq{}, err
+	}
+
+	return UpdateCalendarEventReq{
+		MemberID: memberID,
+		Event:    data,
+	}, nil
+}
+
+// GetCalendarAttendeeToken returns the token identifying the attendee with the given email in the event with the given UID.
+func GetCalendarAttendeeToken(uid, email string) string {
+	hash := sha1.Sum([]byte(uid + strings.ToLower(email))) //nolint:gosec
+
+	return hex.EncodeToString(hash[:])
+}
+
+// calendarEventKeys are the session keys of an event and their key packets, encrypted with the calendar keyring.
+type calendarEventKeys struct {
+	sharedKP string
+	sharedSK *crypto.SessionKey
+
+	calendarKP string
+	calendarSK *crypto.SessionKey
+}
+
+// getCalendarEventKeys returns the session keys of the event.
+func getCalendarEventKeys(event CalendarEvent, calKR *crypto.KeyRing) (calendarEventKeys, error) {
+	var (
+		keys calendarEventKeys
+		err  error
+	)
+
+	if event.SharedKeyPacket != "" {
+		if keys.sharedSK, err = decryptCalendarSessionKey(event.SharedKeyPacket, calKR); err != nil {
+			return calendarEventKeys{}, err
+		}
+
+		keys.sharedKP = event.SharedKeyPacket
+	}
+
+	if event.CalendarKeyPacket != "" {
+		if keys.calendarSK, err = decryptCalendarSessionKey(event.CalendarKeyPacket, calKR); err != nil {
+			return calendarEventKeys{}, err
+		}
+
+		keys.calendarKP = event.CalendarKeyPacket
+	}
+
+	return keys, nil
+}
+
+// newCalendarEventData splits the event into the parts stored by the API.
+// Shared parts are encrypted with a session key, calendar parts with another; both are wrapped by the calendar keyring.
+// The given session keys are used if set, new ones are generated otherwise.
+// All parts are signed with the member's address keyring.
+func newCalendarEventData(event *ical.Event, keys calendarEventKeys, calKR, addrKR *crypto.KeyRing) (CalendarEventData, error) {
+	uid, err := event.Props.Text(ical.PropUID)
+	if err != nil {
+		return CalendarEventData{}, err
+	} else if uid == "" {
+		return CalendarEventData{}, errors.New("event has no UID")
+	}
+
+	// The stamp is added to a copy of the event, so that the caller's event is left untouched.
+	if event.Props.Get(ical.PropDateTimeStamp) == nil {
+		event = &ical.Event{Component: &ical.Component{
+			Name:     event.Name,
+			Props:    maps.Clone(event.Props),
+			Children: event.Children,
+		}}
+
+		event.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+	}
+
+	data := CalendarEventData{
+		IsOrganizer: Bool(isCalendarEventOrganizer(event, addrKR)),
+	}
+
+	// Shared parts: the time of the event, signed, and its other properties, encrypted.
+	if keys.sharedSK == nil {
+		if keys.sharedKP, keys.sharedSK, err = newCalendarSessionKey(calKR); err != nil {
+			return CalendarEventData{}, err
+		}
+	}
+
+	data.SharedKeyPacket = keys.sharedKP
+
+	sharedSigned, err := encodeCalendarEventPart(
+		newCalendarEventComponent(event, calendarEventSharedSignedProps),
+		CalendarEventTypeSigned, nil, addrKR,
+	)
+	if err != nil {
+		return CalendarEventData{}, err
+	}
+
+	sharedEncrypted, err := encodeCalendarEventPart(
+		newCalendarEventComponent(event, getCalendarEventSharedEncryptedProps(event)),
+		CalendarEventTypeEncrypted|CalendarEventTypeSigned, keys.sharedSK, addrKR,
+	)
+	if err != nil {
+		return CalendarEventData{}, err
+	}
+
+	data.SharedEventContent = []CalendarEventPart{sharedSigned, sharedEncrypted}
+
+	// Calendar parts: the properties specific to the calendar the event is in.
+	calendarSigned, err := encodeCalendarEventPart(
+		newCalendarEventComponent(event, calendarEventCalendarSignedProps),
+		CalendarEventTypeSigned, nil, addrKR,
+	)
+	if err != nil {
+		return CalendarEventData{}, err
+	}
+
+	data.CalendarEventContent = []CalendarEventPart{calendarSigned}
+
+	if hasCalendarEventProps(event, calendarEventCalendarEncryptedProps) {
+		if keys.calendarSK == nil {
+			if keys.calendarKP, keys.calendarSK, err = newCalendarSessionKey(calKR); err != nil {
+				return CalendarEventData{}, err
+			}
+		}
+
+		calendarEncrypted, err := encodeCalendarEventPart(
+			newCalendarEventComponent(event, calendarEventCalendarEncryptedProps),
+			CalendarEventTypeEncrypted|CalendarEventTypeSigned, keys.calendarSK, addrKR,
+		)
+		if err != nil {
+			return CalendarEventData{}, err
+		}
+
+		data.CalendarKeyPacket = keys.calendarKP
+		data.CalendarEventContent = append(data.CalendarEventContent, calendarEncrypted)
+	}
+
+	// Personal part: the alarms of the member.
+	if len(event.Children) > 0 {
+		comp := newCalendarEventComponent(event, nil)
+
+		comp.Children = event.Children
+
+		personal, err := encodeCalendarEventPart(comp, CalendarEventTypeSigned, nil, addrKR)
+		if err != nil {
+			return CalendarEventData{}, err
+		}
+
+		data.PersonalEventContent = &personal
+	}
+
+	// Attendees part: the attendees, encrypted, each identified by a token that is also sent in clear with their status.
+	if hasCalendarEventProps(event, calendarEventAttendeesProps) {
+		comp := newCalendarEventComponent(event, nil)
+
+		for _, prop := range event.Props.Values(ical.PropAttendee) {
+			token := GetCalendarAttendeeToken(uid, getCalendarAddress(prop.Value))
+
+			attendee := ical.Prop{Name: prop.Name, Value: prop.Value, Params: make(ical.Params)}
+
+			for name, values := range prop.Params {
+				attendee.Params[name] = slices.Clone(values)
+			}
+
+			attendee.Params.Set(FieldPMToken, token)
+
+			comp.Props.Add(&attendee)
+
+			data.Attendees = append(data.Attendees, CalendarAttendeeData{
+				Token:  token,
+				Status: getCalendarAttendeeStatus(prop.Params.Get(ical.ParamParticipationStatus)),
+			})
+		}
+
+		attendees, err := encodeCalendarEventPart(comp, CalendarEventTypeEncrypted|CalendarEventTypeSigned, keys.sharedSK, addrKR)
+		if err != nil {
+			return CalendarEventData{}, err
+		}
+
+		data.AttendeesEventContent = []CalendarEventPart{attendees}
+	}
+
+	return data, nil
+}
+
+// newCalendarSessionKey returns a new session key and its key packet, encrypted with the calendar keyring and base64-encoded.
+func newCalendarSessionKey(calKR *crypto.KeyRing) (string, *crypto.SessionKey, error) {
+	sk, err := crypto.GenerateSessionKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	kp, err := calKR.EncryptSessionKey(sk)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return base64.StdEncoding.EncodeToString(kp), sk, nil
+}
+
+// decryptCalendarSessionKey decrypts the base64-encoded key packet with the calendar keyring.
+func decryptCalendarSessionKey(kp string, calKR *crypto.KeyRing) (*crypto.SessionKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(kp)
+	if err != nil {
+		return nil, err
+	}
+
+	return calKR.DecryptSessionKey(raw)
+}
+
+// newCalendarEventComponent returns a new VEVENT with the UID and DTSTAMP of the event and the given properties.
+func newCalendarEventComponent(event *ical.Event, props []string) *ical.Component {
+	comp := ical.NewComponent(ical.CompEvent)
+
+	for _, name := range append([]string{ical.PropUID, ical.PropDateTimeStamp}, props...) {
+		if values := event.Props.Values(name); len(values) > 0 {
+			comp.Props[name] = slices.Clone(values)
+		}
+	}
+
+	return comp
+}
+
+// getCalendarEventSharedEncryptedProps returns the properties of the event that are not stored in any other part.
+func getCalendarEventSharedEncryptedProps(event *ical.Event) []string {
+	var props []string
+
+	for name := range event.Props {
+		switch {
+		case name == ical.PropUID, name == ical.PropDateTimeStamp:
+			continue
+
+		case slices.Contains(calendarEventSharedSignedProps, name),
+			slices.Contains(calendarEventCalendarSignedProps, name),
+			slices.Contains(calendarEventCalendarEncryptedProps, name),
+			slices.Contains(calendarEventAttendeesProps, name):
+			continue
+		}
+
+		props = append(props, name)
+	}
+
+	return props
+}
+
+func hasCalendarEventProps(event *ical.Event, props []string) bool {
+	for _, name := range props {
+		if len(event.Props.Values(name)) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isCalendarEventOrganizer returns whether the event has no organizer or is organized by one of the addresses of the keyring.
+func isCalendarEventOrganizer(event *ical.Event, addrKR *crypto.KeyRing) bool {
+	organizer := event.Props.Get(ical.PropOrganizer)
+	if organizer == nil {
+		return true
+	}
+
+	for _, identity := range addrKR.GetIdentities() {
+		if strings.EqualFold(identity.Email, getCalendarAddress(organizer.Value)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getCalendarAddress returns the email of the given ORGANIZER or ATTENDEE value, a mailto URI.
+func getCalendarAddress(value string) string {
+	return strings.TrimPrefix(strings.ToLower(value), "mailto:")
+}
+
+func getCalendarAttendeeStatus(partStat string) CalendarAttendeeStatus {
+	switch strings.ToUpper(partStat) {
+	case "TENTATIVE":
+		return CalendarAttendeeStatusMaybe
+
+	case "DECLINED":
+		return CalendarAttendeeStatusNo
+
+	case "ACCEPTED":
+		return CalendarAttendeeStatusYes
+
+	default:
+		return CalendarAttendeeStatusPending
+	}
+}
+
+// encodeCalendarEventPart wraps the component in a VCALENDAR, signs it with the address keyring
+// and, if the part is encrypted, encrypts it with the given session key.
+func encodeCalendarEventPart(comp *ical.Component, partType CalendarEventType, sk *crypto.SessionKey, addrKR *crypto.KeyRing) (CalendarEventPart, error) {
+	cal := ical.NewCalendar()
+
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, CalendarProductID)
+	cal.Children = append(cal.Children, comp)
+
+	var buf bytes.Buffer
+
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return CalendarEventPart{}, err
+	}
+
+	part := CalendarEventPart{Type: partType}
+
+	if partType&CalendarEventTypeSigned != 0 {
+		sig, err := addrKR.SignDetached(crypto.NewPlainMessageFromString(buf.String()))
+		if err != nil {
+			return CalendarEventPart{}, err
+		}
+
+		if part.Signature, err = sig.GetArmored(); err != nil {
+			return CalendarEventPart{}, err
+		}
+	}
+
+	if partType&CalendarEventTypeEncrypted != 0 {
+		enc, err := sk.Encrypt(crypto.NewPlainMessageFromString(buf.String()))
+		if err != nil {
+			return CalendarEventPart{}, err
+		}
+
+		part.Data = base64.StdEncoding.EncodeToString(enc)
+	} else {
+		part.Data = buf.String()
+	}
+
+	return part, nil
+}
@@ -0,0 +1,515 @@
+package proton_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+func TestNewCreateCalendarEventReq(t *testing.T) {
+	calKR := newKeyRing(t, "calendar", "calendar@proton.test")
+	addrKR := newKeyRing(t, "user", "user@proton.test")
+
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, "event-uid")
+	event.Props.SetDateTime(ical.PropDateTimeStamp, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC))
+	event.Props.SetDateTime(ical.PropDateTimeEnd, time.Date(2023, 1, 2, 11, 0, 0, 0, time.UTC))
+	event.Props.SetText(ical.PropSummary, "Meeting")
+	event.Props.SetText(ical.PropLocation, "Office")
+	event.Props.SetText(ical.PropComment, "Bring slides")
+	event.Props.SetText(ical.PropStatus, "CONFIRMED")
+	event.Props.SetText("X-CUSTOM", "custom")
+
+	attendee := ical.NewProp(ical.PropAttendee)
+	attendee.Value = "mailto:other@proton.test"
+	attendee.Params.Set(ical.ParamParticipationStatus, "ACCEPTED")
+	event.Props.Add(attendee)
+
+	alarm := ical.NewComponent(ical.CompAlarm)
+	alarm.Props.SetText(ical.PropAction, "DISPLAY")
+	alarm.Props.SetText(ical.PropTrigger, "-PT15M")
+	event.Children = append(event.Children, alarm)
+
+	req, err := proton.NewCreateCalendarEventReq(event, "memberID", calKR, addrKR)
+	require.NoError(t, err)
+	require.Equal(t, "memberID", req.MemberID)
+	require.True(t, bool(req.Event.IsOrganizer))
+
+	sharedKP, err := base64.StdEncoding.DecodeString(req.Event.SharedKeyPacket)
+	require.NoError(t, err)
+
+	calendarKP, err := base64.StdEncoding.DecodeString(req.Event.CalendarKeyPacket)
+	require.NoError(t, err)
+
+	// The time of the event is signed but readable by anyone; its other properties are encrypted.
+	require.Len(t, req.Event.SharedEventContent, 2)
+
+	sharedSigned := decodeCalendarEventPart(t, req.Event.SharedEventContent[0], calKR, addrKR, nil)
+	require.Equal(t, proton.CalendarEventTypeSigned, req.Event.SharedEventContent[0].Type)
+	require.NotNil(t, sharedSigned.Props.Get(ical.PropDateTimeStart))
+	require.NotNil(t, sharedSigned.Props.Get(ical.PropDateTimeEnd))
+	require.Nil(t, sharedSigned.Props.Get(ical.PropSummary))
+
+	sharedEncrypted := decodeCalendarEventPart(t, req.Event.SharedEventContent[1], calKR, addrKR, sharedKP)
+	require.Equal(t, proton.CalendarEventTypeEncrypted|proton.CalendarEventTypeSigned, req.Event.SharedEventContent[1].Type)
+	require.Equal(t, "Meeting", sharedEncrypted.Props.Get(ical.PropSummary).Value)
+	require.Equal(t, "Office", sharedEncrypted.Props.Get(ical.PropLocation).Value)
+	require.Equal(t, "custom", sharedEncrypted.Props.Get("X-CUSTOM").Value)
+	require.Nil(t, sharedEncrypted.Props.Get(ical.PropDateTimeStart))
+
+	// Calendar properties are stored in their own parts, with their own session key.
+	require.Len(t, req.Event.CalendarEventContent, 2)
+	require.Equal(t, "CONFIRMED", decodeCalendarEventPart(t, req.Event.CalendarEventContent[0], calKR, addrKR, nil).Props.Get(ical.PropStatus).Value)
+	require.Equal(t, "Bring slides", decodeCalendarEventPart(t, req.Event.CalendarEventContent[1], calKR, addrKR, calendarKP).Props.Get(ical.PropComment).Value)
+
+	// Alarms are personal.
+	require.NotNil(t, req.Event.PersonalEventContent)
+	personal := decodeCalendarEventPart(t, *req.Event.PersonalEventContent, calKR, addrKR, nil)
+	require.Len(t, personal.Children, 1)
+	require.Equal(t, ical.CompAlarm, personal.Children[0].Name)
+
+	// Attendees are encrypted, and identified by a token.
+	token := proton.GetCalendarAttendeeToken("event-uid", "other@proton.test")
+	require.Equal(t, []proton.CalendarAttendeeData{{Token: token, Status: proton.CalendarAttendeeStatusYes}}, req.Event.Attendees)
+
+	require.Len(t, req.Event.AttendeesEventContent, 1)
+	attendees := decodeCalendarEventPart(t, req.Event.AttendeesEventContent[0], calKR, addrKR, sharedKP)
+	require.Equal(t, token, attendees.Props.Get(ical.PropAttendee).Params.Get(proton.FieldPMToken))
+
+	// Every part holds the UID of the event.
+	for _, part := range []*ical.Event{sharedSigned, sharedEncrypted, personal, attendees} {
+		require.Equal(t, "event-uid", part.Props.Get(ical.PropUID).Value)
+	}
+}
+
+func TestCalendarEventPart_Decode(t *testing.T) {
+	calKR := newKeyRing(t, "calendar", "calendar@proton.test")
+	addrKR := newKeyRing(t, "user", "user@proton.test")
+	otherKR := newKeyRing(t, "other", "other@proton.test")
+
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, "event-uid")
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC))
+	event.Props.SetText(ical.PropSummary, "Meeting")
+
+	req, err := proton.NewCreateCalendarEventReq(event, "memberID", calKR, addrKR)
+	require.NoError(t, err)
+
+	// The stamp is only added to the request, not to the given event.
+	require.Nil(t, event.Props.Get(ical.PropDateTimeStamp))
+
+	sharedKP, err := base64.StdEncoding.DecodeString(req.Event.SharedKeyPacket)
+	require.NoError(t, err)
+
+	part := req.Event.SharedEventContent[1]
+
+	// Signed parts are only decoded with the keyring of their author.
+	data, err := part.DecodeData(calKR, addrKR, sharedKP)
+	require.NoError(t, err)
+	require.Contains(t, data, "Meeting")
+	require.NoError(t, part.Decode(calKR, addrKR, sharedKP))
+
+	_, err = part.DecodeData(calKR, otherKR, sharedKP)
+	require.Error(t, err)
+	require.Error(t, part.Decode(calKR, otherKR, sharedKP))
+
+	_, err = part.DecodeData(calKR, nil, sharedKP)
+	require.Error(t, err)
+
+	// Decoding without verification must be asked for explicitly.
+	unverified, err := part.DecodeUnverified(calKR, sharedKP)
+	require.NoError(t, err)
+	require.Equal(t, data, unverified)
+
+	// The part itself is left untouched.
+	require.Equal(t, req.Event.SharedEventContent[1], part)
+}
+
+func TestNewCreateCalendarEventReq_Organizer(t *testing.T) {
+	calKR := newKeyRing(t, "calendar", "calendar@proton.test")
+	addrKR := newKeyRing(t, "user", "user@proton.test")
+
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, "event-uid")
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC))
+
+	organizer := ical.NewProp(ical.PropOrganizer)
+	organizer.Value = "mailto:other@proton.test"
+	event.Props.Set(organizer)
+
+	req, err := proton.NewCreateCalendarEventReq(event, "memberID", calKR, addrKR)
+	require.NoError(t, err)
+	require.False(t, bool(req.Event.IsOrganizer))
+	require.Nil(t, req.Event.PersonalEventContent)
+	require.Empty(t, req.Event.CalendarKeyPacket)
+
+	// Events without UID are rejected.
+	event.Props.Del(ical.PropUID)
+
+	_, err = proton.NewCreateCalendarEventReq(event, "memberID", calKR, addrKR)
+	require.Error(t, err)
+}
+
+func TestCalendarEvent_Merge_Author(t *testing.T) {
+	calKR := newKeyRing(t, "calendar", "calendar@proton.test")
+	addrKR := newKeyRing(t, "user", "user@proton.test")
+	otherKR := newKeyRing(t, "other", "other@proton.test")
+
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, "event-uid")
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC))
+	event.Props.SetText(ical.PropSummary, "Meeting")
+
+	// The event is written by another member of the calendar; it can't be merged without the member's keys.
+	stored := newStoredCalendarEvent(t, "eventID", event, calKR, otherKR)
+
+	setCalendarEventAuthor(&stored, "other@proton.test")
+
+	_, err := stored.Merge("memberID", calKR, addrKR)
+	require.Error(t, err)
+
+	merged, err := stored.MergeWithAuthors("memberID", calKR, addrKR, map[string]*crypto.KeyRing{"other@proton.test": otherKR})
+	require.NoError(t, err)
+	require.Equal(t, "Meeting", merged.Props.Get(ical.PropSummary).Value)
+
+	// Parts claiming to be written by another address must be signed by it.
+	forged := newStoredCalendarEvent(t, "eventID", event, calKR, addrKR)
+
+	setCalendarEventAuthor(&forged, "other@proton.test")
+
+	_, err = forged.Merge("memberID", calKR, addrKR)
+	require.Error(t, err)
+
+	_, err = forged.MergeWithAuthors("memberID", calKR, addrKR, map[string]*crypto.KeyRing{"other@proton.test": otherKR})
+	require.Error(t, err)
+
+	// Parts claiming to be written by the member's address must be signed by it.
+	setCalendarEventAuthor(&stored, "user@proton.test")
+
+	_, err = stored.Merge("memberID", calKR, addrKR)
+	require.Error(t, err)
+}
+
+func TestNewCalendarExport(t *testing.T) {
+	calKR := newKeyRing(t, "calendar", "calendar@proton.test")
+	addrKR := newKeyRing(t, "user", "user@proton.test")
+
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+
+	start := time.Date(2023, 6, 1, 10, 0, 0, 0, zurich)
+
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, "event-uid")
+	event.Props.SetDateTime(ical.PropDateTimeStart, start)
+	event.Props.SetDateTime(ical.PropDateTimeEnd, start.Add(time.Hour))
+	event.Props.SetText(ical.PropSummary, "Meeting")
+
+	attendee := ical.NewProp(ical.PropAttendee)
+	attendee.Value = "mailto:other@proton.test"
+	attendee.Params.Set(ical.ParamParticipationStatus, "NEEDS-ACTION")
+	event.Props.Add(attendee)
+
+	alarm := ical.NewComponent(ical.CompAlarm)
+	alarm.Props.SetText(ical.PropAction, "DISPLAY")
+	alarm.Props.SetText(ical.PropTrigger, "-PT15M")
+	event.Children = append(event.Children, alarm)
+
+	req, err := proton.NewCreateCalendarEventReq(event, "memberID", calKR, addrKR)
+	require.NoError(t, err)
+
+	// The attendee accepted the invitation since the event was written.
+	req.Event.Attendees[0].Status = proton.CalendarAttendeeStatusYes
+
+	cal, err := proton.NewCalendarExport("Work", []proton.CalendarEvent{{
+		ID:                "eventID",
+		UID:               "event-uid",
+		StartTime:         start.Unix(),
+		StartTimezone:     "Europe/Zurich",
+		EndTime:           start.Add(time.Hour).Unix(),
+		EndTimezone:       "Europe/Zurich",
+		SharedKeyPacket:   req.Event.SharedKeyPacket,
+		CalendarKeyPacket: req.Event.CalendarKeyPacket,
+		SharedEvents:      req.Event.SharedEventContent,
+		CalendarEvents:    req.Event.CalendarEventContent,
+		PersonalEvents:    []proton.CalendarEventPart{withMemberID(*req.Event.PersonalEventContent, "memberID")},
+		AttendeesEvents:   req.Event.AttendeesEventContent,
+		Attendees:         []proton.CalendarAttendee{{Token: req.Event.Attendees[0].Token, Status: req.Event.Attendees[0].Status}},
+	}}, "memberID", calKR, addrKR)
+	require.NoError(t, err)
+
+	var buf strings.Builder
+
+	require.NoError(t, ical.NewEncoder(&buf).Encode(cal))
+
+	// The calendar can be read back by any client.
+	dec, err := ical.NewDecoder(strings.NewReader(buf.String())).Decode()
+	require.NoError(t, err)
+	require.Equal(t, "Work", dec.Props.Get(proton.FieldWRCalName).Value)
+
+	// The timezone of the event is described, including its summer time.
+	var timezones []*ical.Component
+
+	for _, child := range dec.Children {
+		if child.Name == ical.CompTimezone {
+			timezones = append(timezones, child)
+		}
+	}
+
+	require.Len(t, timezones, 1)
+	require.Equal(t, "Europe/Zurich", timezones[0].Props.Get(ical.PropTimezoneID).Value)
+
+	var offsets []string
+
+	for _, observance := range timezones[0].Children {
+		offsets = append(offsets, observance.Name+" "+observance.Props.Get(ical.PropTimezoneOffsetTo).Value)
+	}
+
+	require.Equal(t, []string{"STANDARD +0100", "DAYLIGHT +0200", "STANDARD +0100"}, offsets)
+
+	// The event is merged from its parts.
+	events := dec.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, "event-uid", events[0].Props.Get(ical.PropUID).Value)
+	require.Equal(t, "Meeting", events[0].Props.Get(ical.PropSummary).Value)
+	require.Len(t, events[0].Props.Values(ical.PropUID), 1)
+	require.Len(t, events[0].Children, 1)
+
+	dtstart, err := events[0].DateTimeStart(nil)
+	require.NoError(t, err)
+	require.True(t, start.Equal(dtstart))
+
+	// The status of the attendee is read from the clear attendee list.
+	require.Equal(t, "ACCEPTED", events[0].Props.Get(ical.PropAttendee).Params.Get(ical.ParamParticipationStatus))
+	require.Empty(t, events[0].Props.Get(ical.PropAttendee).Params.Get(proton.FieldPMToken))
+}
+
+func TestExpandCalendarEvents(t *testing.T) {
+	calKR := newKeyRing(t, "calendar", "calendar@proton.test")
+	addrKR := newKeyRing(t, "user", "user@proton.test")
+
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+
+	// A weekly meeting at 10:00 in Zurich, across the change to summer time.
+	master := ical.NewEvent()
+	master.Props.SetText(ical.PropUID, "weekly")
+	master.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2023, 3, 20, 10, 0, 0, 0, zurich))
+	master.Props.SetDateTime(ical.PropDateTimeEnd, time.Date(2023, 3, 20, 11, 0, 0, 0, zurich))
+	master.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=WEEKLY;COUNT=4"})
+	master.Props.SetDateTime(ical.PropExceptionDates, time.Date(2023, 4, 3, 10, 0, 0, 0, zurich))
+
+	// The second meeting is moved to the afternoon.
+	override := ical.NewEvent()
+	override.Props.SetText(ical.PropUID, "weekly")
+	override.Props.SetDateTime(ical.PropRecurrenceID, time.Date(2023, 3, 27, 10, 0, 0, 0, zurich))
+	override.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2023, 3, 27, 14, 0, 0, 0, zurich))
+	override.Props.SetDateTime(ical.PropDateTimeEnd, time.Date(2023, 3, 27, 15, 0, 0, 0, zurich))
+
+	// A single event outside of the window.
+	single := ical.NewEvent()
+	single.Props.SetText(ical.PropUID, "single")
+	single.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC))
+
+	events := []proton.CalendarEvent{
+		newStoredCalendarEvent(t, "master", master, calKR, addrKR),
+		newStoredCalendarEvent(t, "override", override, calKR, addrKR),
+		newStoredCalendarEvent(t, "single", single, calKR, addrKR),
+	}
+
+	occurrences, skipped := proton.ExpandCalendarEvents(events, "memberID", calKR, addrKR, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), nil)
+	require.Empty(t, skipped)
+	require.Len(t, occurrences, 3)
+
+	// Occurrences keep their local time after the change to summer time.
+	for i, want := range []struct {
+		eventID      string
+		start        time.Time
+		recurrenceID time.Time
+	}{
+		{"master", time.Date(2023, 3, 20, 10, 0, 0, 0, zurich), time.Date(2023, 3, 20, 10, 0, 0, 0, zurich)},
+		{"override", time.Date(2023, 3, 27, 14, 0, 0, 0, zurich), time.Date(2023, 3, 27, 10, 0, 0, 0, zurich)},
+		{"master", time.Date(2023, 4, 10, 10, 0, 0, 0, zurich), time.Date(2023, 4, 10, 10, 0, 0, 0, zurich)},
+	} {
+		require.Equal(t, want.eventID, occurrences[i].EventID)
+		require.Equal(t, "weekly", occurrences[i].UID)
+		require.True(t, want.start.Equal(occurrences[i].Start), occurrences[i].Start)
+		require.True(t, want.start.Add(time.Hour).Equal(occurrences[i].End), occurrences[i].End)
+		require.True(t, want.recurrenceID.Equal(occurrences[i].RecurrenceID), occurrences[i].RecurrenceID)
+	}
+
+	// Occurrences that are still happening at the start of the window are returned.
+	occurrences, skipped = proton.ExpandCalendarEvents(events, "memberID", calKR, addrKR, time.Date(2023, 4, 10, 8, 30, 0, 0, time.UTC), time.Date(2023, 4, 10, 9, 0, 0, 0, time.UTC), nil)
+	require.Empty(t, skipped)
+	require.Len(t, occurrences, 1)
+	require.True(t, time.Date(2023, 4, 10, 10, 0, 0, 0, zurich).Equal(occurrences[0].Start))
+
+	// Events that can't be decrypted are skipped.
+	otherKR := newKeyRing(t, "other", "other@proton.test")
+
+	occurrences, skipped = proton.ExpandCalendarEvents(
+		append(slices.Clone(events), newStoredCalendarEvent(t, "other", single, otherKR, otherKR)),
+		"memberID", calKR, addrKR, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), nil,
+	)
+	require.Equal(t, []string{"other"}, skipped)
+	require.Len(t, occurrences, 4)
+	require.Equal(t, "single", occurrences[3].EventID)
+}
+
+func TestExpandCalendarEvents_FullDay(t *testing.T) {
+	calKR := newKeyRing(t, "calendar", "calendar@proton.test")
+	addrKR := newKeyRing(t, "user", "user@proton.test")
+
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// A daily all-day event until the 3rd, and on the 10th.
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, "daily")
+	event.Props.SetDate(ical.PropDateTimeStart, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	event.Props.SetDate(ical.PropDateTimeEnd, time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC))
+	event.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=DAILY;UNTIL=20230103"})
+	event.Props.SetDate(ical.PropRecurrenceDates, time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC))
+
+	events := []proton.CalendarEvent{newStoredCalendarEvent(t, "daily", event, calKR, addrKR)}
+
+	// All-day events are read in the given location.
+	occurrences, skipped := proton.ExpandCalendarEvents(events, "memberID", calKR, addrKR, time.Date(2023, 1, 1, 0, 0, 0, 0, newYork), time.Date(2023, 2, 1, 0, 0, 0, 0, newYork), newYork)
+	require.Empty(t, skipped)
+	require.Len(t, occurrences, 4)
+
+	for i, day := range []int{1, 2, 3, 10} {
+		require.True(t, occurrences[i].FullDay)
+		require.Equal(t, time.Date(2023, 1, day, 0, 0, 0, 0, newYork), occurrences[i].Start)
+		require.Equal(t, time.Date(2023, 1, day+1, 0, 0, 0, 0, newYork), occurrences[i].End)
+	}
+
+	// Days only partially in the window are returned; days ending at its start are not.
+	occurrences, skipped = proton.ExpandCalendarEvents(events, "memberID", calKR, addrKR, time.Date(2023, 1, 2, 0, 0, 0, 0, newYork), time.Date(2023, 1, 2, 12, 0, 0, 0, newYork), newYork)
+	require.Empty(t, skipped)
+	require.Len(t, occurrences, 1)
+	require.Equal(t, time.Date(2023, 1, 2, 0, 0, 0, 0, newYork), occurrences[0].Start)
+}
+
+func TestNewCalendarInvite(t *testing.T) {
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, "event-uid")
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC))
+	event.Props.SetDateTime(ical.PropDateTimeEnd, time.Date(2023, 1, 2, 11, 0, 0, 0, time.UTC))
+	event.Props.SetText(ical.PropSummary, "Meeting")
+
+	organizer := ical.NewProp(ical.PropOrganizer)
+	organizer.Value = "mailto:user@proton.test"
+	event.Props.Set(organizer)
+
+	attendee := ical.NewProp(ical.PropAttendee)
+	attendee.Value = "mailto:Other@proton.test"
+	event.Props.Add(attendee)
+
+	// The request asks the attendee to answer.
+	request, err := proton.NewCalendarInviteRequest(event)
+	require.NoError(t, err)
+	require.Equal(t, "REQUEST", request.Props.Get(ical.PropMethod).Value)
+	require.Len(t, request.Events(), 1)
+	require.Equal(t, "NEEDS-ACTION", request.Events()[0].Props.Get(ical.PropAttendee).Params.Get(ical.ParamParticipationStatus))
+	require.Equal(t, "TRUE", request.Events()[0].Props.Get(ical.PropAttendee).Params.Get(ical.ParamRSVP))
+
+	// The reply holds the answering attendee only.
+	reply, err := proton.NewCalendarInviteReply(event, "other@proton.test", proton.CalendarAttendeeStatusYes)
+	require.NoError(t, err)
+	require.Equal(t, "REPLY", reply.Props.Get(ical.PropMethod).Value)
+	require.Len(t, reply.Events()[0].Props.Values(ical.PropAttendee), 1)
+	require.Equal(t, "ACCEPTED", reply.Events()[0].Props.Get(ical.PropAttendee).Params.Get(ical.ParamParticipationStatus))
+
+	_, err = proton.NewCalendarInviteReply(event, "unknown@proton.test", proton.CalendarAttendeeStatusYes)
+	require.Error(t, err)
+
+	// The cancellation increments the sequence of the event.
+	cancel, err := proton.NewCalendarInviteCancel(event)
+	require.NoError(t, err)
+	require.Equal(t, "CANCEL", cancel.Props.Get(ical.PropMethod).Value)
+	require.Equal(t, "1", cancel.Events()[0].Props.Get(ical.PropSequence).Value)
+	require.Equal(t, "CANCELLED", cancel.Events()[0].Props.Get(ical.PropStatus).Value)
+
+	// The invitation can be read back from the MIME body holding it.
+	body, err := proton.NewCalendarInviteMIMEBody("You are invited.", request)
+	require.NoError(t, err)
+	require.Contains(t, body, "Content-Type: text/calendar")
+	require.Contains(t, body, "method=REQUEST")
+
+	var buf bytes.Buffer
+
+	require.NoError(t, ical.NewEncoder(&buf).Encode(reply))
+
+	invite, err := proton.ReadCalendarInvite(&buf)
+	require.NoError(t, err)
+	require.Equal(t, proton.CalendarInviteMethodReply, invite.Method)
+	require.Equal(t, "event-uid", invite.Event.Props.Get(ical.PropUID).Value)
+
+	// Events without organizer can't be sent.
+	event.Props.Del(ical.PropOrganizer)
+
+	_, err = proton.NewCalendarInviteRequest(event)
+	require.Error(t, err)
+}
+
+func newStoredCalendarEvent(t *testing.T, id string, event *ical.Event, calKR, addrKR *crypto.KeyRing) proton.CalendarEvent {
+	req, err := proton.NewCreateCalendarEventReq(event, "memberID", calKR, addrKR)
+	require.NoError(t, err)
+
+	return proton.CalendarEvent{
+		ID:                id,
+		UID:               event.Props.Get(ical.PropUID).Value,
+		SharedKeyPacket:   req.Event.SharedKeyPacket,
+		CalendarKeyPacket: req.Event.CalendarKeyPacket,
+		SharedEvents:      req.Event.SharedEventContent,
+		CalendarEvents:    req.Event.CalendarEventContent,
+		AttendeesEvents:   req.Event.AttendeesEventContent,
+	}
+}
+
+func setCalendarEventAuthor(event *proton.CalendarEvent, author string) {
+	for _, parts := range [][]proton.CalendarEventPart{event.SharedEvents, event.CalendarEvents, event.AttendeesEvents} {
+		for i := range parts {
+			parts[i].Author = author
+		}
+	}
+}
+
+func withMemberID(part proton.CalendarEventPart, memberID string) proton.CalendarEventPart {
+	part.MemberID = memberID
+
+	return part
+}
+
+func newKeyRing(t *testing.T, name, email string) *crypto.KeyRing {
+	key, err := crypto.GenerateKey(name, email, "x25519", 0)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	return kr
+}
+
+func decodeCalendarEventPart(t *testing.T, part proton.CalendarEventPart, calKR, addrKR *crypto.KeyRing, kp []byte) *ical.Event {
+	data, err := part.DecodeData(calKR, addrKR, kp)
+	require.NoError(t, err)
+
+	cal, err := ical.NewDecoder(strings.NewReader(data)).Decode()
+	require.NoError(t, err)
+
+	events := cal.Events()
+	require.Len(t, events, 1)
+
+	return &events[0]
+}
@@ -2,8 +2,12 @@ package proton
 
 import (
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/emersion/go-ical"
 )
 
 type CalendarEvent struct {
@@ -33,6 +37,101 @@ type CalendarEvent struct {
 	PersonalEvents  []CalendarEventPart
 }
 
+// Merge decodes the parts of the event and merges them into a single VEVENT.
+// Only the personal part of the given member is merged, as the other ones are signed by other members.
+// Parts written by the address of addrKR are verified with it; parts written by other members can't be verified
+// without their keys, and are refused. Use MergeWithAuthors to merge them.
+func (event CalendarEvent) Merge(memberID string, calKR, addrKR *crypto.KeyRing) (*ical.Event, error) {
+	return event.MergeWithAuthors(memberID, calKR, addrKR, nil)
+}
+
+// MergeWithAuthors merges the event like Merge, verifying the parts written by other members with the keyrings
+// of authorKRs, keyed by lowercase email address (see Client.GetCalendarAuthorKeyRings).
+func (event CalendarEvent) MergeWithAuthors(memberID string, calKR, addrKR *crypto.KeyRing, authorKRs map[string]*crypto.KeyRing) (*ical.Event, error) {
+	sharedKP, err := decodeKeyPacket(event.SharedKeyPacket)
+	if err != nil {
+		return nil, err
+	}
+
+	calendarKP, err := decodeKeyPacket(event.CalendarKeyPacket)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := ical.NewEvent()
+
+	for _, part := range event.SharedEvents {
+		kr, err := part.getVerificationKeyRing(addrKR, authorKRs)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := part.merge(merged, calKR, kr, sharedKP); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, part := range event.CalendarEvents {
+		kr, err := part.getVerificationKeyRing(addrKR, authorKRs)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := part.merge(merged, calKR, kr, calendarKP); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, part := range event.PersonalEvents {
+		if part.MemberID != memberID {
+			continue
+		}
+
+		kr, err := part.getVerificationKeyRing(addrKR, authorKRs)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := part.merge(merged, calKR, kr, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, part := range event.AttendeesEvents {
+		kr, err := part.getVerificationKeyRing(addrKR, authorKRs)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := part.merge(merged, calKR, kr, sharedKP); err != nil {
+			return nil, err
+		}
+	}
+
+	// The status of the attendees is stored in clear, as attendees update it without rewriting the event.
+	for _, prop := range merged.Props.Values(ical.PropAttendee) {
+		token := prop.Params.Get(FieldPMToken)
+
+		for _, attendee := range event.Attendees {
+			if attendee.Token == token {
+				prop.Params.Set(ical.ParamParticipationStatus, attendee.Status.PartStat())
+			}
+		}
+
+		prop.Params.Del(FieldPMToken)
+	}
+
+	return merged, nil
+}
+
+func decodeKeyPacket(kp string) ([]byte, error) {
+	if kp == "" {
+		return nil, nil
+	}
+
+	return base64.StdEncoding.DecodeString(kp)
+}
+
 // TODO: Only personal events have MemberID; should we have a different type for that?
 type CalendarEventPart struct {
 	MemberID string
@@ -43,42 +142,129 @@ type CalendarEventPart struct {
 	Author    string
 }
 
+// Decode decrypts the part and verifies its signature with addrKR; kp is the key packet of the session key
+// the part is encrypted with. Use DecodeData to get the decoded content.
 func (part CalendarEventPart) Decode(calKR *crypto.KeyRing, addrKR *crypto.KeyRing, kp []byte) error {
-	if part.Type&CalendarEventTypeEncrypted != 0 {
-		var enc *crypto.PGPMessage
+	_, err := part.DecodeData(calKR, addrKR, kp)
+	return err
+}
 
-		if kp != nil {
-			raw, err := base64.StdEncoding.DecodeString(part.Data)
-			if err != nil {
-				return err
-			}
+// DecodeData decrypts the part and verifies its signature with addrKR, returning its decoded content;
+// kp is the key packet of the session key the part is encrypted with. Signed parts can't be decoded without addrKR.
+func (part CalendarEventPart) DecodeData(calKR *crypto.KeyRing, addrKR *crypto.KeyRing, kp []byte) (string, error) {
+	if part.Type&CalendarEventTypeSigned != 0 && addrKR == nil {
+		return "", errors.New("a keyring is needed to verify the signed calendar event part")
+	}
 
-			enc = crypto.NewPGPSplitMessage(kp, raw).GetPGPMessage()
-		} else {
-			var err error
+	data, err := part.decrypt(calKR, kp)
+	if err != nil {
+		return "", err
+	}
 
-			if enc, err = crypto.NewPGPMessageFromArmored(part.Data); err != nil {
-				return err
-			}
+	if part.Type&CalendarEventTypeSigned != 0 {
+		sig, err := crypto.NewPGPSignatureFromArmored(part.Signature)
+		if err != nil {
+			return "", err
 		}
 
-		dec, err := calKR.Decrypt(enc, nil, crypto.GetUnixTime())
-		if err != nil {
-			return err
+		if err := addrKR.VerifyDetached(crypto.NewPlainMessageFromString(data), sig, crypto.GetUnixTime()); err != nil {
+			return "", err
 		}
+	}
+
+	return data, nil
+}
+
+// DecodeUnverified decrypts the part without verifying its signature, returning its decoded content.
+// It is meant for parts written by members whose keys are not available; the content can't be trusted.
+func (part CalendarEventPart) DecodeUnverified(calKR *crypto.KeyRing, kp []byte) (string, error) {
+	return part.decrypt(calKR, kp)
+}
 
-		part.Data = dec.GetString()
+// decrypt returns the content of the part, decrypted with calKR if it is encrypted.
+func (part CalendarEventPart) decrypt(calKR *crypto.KeyRing, kp []byte) (string, error) {
+	if part.Type&CalendarEventTypeEncrypted == 0 {
+		return part.Data, nil
 	}
 
-	if part.Type&CalendarEventTypeSigned != 0 {
-		sig, err := crypto.NewPGPSignatureFromArmored(part.Signature)
+	var enc *crypto.PGPMessage
+
+	if kp != nil {
+		raw, err := base64.StdEncoding.DecodeString(part.Data)
 		if err != nil {
-			return err
+			return "", err
+		}
+
+		enc = crypto.NewPGPSplitMessage(kp, raw).GetPGPMessage()
+	} else {
+		var err error
+
+		if enc, err = crypto.NewPGPMessageFromArmored(part.Data); err != nil {
+			return "", err
+		}
+	}
+
+	dec, err := calKR.Decrypt(enc, nil, crypto.GetUnixTime())
+	if err != nil {
+		return "", err
+	}
+
+	return dec.GetString(), nil
+}
+
+// getVerificationKeyRing returns the keyring with which to verify the part: the address keyring if the part was
+// written by one of its addresses, or the keyring of its author otherwise. The author isn't signed, so parts of
+// authors whose keyring isn't known are refused rather than merged unverified.
+func (part CalendarEventPart) getVerificationKeyRing(addrKR *crypto.KeyRing, authorKRs map[string]*crypto.KeyRing) (*crypto.KeyRing, error) {
+	if part.isWrittenBy(addrKR) {
+		return addrKR, nil
+	}
+
+	if kr, ok := authorKRs[strings.ToLower(part.Author)]; ok {
+		return kr, nil
+	}
+
+	return nil, fmt.Errorf("no keyring to verify the calendar event part written by %v", part.Author)
+}
+
+// isWrittenBy returns whether the part was written by one of the addresses of the keyring.
+func (part CalendarEventPart) isWrittenBy(addrKR *crypto.KeyRing) bool {
+	if part.Author == "" {
+		return true
+	}
+
+	for _, identity := range addrKR.GetIdentities() {
+		if strings.EqualFold(identity.Email, part.Author) {
+			return true
 		}
+	}
+
+	return false
+}
+
+// merge decodes the part and adds its properties and components to the given event.
+// The part is verified with the keyring of its author.
+func (part CalendarEventPart) merge(event *ical.Event, calKR, authorKR *crypto.KeyRing, kp []byte) error {
+	data, err := part.DecodeData(calKR, authorKR, kp)
+	if err != nil {
+		return err
+	}
+
+	cal, err := ical.NewDecoder(strings.NewReader(data)).Decode()
+	if err != nil {
+		return err
+	}
 
-		if err := addrKR.VerifyDetached(crypto.NewPlainMessageFromString(part.Data), sig, crypto.GetUnixTime()); err != nil {
-			return err
+	for _, dec := range cal.Events() {
+		for name, props := range dec.Props {
+			if (name == ical.PropUID || name == ical.PropDateTimeStamp) && event.Props.Get(name) != nil {
+				continue
+			}
+
+			event.Props[name] = append(event.Props[name], props...)
 		}
+
+		event.Children = append(event.Children, dec.Children...)
 	}
 
 	return nil
@@ -108,3 +294,56 @@ const (
 	CalendarAttendeeStatusNo
 	CalendarAttendeeStatusYes
 )
+
+// PartStat returns the iCalendar participation status of the attendee.
+func (status CalendarAttendeeStatus) PartStat() string {
+	switch status {
+	case CalendarAttendeeStatusMaybe:
+		return "TENTATIVE"
+
+	case CalendarAttendeeStatusNo:
+		return "DECLINED"
+
+	case CalendarAttendeeStatusYes:
+		return "ACCEPTED"
+
+	default:
+		return "NEEDS-ACTION"
+	}
+}
+
+// CalendarEventData is the content of an event, as written by a member of the calendar.
+type CalendarEventData struct {
+	Permissions CalendarPermissions
+	IsOrganizer Bool
+
+	SharedKeyPacket    string
+	SharedEventContent []CalendarEventPart
+
+	CalendarKeyPacket    string `json:",omitempty"`
+	CalendarEventContent []CalendarEventPart
+
+	PersonalEventContent  *CalendarEventPart `json:",omitempty"`
+	AttendeesEventContent []CalendarEventPart
+	Attendees             []CalendarAttendeeData
+}
+
+type CalendarAttendeeData struct {
+	Token  string
+	Status CalendarAttendeeStatus
+}
+
+type CreateCalendarEventReq struct {
+	MemberID string
+	Event    CalendarEventData
+}
+
+type UpdateCalendarEventReq struct {
+	MemberID string
+	Event    CalendarEventData
+}
+
+type UpdateCalendarAttendeeReq struct {
+	Status     CalendarAttendeeStatus
+	UpdateTime int64
+}
@@ -0,0 +1,185 @@
+package proton
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/emersion/go-ical"
+	"golang.org/x/exp/slices"
+)
+
+// FreeBusyInterval is a period of time during which the user is busy.
+type FreeBusyInterval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// FreeBusy holds the busy intervals of a set of calendars, sorted by start time and merged when they overlap.
+type FreeBusy struct {
+	// Calendars holds the busy intervals of each calendar, keyed by calendar ID.
+	Calendars map[string][]FreeBusyInterval
+
+	// Busy holds the busy intervals of all the calendars together.
+	Busy []FreeBusyInterval
+
+	// Skipped holds the IDs of the events of each calendar that couldn't be decrypted or expanded, keyed by calendar ID.
+	// The user may be busy during these events, which are missing from the intervals.
+	Skipped map[string][]string
+}
+
+// GetFreeBusy returns the intervals between from and to during which the user is busy in the given calendars.
+// Each calendar is read by the member of the user's address it is shared with, whose keyring is given in addrKRs
+// keyed by address ID. Transparent and cancelled events don't make the user busy.
+// Floating times and all-day events are read in the location of from.
+// Events that can't be read are reported in the Skipped field rather than being taken as free time.
+func (c *Client) GetFreeBusy(
+	ctx context.Context,
+	calendarIDs []string,
+	addrKRs map[string]*crypto.KeyRing,
+	from, to time.Time,
+) (FreeBusy, error) {
+	addresses, err := c.GetAddresses(ctx)
+	if err != nil {
+		return FreeBusy{}, err
+	}
+
+	freeBusy := FreeBusy{
+		Calendars: make(map[string][]FreeBusyInterval),
+		Skipped:   make(map[string][]string),
+	}
+
+	for _, calendarID := range calendarIDs {
+		member, calKR, addrKR, err := c.unlockCalendar(ctx, calendarID, addresses, addrKRs)
+		if err != nil {
+			return FreeBusy{}, err
+		}
+
+		occurrences, skipped, err := c.GetCalendarOccurrences(ctx, calendarID, member.ID, calKR, addrKR, from, to, from.Location())
+		if err != nil {
+			return FreeBusy{}, err
+		}
+
+		if len(skipped) > 0 {
+			freeBusy.Skipped[calendarID] = skipped
+		}
+
+		var intervals []FreeBusyInterval
+
+		for _, occurrence := range occurrences {
+			if !isBusyCalendarOccurrence(occurrence) {
+				continue
+			}
+
+			intervals = append(intervals, FreeBusyInterval{Start: occurrence.Start, End: occurrence.End})
+		}
+
+		freeBusy.Calendars[calendarID] = mergeFreeBusyIntervals(intervals, from, to)
+		freeBusy.Busy = append(freeBusy.Busy, freeBusy.Calendars[calendarID]...)
+	}
+
+	freeBusy.Busy = mergeFreeBusyIntervals(freeBusy.Busy, from, to)
+
+	return freeBusy, nil
+}
+
+// unlockCalendar returns the member through which the user reads the calendar, the calendar keyring
+// and the keyring of the member's address.
+func (c *Client) unlockCalendar(
+	ctx context.Context,
+	calendarID string,
+	addresses []Address,
+	addrKRs map[string]*crypto.KeyRing,
+) (CalendarMember, *crypto.KeyRing, *crypto.KeyRing, error) {
+	members, err := c.GetCalendarMembers(ctx, calendarID)
+	if err != nil {
+		return CalendarMember{}, nil, nil, err
+	}
+
+	keys, err := c.GetCalendarKeys(ctx, calendarID)
+	if err != nil {
+		return CalendarMember{}, nil, nil, err
+	}
+
+	passphrase, err := c.GetCalendarPassphrase(ctx, calendarID)
+	if err != nil {
+		return CalendarMember{}, nil, nil, err
+	}
+
+	for _, member := range members {
+		for _, address := range addresses {
+			addrKR, ok := addrKRs[address.ID]
+			if !ok || !strings.EqualFold(member.Email, address.Email) {
+				continue
+			}
+
+			calPass, err := passphrase.Decrypt(member.ID, addrKR)
+			if err != nil {
+				return CalendarMember{}, nil, nil, err
+			}
+
+			calKR, err := keys.Unlock(calPass)
+			if err != nil {
+				return CalendarMember{}, nil, nil, err
+			}
+
+			return member, calKR, addrKR, nil
+		}
+	}
+
+	return CalendarMember{}, nil, nil, fmt.Errorf("no unlocked address is a member of calendar %v", calendarID)
+}
+
+// isBusyCalendarOccurrence returns whether the occurrence makes the user busy,
+// i.e. it takes time (TRANSP:OPAQUE, the default) and it isn't cancelled.
+func isBusyCalendarOccurrence(occurrence CalendarOccurrence) bool {
+	if prop := occurrence.Event.Props.Get(ical.PropTransparency); prop != nil && strings.EqualFold(prop.Value, "TRANSPARENT") {
+		return false
+	}
+
+	if prop := occurrence.Event.Props.Get(ical.PropStatus); prop != nil && strings.EqualFold(prop.Value, string(ical.EventCancelled)) {
+		return false
+	}
+
+	return occurrence.End.After(occurrence.Start)
+}
+
+// mergeFreeBusyIntervals returns the given intervals clipped to [from, to), sorted by start time,
+// with overlapping and adjacent intervals merged.
+func mergeFreeBusyIntervals(intervals []FreeBusyInterval, from, to time.Time) []FreeBusyInterval {
+	intervals = slices.Clone(intervals)
+
+	slices.SortFunc(intervals, func(a, b FreeBusyInterval) bool {
+		return a.Start.Before(b.Start)
+	})
+
+	var merged []FreeBusyInterval
+
+	for _, interval := range intervals {
+		if interval.Start.Before(from) {
+			interval.Start = from
+		}
+
+		if interval.End.After(to) {
+			interval.End = to
+		}
+
+		if !interval.End.After(interval.Start) {
+			continue
+		}
+
+		if n := len(merged); n > 0 && !interval.Start.After(merged[n-1].End) {
+			if interval.End.After(merged[n-1].End) {
+				merged[n-1].End = interval.End
+			}
+
+			continue
+		}
+
+		merged = append(merged, interval)
+	}
+
+	return merged
+}
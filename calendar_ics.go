@@ -0,0 +1,489 @@
+package proton
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/emersion/go-ical"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// FieldWRCalName is the calendar property holding the name of the calendar, as understood by most clients.
+const FieldWRCalName = "X-WR-CALNAME"
+
+// ExportCalendar returns the events of the calendar, as read by the given member, as an iCalendar object.
+func (c *Client) ExportCalendar(ctx context.Context, calendarID, memberID string, calKR, addrKR *crypto.KeyRing) (*ical.Calendar, error) {
+	calendar, err := c.GetCalendar(ctx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := c.GetAllCalendarEvents(ctx, calendarID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	authorKRs, err := c.GetCalendarAuthorKeyRings(ctx, events, addrKR)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCalendarExport(calendar.Name, events, memberID, calKR, addrKR, authorKRs)
+}
+
+// ImportCalendar creates the events of the given iCalendar object in the calendar.
+// Events whose UID (and recurrence ID, for modified occurrences) is already in the calendar are skipped.
+// Times in timezones defined by the VTIMEZONE components of the object are resolved with them.
+// It returns the created events, along with any error that stopped the import part-way through.
+func (c *Client) ImportCalendar(ctx context.Context, calendarID, memberID string, cal *ical.Calendar, calKR, addrKR *crypto.KeyRing) ([]CalendarEvent, error) {
+	existing, err := c.GetAllCalendarEvents(ctx, calendarID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(existing))
+
+	for _, event := range existing {
+		seen[event.getImportKey()] = struct{}{}
+	}
+
+	timezones := make(map[string]*ical.Component)
+
+	for _, child := range cal.Children {
+		if child.Name == ical.CompTimezone {
+			if tzid, err := child.Props.Text(ical.PropTimezoneID); err == nil && tzid != "" {
+				timezones[tzid] = child
+			}
+		}
+	}
+
+	var created []CalendarEvent
+
+	for _, event := range cal.Events() {
+		// The key is read from the resolved event, as the times of stored events are resolved too.
+		event, err := resolveCalendarTimezones(event, timezones)
+		if err != nil {
+			return created, err
+		}
+
+		key := getCalendarImportKey(event.Component)
+
+		if _, ok := seen[key]; ok {
+			continue
+		}
+
+		req, err := NewCreateCalendarEventReq(event, memberID, calKR, addrKR)
+		if err != nil {
+			return created, err
+		}
+
+		res, err := c.CreateCalendarEvent(ctx, calendarID, req)
+		if err != nil {
+			return created, err
+		}
+
+		seen[key] = struct{}{}
+
+		created = append(created, res)
+	}
+
+	return created, nil
+}
+
+// NewCalendarExport merges the given events into an iCalendar object.
+// The object holds a VTIMEZONE component for each timezone the events start or end in.
+// Events with parts written by other members can't be verified, and are refused; see Client.ExportCalendar.
+func NewCalendarExport(name string, events []CalendarEvent, memberID string, calKR, addrKR *crypto.KeyRing) (*ical.Calendar, error) {
+	return newCalendarExport(name, events, memberID, calKR, addrKR, nil)
+}
+
+// newCalendarExport merges the given events into an iCalendar object like NewCalendarExport,
+// verifying the parts written by other members with authorKRs.
+func newCalendarExport(name string, events []CalendarEvent, memberID string, calKR, addrKR *crypto.KeyRing, authorKRs map[string]*crypto.KeyRing) (*ical.Calendar, error) {
+	cal := ical.NewCalendar()
+
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, CalendarProductID)
+
+	if name != "" {
+		cal.Props.SetText(FieldWRCalName, name)
+	}
+
+	// The range of time covered by the events in each timezone.
+	ranges := make(map[string][2]time.Time)
+
+	for _, event := range events {
+		for _, tz := range []struct {
+			tzid string
+			time int64
+		}{
+			{event.StartTimezone, event.StartTime},
+			{event.EndTimezone, event.EndTime},
+		} {
+			if tz.tzid == "" || tz.tzid == "UTC" {
+				continue
+			}
+
+			t := time.Unix(tz.time, 0)
+
+			if r, ok := ranges[tz.tzid]; !ok {
+				ranges[tz.tzid] = [2]time.Time{t, t}
+			} else {
+				ranges[tz.tzid] = [2]time.Time{minTime(r[0], t), maxTime(r[1], t)}
+			}
+		}
+	}
+
+	tzids := maps.Keys(ranges)
+
+	slices.Sort(tzids)
+
+	for _, tzid := range tzids {
+		tz, err := newCalendarTimezone(tzid, ranges[tzid][0], ranges[tzid][1])
+		if err != nil {
+			return nil, err
+		}
+
+		cal.Children = append(cal.Children, tz)
+	}
+
+	for _, event := range events {
+		merged, err := event.MergeWithAuthors(memberID, calKR, addrKR, authorKRs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge event %v: %w", event.ID, err)
+		}
+
+		cal.Children = append(cal.Children, merged.Component)
+	}
+
+	return cal, nil
+}
+
+// newCalendarTimezone returns a VTIMEZONE component describing the IANA timezone with the given ID
+// from the start of the year of start to the end of the year of end.
+func newCalendarTimezone(tzid string, start, end time.Time) (*ical.Component, error) {
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return nil, err
+	}
+
+	comp := ical.NewComponent(ical.CompTimezone)
+
+	comp.Props.SetText(ical.PropTimezoneID, tzid)
+
+	from := time.Date(start.In(loc).Year(), time.January, 1, 0, 0, 0, 0, loc)
+	to := time.Date(end.In(loc).Year()+1, time.January, 1, 0, 0, 0, 0, loc)
+
+	_, offset := from.Zone()
+
+	// The observance in effect at the start of the range.
+	comp.Children = append(comp.Children, newCalendarTimezoneObservance(from, offset))
+
+	// The observances that start within the range; offset changes are searched day by day, then to the second.
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+
+		if _, nextOffset := next.Zone(); nextOffset == offset {
+			continue
+		}
+
+		lo, hi := day, next
+
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+
+			if _, midOffset := mid.Zone(); midOffset == offset {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+
+		comp.Children = append(comp.Children, newCalendarTimezoneObservance(hi, offset))
+
+		_, offset = hi.Zone()
+	}
+
+	return comp, nil
+}
+
+// newCalendarTimezoneObservance returns the STANDARD or DAYLIGHT component starting at the given time.
+// Its start is expressed in the local time of the offset in effect before it.
+func newCalendarTimezoneObservance(t time.Time, offsetFrom int) *ical.Component {
+	name, offsetTo := t.Zone()
+
+	var comp *ical.Component
+
+	if t.IsDST() {
+		comp = ical.NewComponent(ical.CompTimezoneDaylight)
+	} else {
+		comp = ical.NewComponent(ical.CompTimezoneStandard)
+	}
+
+	dtstart := ical.NewProp(ical.PropDateTimeStart)
+	dtstart.Value = t.In(time.FixedZone("", offsetFrom)).Format("20060102T150405")
+	comp.Props.Set(dtstart)
+
+	comp.Props.SetText(ical.PropTimezoneName, name)
+
+	for prop, offset := range map[string]int{
+		ical.PropTimezoneOffsetFrom: offsetFrom,
+		ical.PropTimezoneOffsetTo:   offsetTo,
+	} {
+		p := ical.NewProp(prop)
+		p.Value = formatUTCOffset(offset)
+		comp.Props.Set(p)
+	}
+
+	return comp
+}
+
+// formatUTCOffset formats the given offset in seconds as an iCalendar UTC offset, e.g. +0100.
+func formatUTCOffset(offset int) string {
+	sign := '+'
+
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+
+	if s := offset % 60; s != 0 {
+		return fmt.Sprintf("%c%02d%02d%02d", sign, offset/3600, offset/60%60, s)
+	}
+
+	return fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset/60%60)
+}
+
+// resolveCalendarTimezones returns a copy of the event whose times in timezones unknown to Go are resolved with
+// the given VTIMEZONE components: they are moved to the IANA timezone the definition is named after if there is one,
+// or converted to UTC otherwise.
+func resolveCalendarTimezones(event ical.Event, timezones map[string]*ical.Component) (*ical.Event, error) {
+	resolved := ical.NewEvent()
+
+	resolved.Children = event.Children
+
+	for name, props := range event.Props {
+		resolved.Props[name] = slices.Clone(props)
+	}
+
+	for _, name := range []string{
+		ical.PropDateTimeStart,
+		ical.PropDateTimeEnd,
+		ical.PropRecurrenceID,
+		ical.PropRecurrenceDates,
+		ical.PropExceptionDates,
+	} {
+		for i := range resolved.Props[name] {
+			prop := &resolved.Props[name][i]
+
+			tzid := prop.Params.Get(ical.PropTimezoneID)
+			if tzid == "" {
+				continue
+			}
+
+			if _, err := time.LoadLocation(tzid); err == nil {
+				continue
+			}
+
+			tz, ok := timezones[tzid]
+			if !ok {
+				return nil, fmt.Errorf("unknown timezone %q", tzid)
+			}
+
+			prop.Params = maps.Clone(prop.Params)
+
+			if loc := getCalendarTimezoneLocation(tz); loc != "" {
+				prop.Params.Set(ical.PropTimezoneID, loc)
+				continue
+			}
+
+			values := strings.Split(prop.Value, ",")
+
+			for j, value := range values {
+				local, err := time.ParseInLocation("20060102T150405", value, time.UTC)
+				if err != nil {
+					return nil, fmt.Errorf("invalid time %q in timezone %q: %w", value, tzid, err)
+				}
+
+				offset, err := getCalendarTimezoneOffset(tz, local)
+				if err != nil {
+					return nil, err
+				}
+
+				values[j] = local.Add(-offset).Format("20060102T150405Z")
+			}
+
+			prop.Value = strings.Join(values, ",")
+			prop.Params.Del(ical.PropTimezoneID)
+		}
+	}
+
+	return resolved, nil
+}
+
+// getCalendarTimezoneLocation returns the IANA timezone the VTIMEZONE component is named after, if any,
+// either with the X-LIC-LOCATION property or at the end of its TZID, such as in /mozilla.org/20050126_1/Europe/Berlin.
+func getCalendarTimezoneLocation(tz *ical.Component) string {
+	var names []string
+
+	if prop := tz.Props.Get("X-LIC-LOCATION"); prop != nil {
+		names = append(names, prop.Value)
+	}
+
+	if prop := tz.Props.Get(ical.PropTimezoneID); prop != nil {
+		for i, c := range prop.Value {
+			if c == '/' {
+				names = append(names, prop.Value[i+1:])
+			}
+		}
+	}
+
+	for _, name := range names {
+		if !strings.Contains(name, "/") {
+			continue
+		}
+
+		if _, err := time.LoadLocation(name); err == nil {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// getCalendarTimezoneOffset returns the UTC offset in effect at the given local time, as defined by the VTIMEZONE
+// component: the offset of its STANDARD or DAYLIGHT observance which started last before that time.
+func getCalendarTimezoneOffset(tz *ical.Component, local time.Time) (time.Duration, error) {
+	var (
+		onset  time.Time
+		offset time.Duration
+		found  bool
+	)
+
+	for _, observance := range tz.Children {
+		if observance.Name != ical.CompTimezoneStandard && observance.Name != ical.CompTimezoneDaylight {
+			continue
+		}
+
+		prop := observance.Props.Get(ical.PropTimezoneOffsetTo)
+		if prop == nil {
+			return 0, fmt.Errorf("timezone observance has no %v", ical.PropTimezoneOffsetTo)
+		}
+
+		offsetTo, err := parseUTCOffset(prop.Value)
+		if err != nil {
+			return 0, err
+		}
+
+		// Onsets are compared with the local time as they are, in the local time in effect before them.
+		start, err := observance.Props.DateTime(ical.PropDateTimeStart, time.UTC)
+		if err != nil {
+			return 0, err
+		}
+
+		if start.After(local) {
+			continue
+		}
+
+		if observance.Props.Get(ical.PropRecurrenceRule) != nil {
+			set, err := observance.RecurrenceSet(time.UTC)
+			if err != nil {
+				return 0, err
+			}
+
+			if last := set.Before(local, true); !last.IsZero() {
+				start = last
+			}
+		}
+
+		if !found || start.After(onset) {
+			onset, offset, found = start, offsetTo, true
+		}
+	}
+
+	if !found {
+		return 0, fmt.Errorf("no timezone observance in effect at %v", local.Format("20060102T150405"))
+	}
+
+	return offset, nil
+}
+
+// parseUTCOffset parses an iCalendar UTC offset, e.g. +0100.
+func parseUTCOffset(value string) (time.Duration, error) {
+	if len(value) != 5 && len(value) != 7 || (value[0] != '+' && value[0] != '-') {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+
+	digits := value[1:]
+
+	if len(digits) == 4 {
+		digits += "00"
+	}
+
+	t, err := time.Parse("150405", digits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid UTC offset %q: %w", value, err)
+	}
+
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if value[0] == '-' {
+		offset = -offset
+	}
+
+	return offset, nil
+}
+
+// getImportKey returns the key identifying the event when importing, read from its signed shared part.
+func (event CalendarEvent) getImportKey() string {
+	for _, part := range event.SharedEvents {
+		if part.Type&CalendarEventTypeEncrypted != 0 {
+			continue
+		}
+
+		cal, err := ical.NewDecoder(strings.NewReader(part.Data)).Decode()
+		if err != nil {
+			continue
+		}
+
+		for _, event := range cal.Events() {
+			return getCalendarImportKey(event.Component)
+		}
+	}
+
+	return event.UID
+}
+
+// getCalendarImportKey returns the UID of the event, followed by its recurrence ID if it is a modified occurrence.
+func getCalendarImportKey(event *ical.Component) string {
+	var key string
+
+	if prop := event.Props.Get(ical.PropUID); prop != nil {
+		key = prop.Value
+	}
+
+	if prop := event.Props.Get(ical.PropRecurrenceID); prop != nil {
+		key += "/" + prop.Value
+	}
+
+	return key
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
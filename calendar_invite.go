@@ -0,0 +1,419 @@
+package proton
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gluon/rfc822"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-message"
+	"golang.org/x/exp/maps"
+)
+
+// GetMessageCalendarInvite returns the calendar invitation the message holds,
+// read from its .ics attachment or, for PGP/MIME messages, from its body.
+func (c *Client) GetMessageCalendarInvite(ctx context.Context, messageID string, addrKR *crypto.KeyRing) (CalendarInvite, error) {
+	msg, err := c.GetMessage(ctx, messageID)
+	if err != nil {
+		return CalendarInvite{}, err
+	}
+
+	for _, att := range msg.Attachments {
+		if !isCalendarInviteAttachment(att) {
+			continue
+		}
+
+		data, err := c.GetAttachment(ctx, att.ID)
+		if err != nil {
+			return CalendarInvite{}, err
+		}
+
+		kps, err := base64.StdEncoding.DecodeString(att.KeyPackets)
+		if err != nil {
+			return CalendarInvite{}, err
+		}
+
+		dec, err := addrKR.Decrypt(crypto.NewPGPSplitMessage(kps, data).GetPGPMessage(), nil, crypto.GetUnixTime())
+		if err != nil {
+			return CalendarInvite{}, err
+		}
+
+		return ReadCalendarInvite(bytes.NewReader(dec.GetBinary()))
+	}
+
+	if msg.MIMEType == rfc822.MultipartMixed {
+		dec, err := msg.Decrypt(addrKR)
+		if err != nil {
+			return CalendarInvite{}, err
+		}
+
+		return readCalendarInviteMIME(bytes.NewReader(dec))
+	}
+
+	return CalendarInvite{}, ErrNoCalendarInvite
+}
+
+// ApplyCalendarInvite applies the invitation sent by the given address to the calendar, as read by the given member:
+//   - a request from the organizer creates the event, or updates it if it is already in the calendar
+//     with the same organizer and a sequence number that isn't newer;
+//   - a reply updates the status of the attendee who sent it;
+//   - a cancellation from the organizer deletes the event, including its modified occurrences if the whole event is cancelled;
+//     a cancelled occurrence that isn't modified in the calendar is excluded from the recurring event instead.
+//
+// The modified occurrences sent along with the event are applied in the same way.
+func (c *Client) ApplyCalendarInvite(
+	ctx context.Context,
+	calendarID, memberID, sender string,
+	invite CalendarInvite,
+	calKR, addrKR *crypto.KeyRing,
+) error {
+	if err := c.applyCalendarInviteEvent(ctx, calendarID, memberID, sender, invite, calKR, addrKR); err != nil {
+		return err
+	}
+
+	// Cancelling the whole event already deleted its modified occurrences.
+	if invite.Method == CalendarInviteMethodCancel && invite.Event.Props.Get(ical.PropRecurrenceID) == nil {
+		return nil
+	}
+
+	for _, override := range invite.Overrides {
+		if err := c.applyCalendarInviteEvent(ctx, calendarID, memberID, sender, CalendarInvite{
+			Method:   invite.Method,
+			Calendar: invite.Calendar,
+			Event:    override,
+		}, calKR, addrKR); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyCalendarInviteEvent applies the event of the invitation, ignoring its overrides.
+// The stored events are read again for each event, as applying the previous ones may have changed them.
+func (c *Client) applyCalendarInviteEvent(
+	ctx context.Context,
+	calendarID, memberID, sender string,
+	invite CalendarInvite,
+	calKR, addrKR *crypto.KeyRing,
+) error {
+	uid, err := invite.Event.Props.Text(ical.PropUID)
+	if err != nil {
+		return err
+	} else if uid == "" {
+		return errors.New("invitation event has no UID")
+	}
+
+	events, err := c.GetAllCalendarEvents(ctx, calendarID, url.Values{"UID": {uid}})
+	if err != nil {
+		return err
+	}
+
+	key := getCalendarImportKey(invite.Event.Component)
+
+	var existing *CalendarEvent
+
+	for i := range events {
+		if events[i].getImportKey() == key {
+			existing = &events[i]
+		}
+	}
+
+	switch invite.Method {
+	case CalendarInviteMethodRequest:
+		return c.applyCalendarInviteRequest(ctx, calendarID, memberID, sender, invite, existing, calKR, addrKR)
+
+	case CalendarInviteMethodReply:
+		if existing == nil {
+			return fmt.Errorf("no event with UID %v in the calendar", uid)
+		}
+
+		return c.applyCalendarInviteReply(ctx, calendarID, uid, sender, invite, *existing)
+
+	case CalendarInviteMethodCancel:
+		if existing == nil && invite.Event.Props.Get(ical.PropRecurrenceID) != nil {
+			return c.applyCalendarInviteOccurrenceCancel(ctx, calendarID, memberID, sender, uid, invite, events, calKR, addrKR)
+		}
+
+		for _, event := range events {
+			if event.getImportKey() != key && invite.Event.Props.Get(ical.PropRecurrenceID) != nil {
+				continue
+			}
+
+			stored, err := c.mergeCalendarEvent(ctx, event, memberID, calKR, addrKR)
+			if err != nil {
+				return err
+			}
+
+			if err := checkCalendarInviteOrganizer(invite, sender, stored); err != nil {
+				return err
+			}
+
+			if err := c.DeleteCalendarEvent(ctx, calendarID, event.ID, memberID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported invitation method %v", invite.Method)
+	}
+}
+
+// applyCalendarInviteOccurrenceCancel excludes the cancelled occurrence from the recurring event it belongs to,
+// for occurrences that aren't modified in the calendar. Nothing is done if the recurring event isn't in the calendar.
+func (c *Client) applyCalendarInviteOccurrenceCancel(
+	ctx context.Context,
+	calendarID, memberID, sender, uid string,
+	invite CalendarInvite,
+	events []CalendarEvent,
+	calKR, addrKR *crypto.KeyRing,
+) error {
+	// The recurring event is the one whose import key is its bare UID, without a recurrence ID.
+	idx := xslices.IndexFunc(events, func(event CalendarEvent) bool {
+		return event.getImportKey() == uid
+	})
+	if idx < 0 {
+		return nil
+	}
+
+	master, err := c.mergeCalendarEvent(ctx, events[idx], memberID, calKR, addrKR)
+	if err != nil {
+		return err
+	}
+
+	if err := checkCalendarInviteOrganizer(invite, sender, master); err != nil {
+		return err
+	}
+
+	recurrenceID := invite.Event.Props.Get(ical.PropRecurrenceID)
+
+	exdate := ical.NewProp(ical.PropExceptionDates)
+	exdate.Value = recurrenceID.Value
+	exdate.Params = maps.Clone(recurrenceID.Params)
+	exdate.Params.Del("RANGE")
+
+	master.Props.Add(exdate)
+
+	req, err := NewUpdateCalendarEventReq(master, events[idx], memberID, calKR, addrKR)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.UpdateCalendarEvent(ctx, calendarID, events[idx].ID, req)
+
+	return err
+}
+
+func (c *Client) applyCalendarInviteRequest(
+	ctx context.Context,
+	calendarID, memberID, sender string,
+	invite CalendarInvite,
+	existing *CalendarEvent,
+	calKR, addrKR *crypto.KeyRing,
+) error {
+	if existing == nil {
+		if err := checkCalendarInviteOrganizer(invite, sender, nil); err != nil {
+			return err
+		}
+
+		req, err := NewCreateCalendarEventReq(invite.Event, memberID, calKR, addrKR)
+		if err != nil {
+			return err
+		}
+
+		_, err = c.CreateCalendarEvent(ctx, calendarID, req)
+
+		return err
+	}
+
+	stored, err := c.mergeCalendarEvent(ctx, *existing, memberID, calKR, addrKR)
+	if err != nil {
+		return err
+	}
+
+	if err := checkCalendarInviteOrganizer(invite, sender, stored); err != nil {
+		return err
+	}
+
+	sequence, err := getCalendarEventSequence(invite.Event)
+	if err != nil {
+		return err
+	}
+
+	storedSequence, err := getCalendarEventSequence(stored)
+	if err != nil {
+		return err
+	}
+
+	if sequence < storedSequence {
+		return fmt.Errorf("invitation sequence %v is older than the sequence %v of the event in the calendar", sequence, storedSequence)
+	}
+
+	req, err := NewUpdateCalendarEventReq(invite.Event, *existing, memberID, calKR, addrKR)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.UpdateCalendarEvent(ctx, calendarID, existing.ID, req)
+
+	return err
+}
+
+// applyCalendarInviteReply updates the status of the attendee who sent the reply; the answers it may hold for others are ignored.
+func (c *Client) applyCalendarInviteReply(ctx context.Context, calendarID, uid, sender string, invite CalendarInvite, event CalendarEvent) error {
+	prop := getCalendarAttendeeProp(invite.Event, sender)
+	if prop == nil {
+		return fmt.Errorf("reply holds no answer of its sender %v", sender)
+	}
+
+	token := GetCalendarAttendeeToken(uid, getCalendarAddress(prop.Value))
+
+	idx := xslices.IndexFunc(event.Attendees, func(attendee CalendarAttendee) bool {
+		return attendee.Token == token
+	})
+	if idx < 0 {
+		return fmt.Errorf("%v is not an attendee of the event", getCalendarAddress(prop.Value))
+	}
+
+	_, err := c.UpdateCalendarAttendee(ctx, calendarID, event.ID, event.Attendees[idx].ID, UpdateCalendarAttendeeReq{
+		Status:     getCalendarAttendeeStatus(prop.Params.Get(ical.ParamParticipationStatus)),
+		UpdateTime: time.Now().Unix(),
+	})
+
+	return err
+}
+
+// checkCalendarInviteOrganizer returns an error if the invitation isn't sent by the organizer of its event
+// or, if the event is already in the calendar, if it is stored with another organizer.
+func checkCalendarInviteOrganizer(invite CalendarInvite, sender string, stored *ical.Event) error {
+	organizer := invite.Event.Props.Get(ical.PropOrganizer)
+	if organizer == nil {
+		return errors.New("invitation event has no organizer")
+	}
+
+	if !strings.EqualFold(getCalendarAddress(organizer.Value), sender) {
+		return fmt.Errorf("invitation is sent by %v, not by the organizer %v", sender, getCalendarAddress(organizer.Value))
+	}
+
+	if stored == nil {
+		return nil
+	}
+
+	if prop := stored.Props.Get(ical.PropOrganizer); prop == nil || getCalendarAddress(prop.Value) != getCalendarAddress(organizer.Value) {
+		return fmt.Errorf("event in the calendar is not organized by %v", getCalendarAddress(organizer.Value))
+	}
+
+	return nil
+}
+
+// ReadCalendarInvite reads an invitation from the given iCalendar object.
+func ReadCalendarInvite(r io.Reader) (CalendarInvite, error) {
+	cal, err := ical.NewDecoder(r).Decode()
+	if err != nil {
+		return CalendarInvite{}, err
+	}
+
+	method, err := cal.Props.Text(ical.PropMethod)
+	if err != nil {
+		return CalendarInvite{}, err
+	} else if method == "" {
+		return CalendarInvite{}, errors.New("invitation has no method")
+	}
+
+	events := cal.Events()
+	if len(events) == 0 {
+		return CalendarInvite{}, ErrNoCalendarInvite
+	}
+
+	// The invitation is about the recurring event if it holds it, and about its first event otherwise.
+	idx := xslices.IndexFunc(events, func(event ical.Event) bool {
+		return event.Props.Get(ical.PropRecurrenceID) == nil
+	})
+	if idx < 0 {
+		idx = 0
+	}
+
+	invite := CalendarInvite{
+		Method:   CalendarInviteMethod(strings.ToUpper(method)),
+		Calendar: cal,
+		Event:    &events[idx],
+	}
+
+	uid, err := events[idx].Props.Text(ical.PropUID)
+	if err != nil {
+		return CalendarInvite{}, err
+	}
+
+	for i := range events {
+		if i == idx || events[i].Props.Get(ical.PropRecurrenceID) == nil {
+			continue
+		}
+
+		if other, err := events[i].Props.Text(ical.PropUID); err == nil && other == uid {
+			invite.Overrides = append(invite.Overrides, &events[i])
+		}
+	}
+
+	return invite, nil
+}
+
+// readCalendarInviteMIME reads the invitation from the first calendar part of the given MIME message.
+func readCalendarInviteMIME(r io.Reader) (CalendarInvite, error) {
+	entity, err := message.Read(r)
+	if err != nil && !message.IsUnknownCharset(err) {
+		return CalendarInvite{}, err
+	}
+
+	var (
+		invite CalendarInvite
+		found  bool
+	)
+
+	if err := entity.Walk(func(_ []int, part *message.Entity, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if mediaType, _, err := part.Header.ContentType(); found || err != nil || !isCalendarInviteMIMEType(mediaType) {
+			return nil
+		}
+
+		found = true
+
+		invite, err = ReadCalendarInvite(part.Body)
+
+		return err
+	}); err != nil {
+		return CalendarInvite{}, err
+	}
+
+	if !found {
+		return CalendarInvite{}, ErrNoCalendarInvite
+	}
+
+	return invite, nil
+}
+
+func isCalendarInviteAttachment(att Attachment) bool {
+	if mediaType, _, err := mime.ParseMediaType(string(att.MIMEType)); err == nil && isCalendarInviteMIMEType(mediaType) {
+		return true
+	}
+
+	return strings.HasSuffix(strings.ToLower(att.Name), ".ics")
+}
+
+func isCalendarInviteMIMEType(mediaType string) bool {
+	return strings.EqualFold(mediaType, "text/calendar") || strings.EqualFold(mediaType, "application/ics")
+}
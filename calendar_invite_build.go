@@ -0,0 +1,318 @@
+package proton
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gluon/rfc822"
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-message"
+	"golang.org/x/exp/slices"
+)
+
+// CalendarInviteFilename is the name of the file holding an invitation attached to a message.
+const CalendarInviteFilename = "invite.ics"
+
+// NewCalendarInviteRequest returns the invitation to the event to send to its attendees (METHOD:REQUEST).
+// Attendees that haven't answered yet are asked to.
+func NewCalendarInviteRequest(event *ical.Event) (*ical.Calendar, error) {
+	if err := checkCalendarInviteEvent(event); err != nil {
+		return nil, err
+	}
+
+	comp := newCalendarInviteComponent(event, nil)
+
+	for i := range comp.Props[ical.PropAttendee] {
+		attendee := &comp.Props[ical.PropAttendee][i]
+
+		if attendee.Params.Get(ical.ParamParticipationStatus) == "" {
+			attendee.Params.Set(ical.ParamParticipationStatus, CalendarAttendeeStatusPending.PartStat())
+		}
+
+		if attendee.Params.Get(ical.ParamRSVP) == "" {
+			attendee.Params.Set(ical.ParamRSVP, "TRUE")
+		}
+	}
+
+	return newCalendarInvite(CalendarInviteMethodRequest, comp)
+}
+
+// NewCalendarInviteReply returns the answer of the attendee with the given email to the invitation to the event (METHOD:REPLY).
+func NewCalendarInviteReply(event *ical.Event, email string, status CalendarAttendeeStatus) (*ical.Calendar, error) {
+	if err := checkCalendarInviteEvent(event); err != nil {
+		return nil, err
+	}
+
+	attendee := getCalendarAttendeeProp(event, email)
+	if attendee == nil {
+		return nil, fmt.Errorf("%v is not an attendee of the event", email)
+	}
+
+	comp := newCalendarInviteComponent(event, []string{
+		ical.PropRecurrenceID,
+		ical.PropSequence,
+		ical.PropOrganizer,
+		ical.PropDateTimeStart,
+		ical.PropDateTimeEnd,
+		ical.PropDuration,
+		ical.PropSummary,
+	})
+
+	reply := cloneCalendarProp(*attendee)
+
+	reply.Params.Set(ical.ParamParticipationStatus, status.PartStat())
+	reply.Params.Del(ical.ParamRSVP)
+
+	comp.Props.Add(&reply)
+
+	return newCalendarInvite(CalendarInviteMethodReply, comp)
+}
+
+// NewCalendarInviteCancel returns the cancellation of the event to send to its attendees (METHOD:CANCEL).
+// The sequence number of the event is incremented, as for any change the organizer makes to the event.
+func NewCalendarInviteCancel(event *ical.Event) (*ical.Calendar, error) {
+	if err := checkCalendarInviteEvent(event); err != nil {
+		return nil, err
+	}
+
+	comp := newCalendarInviteComponent(event, []string{
+		ical.PropRecurrenceID,
+		ical.PropOrganizer,
+		ical.PropAttendee,
+		ical.PropDateTimeStart,
+		ical.PropDateTimeEnd,
+		ical.PropDuration,
+		ical.PropSummary,
+	})
+
+	sequence, err := getCalendarEventSequence(event)
+	if err != nil {
+		return nil, err
+	}
+
+	comp.Props.Set(&ical.Prop{Name: ical.PropSequence, Params: make(ical.Params), Value: strconv.Itoa(sequence + 1)})
+	comp.Props.SetText(ical.PropStatus, string(ical.EventCancelled))
+
+	return newCalendarInvite(CalendarInviteMethodCancel, comp)
+}
+
+// NewCalendarInviteAttachmentReq returns the request to attach the invitation to the given draft, as created with CreateDraft.
+func NewCalendarInviteAttachmentReq(messageID string, cal *ical.Calendar) (CreateAttachmentReq, error) {
+	body, mimeType, err := encodeCalendarInvite(cal)
+	if err != nil {
+		return CreateAttachmentReq{}, err
+	}
+
+	return CreateAttachmentReq{
+		MessageID:   messageID,
+		Filename:    CalendarInviteFilename,
+		MIMEType:    mimeType,
+		Disposition: AttachmentDisposition,
+		Body:        body,
+	}, nil
+}
+
+// NewCalendarInviteMIMEBody returns a multipart/mixed MIME body holding the given text and the invitation,
+// to be sent with AddMIMEPackage.
+func NewCalendarInviteMIMEBody(text string, cal *ical.Calendar) (string, error) {
+	body, mimeType, err := encodeCalendarInvite(cal)
+	if err != nil {
+		return "", err
+	}
+
+	var header message.Header
+
+	header.SetContentType(string(rfc822.MultipartMixed), nil)
+
+	buf := new(bytes.Buffer)
+
+	w, err := message.CreateWriter(buf, header)
+	if err != nil {
+		return "", err
+	}
+
+	var textHeader message.Header
+
+	textHeader.SetContentType(string(rfc822.TextPlain), map[string]string{"charset": "utf-8"})
+	textHeader.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	if err := writeCalendarInvitePart(w, textHeader, []byte(text)); err != nil {
+		return "", err
+	}
+
+	var inviteHeader message.Header
+
+	inviteHeader.Set("Content-Type", string(mimeType))
+	inviteHeader.SetContentDisposition(string(AttachmentDisposition), map[string]string{"filename": CalendarInviteFilename})
+	inviteHeader.Set("Content-Transfer-Encoding", "base64")
+
+	if err := writeCalendarInvitePart(w, inviteHeader, body); err != nil {
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func writeCalendarInvitePart(w *message.Writer, header message.Header, body []byte) error {
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	if _, err := part.Write(body); err != nil {
+		return err
+	}
+
+	return part.Close()
+}
+
+// encodeCalendarInvite returns the invitation as a text/calendar file and its MIME type, which holds its method.
+func encodeCalendarInvite(cal *ical.Calendar) ([]byte, rfc822.MIMEType, error) {
+	method, err := cal.Props.Text(ical.PropMethod)
+	if err != nil {
+		return nil, "", err
+	} else if method == "" {
+		return nil, "", errors.New("invitation has no method")
+	}
+
+	var buf bytes.Buffer
+
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return nil, "", err
+	}
+
+	mimeType := mime.FormatMediaType("text/calendar", map[string]string{
+		"method":  method,
+		"charset": "utf-8",
+	})
+
+	return buf.Bytes(), rfc822.MIMEType(mimeType), nil
+}
+
+// newCalendarInvite wraps the component in a VCALENDAR with the given method and the timezones of the event.
+func newCalendarInvite(method CalendarInviteMethod, comp *ical.Component) (*ical.Calendar, error) {
+	cal := ical.NewCalendar()
+
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, CalendarProductID)
+	cal.Props.SetText(ical.PropMethod, string(method))
+
+	start, err := comp.Props.DateTime(ical.PropDateTimeStart, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	end := start
+
+	if comp.Props.Get(ical.PropDateTimeEnd) != nil {
+		if end, err = comp.Props.DateTime(ical.PropDateTimeEnd, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var tzids []string
+
+	for _, name := range []string{ical.PropDateTimeStart, ical.PropDateTimeEnd, ical.PropRecurrenceID} {
+		if prop := comp.Props.Get(name); prop != nil {
+			if tzid := prop.Params.Get(ical.PropTimezoneID); tzid != "" && !slices.Contains(tzids, tzid) {
+				tzids = append(tzids, tzid)
+			}
+		}
+	}
+
+	for _, tzid := range tzids {
+		tz, err := newCalendarTimezone(tzid, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		cal.Children = append(cal.Children, tz)
+	}
+
+	cal.Children = append(cal.Children, comp)
+
+	return cal, nil
+}
+
+// newCalendarInviteComponent returns a new VEVENT with the UID of the event and the given properties, or all of them if nil.
+// Alarms, which are personal, and the tokens of the attendees, which are specific to the API, are not included.
+func newCalendarInviteComponent(event *ical.Event, props []string) *ical.Component {
+	comp := ical.NewComponent(ical.CompEvent)
+
+	for name, values := range event.Props {
+		if props != nil && name != ical.PropUID && !slices.Contains(props, name) {
+			continue
+		}
+
+		for _, prop := range values {
+			prop := cloneCalendarProp(prop)
+
+			comp.Props.Add(&prop)
+		}
+	}
+
+	// The stamp of an invitation is the time it is sent.
+	comp.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+
+	return comp
+}
+
+func checkCalendarInviteEvent(event *ical.Event) error {
+	if uid, err := event.Props.Text(ical.PropUID); err != nil {
+		return err
+	} else if uid == "" {
+		return errors.New("event has no UID")
+	}
+
+	if event.Props.Get(ical.PropOrganizer) == nil {
+		return errors.New("event has no organizer")
+	}
+
+	if event.Props.Get(ical.PropDateTimeStart) == nil {
+		return errors.New("event has no start")
+	}
+
+	return nil
+}
+
+// getCalendarAttendeeProp returns the ATTENDEE property of the event with the given email, if any.
+func getCalendarAttendeeProp(event *ical.Event, email string) *ical.Prop {
+	for i, prop := range event.Props.Values(ical.PropAttendee) {
+		if strings.EqualFold(getCalendarAddress(prop.Value), email) {
+			return &event.Props.Values(ical.PropAttendee)[i]
+		}
+	}
+
+	return nil
+}
+
+// getCalendarEventSequence returns the sequence number of the event, which is 0 if it has none.
+func getCalendarEventSequence(event *ical.Event) (int, error) {
+	prop := event.Props.Get(ical.PropSequence)
+	if prop == nil {
+		return 0, nil
+	}
+
+	return prop.Int()
+}
+
+func cloneCalendarProp(prop ical.Prop) ical.Prop {
+	params := make(ical.Params, len(prop.Params))
+
+	for name, values := range prop.Params {
+		if name != FieldPMToken {
+			params[name] = slices.Clone(values)
+		}
+	}
+
+	return ical.Prop{Name: prop.Name, Params: params, Value: prop.Value}
+}
@@ -0,0 +1,34 @@
+package proton
+
+import (
+	"errors"
+
+	"github.com/emersion/go-ical"
+)
+
+// ErrNoCalendarInvite is returned when a message holds no calendar invitation.
+var ErrNoCalendarInvite = errors.New("no calendar invitation")
+
+// CalendarInviteMethod is the iTIP method of a calendar invitation (RFC 5546).
+type CalendarInviteMethod string
+
+const (
+	CalendarInviteMethodRequest CalendarInviteMethod = "REQUEST"
+	CalendarInviteMethodReply   CalendarInviteMethod = "REPLY"
+	CalendarInviteMethodCancel  CalendarInviteMethod = "CANCEL"
+)
+
+// CalendarInvite is a calendar invitation, or an answer to one, as sent by email.
+type CalendarInvite struct {
+	Method CalendarInviteMethod
+
+	// Calendar is the whole iCalendar object of the invitation, including its timezones.
+	Calendar *ical.Calendar
+
+	// Event is the event the invitation is about: the recurring event if the invitation holds it.
+	Event *ical.Event
+
+	// Overrides holds the modified occurrences of the event sent along with it,
+	// i.e. the other events of the invitation with the same UID and a RECURRENCE-ID.
+	Overrides []*ical.Event
+}
@@ -0,0 +1,150 @@
+package proton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/go-resty/resty/v2"
+)
+
+// ShareCalendar invites the given email address to become a member of the calendar with the given permissions.
+// The passphrase of the inviting member is re-encrypted to the primary active encryption key of the invited address.
+func (c *Client) ShareCalendar(
+	ctx context.Context,
+	calendarID, memberID string,
+	addrKR *crypto.KeyRing,
+	email string,
+	permissions CalendarPermissions,
+) (CalendarInvitation, error) {
+	pubKeys, recipientType, err := c.GetPublicKeys(ctx, email)
+	if err != nil {
+		return CalendarInvitation{}, err
+	} else if recipientType != RecipientTypeInternal {
+		return CalendarInvitation{}, errors.New("calendars can only be shared with internal addresses")
+	}
+
+	// The API lists the primary key first; only the primary key of an address is used to unlock its calendars.
+	sendKeys, err := getSendKeys(pubKeys)
+	if err != nil {
+		return CalendarInvitation{}, err
+	} else if len(sendKeys) == 0 {
+		return CalendarInvitation{}, fmt.Errorf("%v has no active encryption key", email)
+	}
+
+	pubKR, err := crypto.NewKeyRing(sendKeys[0])
+	if err != nil {
+		return CalendarInvitation{}, err
+	}
+
+	passphrase, err := c.GetCalendarPassphrase(ctx, calendarID)
+	if err != nil {
+		return CalendarInvitation{}, err
+	}
+
+	keyPacket, err := passphrase.NewKeyPacket(memberID, addrKR, pubKR)
+	if err != nil {
+		return CalendarInvitation{}, err
+	}
+
+	return c.CreateCalendarInvitation(ctx, calendarID, CreateCalendarInvitationReq{
+		MemberID:            memberID,
+		Email:               email,
+		Permissions:         permissions,
+		PassphraseKeyPacket: keyPacket,
+	})
+}
+
+func (c *Client) CreateCalendarInvitation(ctx context.Context, calendarID string, req CreateCalendarInvitationReq) (CalendarInvitation, error) {
+	var res struct {
+		Invitation CalendarInvitation
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetBody(req).SetResult(&res).Post("/calendar/v1/" + calendarID + "/invitations")
+	}); err != nil {
+		return CalendarInvitation{}, err
+	}
+
+	return res.Invitation, nil
+}
+
+// GetCalendarInvitations returns the invitations sent to become a member of the calendar.
+func (c *Client) GetCalendarInvitations(ctx context.Context, calendarID string) ([]CalendarInvitation, error) {
+	var res struct {
+		Invitations []CalendarInvitation
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Get("/calendar/v1/" + calendarID + "/invitations")
+	}); err != nil {
+		return nil, err
+	}
+
+	return res.Invitations, nil
+}
+
+// GetPendingCalendarInvitations returns the invitations to become a member of a calendar that the user's addresses haven't answered yet.
+func (c *Client) GetPendingCalendarInvitations(ctx context.Context) ([]CalendarInvitation, error) {
+	var res struct {
+		Invitations []CalendarInvitation
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Get("/calendar/v1/invitations")
+	}); err != nil {
+		return nil, err
+	}
+
+	return res.Invitations, nil
+}
+
+// AcceptCalendarInvitation accepts the invitation, which makes the invited address a member of the calendar.
+func (c *Client) AcceptCalendarInvitation(ctx context.Context, calendarID, invitationID string, req AcceptCalendarInvitationReq) (CalendarMember, error) {
+	var res struct {
+		Member CalendarMember
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetBody(req).SetResult(&res).Put("/calendar/v1/" + calendarID + "/invitations/" + invitationID + "/accept")
+	}); err != nil {
+		return CalendarMember{}, err
+	}
+
+	return res.Member, nil
+}
+
+func (c *Client) DeclineCalendarInvitation(ctx context.Context, calendarID, invitationID string) error {
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.Put("/calendar/v1/" + calendarID + "/invitations/" + invitationID + "/decline")
+	})
+}
+
+// DeleteCalendarInvitation cancels an invitation to become a member of the calendar.
+func (c *Client) DeleteCalendarInvitation(ctx context.Context, calendarID, invitationID string) error {
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.Delete("/calendar/v1/" + calendarID + "/invitations/" + invitationID)
+	})
+}
+
+func (c *Client) UpdateCalendarMember(ctx context.Context, calendarID, memberID string, req UpdateCalendarMemberReq) (CalendarMember, error) {
+	var res struct {
+		Member CalendarMember
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetBody(req).SetResult(&res).Put("/calendar/v1/" + calendarID + "/members/" + memberID)
+	}); err != nil {
+		return CalendarMember{}, err
+	}
+
+	return res.Member, nil
+}
+
+// DeleteCalendarMember removes the member from the calendar; members may remove themselves to leave a calendar shared with them.
+func (c *Client) DeleteCalendarMember(ctx context.Context, calendarID, memberID string) error {
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.Delete("/calendar/v1/" + calendarID + "/members/" + memberID)
+	})
+}
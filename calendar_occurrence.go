@@ -0,0 +1,458 @@
+package proton
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/emersion/go-ical"
+	"github.com/teambition/rrule-go"
+	"golang.org/x/exp/slices"
+)
+
+// CalendarOccurrence is a single occurrence of a calendar event.
+type CalendarOccurrence struct {
+	// EventID is the ID of the stored event the occurrence is read from:
+	// the recurring event itself, or the event overriding this occurrence.
+	EventID string
+	UID     string
+
+	// RecurrenceID is the start of the occurrence as given by the recurrence rule.
+	// It is zero for events that don't recur.
+	RecurrenceID time.Time
+
+	Start   time.Time
+	End     time.Time
+	FullDay bool
+
+	// Event is the decrypted event the occurrence is read from.
+	Event *ical.Event
+}
+
+// GetCalendarOccurrences returns the occurrences of the events of the calendar that happen between start and end,
+// sorted by start time. Floating times and all-day events are read in the given location.
+// It also returns the IDs of the events that couldn't be decrypted or expanded, whose occurrences are missing.
+func (c *Client) GetCalendarOccurrences(
+	ctx context.Context,
+	calendarID, memberID string,
+	calKR, addrKR *crypto.KeyRing,
+	start, end time.Time,
+	loc *time.Location,
+) ([]CalendarOccurrence, []string, error) {
+	events, err := c.GetAllCalendarEvents(ctx, calendarID, url.Values{
+		"Start": {strconv.FormatInt(start.Unix(), 10)},
+		"End":   {strconv.FormatInt(end.Unix(), 10)},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authorKRs, err := c.GetCalendarAuthorKeyRings(ctx, events, addrKR)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decoded, skipped := decodeCalendarEvents(events, memberID, calKR, addrKR, authorKRs)
+
+	// An occurrence of a recurring event may have been moved out of the window by an override, which still replaces it.
+	seen := make(map[string]struct{})
+
+	for _, event := range events {
+		seen[event.ID] = struct{}{}
+	}
+
+	for _, uid := range getRecurringCalendarEventUIDs(decoded) {
+		others, err := c.GetAllCalendarEvents(ctx, calendarID, url.Values{"UID": {uid}})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		others = xslices.Filter(others, func(event CalendarEvent) bool {
+			_, ok := seen[event.ID]
+			return !ok
+		})
+
+		othersKRs, err := c.GetCalendarAuthorKeyRings(ctx, others, addrKR)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		othersDecoded, othersSkipped := decodeCalendarEvents(others, memberID, calKR, addrKR, othersKRs)
+
+		decoded = append(decoded, othersDecoded...)
+		skipped = append(skipped, othersSkipped...)
+	}
+
+	occurrences, expandSkipped := expandCalendarEvents(decoded, start, end, loc)
+
+	return occurrences, append(skipped, expandSkipped...), nil
+}
+
+// ExpandCalendarEvents decrypts the given events and returns their occurrences that happen between start and end,
+// sorted by start time. Recurring events are expanded according to their RRULE, RDATE and EXDATE properties,
+// and their occurrences are replaced by the given events overriding them (i.e. with the same UID and a RECURRENCE-ID).
+// Floating times and all-day events are read in the given location.
+// Events that can't be decrypted or verified, such as those with parts written by other members, or that can't be
+// expanded are skipped; their IDs are returned along with the occurrences.
+func ExpandCalendarEvents(events []CalendarEvent, memberID string, calKR, addrKR *crypto.KeyRing, start, end time.Time, loc *time.Location) ([]CalendarOccurrence, []string) {
+	decoded, skipped := decodeCalendarEvents(events, memberID, calKR, addrKR, nil)
+
+	occurrences, expandSkipped := expandCalendarEvents(decoded, start, end, loc)
+
+	return occurrences, append(skipped, expandSkipped...)
+}
+
+type decodedCalendarEvent struct {
+	eventID string
+	event   *ical.Event
+}
+
+// decodeCalendarEvents decrypts the given events, verifying the parts written by other members with authorKRs.
+// Events that can't be decrypted are logged and skipped, so that a single bad event doesn't hide all the others;
+// their IDs are returned.
+func decodeCalendarEvents(events []CalendarEvent, memberID string, calKR, addrKR *crypto.KeyRing, authorKRs map[string]*crypto.KeyRing) ([]decodedCalendarEvent, []string) {
+	decoded := make([]decodedCalendarEvent, 0, len(events))
+
+	var skipped []string
+
+	for _, event := range events {
+		merged, err := event.MergeWithAuthors(memberID, calKR, addrKR, authorKRs)
+		if err != nil {
+			log.WithError(err).WithField("eventID", event.ID).Warn("Failed to decrypt calendar event")
+			skipped = append(skipped, event.ID)
+
+			continue
+		}
+
+		decoded = append(decoded, decodedCalendarEvent{eventID: event.ID, event: merged})
+	}
+
+	return decoded, skipped
+}
+
+func getRecurringCalendarEventUIDs(events []decodedCalendarEvent) []string {
+	var uids []string
+
+	for _, event := range events {
+		if !isRecurringCalendarEvent(event.event) {
+			continue
+		}
+
+		if uid := event.event.Props.Get(ical.PropUID); uid != nil && !slices.Contains(uids, uid.Value) {
+			uids = append(uids, uid.Value)
+		}
+	}
+
+	return uids
+}
+
+func isRecurringCalendarEvent(event *ical.Event) bool {
+	return event.Props.Get(ical.PropRecurrenceRule) != nil || event.Props.Get(ical.PropRecurrenceDates) != nil
+}
+
+// expandCalendarEvents returns the occurrences of the given events that happen between start and end, sorted by start time.
+// Events that can't be expanded are logged and skipped, along with their overrides; their IDs are returned.
+func expandCalendarEvents(events []decodedCalendarEvent, start, end time.Time, loc *time.Location) ([]CalendarOccurrence, []string) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var (
+		uids      []string
+		masters   = make(map[string]decodedCalendarEvent)
+		overrides = make(map[string][]decodedCalendarEvent)
+		skipped   []string
+	)
+
+	for _, event := range events {
+		uid, err := event.event.Props.Text(ical.PropUID)
+		if err != nil {
+			log.WithError(err).WithField("eventID", event.eventID).Warn("Failed to read calendar event UID")
+			skipped = append(skipped, event.eventID)
+
+			continue
+		}
+
+		if !slices.Contains(uids, uid) {
+			uids = append(uids, uid)
+		}
+
+		if event.event.Props.Get(ical.PropRecurrenceID) != nil {
+			overrides[uid] = append(overrides[uid], event)
+		} else {
+			masters[uid] = event
+		}
+	}
+
+	var occurrences []CalendarOccurrence
+
+	for _, uid := range uids {
+		var (
+			expanded []CalendarOccurrence
+			err      error
+		)
+
+		if master, ok := masters[uid]; ok {
+			expanded, err = expandCalendarEvent(master, overrides[uid], start, end, loc)
+		} else {
+			// Overrides whose recurring event is unknown are occurrences of their own.
+			expanded, err = expandCalendarEvent(decodedCalendarEvent{}, overrides[uid], start, end, loc)
+		}
+
+		if err != nil {
+			log.WithError(err).WithField("UID", uid).Warn("Failed to expand calendar event")
+
+			if master, ok := masters[uid]; ok {
+				skipped = append(skipped, master.eventID)
+			}
+
+			for _, override := range overrides[uid] {
+				skipped = append(skipped, override.eventID)
+			}
+
+			continue
+		}
+
+		occurrences = append(occurrences, expanded...)
+	}
+
+	slices.SortStableFunc(occurrences, func(a, b CalendarOccurrence) bool {
+		return a.Start.Before(b.Start)
+	})
+
+	return occurrences, skipped
+}
+
+// expandCalendarEvent returns the occurrences of the event that happen between start and end.
+// If the event is nil, only the overrides are returned.
+func expandCalendarEvent(master decodedCalendarEvent, overrides []decodedCalendarEvent, start, end time.Time, loc *time.Location) ([]CalendarOccurrence, error) {
+	var occurrences []CalendarOccurrence
+
+	overridden := make(map[int64]struct{})
+
+	for _, override := range overrides {
+		occurrence, err := newCalendarOccurrence(override, loc)
+		if err != nil {
+			return nil, err
+		}
+
+		overridden[occurrence.RecurrenceID.Unix()] = struct{}{}
+
+		if occurrence.overlaps(start, end) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+
+	if master.event == nil {
+		return occurrences, nil
+	}
+
+	first, err := newCalendarOccurrence(master, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := getCalendarRecurrenceSet(master.event, first.Start, loc)
+	if err != nil {
+		return nil, err
+	} else if set == nil {
+		if first.overlaps(start, end) {
+			occurrences = append(occurrences, first)
+		}
+
+		return occurrences, nil
+	}
+
+	// Occurrences that started before the window may still be happening; a day of margin accounts for DST changes.
+	span := first.End.Sub(first.Start)
+
+	for _, t := range set.Between(start.Add(-span).AddDate(0, 0, -1), end, true) {
+		if _, ok := overridden[t.Unix()]; ok {
+			continue
+		}
+
+		occurrence := first
+
+		occurrence.RecurrenceID = t
+		occurrence.Start = t
+
+		if first.FullDay {
+			occurrence.End = t.AddDate(0, 0, getDaysBetween(first.Start, first.End))
+		} else {
+			occurrence.End = t.Add(span)
+		}
+
+		if occurrence.overlaps(start, end) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+
+	return occurrences, nil
+}
+
+// newCalendarOccurrence returns the occurrence described by the DTSTART, DTEND or DURATION and RECURRENCE-ID of the event.
+func newCalendarOccurrence(event decodedCalendarEvent, loc *time.Location) (CalendarOccurrence, error) {
+	dtstart := event.event.Props.Get(ical.PropDateTimeStart)
+	if dtstart == nil {
+		return CalendarOccurrence{}, errors.New("event has no start")
+	}
+
+	start, err := dtstart.DateTime(loc)
+	if err != nil {
+		return CalendarOccurrence{}, err
+	}
+
+	occurrence := CalendarOccurrence{
+		EventID: event.eventID,
+		Start:   start,
+		FullDay: isCalendarDate(dtstart),
+		Event:   event.event,
+	}
+
+	if uid := event.event.Props.Get(ical.PropUID); uid != nil {
+		occurrence.UID = uid.Value
+	}
+
+	switch {
+	case event.event.Props.Get(ical.PropDateTimeEnd) != nil:
+		if occurrence.End, err = event.event.Props.DateTime(ical.PropDateTimeEnd, loc); err != nil {
+			return CalendarOccurrence{}, err
+		}
+
+	case event.event.Props.Get(ical.PropDuration) != nil:
+		duration, err := event.event.Props.Get(ical.PropDuration).Duration()
+		if err != nil {
+			return CalendarOccurrence{}, err
+		}
+
+		if occurrence.FullDay {
+			occurrence.End = start.AddDate(0, 0, int(duration/(24*time.Hour)))
+		} else {
+			occurrence.End = start.Add(duration)
+		}
+
+	case occurrence.FullDay:
+		occurrence.End = start.AddDate(0, 0, 1)
+
+	default:
+		occurrence.End = start
+	}
+
+	if prop := event.event.Props.Get(ical.PropRecurrenceID); prop != nil {
+		if occurrence.RecurrenceID, err = prop.DateTime(loc); err != nil {
+			return CalendarOccurrence{}, err
+		}
+	}
+
+	return occurrence, nil
+}
+
+// overlaps returns whether the occurrence happens between start and end.
+// Occurrences without duration happen at their start.
+func (occurrence CalendarOccurrence) overlaps(start, end time.Time) bool {
+	if occurrence.End.After(occurrence.Start) {
+		return occurrence.Start.Before(end) && occurrence.End.After(start)
+	}
+
+	return !occurrence.Start.Before(start) && occurrence.Start.Before(end)
+}
+
+// getCalendarRecurrenceSet returns the recurrence set of the event starting at dtstart, or nil if the event doesn't recur.
+// The rule is expanded in the location of dtstart, so that occurrences keep their local time across DST changes.
+func getCalendarRecurrenceSet(event *ical.Event, dtstart time.Time, loc *time.Location) (*rrule.Set, error) {
+	if !isRecurringCalendarEvent(event) {
+		return nil, nil
+	}
+
+	set := &rrule.Set{}
+
+	set.DTStart(dtstart)
+
+	// The start of the event is always its first occurrence.
+	set.RDate(dtstart)
+
+	if prop := event.Props.Get(ical.PropRecurrenceRule); prop != nil {
+		option, err := rrule.StrToROptionInLocation(prop.Value, dtstart.Location())
+		if err != nil {
+			return nil, err
+		}
+
+		option.Dtstart = dtstart
+
+		rule, err := rrule.NewRRule(*option)
+		if err != nil {
+			return nil, err
+		}
+
+		set.RRule(rule)
+	}
+
+	rdates, err := getCalendarDateTimes(event.Props.Values(ical.PropRecurrenceDates), loc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rdate := range rdates {
+		set.RDate(rdate)
+	}
+
+	exdates, err := getCalendarDateTimes(event.Props.Values(ical.PropExceptionDates), loc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, exdate := range exdates {
+		set.ExDate(exdate)
+	}
+
+	return set, nil
+}
+
+// getCalendarDateTimes parses the values of the given RDATE or EXDATE properties, which may each hold a list.
+// Periods are read as their start.
+func getCalendarDateTimes(props []ical.Prop, loc *time.Location) ([]time.Time, error) {
+	var times []time.Time
+
+	for _, prop := range props {
+		params := make(ical.Params, len(prop.Params))
+
+		for name, values := range prop.Params {
+			params[name] = values
+		}
+
+		if prop.ValueType() == ical.ValuePeriod {
+			params.Del(ical.ParamValue)
+		}
+
+		for _, value := range strings.Split(prop.Value, ",") {
+			value, _, _ := strings.Cut(value, "/")
+
+			t, err := (&ical.Prop{Name: prop.Name, Params: params, Value: value}).DateTime(loc)
+			if err != nil {
+				return nil, err
+			}
+
+			times = append(times, t)
+		}
+	}
+
+	return times, nil
+}
+
+// isCalendarDate returns whether the property holds a date rather than a date-time.
+func isCalendarDate(prop *ical.Prop) bool {
+	return prop.ValueType() == ical.ValueDate || (prop.ValueType() == ical.ValueDefault && len(prop.Value) == len("20060102"))
+}
+
+// getDaysBetween returns the number of calendar days between the dates of a and b.
+func getDaysBetween(a, b time.Time) int {
+	da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+
+	return int(db.Sub(da) / (24 * time.Hour))
+}
@@ -1,6 +1,7 @@
 package proton
 
 import (
+	"encoding/base64"
 	"errors"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
@@ -87,9 +88,30 @@ type CalendarMember struct {
 	CalendarID  string
 }
 
-// TODO: What is this?
+// CalendarPermissions are the rights of a member on a calendar, as a set of CalendarPermission* flags.
 type CalendarPermissions int
 
+const (
+	CalendarPermissionSuperOwner CalendarPermissions = 1 << iota
+	CalendarPermissionOwner
+	CalendarPermissionAdmin
+	CalendarPermissionReadMemberList
+	CalendarPermissionWrite
+	CalendarPermissionRead
+	CalendarPermissionAvailability
+)
+
+const (
+	// CalendarPermissionsOwner are the permissions of the owner of a calendar.
+	CalendarPermissionsOwner CalendarPermissions = 127
+
+	// CalendarPermissionsView are the permissions of a member the calendar is shared with in read-only mode.
+	CalendarPermissionsView = CalendarPermissionRead | CalendarPermissionAvailability
+
+	// CalendarPermissionsEdit are the permissions of a member the calendar is shared with in read-write mode.
+	CalendarPermissionsEdit = CalendarPermissionsView | CalendarPermissionWrite
+)
+
 // TODO: Support invitations.
 type CalendarPassphrase struct {
 	ID                string
@@ -107,6 +129,19 @@ func (passphrase CalendarPassphrase) Decrypt(memberID string, addrKR *crypto.Key
 	return nil, errors.New("no such member passphrase")
 }
 
+// NewKeyPacket returns the key packet of the passphrase of the given member, encrypted to the given keyring.
+// Together with the member's encrypted passphrase, it lets the holder of the keyring decrypt the calendar passphrase,
+// e.g. when the calendar is shared with them.
+func (passphrase CalendarPassphrase) NewKeyPacket(memberID string, addrKR, kr *crypto.KeyRing) (string, error) {
+	for _, passphrase := range passphrase.MemberPassphrases {
+		if passphrase.MemberID == memberID {
+			return passphrase.newKeyPacket(addrKR, kr)
+		}
+	}
+
+	return "", errors.New("no such member passphrase")
+}
+
 // TODO: What is this?
 type CalendarPassphraseFlag int64
 
@@ -138,3 +173,103 @@ func (passphrase MemberPassphrase) decrypt(addrKR *crypto.KeyRing) ([]byte, erro
 
 	return dec.GetBinary(), nil
 }
+
+func (passphrase MemberPassphrase) newKeyPacket(addrKR, kr *crypto.KeyRing) (string, error) {
+	msg, err := crypto.NewPGPMessageFromArmored(passphrase.Passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	split, err := msg.SplitMessage()
+	if err != nil {
+		return "", err
+	}
+
+	sessionKey, err := addrKR.DecryptSessionKey(split.GetBinaryKeyPacket())
+	if err != nil {
+		return "", err
+	}
+
+	keyPacket, err := kr.EncryptSessionKey(sessionKey)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(keyPacket), nil
+}
+
+// CalendarInvitation is an invitation of an address to become a member of a calendar.
+type CalendarInvitation struct {
+	ID          string
+	CalendarID  string
+	Email       string
+	Permissions CalendarPermissions
+	Status      CalendarInvitationStatus
+	CreateTime  int64
+
+	// Passphrase is the calendar passphrase, encrypted to the key of the invited address.
+	Passphrase string
+}
+
+// Decrypt returns the calendar passphrase of the invitation.
+func (invitation CalendarInvitation) Decrypt(addrKR *crypto.KeyRing) ([]byte, error) {
+	msg, err := crypto.NewPGPMessageFromArmored(invitation.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := addrKR.Decrypt(msg, nil, crypto.GetUnixTime())
+	if err != nil {
+		return nil, err
+	}
+
+	return dec.GetBinary(), nil
+}
+
+type CalendarInvitationStatus int
+
+const (
+	CalendarInvitationStatusPending CalendarInvitationStatus = iota
+	CalendarInvitationStatusAccepted
+	CalendarInvitationStatusDeclined
+)
+
+type CreateCalendarInvitationReq struct {
+	// MemberID is the inviting member, whose passphrase is shared with the invited address.
+	MemberID string
+
+	Email       string
+	Permissions CalendarPermissions
+
+	// PassphraseKeyPacket is the key packet of the inviting member's passphrase, encrypted to the key of the invited address.
+	PassphraseKeyPacket string
+}
+
+type AcceptCalendarInvitationReq struct {
+	// Signature is the signature of the calendar passphrase by the key of the invited address.
+	Signature string
+}
+
+// NewAcceptCalendarInvitationReq returns the request to accept the invitation, signing the calendar passphrase with the invited address's key.
+func NewAcceptCalendarInvitationReq(invitation CalendarInvitation, addrKR *crypto.KeyRing) (AcceptCalendarInvitationReq, error) {
+	passphrase, err := invitation.Decrypt(addrKR)
+	if err != nil {
+		return AcceptCalendarInvitationReq{}, err
+	}
+
+	sig, err := addrKR.SignDetached(crypto.NewPlainMessage(passphrase))
+	if err != nil {
+		return AcceptCalendarInvitationReq{}, err
+	}
+
+	arm, err := sig.GetArmored()
+	if err != nil {
+		return AcceptCalendarInvitationReq{}, err
+	}
+
+	return AcceptCalendarInvitationReq{Signature: arm}, nil
+}
+
+type UpdateCalendarMemberReq struct {
+	Permissions CalendarPermissions
+}
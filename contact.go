@@ -110,18 +110,22 @@ func (c *Client) GetContactEmails(ctx context.Context, email string, page, pageS
 		pageSize = maxPageSize
 	}
 
-	_, contacts, err := c.getContactEmailsImpl(ctx, email, page, pageSize)
+	_, contacts, err := c.getContactEmailsImpl(ctx, email, "", page, pageSize)
 
 	return contacts, err
 }
 
-func (c *Client) getContactEmailsImpl(ctx context.Context, email string, page, pageSize int) (int, []ContactEmail, error) {
+func (c *Client) getContactEmailsImpl(ctx context.Context, email, labelID string, page, pageSize int) (int, []ContactEmail, error) {
 	var res struct {
 		ContactEmails []ContactEmail
 		Total         int
 	}
 
 	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		if labelID != "" {
+			r = r.SetQueryParam("LabelID", labelID)
+		}
+
 		return r.SetQueryParams(map[string]string{
 			"Page":     strconv.Itoa(page),
 			"PageSize": strconv.Itoa(pageSize),
@@ -143,7 +147,7 @@ func (c *Client) GetAllContactEmailsPaged(ctx context.Context, email string, pag
 		pageSize = maxPageSize
 	}
 
-	total, firstBatch, err := c.getContactEmailsImpl(ctx, email, 0, pageSize)
+	total, firstBatch, err := c.getContactEmailsImpl(ctx, email, "", 0, pageSize)
 	if err != nil {
 		return nil, err
 	}
@@ -155,7 +159,7 @@ func (c *Client) GetAllContactEmailsPaged(ctx context.Context, email string, pag
 	remainingPages := (total / pageSize) + 1
 
 	for i := 1; i < remainingPages; i++ {
-		_, batch, err := c.getContactEmailsImpl(ctx, email, i, pageSize)
+		_, batch, err := c.getContactEmailsImpl(ctx, email, "", i, pageSize)
 		if err != nil {
 			return nil, err
 		}
@@ -199,3 +203,9 @@ func (c *Client) DeleteContacts(ctx context.Context, req DeleteContactsReq) erro
 		return r.SetBody(req).Put("/contacts/v4/delete")
 	})
 }
+
+func (c *Client) DeleteAllContacts(ctx context.Context) error {
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.Delete("/contacts/v4")
+	})
+}
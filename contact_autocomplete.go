@@ -0,0 +1,313 @@
+package proton
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+	"sync"
+
+	"github.com/bradenaw/juniper/xslices"
+	"golang.org/x/exp/slices"
+)
+
+// ContactIndex is an in-memory index of the user's contact emails and contact groups, used to suggest recipients.
+// It is built by NewContactIndex and kept up to date with HandleEvent.
+type ContactIndex struct {
+	// emails holds the contact emails, keyed by ID.
+	emails map[string]ContactEmail
+
+	// groups holds the contact groups, keyed by ID.
+	groups map[string]Label
+
+	// lastSent holds, for each normalized address, the time of the last message sent to it.
+	lastSent map[string]int64
+
+	lock sync.RWMutex
+}
+
+// ContactSuggestion is a recipient suggested for a query: either a contact email or a contact group.
+type ContactSuggestion struct {
+	// Name is the name of the contact email or of the group.
+	Name string
+
+	// ContactEmail is the suggested contact email; it is nil if the suggestion is a group.
+	ContactEmail *ContactEmail
+
+	// Group is the suggested contact group; it is nil if the suggestion is a contact email.
+	Group *Label
+
+	// Recipients are the addresses the suggestion expands to: the contact email, or the emails of the group.
+	Recipients []*mail.Address
+
+	// LastSent is the time of the last message sent to one of the recipients, or zero if there was none.
+	LastSent int64
+}
+
+// contactMatch is how well a query matches a name or an email; lower is better.
+type contactMatch int
+
+const (
+	contactMatchPrefix contactMatch = iota
+	contactMatchWordPrefix
+	contactMatchSubstring
+	contactMatchFuzzy
+	contactMatchNone
+)
+
+// NewContactIndex builds the index of the user's contact emails and contact groups.
+// The recency of the addresses is taken from the recipients of the messages in the sent folder.
+func (c *Client) NewContactIndex(ctx context.Context) (*ContactIndex, error) {
+	emails, err := c.GetAllContactEmails(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := c.GetLabels(ctx, LabelTypeContactGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	sent, err := c.GetMessageMetadata(ctx, MessageFilter{LabelID: AllSentLabel})
+	if err != nil {
+		return nil, err
+	}
+
+	index := &ContactIndex{
+		emails:   make(map[string]ContactEmail, len(emails)),
+		groups:   make(map[string]Label, len(groups)),
+		lastSent: make(map[string]int64),
+	}
+
+	for _, email := range emails {
+		index.emails[email.ID] = email
+	}
+
+	for _, group := range groups {
+		index.groups[group.ID] = group
+	}
+
+	for _, message := range sent {
+		index.addSent(message)
+	}
+
+	return index, nil
+}
+
+// HandleEvent applies the changes of the event to the contact emails, the contact groups and the sent messages
+// of the index. It returns false if the event asks for a refresh; the index must then be built again.
+func (index *ContactIndex) HandleEvent(event Event) bool {
+	if event.Refresh != 0 {
+		return false
+	}
+
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	for _, event := range event.ContactEmails {
+		if event.Action == EventDelete {
+			delete(index.emails, event.ID)
+		} else {
+			index.emails[event.ID] = event.ContactEmail
+		}
+	}
+
+	for _, event := range event.Labels {
+		if event.Action == EventDelete || event.Label.Type != LabelTypeContactGroup {
+			delete(index.groups, event.ID)
+		} else {
+			index.groups[event.ID] = event.Label
+		}
+	}
+
+	for _, event := range event.Messages {
+		if event.Action != EventDelete {
+			index.addSent(event.Message)
+		}
+	}
+
+	return true
+}
+
+// Suggest returns at most limit suggestions for the query, or all of them if limit isn't positive.
+// The query is matched, case-insensitively, against the names and emails of the contact emails and the names
+// of the groups: prefixes of the whole value first, then prefixes of its words, then substrings, then values that
+// contain all the characters of the query in order. Suggestions that match equally well are ranked by how recently
+// mail was sent to them. Groups expand to the emails they contain; empty groups aren't suggested.
+func (index *ContactIndex) Suggest(query string, limit int) []ContactSuggestion {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	type ranked struct {
+		ContactSuggestion
+		match contactMatch
+	}
+
+	var suggestions []ranked
+
+	for _, email := range index.emails {
+		match := matchContact(query, email.Name, email.Email)
+		if match == contactMatchNone {
+			continue
+		}
+
+		email := email
+
+		suggestions = append(suggestions, ranked{
+			ContactSuggestion: ContactSuggestion{
+				Name:         email.Name,
+				ContactEmail: &email,
+				Recipients:   []*mail.Address{{Name: email.Name, Address: email.Email}},
+				LastSent:     index.lastSent[normalizeContactEmail(email.Email)],
+			},
+			match: match,
+		})
+	}
+
+	for _, group := range index.groups {
+		match := matchContact(query, group.Name)
+		if match == contactMatchNone {
+			continue
+		}
+
+		recipients, lastSent := index.expandGroup(group.ID)
+		if len(recipients) == 0 {
+			continue
+		}
+
+		group := group
+
+		suggestions = append(suggestions, ranked{
+			ContactSuggestion: ContactSuggestion{
+				Name:       group.Name,
+				Group:      &group,
+				Recipients: recipients,
+				LastSent:   lastSent,
+			},
+			match: match,
+		})
+	}
+
+	slices.SortFunc(suggestions, func(a, b ranked) bool {
+		if a.match != b.match {
+			return a.match < b.match
+		}
+
+		if a.LastSent != b.LastSent {
+			return a.LastSent > b.LastSent
+		}
+
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+
+		return a.Recipients[0].Address < b.Recipients[0].Address
+	})
+
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return xslices.Map(suggestions, func(suggestion ranked) ContactSuggestion {
+		return suggestion.ContactSuggestion
+	})
+}
+
+// expandGroup returns the addresses of the emails in the group, sorted by name, and the time of the last message
+// sent to one of them.
+func (index *ContactIndex) expandGroup(groupID string) ([]*mail.Address, int64) {
+	var (
+		emails   []ContactEmail
+		lastSent int64
+	)
+
+	for _, email := range index.emails {
+		if !slices.Contains(email.LabelIDs, groupID) {
+			continue
+		}
+
+		if slices.ContainsFunc(emails, func(other ContactEmail) bool {
+			return normalizeContactEmail(other.Email) == normalizeContactEmail(email.Email)
+		}) {
+			continue
+		}
+
+		emails = append(emails, email)
+
+		lastSent = max(lastSent, index.lastSent[normalizeContactEmail(email.Email)])
+	}
+
+	slices.SortFunc(emails, func(a, b ContactEmail) bool {
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+
+		return a.Email < b.Email
+	})
+
+	return xslices.Map(emails, func(email ContactEmail) *mail.Address {
+		return &mail.Address{Name: email.Name, Address: email.Email}
+	}), lastSent
+}
+
+// addSent records the recipients of the message if it was sent.
+func (index *ContactIndex) addSent(message MessageMetadata) {
+	if !slices.Contains(message.LabelIDs, AllSentLabel) {
+		return
+	}
+
+	for _, list := range [][]*mail.Address{message.ToList, message.CCList, message.BCCList} {
+		for _, addr := range list {
+			email := normalizeContactEmail(addr.Address)
+
+			index.lastSent[email] = max(index.lastSent[email], message.Time)
+		}
+	}
+}
+
+// matchContact returns how well the query, which must be lowercase, matches the best of the values.
+func matchContact(query string, values ...string) contactMatch {
+	best := contactMatchNone
+
+	for _, value := range values {
+		best = min(best, matchContactValue(query, strings.ToLower(value)))
+	}
+
+	return best
+}
+
+func matchContactValue(query, value string) contactMatch {
+	if value == "" {
+		return contactMatchNone
+	}
+
+	if strings.HasPrefix(value, query) {
+		return contactMatchPrefix
+	}
+
+	words := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(" \t.,@_-+<>\"'", r)
+	})
+
+	if xslices.Any(words, func(word string) bool { return strings.HasPrefix(word, query) }) {
+		return contactMatchWordPrefix
+	}
+
+	if strings.Contains(value, query) {
+		return contactMatchSubstring
+	}
+
+	rest := value
+
+	for _, r := range query {
+		idx := strings.IndexRune(rest, r)
+		if idx < 0 {
+			return contactMatchNone
+		}
+
+		rest = rest[idx+len(string(r)):]
+	}
+
+	return contactMatchFuzzy
+}
@@ -0,0 +1,92 @@
+package proton
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/go-resty/resty/v2"
+)
+
+// LabelContactEmails adds the contact emails to the contact group with the given label ID.
+func (c *Client) LabelContactEmails(ctx context.Context, labelID string, contactEmailIDs []string) error {
+	return c.labelContactEmails(ctx, labelID, contactEmailIDs, "/contacts/v4/emails/label")
+}
+
+// UnlabelContactEmails removes the contact emails from the contact group with the given label ID.
+func (c *Client) UnlabelContactEmails(ctx context.Context, labelID string, contactEmailIDs []string) error {
+	return c.labelContactEmails(ctx, labelID, contactEmailIDs, "/contacts/v4/emails/unlabel")
+}
+
+func (c *Client) labelContactEmails(ctx context.Context, labelID string, contactEmailIDs []string, path string) error {
+	for _, chunk := range xslices.Chunk(contactEmailIDs, maxPageSize) {
+		var res LabelContactEmailsRes
+
+		if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+			return r.SetBody(LabelContactEmailsReq{
+				LabelID:         labelID,
+				ContactEmailIDs: chunk,
+			}).SetResult(&res).Put(path)
+		}); err != nil {
+			return err
+		}
+
+		if ok, errStr := res.ok(); !ok {
+			return fmt.Errorf("failed to label contact emails: %v", errStr)
+		}
+	}
+
+	return nil
+}
+
+// GetContactGroupEmails returns the contact emails that are members of the contact group with the given label ID.
+func (c *Client) GetContactGroupEmails(ctx context.Context, labelID string) ([]ContactEmail, error) {
+	total, firstBatch, err := c.getContactEmailsImpl(ctx, "", labelID, 0, maxPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if total <= maxPageSize {
+		return firstBatch, nil
+	}
+
+	remainingPages := (total / maxPageSize) + 1
+
+	for i := 1; i < remainingPages; i++ {
+		_, batch, err := c.getContactEmailsImpl(ctx, "", labelID, i, maxPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		firstBatch = append(firstBatch, batch...)
+	}
+
+	return firstBatch, nil
+}
+
+// GetContactGroupRecipients returns the addresses of the members of the contact groups with the given label IDs,
+// so that a draft can be addressed to the groups. Addresses that are members of several groups are returned once.
+func (c *Client) GetContactGroupRecipients(ctx context.Context, labelIDs ...string) ([]*mail.Address, error) {
+	var recipients []*mail.Address
+
+	for _, labelID := range labelIDs {
+		emails, err := c.GetContactGroupEmails(ctx, labelID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, email := range emails {
+			if xslices.IndexFunc(recipients, func(recipient *mail.Address) bool {
+				return strings.EqualFold(recipient.Address, email.Email)
+			}) >= 0 {
+				continue
+			}
+
+			recipients = append(recipients, &mail.Address{Name: email.Name, Address: email.Email})
+		}
+	}
+
+	return recipients, nil
+}
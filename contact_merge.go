@@ -0,0 +1,304 @@
+package proton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/emersion/go-vcard"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// singleContactFields are the vCard fields that a contact has at most once;
+// when merging contacts, the value of the first contact that has one is kept.
+var singleContactFields = []string{
+	vcard.FieldFormattedName,
+	vcard.FieldName,
+	vcard.FieldUID,
+	vcard.FieldKind,
+	vcard.FieldBirthday,
+	vcard.FieldAnniversary,
+	vcard.FieldGender,
+	vcard.FieldRevision,
+}
+
+// contactSettingsFields are the fields holding the settings of an email, in the group of the email.
+var contactSettingsFields = []string{
+	FieldPMScheme,
+	FieldPMSign,
+	FieldPMEncrypt,
+	FieldPMEncryptUntrusted,
+	FieldPMMIMEType,
+}
+
+// GetDuplicateContacts returns the groups of the user's contacts that are likely duplicates of each other.
+// See FindDuplicateContacts.
+func (c *Client) GetDuplicateContacts(ctx context.Context, kr *crypto.KeyRing) ([][]Contact, error) {
+	contacts, err := c.getAllContactsWithCards(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return FindDuplicateContacts(kr, contacts)
+}
+
+// MergeContacts merges the contacts into the first one, which is updated, and deletes the others.
+// The fields of the contacts are combined; for fields a contact has only once (name, birthday, ...) and for
+// the settings of an email (keys aside), the value of the first contact that has one is kept.
+// Emails keep the contact groups they were in.
+func (c *Client) MergeContacts(ctx context.Context, kr *crypto.KeyRing, contacts []Contact) (Contact, error) {
+	if len(contacts) < 2 {
+		return Contact{}, errors.New("at least two contacts are needed to merge")
+	}
+
+	// The contacts are completed on a copy, leaving the caller's slice as given.
+	contacts = slices.Clone(contacts)
+
+	for i, contact := range contacts {
+		if len(contact.Cards) > 0 {
+			continue
+		}
+
+		full, err := c.GetContact(ctx, contact.ID)
+		if err != nil {
+			return Contact{}, err
+		}
+
+		contacts[i] = full
+	}
+
+	card, err := mergeContactVCards(kr, contacts)
+	if err != nil {
+		return Contact{}, err
+	}
+
+	cards, err := NewContactCards(kr, card)
+	if err != nil {
+		return Contact{}, err
+	}
+
+	merged, err := c.UpdateContact(ctx, contacts[0].ID, UpdateContactReq{Cards: cards})
+	if err != nil {
+		return Contact{}, err
+	}
+
+	// The emails of the other contacts are new emails of the merged contact; put them back in their groups.
+	labels := make(map[string][]string)
+
+	for _, contact := range contacts[1:] {
+		for _, email := range contact.ContactEmails {
+			idx := xslices.IndexFunc(merged.ContactEmails, func(other ContactEmail) bool {
+				return normalizeContactEmail(other.Email) == normalizeContactEmail(email.Email)
+			})
+			if idx < 0 {
+				continue
+			}
+
+			for _, labelID := range email.LabelIDs {
+				if !slices.Contains(merged.ContactEmails[idx].LabelIDs, labelID) && !slices.Contains(labels[labelID], merged.ContactEmails[idx].ID) {
+					labels[labelID] = append(labels[labelID], merged.ContactEmails[idx].ID)
+				}
+			}
+		}
+	}
+
+	for labelID, contactEmailIDs := range labels {
+		if err := c.LabelContactEmails(ctx, labelID, contactEmailIDs); err != nil {
+			return Contact{}, err
+		}
+	}
+
+	if err := c.DeleteContacts(ctx, DeleteContactsReq{
+		IDs: xslices.Map(contacts[1:], func(contact Contact) string { return contact.ID }),
+	}); err != nil {
+		return Contact{}, err
+	}
+
+	if len(labels) == 0 {
+		return merged, nil
+	}
+
+	return c.GetContact(ctx, merged.ID)
+}
+
+// FindDuplicateContacts returns the groups of contacts that are likely duplicates of each other:
+// contacts are duplicates if they share an email or a name, compared case-insensitively and,
+// for names, ignoring extra whitespace. Duplication is transitive. Only groups of at least two contacts
+// are returned, in the order of their first contact.
+func FindDuplicateContacts(kr *crypto.KeyRing, contacts []Contact) ([][]Contact, error) {
+	parents := make([]int, len(contacts))
+
+	for i := range parents {
+		parents[i] = i
+	}
+
+	var find func(int) int
+
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+
+		return parents[i]
+	}
+
+	// owners holds, for each normalized email and name, the first contact that has it.
+	owners := make(map[string]int)
+
+	for i, contact := range contacts {
+		card, err := MergeContactCards(kr, contact.Cards)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cards of contact %v: %w", contact.ID, err)
+		}
+
+		var keys []string
+
+		for _, field := range card[vcard.FieldEmail] {
+			if email := normalizeContactEmail(field.Value); email != "" {
+				keys = append(keys, "email:"+email)
+			}
+		}
+
+		if name := normalizeContactName(card.PreferredValue(vcard.FieldFormattedName)); name != "" {
+			keys = append(keys, "name:"+name)
+		}
+
+		for _, key := range keys {
+			owner, ok := owners[key]
+			if !ok {
+				owners[key] = i
+				continue
+			}
+
+			if a, b := find(owner), find(i); a != b {
+				parents[max(a, b)] = min(a, b)
+			}
+		}
+	}
+
+	groups := make(map[int][]Contact)
+
+	for i, contact := range contacts {
+		groups[find(i)] = append(groups[find(i)], contact)
+	}
+
+	roots := maps.Keys(groups)
+
+	slices.Sort(roots)
+
+	var duplicates [][]Contact
+
+	for _, root := range roots {
+		if len(groups[root]) > 1 {
+			duplicates = append(duplicates, groups[root])
+		}
+	}
+
+	return duplicates, nil
+}
+
+// mergeContactVCards merges the cards of the contacts into a single vCard.
+// The groups of the contacts are renamed so that they don't clash; an email that several contacts have
+// is kept once, and its settings are combined in its group.
+func mergeContactVCards(kr *crypto.KeyRing, contacts []Contact) (vcard.Card, error) {
+	merged := newVCard()
+
+	var groupCount int
+
+	newGroup := func() string {
+		groupCount++
+		return fmt.Sprintf("item%v", groupCount)
+	}
+
+	// emailGroups holds the group of each normalized email of the merged card.
+	emailGroups := make(map[string]string)
+
+	for _, contact := range contacts {
+		card, err := MergeContactCards(kr, contact.Cards)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cards of contact %v: %w", contact.ID, err)
+		}
+
+		// groups maps the groups of the contact's card to those of the merged card.
+		groups := make(map[string]string)
+
+		for _, field := range card[vcard.FieldEmail] {
+			email := normalizeContactEmail(field.Value)
+
+			if group, ok := emailGroups[email]; ok {
+				if field.Group != "" {
+					groups[field.Group] = group
+				}
+
+				continue
+			}
+
+			group, ok := groups[field.Group]
+			if !ok || field.Group == "" {
+				group = newGroup()
+
+				if field.Group != "" {
+					groups[field.Group] = group
+				}
+			}
+
+			emailGroups[email] = group
+
+			merged.Add(vcard.FieldEmail, withVCardGroup(field, group))
+		}
+
+		for key, fields := range card {
+			if key == vcard.FieldVersion || key == vcard.FieldEmail {
+				continue
+			}
+
+			if slices.Contains(singleContactFields, key) && len(merged[key]) > 0 {
+				continue
+			}
+
+			for _, field := range fields {
+				group, ok := groups[field.Group]
+				if !ok && field.Group != "" {
+					group = newGroup()
+					groups[field.Group] = group
+				}
+
+				field = withVCardGroup(field, group)
+
+				if slices.Contains(contactSettingsFields, key) && slices.ContainsFunc(merged[key], func(other *vcard.Field) bool {
+					return other.Group == field.Group
+				}) {
+					continue
+				}
+
+				if slices.ContainsFunc(merged[key], func(other *vcard.Field) bool { return equalVCardFields(field, other) }) {
+					continue
+				}
+
+				merged.Add(key, field)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+func withVCardGroup(field *vcard.Field, group string) *vcard.Field {
+	clone := *field
+
+	clone.Group = group
+
+	return &clone
+}
+
+func normalizeContactEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func normalizeContactName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
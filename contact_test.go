@@ -5,6 +5,7 @@ import (
 	"github.com/ProtonMail/gluon/rfc822"
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
 	"github.com/emersion/go-vcard"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -73,6 +74,116 @@ func TestContactSettings(t *testing.T) {
 	require.Equal(t, "This is explicitly typed plain ASCII text.\n", dec.GetString())
 }
 
+func TestContactCards(t *testing.T) {
+	kr := newKeyRing(t, "user", "user@user")
+
+	card := make(vcard.Card)
+	card.SetValue(vcard.FieldVersion, "3.0")
+	card.SetValue(vcard.FieldFormattedName, "Foo Bar")
+	card.Add(vcard.FieldEmail, &vcard.Field{Value: "foo@bar.com", Group: "item1", Params: vcard.Params{vcard.ParamType: []string{"pref"}}})
+	card.Add(proton.FieldPMSign, &vcard.Field{Value: "true", Group: "item1"})
+	card.SetValue(vcard.FieldTelephone, "+41 22 000 00 00")
+	card.SetValue(vcard.FieldNote, "Some note")
+
+	cards, err := proton.NewContactCards(kr, card)
+	require.NoError(t, err)
+	require.Len(t, cards, 2)
+
+	signed, ok := cards.Get(proton.CardTypeSigned)
+	require.True(t, ok)
+
+	for _, key := range []string{vcard.FieldFormattedName, vcard.FieldUID, vcard.FieldEmail, proton.FieldPMSign} {
+		fields, err := signed.Get(kr, key)
+		require.NoError(t, err)
+		require.Len(t, fields, 1, key)
+	}
+
+	fields, err := signed.Get(kr, vcard.FieldTelephone)
+	require.NoError(t, err)
+	require.Empty(t, fields)
+
+	encrypted, ok := cards.Get(proton.CardTypeEncrypted | proton.CardTypeSigned)
+	require.True(t, ok)
+
+	fields, err = encrypted.Get(kr, vcard.FieldTelephone)
+	require.NoError(t, err)
+	require.Len(t, fields, 1)
+
+	// The cards are merged back into a single vCard 4.0.
+	merged, err := proton.MergeContactCards(kr, cards)
+	require.NoError(t, err)
+
+	require.Equal(t, "4.0", merged.Value(vcard.FieldVersion))
+	require.Len(t, merged[vcard.FieldVersion], 1)
+	require.Equal(t, "Foo Bar", merged.Value(vcard.FieldFormattedName))
+	require.Equal(t, "+41 22 000 00 00", merged.Value(vcard.FieldTelephone))
+	require.Equal(t, "Some note", merged.Value(vcard.FieldNote))
+	require.Equal(t, "1", merged.Get(vcard.FieldEmail).Params.Get(vcard.ParamPreferred))
+	require.NotEmpty(t, merged.Value(vcard.FieldUID))
+
+	// Fields present in several cards are only kept once.
+	merged, err = proton.MergeContactCards(kr, append(cards, signed))
+	require.NoError(t, err)
+	require.Len(t, merged[vcard.FieldEmail], 1)
+}
+
+func TestContactCards_NoName(t *testing.T) {
+	kr := newKeyRing(t, "user", "user@user")
+
+	card := make(vcard.Card)
+	card.SetValue(vcard.FieldVersion, "4.0")
+	card.SetValue(vcard.FieldEmail, "foo@bar.com")
+
+	cards, err := proton.NewContactCards(kr, card)
+	require.NoError(t, err)
+	require.Len(t, cards, 1)
+
+	names, err := cards[0].Get(kr, vcard.FieldFormattedName)
+	require.NoError(t, err)
+	require.Equal(t, "foo@bar.com", names[0].Value)
+
+	delete(card, vcard.FieldEmail)
+
+	_, err = proton.NewContactCards(kr, card)
+	require.Error(t, err)
+}
+
+func TestFindDuplicateContacts(t *testing.T) {
+	kr := newKeyRing(t, "user", "user@user")
+
+	newContact := func(id, name string, emails ...string) proton.Contact {
+		card := make(vcard.Card)
+		card.SetValue(vcard.FieldVersion, "4.0")
+		card.SetValue(vcard.FieldFormattedName, name)
+
+		for _, email := range emails {
+			card.AddValue(vcard.FieldEmail, email)
+		}
+
+		cards, err := proton.NewContactCards(kr, card)
+		require.NoError(t, err)
+
+		return proton.Contact{ContactMetadata: proton.ContactMetadata{ID: id}, ContactCards: proton.ContactCards{Cards: cards}}
+	}
+
+	contacts := []proton.Contact{
+		newContact("1", "Foo Bar", "foo@bar.com"),
+		newContact("2", "Someone", "baz@bar.com"),
+		newContact("3", "Other", " FOO@bar.com"),
+		newContact("4", "Nobody", "nobody@bar.com"),
+		newContact("5", "someone ", "qux@bar.com"),
+		// Duplicate of 3 by name, hence of 1 as well.
+		newContact("6", "other", "other@bar.com"),
+	}
+
+	duplicates, err := proton.FindDuplicateContacts(kr, contacts)
+	require.NoError(t, err)
+
+	require.Equal(t, [][]string{{"1", "3", "6"}, {"2", "5"}}, xslices.Map(duplicates, func(group []proton.Contact) []string {
+		return xslices.Map(group, func(contact proton.Contact) string { return contact.ID })
+	}))
+}
+
 func encryptMessage(key *crypto.Key) ([]byte, error) {
 	var buf bytes.Buffer
 	kr, err := crypto.NewKeyRing(key)
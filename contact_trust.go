@@ -0,0 +1,448 @@
+package proton
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/ProtonMail/gluon/rfc822"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/emersion/go-vcard"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// GetKeyTrust returns how much the key used to encrypt messages to the recipient can be trusted,
+// comparing the keys returned by the API with those pinned in the recipient's contact.
+// The signed card of the contact is verified with kr.
+func (c *Client) GetKeyTrust(ctx context.Context, kr *crypto.KeyRing, email string) (KeyTrust, error) {
+	pubKeys, _, err := c.GetPublicKeys(ctx, email)
+	if err != nil {
+		return KeyTrustNone, err
+	}
+
+	apiKeys, err := getSendKeys(pubKeys)
+	if err != nil {
+		return KeyTrustNone, err
+	}
+
+	settings, err := c.getRecipientSettings(ctx, kr, email)
+	if err != nil {
+		return KeyTrustNone, err
+	}
+
+	return getKeyTrust(apiKeys, xslices.Filter(settings.Keys, isSendKey)), nil
+}
+
+// GetMessageAttachedKey returns the public key attached to the message, which must belong to its sender
+// and have signed the message. It returns ErrNoAttachedKey if the message has no attached key.
+func (c *Client) GetMessageAttachedKey(ctx context.Context, messageID string, addrKR *crypto.KeyRing) (*crypto.Key, error) {
+	msg, err := c.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, att := range msg.Attachments {
+		if !isPublicKeyAttachment(att) {
+			continue
+		}
+
+		data, err := c.GetAttachment(ctx, att.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		kps, err := base64.StdEncoding.DecodeString(att.KeyPackets)
+		if err != nil {
+			return nil, err
+		}
+
+		dec, err := addrKR.Decrypt(crypto.NewPGPSplitMessage(kps, data).GetPGPMessage(), nil, crypto.GetUnixTime())
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := readPublicKey(dec.GetBinary())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attached key: %w", err)
+		}
+
+		if msg.Sender == nil || !hasKeyIdentity(key, msg.Sender.Address) {
+			return nil, errors.New("attached key doesn't belong to the sender")
+		}
+
+		if err := verifyMessageSignature(msg, addrKR, key); err != nil {
+			return nil, fmt.Errorf("message isn't signed by the attached key: %w", err)
+		}
+
+		return key, nil
+	}
+
+	return nil, ErrNoAttachedKey
+}
+
+// PinAttachedKey pins, in the contact of the message's sender, the public key attached to the message.
+// The message is decrypted with addrKR and the contact is signed with kr.
+func (c *Client) PinAttachedKey(ctx context.Context, kr, addrKR *crypto.KeyRing, messageID string) (Contact, error) {
+	msg, err := c.GetMessage(ctx, messageID)
+	if err != nil {
+		return Contact{}, err
+	}
+
+	key, err := c.GetMessageAttachedKey(ctx, messageID, addrKR)
+	if err != nil {
+		return Contact{}, err
+	}
+
+	return c.PinContactKey(ctx, kr, msg.Sender.Address, key)
+}
+
+// PinPublicKey pins, in the recipient's contact, the key the API returns to encrypt messages to the recipient.
+func (c *Client) PinPublicKey(ctx context.Context, kr *crypto.KeyRing, email string) (Contact, error) {
+	pubKeys, _, err := c.GetPublicKeys(ctx, email)
+	if err != nil {
+		return Contact{}, err
+	}
+
+	keys, err := getSendKeys(pubKeys)
+	if err != nil {
+		return Contact{}, err
+	} else if len(keys) == 0 {
+		return Contact{}, fmt.Errorf("no usable key for %v", email)
+	}
+
+	return c.PinContactKey(ctx, kr, email, keys[0])
+}
+
+// PinContactKey pins the key in the contact of the email, as the preferred key of the email.
+// The contact is created if the email has none. The key is stored, without its private part,
+// in the signed card of the contact, in the group of the email.
+func (c *Client) PinContactKey(ctx context.Context, kr *crypto.KeyRing, email string, key *crypto.Key) (Contact, error) {
+	if !isSendKey(key) {
+		return Contact{}, errors.New("key can't be used for encryption")
+	}
+
+	pubKey := key
+
+	if key.IsPrivate() {
+		var err error
+
+		if pubKey, err = key.ToPublic(); err != nil {
+			return Contact{}, err
+		}
+	}
+
+	return c.updateContactKeys(ctx, kr, email, true, func(keys []*crypto.Key) []*crypto.Key {
+		return append([]*crypto.Key{pubKey}, xslices.Filter(keys, func(other *crypto.Key) bool {
+			return other.GetFingerprint() != pubKey.GetFingerprint()
+		})...)
+	})
+}
+
+// UnpinContactKey removes the key with the given fingerprint from the keys pinned in the contact of the email.
+func (c *Client) UnpinContactKey(ctx context.Context, kr *crypto.KeyRing, email, fingerprint string) (Contact, error) {
+	return c.updateContactKeys(ctx, kr, email, false, func(keys []*crypto.Key) []*crypto.Key {
+		return xslices.Filter(keys, func(key *crypto.Key) bool {
+			return key.GetFingerprint() != fingerprint
+		})
+	})
+}
+
+// updateContactKeys replaces the keys pinned for the email in the signed card of its contact.
+// If create is set, the contact is created if the email has none.
+func (c *Client) updateContactKeys(
+	ctx context.Context,
+	kr *crypto.KeyRing,
+	email string,
+	create bool,
+	update func([]*crypto.Key) []*crypto.Key,
+) (Contact, error) {
+	emails, err := c.GetAllContactEmails(ctx, email)
+	if err != nil {
+		return Contact{}, err
+	}
+
+	var contact Contact
+
+	switch {
+	case len(emails) > 0:
+		if contact, err = c.GetContact(ctx, emails[0].ContactID); err != nil {
+			return Contact{}, err
+		}
+
+	case create:
+		card := newVCard()
+
+		card.AddValue(vcard.FieldFormattedName, email)
+		card.Add(vcard.FieldEmail, &vcard.Field{Value: email, Group: "item1"})
+
+		cards, err := NewContactCards(kr, card)
+		if err != nil {
+			return Contact{}, err
+		}
+
+		res, err := c.CreateContacts(ctx, CreateContactsReq{Contacts: []ContactCards{{Cards: cards}}})
+		if err != nil {
+			return Contact{}, err
+		} else if len(res) != 1 {
+			return Contact{}, fmt.Errorf("unexpected number of created contacts: %v", len(res))
+		} else if res[0].Response.Code != SuccessCode {
+			return Contact{}, res[0].Response.APIError
+		}
+
+		contact = res[0].Response.Contact
+
+	default:
+		return Contact{}, fmt.Errorf("no contact for %v", email)
+	}
+
+	signedCard, ok := contact.Cards.Get(CardTypeSigned)
+	if !ok {
+		if signedCard, err = NewCard(kr, CardTypeSigned); err != nil {
+			return Contact{}, err
+		}
+
+		contact.Cards = append(contact.Cards, signedCard)
+	}
+
+	card, err := signedCard.decode(kr)
+	if err != nil {
+		return Contact{}, err
+	}
+
+	group := getContactEmailGroup(card, email)
+
+	keys, err := getContactKeys(card, group)
+	if err != nil {
+		return Contact{}, err
+	}
+
+	card[vcard.FieldKey] = xslices.Filter(card[vcard.FieldKey], func(field *vcard.Field) bool {
+		return field.Group != group
+	})
+
+	for i, key := range update(keys) {
+		value, err := encodeContactKey(key)
+		if err != nil {
+			return Contact{}, err
+		}
+
+		card.Add(vcard.FieldKey, &vcard.Field{
+			Value:  value,
+			Group:  group,
+			Params: vcard.Params{vcard.ParamPreferred: {strconv.Itoa(i + 1)}},
+		})
+	}
+
+	if len(card[vcard.FieldKey]) == 0 {
+		delete(card, vcard.FieldKey)
+	}
+
+	if err := signedCard.encode(kr, card); err != nil {
+		return Contact{}, err
+	}
+
+	return c.UpdateContact(ctx, contact.ID, UpdateContactReq{Cards: contact.Cards})
+}
+
+// getKeyTrust returns the trust of the keys to which messages are encrypted, given the send keys returned
+// by the API and those pinned in the recipient's contact.
+func getKeyTrust(apiKeys, pinnedKeys []*crypto.Key) KeyTrust {
+	switch {
+	case len(apiKeys) == 0 && len(pinnedKeys) == 0:
+		return KeyTrustNone
+
+	case len(pinnedKeys) == 0:
+		return KeyTrustUnpinned
+
+	case len(apiKeys) == 0:
+		return KeyTrustPinned
+	}
+
+	for _, key := range apiKeys {
+		if xslices.IndexFunc(pinnedKeys, func(pinned *crypto.Key) bool {
+			return pinned.GetFingerprint() == key.GetFingerprint()
+		}) >= 0 {
+			return KeyTrustPinned
+		}
+	}
+
+	return KeyTrustMismatch
+}
+
+// getContactEmailGroup returns the group of the email in the card. The email is added to the card if it isn't
+// in it yet, and put in a new group if it has none, so that its settings can be stored next to it.
+func getContactEmailGroup(card vcard.Card, email string) string {
+	newGroup := func() string {
+		for i := 1; ; i++ {
+			group := fmt.Sprintf("item%v", i)
+
+			if !xslices.Any(maps.Values(card), func(fields []*vcard.Field) bool {
+				return slices.ContainsFunc(fields, func(field *vcard.Field) bool { return strings.EqualFold(field.Group, group) })
+			}) {
+				return group
+			}
+		}
+	}
+
+	idx := xslices.IndexFunc(card[vcard.FieldEmail], func(field *vcard.Field) bool {
+		return normalizeContactEmail(field.Value) == normalizeContactEmail(email)
+	})
+
+	if idx < 0 {
+		group := newGroup()
+
+		card.Add(vcard.FieldEmail, &vcard.Field{Value: email, Group: group})
+
+		return group
+	}
+
+	field := card[vcard.FieldEmail][idx]
+
+	if field.Group == "" {
+		field.Group = newGroup()
+	}
+
+	return field.Group
+}
+
+// getContactKeys returns the keys in the group of the card, in order of preference.
+func getContactKeys(card vcard.Card, group string) ([]*crypto.Key, error) {
+	fields := xslices.Filter(card[vcard.FieldKey], func(field *vcard.Field) bool {
+		return field.Group == group
+	})
+
+	slices.SortStableFunc(fields, func(a, b *vcard.Field) bool {
+		return getContactKeyPref(a) < getContactKeyPref(b)
+	})
+
+	var keys []*crypto.Key
+
+	for _, field := range fields {
+		key, err := decodeContactKey(field.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func getContactKeyPref(field *vcard.Field) int {
+	pref, err := strconv.Atoi(field.Params.Get(vcard.ParamPreferred))
+	if err != nil {
+		return 100
+	}
+
+	return pref
+}
+
+// encodeContactKey encodes the key as the value of a KEY field, as a data URI.
+func encodeContactKey(key *crypto.Key) (string, error) {
+	data, err := key.Serialize()
+	if err != nil {
+		return "", err
+	}
+
+	return "data:application/pgp-keys;base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decodeContactKey decodes the value of a KEY field: a data URI, a base64 value with a prefix such as "base64,",
+// or a plain base64 value.
+func decodeContactKey(value string) (*crypto.Key, error) {
+	// Commas aren't part of the base64 alphabet; anything before one is a prefix.
+	if _, encoded, ok := strings.Cut(value, ","); ok {
+		value = encoded
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.NewKey(data)
+}
+
+// verifyMessageSignature returns an error if the message, decrypted with addrKR, isn't signed by the key,
+// either with a signature in its encrypted body or, for PGP/MIME messages, with a multipart/signed body.
+func verifyMessageSignature(msg Message, addrKR *crypto.KeyRing, key *crypto.Key) error {
+	verifyKR, err := crypto.NewKeyRing(key)
+	if err != nil {
+		return err
+	}
+
+	enc, err := crypto.NewPGPMessageFromArmored(msg.Body)
+	if err != nil {
+		return err
+	}
+
+	dec, sigErr := addrKR.Decrypt(enc, verifyKR, crypto.GetUnixTime())
+	if sigErr == nil {
+		return nil
+	} else if !errors.As(sigErr, new(crypto.SignatureVerificationError)) {
+		return sigErr
+	}
+
+	section := rfc822.Parse(dec.GetBinary())
+
+	if mimeType, _, err := section.ContentType(); err != nil || mimeType != "multipart/signed" {
+		return sigErr
+	}
+
+	parts, err := section.Children()
+	if err != nil {
+		return err
+	} else if len(parts) != 2 {
+		return errors.New("invalid multipart/signed body")
+	}
+
+	sigData, err := parts[1].DecodedBody()
+	if err != nil {
+		return err
+	}
+
+	sig := crypto.NewPGPSignature(sigData)
+
+	if bytes.HasPrefix(bytes.TrimSpace(sigData), []byte("-----BEGIN")) {
+		if sig, err = crypto.NewPGPSignatureFromArmored(string(sigData)); err != nil {
+			return err
+		}
+	}
+
+	return verifyKR.VerifyDetached(crypto.NewPlainMessage(parts[0].Literal()), sig, crypto.GetUnixTime())
+}
+
+// readPublicKey reads a public key, armored or not.
+func readPublicKey(data []byte) (*crypto.Key, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return crypto.NewKeyFromArmored(string(data))
+	}
+
+	return crypto.NewKey(data)
+}
+
+func hasKeyIdentity(key *crypto.Key, email string) bool {
+	for _, identity := range key.GetEntity().Identities {
+		if identity.UserId != nil && normalizeContactEmail(identity.UserId.Email) == normalizeContactEmail(email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isPublicKeyAttachment(att Attachment) bool {
+	if mediaType, _, err := mime.ParseMediaType(string(att.MIMEType)); err == nil && mediaType == "application/pgp-keys" {
+		return true
+	}
+
+	return strings.HasSuffix(strings.ToLower(att.Name), ".asc") && strings.Contains(strings.ToLower(att.Name), "publickey")
+}
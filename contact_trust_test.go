@@ -0,0 +1,87 @@
+package proton
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyMessageSignature(t *testing.T) {
+	addrKR := newTestKeyRing(t, "user@proton.test")
+	senderKR := newTestKeyRing(t, "sender@example.com")
+	otherKR := newTestKeyRing(t, "other@example.com")
+
+	senderKey := senderKR.GetKeys()[0]
+
+	encrypt := func(body string, signKR *crypto.KeyRing) Message {
+		enc, err := addrKR.Encrypt(crypto.NewPlainMessageFromString(body), signKR)
+		require.NoError(t, err)
+
+		arm, err := enc.GetArmored()
+		require.NoError(t, err)
+
+		return Message{Body: arm}
+	}
+
+	// Bodies with a signature of the key are verified; others aren't.
+	require.NoError(t, verifyMessageSignature(encrypt("Hello.", senderKR), addrKR, senderKey))
+	require.Error(t, verifyMessageSignature(encrypt("Hello.", nil), addrKR, senderKey))
+	require.Error(t, verifyMessageSignature(encrypt("Hello.", otherKR), addrKR, senderKey))
+
+	// PGP/MIME bodies are verified with their detached signature, made over the signed part.
+	signed := "Content-Type: text/plain\r\n\r\nHello."
+
+	newSignedBody := func(content string, signKR *crypto.KeyRing) string {
+		sig, err := signKR.SignDetached(crypto.NewPlainMessageFromString(signed))
+		require.NoError(t, err)
+
+		armSig, err := sig.GetArmored()
+		require.NoError(t, err)
+
+		return fmt.Sprintf(
+			"Content-Type: multipart/signed; boundary=boundary; protocol=\"application/pgp-signature\"; micalg=pgp-sha256\r\n\r\n"+
+				"--boundary\r\n%v\r\n"+
+				"--boundary\r\nContent-Type: application/pgp-signature\r\n\r\n%v\r\n"+
+				"--boundary--\r\n",
+			content, armSig,
+		)
+	}
+
+	require.NoError(t, verifyMessageSignature(encrypt(newSignedBody(signed, senderKR), nil), addrKR, senderKey))
+	require.Error(t, verifyMessageSignature(encrypt(newSignedBody(signed, otherKR), nil), addrKR, senderKey))
+	require.Error(t, verifyMessageSignature(encrypt(newSignedBody(signed+" Changed.", senderKR), nil), addrKR, senderKey))
+}
+
+func TestDecodeContactKey(t *testing.T) {
+	key, err := crypto.GenerateKey("sender", "sender@example.com", "x25519", 0)
+	require.NoError(t, err)
+
+	data, err := key.Serialize()
+	require.NoError(t, err)
+
+	for _, value := range []string{
+		"data:application/pgp-keys;base64," + base64.StdEncoding.EncodeToString(data),
+		"base64," + base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(data),
+	} {
+		decoded, err := decodeContactKey(value)
+		require.NoError(t, err)
+		require.Equal(t, key.GetFingerprint(), decoded.GetFingerprint())
+	}
+
+	_, err = decodeContactKey("data:application/pgp-keys;base64")
+	require.Error(t, err)
+}
+
+func newTestKeyRing(t *testing.T, email string) *crypto.KeyRing {
+	key, err := crypto.GenerateKey(email, email, "x25519", 0)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	return kr
+}
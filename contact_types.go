@@ -1,16 +1,16 @@
 package proton
 
 import (
-	"encoding/base64"
 	"errors"
 	"strconv"
-	"strings"
 
 	"github.com/ProtonMail/gluon/rfc822"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/emersion/go-vcard"
 )
 
+var ErrNoAttachedKey = errors.New("no attached public key")
+
 type RecipientType int
 
 const (
@@ -18,6 +18,44 @@ const (
 	RecipientTypeExternal
 )
 
+// KeyTrust is how much the key used to encrypt messages to a recipient can be trusted.
+type KeyTrust int
+
+const (
+	// KeyTrustNone means the recipient has no key to encrypt to.
+	KeyTrustNone KeyTrust = iota
+
+	// KeyTrustUnpinned means the key is returned by the API but isn't pinned in the recipient's contact.
+	KeyTrustUnpinned
+
+	// KeyTrustPinned means the key is pinned in the recipient's contact and, if the API returns keys
+	// for the recipient, is one of them.
+	KeyTrustPinned
+
+	// KeyTrustMismatch means the API returns keys for the recipient but none of them is pinned
+	// in the recipient's contact: the recipient's keys changed, or the API returns the wrong keys.
+	KeyTrustMismatch
+)
+
+func (trust KeyTrust) String() string {
+	switch trust {
+	case KeyTrustNone:
+		return "none"
+
+	case KeyTrustUnpinned:
+		return "unpinned"
+
+	case KeyTrustPinned:
+		return "pinned"
+
+	case KeyTrustMismatch:
+		return "mismatch"
+
+	default:
+		return "unknown"
+	}
+}
+
 type ContactSettings struct {
 	MIMEType         *rfc822.MIMEType
 	Scheme           *EncryptionScheme
@@ -150,25 +188,13 @@ func (c *Contact) GetSettings(kr *crypto.KeyRing, email string, cardType CardTyp
 		settings.EncryptUntrusted = newPtr(b)
 	}
 
-	keys, err := group.Get(vcard.FieldKey)
+	dec, err := group.decode(kr)
 	if err != nil {
 		return ContactSettings{}, err
 	}
 
-	if len(keys) > 0 {
-		for _, key := range keys {
-			dec, err := base64.StdEncoding.DecodeString(strings.SplitN(key, ",", 2)[1])
-			if err != nil {
-				return ContactSettings{}, err
-			}
-
-			pubKey, err := crypto.NewKey(dec)
-			if err != nil {
-				return ContactSettings{}, err
-			}
-
-			settings.Keys = append(settings.Keys, pubKey)
-		}
+	if settings.Keys, err = getContactKeys(dec, group.group); err != nil {
+		return ContactSettings{}, err
 	}
 
 	return settings, nil
@@ -263,20 +289,25 @@ func (c *Contact) SetSettings(kr *crypto.KeyRing, email string, cardType CardTyp
 	}
 
 	// KEY
-	if settings.Keys != nil && len(settings.Keys) > 0 {
-		var keys = ""
+	if len(settings.Keys) > 0 {
+		if keys, err := group.Get(vcard.FieldKey); err != nil {
+			return err
+		} else if len(keys) > 0 {
+			if err := group.RemoveAll(vcard.FieldKey); err != nil {
+				return err
+			}
+		}
+
 		for i, key := range settings.Keys {
-			if i > 0 {
-				keys += ","
+			value, err := encodeContactKey(key)
+			if err != nil {
+				return err
 			}
-			if dec, err := key.Serialize(); err == nil {
-				keys += string(dec)
+
+			if err := group.Add(vcard.FieldKey, value, vcard.Params{vcard.ParamPreferred: {strconv.Itoa(i + 1)}}); err != nil {
+				return err
 			}
 		}
-		enc := base64.StdEncoding.EncodeToString([]byte(keys))
-		if err := group.Set(vcard.FieldKey, "base64,"+enc, vcard.Params{}); err != nil {
-			return err
-		}
 	}
 	*signedCard = group.Card
 	return nil
@@ -334,3 +365,36 @@ type DeleteContactsReq struct {
 func newPtr[T any](v T) *T {
 	return &v
 }
+
+// ImportContactRes is the result of importing one of the vCards of a VCF stream.
+type ImportContactRes struct {
+	// Index is the position of the vCard in the stream.
+	Index int
+
+	Contact Contact
+	Err     error
+}
+
+type LabelContactEmailsReq struct {
+	LabelID         string
+	ContactEmailIDs []string
+}
+
+type LabelContactEmailsRes struct {
+	Responses []LabelContactEmailRes
+}
+
+func (res LabelContactEmailsRes) ok() (bool, string) {
+	for _, resp := range res.Responses {
+		if resp.Response.Code != SuccessCode {
+			return false, resp.Response.Error()
+		}
+	}
+
+	return true, ""
+}
+
+type LabelContactEmailRes struct {
+	ID       string
+	Response APIError
+}
@@ -0,0 +1,372 @@
+package proton
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/emersion/go-vcard"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+// maxCreateContacts is the maximum number of contacts created in a single request.
+const maxCreateContacts = 10
+
+// ExportContacts writes all the user's contacts to w as a stream of vCard 4.0 cards, one per contact.
+// The cards of each contact (clear, signed and encrypted) are merged together; kr must be able to
+// verify and decrypt them.
+func (c *Client) ExportContacts(ctx context.Context, kr *crypto.KeyRing, w io.Writer) error {
+	contacts, err := c.getAllContactsWithCards(ctx)
+	if err != nil {
+		return err
+	}
+
+	enc := vcard.NewEncoder(w)
+
+	for _, contact := range contacts {
+		card, err := MergeContactCards(kr, contact.Cards)
+		if err != nil {
+			return fmt.Errorf("failed to merge cards of contact %v: %w", contact.ID, err)
+		}
+
+		if err := enc.Encode(card); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getAllContactsWithCards returns all the user's contacts along with their cards.
+func (c *Client) getAllContactsWithCards(ctx context.Context) ([]Contact, error) {
+	contacts, err := c.GetAllContacts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, contact := range contacts {
+		// Contacts may be listed without their cards.
+		if len(contact.Cards) > 0 {
+			continue
+		}
+
+		if contacts[i], err = c.GetContact(ctx, contact.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return contacts, nil
+}
+
+// ImportContacts creates a contact for each of the vCards read from r.
+// The contacts are created in batches; an error in one vCard, or in the request creating one batch,
+// doesn't prevent the others from being imported. The result of each vCard is returned in the order in which they were read;
+// text between vCards is reported as a single invalid vCard. If r fails, no contact is created and the read error is returned.
+func (c *Client) ImportContacts(ctx context.Context, kr *crypto.KeyRing, r io.Reader) ([]ImportContactRes, error) {
+	var (
+		res     []ImportContactRes
+		pending []int
+		cards   []ContactCards
+	)
+
+	sc := newVCardScanner(r)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		entry, err := sc.next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read vCards: %w", err)
+		}
+
+		res = append(res, ImportContactRes{Index: len(res)})
+
+		// Each entry is decoded on its own, so that a syntax error only affects the vCard it is in.
+		card, err := vcard.NewDecoder(strings.NewReader(entry)).Decode()
+		if err != nil {
+			res[len(res)-1].Err = err
+			continue
+		}
+
+		contactCards, err := NewContactCards(kr, card)
+		if err != nil {
+			res[len(res)-1].Err = err
+			continue
+		}
+
+		pending = append(pending, len(res)-1)
+		cards = append(cards, ContactCards{Cards: contactCards})
+	}
+
+	for start := 0; start < len(pending); start += maxCreateContacts {
+		end := min(start+maxCreateContacts, len(pending))
+
+		created, err := c.CreateContacts(ctx, CreateContactsReq{Contacts: cards[start:end]})
+		if err != nil {
+			for _, idx := range pending[start:end] {
+				res[idx].Err = err
+			}
+
+			continue
+		}
+
+		for _, item := range created {
+			if item.Index < 0 || item.Index >= end-start {
+				return res, fmt.Errorf("invalid contact index %v in response", item.Index)
+			}
+
+			idx := pending[start+item.Index]
+
+			if item.Response.Code != SuccessCode {
+				res[idx].Err = item.Response.APIError
+			} else {
+				res[idx].Contact = item.Response.Contact
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// vcardScanner splits a stream into its vCards, from a BEGIN:VCARD line to the matching END:VCARD line,
+// and the runs of text between them.
+type vcardScanner struct {
+	r *bufio.Reader
+
+	// line is the first line of the next entry, if it was already read.
+	line string
+	err  error
+}
+
+func newVCardScanner(r io.Reader) *vcardScanner {
+	return &vcardScanner{r: bufio.NewReader(r)}
+}
+
+// next returns the next vCard, or the next run of text that isn't one, or io.EOF at the end of the stream.
+// Blank lines between vCards are skipped.
+func (sc *vcardScanner) next() (string, error) {
+	var entry strings.Builder
+
+	inCard := false
+
+	for {
+		line, err := sc.readLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) && entry.Len() > 0 {
+				return entry.String(), nil
+			}
+
+			return "", err
+		}
+
+		switch {
+		case isVCardLine(line, "BEGIN"):
+			// A new vCard ends the current entry, even if it is a vCard without its END line.
+			if entry.Len() > 0 {
+				sc.line = line
+				return entry.String(), nil
+			}
+
+			inCard = true
+
+		case entry.Len() == 0 && strings.TrimSpace(line) == "":
+			continue
+		}
+
+		entry.WriteString(line)
+
+		if inCard && isVCardLine(line, "END") {
+			return entry.String(), nil
+		}
+	}
+}
+
+// readLine returns the next line of the stream, including its line ending.
+func (sc *vcardScanner) readLine() (string, error) {
+	if sc.line != "" {
+		line := sc.line
+		sc.line = ""
+
+		return line, nil
+	}
+
+	if sc.err != nil {
+		return "", sc.err
+	}
+
+	line, err := sc.r.ReadString('\n')
+	if err != nil {
+		// The last line may not end with a line ending; it is returned before the error.
+		sc.err = err
+
+		if line != "" {
+			return line, nil
+		}
+	}
+
+	return line, err
+}
+
+// isVCardLine returns whether the line is the given BEGIN or END line of a vCard.
+func isVCardLine(line, name string) bool {
+	key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+
+	return ok && strings.EqualFold(key, name) && strings.EqualFold(value, "VCARD")
+}
+
+// NewContactCards splits the vCard into the cards of a contact: the formatted name, the UID, the emails
+// and the settings of the emails go in a signed card; everything else goes in an encrypted and signed card.
+// If the vCard has no formatted name, its first email is used; if it has no UID, one is generated.
+func NewContactCards(kr *crypto.KeyRing, card vcard.Card) (Cards, error) {
+	card = cloneVCard(card)
+
+	vcard.ToV4(card)
+
+	signed, encrypted := newVCard(), newVCard()
+
+	if card.PreferredValue(vcard.FieldFormattedName) == "" {
+		email := card.PreferredValue(vcard.FieldEmail)
+		if email == "" {
+			return nil, errors.New("contact has neither a name nor an email")
+		}
+
+		card.SetValue(vcard.FieldFormattedName, email)
+	}
+
+	if card.Value(vcard.FieldUID) == "" {
+		card.SetValue(vcard.FieldUID, "proton-autosave-"+uuid.NewString())
+	}
+
+	// The settings of an email share its group.
+	emailGroups := make(map[string]struct{})
+
+	for _, field := range card[vcard.FieldEmail] {
+		if field.Group != "" {
+			emailGroups[field.Group] = struct{}{}
+		}
+	}
+
+	for key, fields := range card {
+		if strings.EqualFold(key, vcard.FieldVersion) || strings.EqualFold(key, vcard.FieldProductID) {
+			continue
+		}
+
+		for _, field := range fields {
+			if isSignedContactField(key, field, emailGroups) {
+				signed.Add(key, field)
+			} else {
+				encrypted.Add(key, field)
+			}
+		}
+	}
+
+	signedCard := &Card{Type: CardTypeSigned}
+
+	if err := signedCard.encode(kr, signed); err != nil {
+		return nil, err
+	}
+
+	cards := Cards{signedCard}
+
+	// The encrypted card is only needed if there is something besides its version.
+	if len(encrypted) > 1 {
+		encryptedCard := &Card{Type: CardTypeEncrypted | CardTypeSigned}
+
+		if err := encryptedCard.encode(kr, encrypted); err != nil {
+			return nil, err
+		}
+
+		cards = append(cards, encryptedCard)
+	}
+
+	return cards, nil
+}
+
+// MergeContactCards merges the cards of a contact into a single vCard 4.0.
+// Fields that appear identically in several cards are kept once.
+func MergeContactCards(kr *crypto.KeyRing, cards Cards) (vcard.Card, error) {
+	merged := newVCard()
+
+	for _, card := range cards {
+		dec, err := card.decode(kr)
+		if err != nil {
+			return nil, err
+		}
+
+		vcard.ToV4(dec)
+
+		for key, fields := range dec {
+			if strings.EqualFold(key, vcard.FieldVersion) || strings.EqualFold(key, vcard.FieldProductID) {
+				continue
+			}
+
+			for _, field := range fields {
+				if slices.ContainsFunc(merged[key], func(other *vcard.Field) bool { return equalVCardFields(field, other) }) {
+					continue
+				}
+
+				merged.Add(key, field)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// isSignedContactField returns whether the field goes in the signed card of a contact.
+func isSignedContactField(key string, field *vcard.Field, emailGroups map[string]struct{}) bool {
+	switch strings.ToUpper(key) {
+	case vcard.FieldFormattedName, vcard.FieldUID, vcard.FieldEmail:
+		return true
+
+	case vcard.FieldKey, FieldPMScheme, FieldPMSign, FieldPMEncrypt, FieldPMEncryptUntrusted, FieldPMMIMEType:
+		_, ok := emailGroups[field.Group]
+		return ok
+	}
+
+	return false
+}
+
+func cloneVCard(card vcard.Card) vcard.Card {
+	clone := make(vcard.Card, len(card))
+
+	for key, fields := range card {
+		clone[key] = xslices.Map(fields, func(field *vcard.Field) *vcard.Field {
+			clone := *field
+
+			clone.Params = make(vcard.Params, len(field.Params))
+
+			for key, values := range field.Params {
+				clone.Params[key] = slices.Clone(values)
+			}
+
+			return &clone
+		})
+	}
+
+	return clone
+}
+
+func equalVCardFields(a, b *vcard.Field) bool {
+	if a.Value != b.Value || a.Group != b.Group || len(a.Params) != len(b.Params) {
+		return false
+	}
+
+	for key, values := range a.Params {
+		if !slices.Equal(values, b.Params[key]) {
+			return false
+		}
+	}
+
+	return true
+}
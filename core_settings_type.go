@@ -12,6 +12,11 @@ type SetTelemetryReq struct {
 type SetCrashReportReq struct {
 	CrashReports SettingsBool
 }
+
+type SetPasswordReq struct {
+	Auth AuthVerifier
+}
+
 type SettingsBool int
 
 const (
@@ -0,0 +1,76 @@
+package drivedav
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache is a size-bounded cache which evicts its least recently used entries first.
+// It is safe for concurrent use.
+type cache[K comparable, V any] struct {
+	size int
+
+	items map[K]*list.Element
+	order *list.List
+	lock  sync.Mutex
+}
+
+type cacheItem[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newCache[K comparable, V any](size int) *cache[K, V] {
+	return &cache[K, V]{
+		size:  size,
+		items: make(map[K]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (c *cache[K, V]) get(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return *new(V), false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*cacheItem[K, V]).value, true
+}
+
+func (c *cache[K, V]) set(key K, value V) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheItem[K, V]).value = value
+		c.order.MoveToFront(elem)
+
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheItem[K, V]{key: key, value: value})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+
+		delete(c.items, oldest.Value.(*cacheItem[K, V]).key)
+
+		c.order.Remove(oldest)
+	}
+}
+
+func (c *cache[K, V]) delete(key K) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		delete(c.items, key)
+
+		c.order.Remove(elem)
+	}
+}
@@ -0,0 +1,41 @@
+package drivedav
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	c := newCache[string, int](2)
+
+	c.set("a", 1)
+	c.set("b", 2)
+
+	// Reading an entry makes it the most recently used.
+	v, ok := c.get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	// The least recently used entry is evicted.
+	c.set("c", 3)
+
+	_, ok = c.get("b")
+	require.False(t, ok)
+
+	v, ok = c.get("c")
+	require.True(t, ok)
+	require.Equal(t, 3, v)
+
+	// Entries are updated and deleted.
+	c.set("a", 4)
+
+	v, ok = c.get("a")
+	require.True(t, ok)
+	require.Equal(t, 4, v)
+
+	c.delete("a")
+
+	_, ok = c.get("a")
+	require.False(t, ok)
+}
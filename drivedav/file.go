@@ -0,0 +1,346 @@
+package drivedav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"golang.org/x/net/webdav"
+)
+
+var (
+	errIsDir     = errors.New("is a folder")
+	errNotDir    = errors.New("not a folder")
+	errReadOnly  = errors.New("file is opened for reading")
+	errWriteOnly = errors.New("file is opened for writing")
+	errAborted   = errors.New("upload was interrupted")
+)
+
+// fileInfo is the info of a link. It implements webdav.ContentTyper and webdav.ETager,
+// so that listing a folder doesn't require downloading the content of its files.
+type fileInfo struct {
+	name     string
+	size     int64
+	modTime  time.Time
+	isDir    bool
+	mimeType string
+
+	revisionID string
+	blockSizes []int64
+}
+
+func (info *fileInfo) Name() string       { return info.name }
+func (info *fileInfo) Size() int64        { return info.size }
+func (info *fileInfo) ModTime() time.Time { return info.modTime }
+func (info *fileInfo) IsDir() bool        { return info.isDir }
+func (info *fileInfo) Sys() any           { return nil }
+
+func (info *fileInfo) Mode() fs.FileMode {
+	if info.isDir {
+		return fs.ModeDir | 0o700
+	}
+
+	return 0o600
+}
+
+func (info *fileInfo) ContentType(context.Context) (string, error) {
+	if info.mimeType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+
+	return info.mimeType, nil
+}
+
+func (info *fileInfo) ETag(context.Context) (string, error) {
+	if info.revisionID == "" {
+		return "", webdav.ErrNotImplemented
+	}
+
+	return `"` + info.revisionID + `"`, nil
+}
+
+// dir is an opened folder.
+type dir struct {
+	fs   *FileSystem
+	ctx  context.Context
+	link proton.Link
+	info *fileInfo
+
+	children []fs.FileInfo
+	listed   bool
+}
+
+func (d *dir) Readdir(count int) ([]fs.FileInfo, error) {
+	if !d.listed {
+		children, err := d.list()
+		if err != nil {
+			return nil, err
+		}
+
+		d.children, d.listed = children, true
+	}
+
+	if count <= 0 {
+		children := d.children
+		d.children = nil
+
+		return children, nil
+	}
+
+	if len(d.children) == 0 {
+		return nil, io.EOF
+	}
+
+	n := min(count, len(d.children))
+	children := d.children[:n]
+	d.children = d.children[n:]
+
+	return children, nil
+}
+
+func (d *dir) list() ([]fs.FileInfo, error) {
+	children, err := d.fs.c.ListChildren(d.ctx, d.fs.shareID, d.link.LinkID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	kr, err := d.fs.keyRing(d.ctx, d.link)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]fs.FileInfo, 0, len(children))
+
+	for _, child := range children {
+		// Children that can't be decrypted are not listed.
+		name, err := d.fs.getName(child, kr)
+		if err != nil {
+			continue
+		}
+
+		info, err := d.fs.getFileInfo(d.ctx, child, name)
+		if err != nil {
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (d *dir) Stat() (fs.FileInfo, error)                   { return d.info, nil }
+func (d *dir) Read([]byte) (int, error)                     { return 0, errIsDir }
+func (d *dir) Write([]byte) (int, error)                    { return 0, errIsDir }
+func (d *dir) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (d *dir) Close() error                                 { return nil }
+
+// reader is a file opened for reading. Blocks are downloaded, decrypted and verified one at a time, as they are read.
+type reader struct {
+	ctx        context.Context
+	c          *proton.Client
+	blocks     []proton.Block
+	signerKR   *crypto.KeyRing
+	nodeKR     *crypto.KeyRing
+	sessionKey *crypto.SessionKey
+	info       *fileInfo
+
+	// offsets holds the offset of each block in the file, followed by the file size.
+	offsets []int64
+	offset  int64
+
+	current int
+	data    []byte
+}
+
+func newReader(
+	ctx context.Context,
+	c *proton.Client,
+	blocks []proton.Block,
+	signerKR, nodeKR *crypto.KeyRing,
+	sessionKey *crypto.SessionKey,
+	info *fileInfo,
+) (*reader, error) {
+	r := &reader{
+		ctx:        ctx,
+		c:          c,
+		blocks:     blocks,
+		signerKR:   signerKR,
+		nodeKR:     nodeKR,
+		sessionKey: sessionKey,
+		info:       info,
+		current:    -1,
+	}
+
+	blockSizes := info.blockSizes
+
+	// Without extended attributes, the size of each block is only known once it is decrypted.
+	if len(blockSizes) != len(blocks) {
+		blockSizes = make([]int64, len(blocks))
+
+		for i := range blocks {
+			data, err := r.download(i)
+			if err != nil {
+				return nil, err
+			}
+
+			blockSizes[i] = int64(len(data))
+		}
+	}
+
+	r.offsets = make([]int64, len(blocks)+1)
+
+	for i, size := range blockSizes {
+		r.offsets[i+1] = r.offsets[i] + size
+	}
+
+	info.size = r.offsets[len(blocks)]
+
+	return r, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.offset >= r.info.size {
+		return 0, io.EOF
+	}
+
+	index := sort.Search(len(r.blocks), func(i int) bool {
+		return r.offsets[i+1] > r.offset
+	})
+
+	if index != r.current {
+		data, err := r.download(index)
+		if err != nil {
+			return 0, err
+		}
+
+		r.current, r.data = index, data
+	}
+
+	if r.offset-r.offsets[index] > int64(len(r.data)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n := copy(p, r.data[r.offset-r.offsets[index]:])
+
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		// offset is absolute.
+
+	case io.SeekCurrent:
+		offset += r.offset
+
+	case io.SeekEnd:
+		offset += r.info.size
+
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	r.offset = offset
+
+	return offset, nil
+}
+
+// download downloads and decrypts the block at the given index, verifying its signature.
+func (r *reader) download(index int) ([]byte, error) {
+	return r.c.DownloadBlock(r.ctx, r.blocks[index], r.signerKR, r.nodeKR, r.sessionKey)
+}
+
+func (r *reader) Stat() (fs.FileInfo, error)         { return r.info, nil }
+func (r *reader) Readdir(int) ([]fs.FileInfo, error) { return nil, errNotDir }
+func (r *reader) Write([]byte) (int, error)          { return 0, errReadOnly }
+func (r *reader) Close() error                       { return nil }
+
+// writer is a file opened for writing. The written content is streamed to the given upload function,
+// which is started by the first write and runs until the file is closed. If the body of the request
+// writing the file wasn't read entirely, the upload is aborted when the file is closed.
+type writer struct {
+	ctx    context.Context
+	info   *fileInfo
+	upload func(io.Reader) error
+
+	pw    *io.PipeWriter
+	errCh chan error
+}
+
+func newWriter(ctx context.Context, name string, upload func(io.Reader) error) *writer {
+	return &writer{
+		ctx:    ctx,
+		info:   &fileInfo{name: name, modTime: time.Now()},
+		upload: upload,
+
+		errCh: make(chan error, 1),
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.start()
+
+	n, err := w.pw.Write(p)
+
+	w.info.size += int64(n)
+
+	return n, err
+}
+
+func (w *writer) Close() error {
+	if !isBodyComplete(w.ctx) {
+		if w.pw == nil {
+			return errAborted
+		}
+
+		_ = w.pw.CloseWithError(errAborted)
+
+		<-w.errCh
+
+		return errAborted
+	}
+
+	w.start()
+
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+
+	return <-w.errCh
+}
+
+func (w *writer) start() {
+	if w.pw != nil {
+		return
+	}
+
+	pr, pw := io.Pipe()
+
+	w.pw = pw
+
+	go func() {
+		err := w.upload(pr)
+
+		// Unblock the writer if the upload stopped before reading all the content.
+		_ = pr.CloseWithError(err)
+
+		w.errCh <- err
+	}()
+}
+
+func (w *writer) Stat() (fs.FileInfo, error)         { return w.info, nil }
+func (w *writer) Read([]byte) (int, error)           { return 0, errWriteOnly }
+func (w *writer) Seek(int64, int) (int64, error)     { return 0, errWriteOnly }
+func (w *writer) Readdir(int) ([]fs.FileInfo, error) { return nil, errNotDir }
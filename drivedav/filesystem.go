@@ -0,0 +1,508 @@
+// Package drivedav serves a Drive share over WebDAV.
+package drivedav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"golang.org/x/net/webdav"
+)
+
+// FileSystem is a webdav.FileSystem exposing the root folder of a Drive share.
+// Decrypted link names and node keyrings are cached; file contents are streamed block by block.
+type FileSystem struct {
+	c *proton.Client
+
+	addr   proton.Address
+	addrKR *crypto.KeyRing
+
+	shareID string
+	rootID  string
+
+	krs *proton.LinkKeyRings
+
+	// names caches decrypted link names, keyed by encrypted name.
+	names *cache[string, string]
+
+	// children caches the IDs of the children of folders, keyed by parent link ID and decrypted name.
+	// Cached children are checked against their current parent and name before being used.
+	children *cache[childKey, string]
+}
+
+type childKey struct {
+	parentID string
+	name     string
+}
+
+const (
+	// maxCachedNames is the maximum number of decrypted link names kept in cache.
+	maxCachedNames = 10000
+
+	// maxCachedChildren is the maximum number of resolved children kept in cache.
+	maxCachedChildren = 10000
+)
+
+// New returns a new FileSystem exposing the given share. Uploaded content is signed with the given address.
+func New(ctx context.Context, c *proton.Client, addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing) (*FileSystem, error) {
+	root, err := c.GetLink(ctx, share.ShareID, share.LinkID)
+	if err != nil {
+		return nil, err
+	}
+
+	rootKR, err := root.GetKeyRing(shareKR, addrKR)
+	if err != nil {
+		return nil, err
+	}
+
+	krs := proton.NewLinkKeyRings(addrKR)
+
+	krs.Add(root.LinkID, rootKR)
+
+	return &FileSystem{
+		c: c,
+
+		addr:   addr,
+		addrKR: addrKR,
+
+		shareID: share.ShareID,
+		rootID:  share.LinkID,
+
+		krs: krs,
+
+		names:    newCache[string, string](maxCachedNames),
+		children: newCache[childKey, string](maxCachedChildren),
+	}, nil
+}
+
+// Handler returns a WebDAV handler serving the file system, with an in-memory lock system.
+// Files are only committed once the body of the request uploading them has been read entirely.
+func (fs *FileSystem) Handler() http.Handler {
+	handler := &webdav.Handler{
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			body := &requestBody{ReadCloser: r.Body}
+
+			r = r.WithContext(context.WithValue(r.Context(), requestBodyKey{}, body))
+			r.Body = body
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (fs *FileSystem) Mkdir(ctx context.Context, name string, _ os.FileMode) error {
+	if _, err := fs.resolve(ctx, name); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	parent, parentKR, parentHashKey, err := fs.resolveParent(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	req, err := proton.NewCreateFolderReq(parent.LinkID, path.Base(cleanPath(name)), parentKR, parentHashKey, fs.addrKR, fs.addr.Email)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fs.c.CreateFolder(ctx, fs.shareID, req); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	link, err := fs.resolve(ctx, name)
+
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, os.ErrExist
+
+	case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0:
+		return fs.create(ctx, name)
+
+	case err != nil:
+		return nil, err
+	}
+
+	info, err := fs.getFileInfo(ctx, link, path.Base(cleanPath(name)))
+	if err != nil {
+		return nil, err
+	}
+
+	if link.Type == proton.LinkTypeFolder {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, errors.New("cannot write to a folder")
+		}
+
+		return &dir{fs: fs, ctx: ctx, link: link, info: info}, nil
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return fs.update(ctx, link, info)
+	}
+
+	return fs.open(ctx, link, info)
+}
+
+// RemoveAll moves the given file or folder to the trash.
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	if cleanPath(name) == "" {
+		return errors.New("cannot remove the root folder")
+	}
+
+	link, err := fs.resolve(ctx, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return fs.c.TrashChildren(ctx, fs.shareID, link.ParentLinkID, link.LinkID)
+}
+
+func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if cleanPath(oldName) == "" {
+		return errors.New("cannot move the root folder")
+	}
+
+	link, err := fs.resolve(ctx, oldName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fs.resolve(ctx, newName); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	oldParentKR, err := fs.keyRingByID(ctx, link.ParentLinkID)
+	if err != nil {
+		return err
+	}
+
+	parent, parentKR, parentHashKey, err := fs.resolveParent(ctx, newName)
+	if err != nil {
+		return err
+	}
+
+	req, err := proton.NewMoveLinkReq(link, parent.LinkID, path.Base(cleanPath(newName)), oldParentKR, parentKR, parentHashKey, fs.addrKR, fs.addr.Email)
+	if err != nil {
+		return err
+	}
+
+	return fs.c.MoveLink(ctx, fs.shareID, link.LinkID, req)
+}
+
+func (fs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	link, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.getFileInfo(ctx, link, path.Base(cleanPath(name)))
+}
+
+// resolve returns the link at the given path, or os.ErrNotExist if there is none.
+func (fs *FileSystem) resolve(ctx context.Context, name string) (proton.Link, error) {
+	link, err := fs.c.GetLink(ctx, fs.shareID, fs.rootID)
+	if err != nil {
+		return proton.Link{}, err
+	}
+
+	for _, elem := range strings.Split(cleanPath(name), "/") {
+		if elem == "" {
+			continue
+		}
+
+		if link.Type != proton.LinkTypeFolder {
+			return proton.Link{}, os.ErrNotExist
+		}
+
+		if link, err = fs.lookup(ctx, link, elem); err != nil {
+			return proton.Link{}, err
+		}
+	}
+
+	return link, nil
+}
+
+// resolveParent returns the folder containing the given path, along with its keyring and hash key.
+func (fs *FileSystem) resolveParent(ctx context.Context, name string) (proton.Link, *crypto.KeyRing, []byte, error) {
+	parent, err := fs.resolve(ctx, path.Dir(cleanPath(name)))
+	if err != nil {
+		return proton.Link{}, nil, nil, err
+	}
+
+	if parent.Type != proton.LinkTypeFolder {
+		return proton.Link{}, nil, nil, os.ErrNotExist
+	}
+
+	parentKR, err := fs.keyRing(ctx, parent)
+	if err != nil {
+		return proton.Link{}, nil, nil, err
+	}
+
+	parentHashKey, err := parent.GetHashKey(parentKR)
+	if err != nil {
+		return proton.Link{}, nil, nil, err
+	}
+
+	return parent, parentKR, parentHashKey, nil
+}
+
+// lookup returns the child of the given folder with the given name.
+// A cached child is used if it is still in the folder with that name; otherwise, the folder is listed.
+func (fs *FileSystem) lookup(ctx context.Context, parent proton.Link, name string) (proton.Link, error) {
+	parentKR, err := fs.keyRing(ctx, parent)
+	if err != nil {
+		return proton.Link{}, err
+	}
+
+	key := childKey{parentID: parent.LinkID, name: name}
+
+	if childID, ok := fs.children.get(key); ok {
+		if child, err := fs.c.GetLink(ctx, fs.shareID, childID); err == nil && child.State == proton.LinkStateActive && child.ParentLinkID == parent.LinkID {
+			if childName, err := fs.getName(child, parentKR); err == nil && childName == name {
+				return child, nil
+			}
+		}
+
+		fs.children.delete(key)
+	}
+
+	children, err := fs.c.ListChildren(ctx, fs.shareID, parent.LinkID, false)
+	if err != nil {
+		return proton.Link{}, err
+	}
+
+	for _, child := range children {
+		childName, err := fs.getName(child, parentKR)
+		if err != nil {
+			continue
+		}
+
+		fs.children.set(childKey{parentID: parent.LinkID, name: childName}, child.LinkID)
+
+		if childName == name {
+			return child, nil
+		}
+	}
+
+	return proton.Link{}, os.ErrNotExist
+}
+
+// getName returns the decrypted name of the given link.
+func (fs *FileSystem) getName(link proton.Link, parentKR *crypto.KeyRing) (string, error) {
+	if name, ok := fs.names.get(link.Name); ok {
+		return name, nil
+	}
+
+	name, err := link.GetName(parentKR, fs.addrKR)
+	if err != nil {
+		return "", err
+	}
+
+	fs.names.set(link.Name, name)
+
+	return name, nil
+}
+
+// keyRing returns the node keyring of the given link.
+func (fs *FileSystem) keyRing(ctx context.Context, link proton.Link) (*crypto.KeyRing, error) {
+	if kr, ok := fs.krs.Get(link.LinkID); ok {
+		return kr, nil
+	}
+
+	parentKR, err := fs.keyRingByID(ctx, link.ParentLinkID)
+	if err != nil {
+		return nil, err
+	}
+
+	kr, err := link.GetKeyRing(parentKR, fs.addrKR)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.krs.Add(link.LinkID, kr)
+
+	return kr, nil
+}
+
+// keyRingByID returns the node keyring of the link with the given ID, fetching it if it isn't cached.
+func (fs *FileSystem) keyRingByID(ctx context.Context, linkID string) (*crypto.KeyRing, error) {
+	if kr, ok := fs.krs.Get(linkID); ok {
+		return kr, nil
+	}
+
+	link, err := fs.c.GetLink(ctx, fs.shareID, linkID)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.keyRing(ctx, link)
+}
+
+// getFileInfo returns the info of the given link; the size and modification time of files are read from
+// their extended attributes when available.
+func (fs *FileSystem) getFileInfo(ctx context.Context, link proton.Link, name string) (*fileInfo, error) {
+	info := &fileInfo{
+		name:     name,
+		size:     link.Size,
+		modTime:  time.Unix(link.ModifyTime, 0),
+		isDir:    link.Type == proton.LinkTypeFolder,
+		mimeType: link.MIMEType,
+	}
+
+	if link.Type != proton.LinkTypeFile || link.FileProperties == nil {
+		return info, nil
+	}
+
+	info.revisionID = link.FileProperties.ActiveRevision.ID
+
+	nodeKR, err := fs.keyRing(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	if xAttr, err := link.FileProperties.ActiveRevision.GetXAttr(nodeKR, fs.addrKR); err == nil && xAttr != nil {
+		info.size = xAttr.Common.Size
+		info.modTime = xAttr.Common.ModificationTime
+		info.blockSizes = xAttr.Common.BlockSizes
+	}
+
+	return info, nil
+}
+
+// create returns a file whose written content is streamed to a new file at the given path.
+func (fs *FileSystem) create(ctx context.Context, name string) (webdav.File, error) {
+	parent, parentKR, parentHashKey, err := fs.resolveParent(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	name = path.Base(cleanPath(name))
+
+	return newWriter(ctx, name, func(r io.Reader) error {
+		req, nodeKR, sessionKey, err := proton.NewCreateFileReq(parent.LinkID, name, proton.GetMIMEType(name), parentKR, parentHashKey, fs.addrKR, fs.addr.Email)
+		if err != nil {
+			return err
+		}
+
+		res, err := fs.c.CreateFile(ctx, fs.shareID, req)
+		if err != nil {
+			return err
+		}
+
+		if err := fs.c.UploadRevision(ctx, fs.shareID, res.ID, res.RevisionID, fs.addr, fs.addrKR, nodeKR, sessionKey, time.Now(), r); err != nil {
+			// The draft file is deleted, even if the request was canceled, so that it doesn't keep its name.
+			_ = fs.c.DeleteChildren(context.WithoutCancel(ctx), fs.shareID, parent.LinkID, res.ID)
+
+			return err
+		}
+
+		return nil
+	}), nil
+}
+
+// update returns a file whose written content is streamed to a new revision of the given file.
+func (fs *FileSystem) update(ctx context.Context, link proton.Link, info *fileInfo) (webdav.File, error) {
+	nodeKR, err := fs.keyRing(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionKey, err := link.GetSessionKey(nodeKR)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWriter(ctx, info.name, func(r io.Reader) error {
+		revision, err := fs.c.CreateRevision(ctx, fs.shareID, link.LinkID)
+		if err != nil {
+			return err
+		}
+
+		if err := fs.c.UploadRevision(ctx, fs.shareID, link.LinkID, revision.ID, fs.addr, fs.addrKR, nodeKR, sessionKey, time.Now(), r); err != nil {
+			// The draft revision is deleted, even if the request was canceled, as it would prevent new revisions.
+			_ = fs.c.DeleteRevision(context.WithoutCancel(ctx), fs.shareID, link.LinkID, revision.ID)
+
+			return err
+		}
+
+		return nil
+	}), nil
+}
+
+// open returns a file reading the content of the given file.
+func (fs *FileSystem) open(ctx context.Context, link proton.Link, info *fileInfo) (webdav.File, error) {
+	nodeKR, err := fs.keyRing(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionKey, err := link.GetSessionKey(nodeKR)
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := fs.c.GetRevisionAllBlocks(ctx, fs.shareID, link.LinkID, info.revisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocks are verified with the keys of the address which uploaded them.
+	signerKR, err := fs.c.GetSignerKeyRing(ctx, revision.SignatureEmail, fs.addr, fs.addrKR)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReader(ctx, fs.c, revision.Blocks, signerKR, nodeKR, sessionKey, info)
+}
+
+// requestBody is the body of a request writing a file, which records whether it was read entirely.
+type requestBody struct {
+	io.ReadCloser
+
+	complete bool
+}
+
+type requestBodyKey struct{}
+
+func (body *requestBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+
+	if errors.Is(err, io.EOF) {
+		body.complete = true
+	}
+
+	return n, err
+}
+
+// isBodyComplete returns whether the body of the request of the context, if any, was read entirely.
+func isBodyComplete(ctx context.Context) bool {
+	body, ok := ctx.Value(requestBodyKey{}).(*requestBody)
+
+	return !ok || body.complete
+}
+
+// cleanPath returns the given WebDAV path without its leading and trailing slashes.
+func cleanPath(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
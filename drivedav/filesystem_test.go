@@ -0,0 +1,214 @@
+package drivedav_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/gluon/async"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/go-proton-api/drivedav"
+	"github.com/ProtonMail/go-proton-api/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSystem(t *testing.T) {
+	withDAV(t, func(ctx context.Context, dav *davClient) {
+		// Files are uploaded and downloaded.
+		require.Equal(t, http.StatusCreated, dav.do(t, http.MethodPut, "/hello.txt", nil, "hello").StatusCode)
+		require.Equal(t, "hello", dav.get(t, "/hello.txt"))
+
+		// Folders are created.
+		require.Equal(t, http.StatusCreated, dav.do(t, "MKCOL", "/dir", nil, "").StatusCode)
+		require.Equal(t, http.StatusMethodNotAllowed, dav.do(t, "MKCOL", "/dir", nil, "").StatusCode)
+
+		// Files spanning several blocks are streamed, and can be read partially.
+		big := make([]byte, 5*1024*1024)
+		_, err := rand.Read(big)
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusCreated, dav.do(t, http.MethodPut, "/dir/big.bin", nil, string(big)).StatusCode)
+		require.Equal(t, string(big), dav.get(t, "/dir/big.bin"))
+
+		res := dav.do(t, http.MethodGet, "/dir/big.bin", map[string]string{"Range": "bytes=4194300-4194310"}, "")
+		require.Equal(t, http.StatusPartialContent, res.StatusCode)
+		require.Equal(t, big[4194300:4194311], readBody(t, res))
+
+		// Folders are listed.
+		require.ElementsMatch(t, []string{"/", "/hello.txt", "/dir/"}, dav.list(t, "/"))
+		require.ElementsMatch(t, []string{"/dir/", "/dir/big.bin"}, dav.list(t, "/dir/"))
+
+		// Existing files get a new revision.
+		require.Equal(t, http.StatusCreated, dav.do(t, http.MethodPut, "/hello.txt", nil, "hello again").StatusCode)
+		require.Equal(t, "hello again", dav.get(t, "/hello.txt"))
+
+		// Interrupted uploads are not committed, and don't prevent new ones.
+		require.NotEqual(t, http.StatusCreated, dav.putInterrupted(t, "/hello.txt", "cut off"))
+		require.Equal(t, "hello again", dav.get(t, "/hello.txt"))
+
+		require.NotEqual(t, http.StatusCreated, dav.putInterrupted(t, "/new.txt", "cut off"))
+		require.Equal(t, http.StatusNotFound, dav.do(t, http.MethodGet, "/new.txt", nil, "").StatusCode)
+
+		require.Equal(t, http.StatusCreated, dav.do(t, http.MethodPut, "/hello.txt", nil, "hello again").StatusCode)
+		require.Equal(t, "hello again", dav.get(t, "/hello.txt"))
+
+		// Files are moved and renamed.
+		require.Equal(t, http.StatusCreated, dav.do(t, "MOVE", "/hello.txt", map[string]string{"Destination": "/dir/moved.txt"}, "").StatusCode)
+		require.Equal(t, http.StatusNotFound, dav.do(t, http.MethodGet, "/hello.txt", nil, "").StatusCode)
+		require.Equal(t, "hello again", dav.get(t, "/dir/moved.txt"))
+
+		require.Equal(t, http.StatusCreated, dav.do(t, "MOVE", "/dir", map[string]string{"Destination": "/renamed"}, "").StatusCode)
+		require.Equal(t, "hello again", dav.get(t, "/renamed/moved.txt"))
+
+		// Files and folders are deleted.
+		require.Equal(t, http.StatusNoContent, dav.do(t, http.MethodDelete, "/renamed", nil, "").StatusCode)
+		require.ElementsMatch(t, []string{"/"}, dav.list(t, "/"))
+	})
+}
+
+type davClient struct {
+	url string
+}
+
+func (dav *davClient) do(t *testing.T, method, path string, header map[string]string, body string) *http.Response {
+	req, err := http.NewRequest(method, dav.url+path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	for key, value := range header {
+		if key == "Destination" {
+			value = dav.url + value
+		}
+
+		req.Header.Set(key, value)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = res.Body.Close() })
+
+	return res
+}
+
+// putInterrupted sends a PUT request whose body ends before its announced length and returns the response status.
+func (dav *davClient) putInterrupted(t *testing.T, path, body string) int {
+	davURL, err := url.Parse(dav.url)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", davURL.Host)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "PUT %v HTTP/1.1\r\nHost: %v\r\nContent-Length: %v\r\n\r\n%v", path, davURL.Host, len(body)+100, body)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	return res.StatusCode
+}
+
+func (dav *davClient) get(t *testing.T, path string) string {
+	res := dav.do(t, http.MethodGet, path, nil, "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	return string(readBody(t, res))
+}
+
+// list returns the paths of the given folder and of its children.
+func (dav *davClient) list(t *testing.T, path string) []string {
+	res := dav.do(t, "PROPFIND", path, map[string]string{"Depth": "1"}, "")
+	require.Equal(t, http.StatusMultiStatus, res.StatusCode)
+
+	var multistatus struct {
+		Responses []struct {
+			Href string `xml:"href"`
+		} `xml:"response"`
+	}
+
+	require.NoError(t, xml.NewDecoder(bytes.NewReader(readBody(t, res))).Decode(&multistatus))
+
+	var hrefs []string
+
+	for _, res := range multistatus.Responses {
+		hrefs = append(hrefs, res.Href)
+	}
+
+	return hrefs
+}
+
+func readBody(t *testing.T, res *http.Response) []byte {
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return b
+}
+
+func withDAV(t *testing.T, fn func(ctx context.Context, dav *davClient)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := server.New()
+	defer s.Close()
+
+	_, _, err := s.CreateUser("user", []byte("pass"))
+	require.NoError(t, err)
+
+	m := proton.New(
+		proton.WithHostURL(s.GetHostURL()),
+		proton.WithTransport(proton.InsecureTransport()),
+	)
+	defer m.Close()
+
+	c, _, err := m.NewClientWithLogin(ctx, "user", []byte("pass"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	user, err := c.GetUser(ctx)
+	require.NoError(t, err)
+
+	addr, err := c.GetAddresses(ctx)
+	require.NoError(t, err)
+
+	salt, err := c.GetSalts(ctx)
+	require.NoError(t, err)
+
+	keyPass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+	require.NoError(t, err)
+
+	_, addrKRs, err := proton.Unlock(user, addr, keyPass, async.NoopPanicHandler{})
+	require.NoError(t, err)
+
+	req, err := proton.NewCreateVolumeReq(addr[0].ID, addrKRs[addr[0].ID])
+	require.NoError(t, err)
+
+	volume, err := c.CreateVolume(ctx, req)
+	require.NoError(t, err)
+
+	share, err := c.GetShare(ctx, volume.Share.ShareID)
+	require.NoError(t, err)
+
+	shareKR, err := share.GetKeyRing(addrKRs[addr[0].ID])
+	require.NoError(t, err)
+
+	fs, err := drivedav.New(ctx, c, addr[0], addrKRs[addr[0].ID], share, shareKR)
+	require.NoError(t, err)
+
+	dav := httptest.NewServer(fs.Handler())
+	defer dav.Close()
+
+	fn(ctx, &davClient{url: dav.URL})
+}
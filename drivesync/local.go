@@ -0,0 +1,452 @@
+package drivesync
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// push scans the local directory and uploads the local changes since the last synchronisation.
+// New files and folders are created, changed files get a new revision and removed links are trashed.
+func (s *Syncer) push(ctx context.Context) error {
+	seen := make(map[string]struct{})
+
+	if err := filepath.WalkDir(s.dir, func(abs string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if abs == s.dir {
+			return nil
+		}
+
+		// Temporary files, the state file and anything that isn't a regular file or a folder are not synchronised.
+		if strings.HasPrefix(d.Name(), tmpPrefix) || abs == s.statePath || !(d.Type().IsRegular() || d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, abs)
+		if err != nil {
+			return err
+		}
+
+		entry, err := s.pushPath(ctx, filepath.ToSlash(rel), d)
+		if err != nil {
+			return err
+		}
+
+		if entry == nil {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		seen[entry.LinkID] = struct{}{}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return s.trashMissing(ctx, seen)
+}
+
+// pushPath uploads the local changes of the given path and returns its entry.
+// It returns nil if the path can't be synchronised.
+func (s *Syncer) pushPath(ctx context.Context, rel string, d fs.DirEntry) (*Entry, error) {
+	parentID, ok := s.getParentID(rel)
+	if !ok {
+		return nil, nil
+	}
+
+	entry, ok := s.state.getByPath(rel)
+
+	// The upload of a new file was interrupted: the file is kept if its upload completed, or created again.
+	if ok && entry.Draft {
+		resumed, err := s.resumeDraft(ctx, entry)
+		if err != nil {
+			return nil, err
+		}
+
+		ok = resumed
+	}
+
+	// A file replaced by a folder, or the opposite, is trashed and created again.
+	if ok && entry.IsDir != d.IsDir() {
+		if err := s.trash(ctx, entry); err != nil {
+			return nil, err
+		}
+
+		ok = false
+	}
+
+	switch {
+	case !ok && d.IsDir():
+		return s.createFolder(ctx, parentID, rel)
+
+	case !ok:
+		return s.createFile(ctx, parentID, rel)
+
+	case !d.IsDir():
+		return entry, s.updateFile(ctx, entry)
+
+	default:
+		return entry, nil
+	}
+}
+
+// getParentID returns the link ID of the parent folder of the given path, or false if it is not synchronised.
+func (s *Syncer) getParentID(rel string) (string, bool) {
+	parent := path.Dir(rel)
+	if parent == "." {
+		return s.share.LinkID, true
+	}
+
+	entry, ok := s.state.getByPath(parent)
+	if !ok || !entry.IsDir {
+		return "", false
+	}
+
+	return entry.LinkID, true
+}
+
+// getFolder returns the keyring and the hash key of the given folder, with which its children are created.
+func (s *Syncer) getFolder(ctx context.Context, linkID string) (*crypto.KeyRing, []byte, error) {
+	link, err := s.c.GetLink(ctx, s.share.ShareID, linkID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kr, err := s.keyRing(ctx, linkID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashKey, err := link.GetHashKey(kr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return kr, hashKey, nil
+}
+
+func (s *Syncer) createFolder(ctx context.Context, parentID, rel string) (*Entry, error) {
+	parentKR, parentHashKey, err := s.getFolder(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := proton.NewCreateFolderReq(parentID, path.Base(rel), parentKR, parentHashKey, s.addrKR, s.addr.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.c.CreateFolder(ctx, s.share.ShareID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{
+		LinkID:       res.ID,
+		ParentLinkID: parentID,
+		Path:         rel,
+		IsDir:        true,
+	}
+
+	s.state.Links[entry.LinkID] = entry
+
+	return entry, s.save()
+}
+
+// createFile creates the given file and uploads its first revision.
+// The file is recorded as a draft before its content is uploaded, so that an interrupted upload can be resumed.
+func (s *Syncer) createFile(ctx context.Context, parentID, rel string) (*Entry, error) {
+	parentKR, parentHashKey, err := s.getFolder(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	f, info, err := openFile(s.abs(rel))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	req, nodeKR, sessionKey, err := proton.NewCreateFileReq(
+		parentID,
+		path.Base(rel),
+		proton.GetMIMEType(rel),
+		parentKR,
+		parentHashKey,
+		s.addrKR,
+		s.addr.Email,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.c.CreateFile(ctx, s.share.ShareID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{
+		LinkID:       res.ID,
+		ParentLinkID: parentID,
+		Path:         rel,
+		RevisionID:   res.RevisionID,
+		Draft:        true,
+	}
+
+	s.state.Links[entry.LinkID] = entry
+
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+
+	hash := sha1.New() //nolint:gosec
+
+	if err := s.c.UploadRevision(
+		ctx,
+		s.share.ShareID,
+		res.ID,
+		res.RevisionID,
+		s.addr,
+		s.addrKR,
+		nodeKR,
+		sessionKey,
+		info.ModTime(),
+		io.TeeReader(f, hash),
+	); err != nil {
+		return nil, err
+	}
+
+	entry.Draft = false
+	entry.Hash = hex.EncodeToString(hash.Sum(nil))
+	entry.Size, entry.ModTime = info.Size(), info.ModTime().UnixNano()
+
+	return entry, s.save()
+}
+
+// resumeDraft resumes the interrupted creation of the given file and returns whether the file still exists.
+// If the upload of its first revision completed, the file is kept and its local copy is compared with it by the
+// next update. Otherwise, its draft is deleted so that the file can be created again.
+func (s *Syncer) resumeDraft(ctx context.Context, entry *Entry) (bool, error) {
+	link, err := s.c.GetLink(ctx, s.share.ShareID, entry.LinkID)
+	if isNotFound(err) {
+		s.forget(entry.LinkID)
+		return false, s.save()
+	} else if err != nil {
+		return false, err
+	}
+
+	if link.State == proton.LinkStateActive && link.FileProperties != nil && link.FileProperties.ActiveRevision.ID == entry.RevisionID {
+		nodeKR, err := s.keyRing(ctx, entry.LinkID)
+		if err != nil {
+			return false, err
+		}
+
+		xAttr, err := link.FileProperties.ActiveRevision.GetXAttr(nodeKR, s.addrKR)
+		if err != nil {
+			return false, err
+		}
+
+		if xAttr != nil {
+			entry.Hash = xAttr.Common.Digests.SHA1
+		}
+
+		entry.Draft = false
+
+		return true, s.save()
+	}
+
+	return false, s.deleteDraft(ctx, entry)
+}
+
+// deleteDraft deletes the draft left behind by the interrupted creation of the given file and forgets it.
+func (s *Syncer) deleteDraft(ctx context.Context, entry *Entry) error {
+	if err := s.c.DeleteChildren(ctx, s.share.ShareID, entry.ParentLinkID, entry.LinkID); err != nil && !isNotFound(err) {
+		return err
+	}
+
+	s.forget(entry.LinkID)
+
+	return s.save()
+}
+
+// updateFile uploads a new revision of the given file if it changed since it was last synchronised.
+func (s *Syncer) updateFile(ctx context.Context, entry *Entry) error {
+	info, err := os.Stat(s.abs(entry.Path))
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == entry.Size && info.ModTime().UnixNano() == entry.ModTime {
+		return nil
+	}
+
+	hash, err := hashFile(s.abs(entry.Path))
+	if err != nil {
+		return err
+	}
+
+	if hash == entry.Hash {
+		entry.Size, entry.ModTime = info.Size(), info.ModTime().UnixNano()
+		return s.save()
+	}
+
+	link, err := s.c.GetLink(ctx, s.share.ShareID, entry.LinkID)
+	if err != nil {
+		return err
+	}
+
+	// The remote file changed since the last pull; the conflict is resolved by the next pull.
+	if link.FileProperties == nil || link.FileProperties.ActiveRevision.ID != entry.RevisionID {
+		return nil
+	}
+
+	nodeKR, err := s.keyRing(ctx, entry.LinkID)
+	if err != nil {
+		return err
+	}
+
+	sessionKey, err := link.GetSessionKey(nodeKR)
+	if err != nil {
+		return err
+	}
+
+	// Drafts left behind by an interrupted upload would prevent creating a new revision.
+	revisions, err := s.c.ListRevisions(ctx, s.share.ShareID, entry.LinkID)
+	if err != nil {
+		return err
+	}
+
+	for _, revision := range revisions {
+		if revision.State == proton.RevisionStateDraft {
+			if err := s.c.DeleteRevision(ctx, s.share.ShareID, entry.LinkID, revision.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	revision, err := s.c.CreateRevision(ctx, s.share.ShareID, entry.LinkID)
+	if err != nil {
+		return err
+	}
+
+	f, info, err := openFile(s.abs(entry.Path))
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	digest := sha1.New() //nolint:gosec
+
+	if err := s.c.UploadRevision(
+		ctx,
+		s.share.ShareID,
+		entry.LinkID,
+		revision.ID,
+		s.addr,
+		s.addrKR,
+		nodeKR,
+		sessionKey,
+		info.ModTime(),
+		io.TeeReader(f, digest),
+	); err != nil {
+		return err
+	}
+
+	entry.RevisionID = revision.ID
+	entry.Hash = hex.EncodeToString(digest.Sum(nil))
+	entry.Size, entry.ModTime = info.Size(), info.ModTime().UnixNano()
+
+	return s.save()
+}
+
+// trashMissing trashes the synchronised links whose local copy was removed.
+func (s *Syncer) trashMissing(ctx context.Context, seen map[string]struct{}) error {
+	var missing []*Entry
+
+	for linkID, entry := range s.state.Links {
+		if _, ok := seen[linkID]; !ok {
+			missing = append(missing, entry)
+		}
+	}
+
+	// Parents are trashed before their children, which are then trashed along with them.
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].Path < missing[j].Path
+	})
+
+	for _, entry := range missing {
+		if _, ok := s.state.Links[entry.LinkID]; !ok {
+			continue
+		}
+
+		if err := s.trash(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trash trashes the given link and forgets it along with its descendants.
+func (s *Syncer) trash(ctx context.Context, entry *Entry) error {
+	// Drafts can't be trashed; they are deleted instead.
+	if entry.Draft {
+		return s.deleteDraft(ctx, entry)
+	}
+
+	if err := s.c.TrashChildren(ctx, s.share.ShareID, entry.ParentLinkID, entry.LinkID); err != nil && !isNotFound(err) {
+		return err
+	}
+
+	s.forget(entry.LinkID)
+
+	return s.save()
+}
+
+// forget removes the given link and its descendants from the state.
+func (s *Syncer) forget(linkID string) {
+	for _, child := range s.state.getChildren(linkID) {
+		s.forget(child.LinkID)
+	}
+
+	s.krs.Remove(linkID)
+
+	delete(s.state.Links, linkID)
+}
+
+// openFile opens the given file and returns it along with its info.
+func openFile(path string) (*os.File, fs.FileInfo, error) {
+	f, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+
+	return f, info, nil
+}
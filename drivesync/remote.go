@@ -0,0 +1,378 @@
+package drivesync
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/ProtonMail/go-proton-api"
+)
+
+// pull applies the remote changes that happened since the last applied event to the local directory.
+// On the first run, the whole remote tree is synchronised instead.
+func (s *Syncer) pull(ctx context.Context) error {
+	if s.state.LastEventID == "" {
+		eventID, err := s.c.GetLatestVolumeEventID(ctx, s.share.VolumeID)
+		if err != nil {
+			return err
+		}
+
+		if err := s.walk(ctx); err != nil {
+			return err
+		}
+
+		s.state.LastEventID = eventID
+
+		return s.save()
+	}
+
+	event, err := s.c.GetVolumeEvent(ctx, s.share.VolumeID, s.state.LastEventID)
+	if err != nil {
+		return err
+	}
+
+	if event.Refresh {
+		if err := s.walk(ctx); err != nil {
+			return err
+		}
+	} else {
+		for _, linkEvent := range event.Events {
+			if err := s.applyEvent(ctx, linkEvent); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Events are applied idempotently, so a crash before this point simply replays them on the next run.
+	s.state.LastEventID = event.EventID
+
+	return s.save()
+}
+
+// walk synchronises the whole remote tree, removing the local copies of links that no longer exist.
+func (s *Syncer) walk(ctx context.Context) error {
+	seen := make(map[string]struct{})
+
+	if err := s.walkFolder(ctx, s.share.LinkID, seen); err != nil {
+		return err
+	}
+
+	// Drafts are not listed; they are resumed or deleted by the next push.
+	for linkID, entry := range s.state.Links {
+		if _, ok := seen[linkID]; !ok && !entry.Draft {
+			if err := s.removeLink(linkID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Syncer) walkFolder(ctx context.Context, linkID string, seen map[string]struct{}) error {
+	children, err := s.c.ListChildren(ctx, s.share.ShareID, linkID, false)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if err := s.syncLink(ctx, child); err != nil {
+			return err
+		}
+
+		if _, ok := s.state.Links[child.LinkID]; !ok {
+			continue
+		}
+
+		seen[child.LinkID] = struct{}{}
+
+		if child.Type == proton.LinkTypeFolder {
+			if err := s.walkFolder(ctx, child.LinkID, seen); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyEvent applies the given link event to the local directory.
+// Events may be stale, so the current state of the link is fetched before it is synchronised.
+func (s *Syncer) applyEvent(ctx context.Context, event proton.LinkEvent) error {
+	if event.Change(nil).Type == proton.LinkChangeDelete {
+		return s.removeLink(event.Link.LinkID)
+	}
+
+	link, err := s.c.GetLink(ctx, s.share.ShareID, event.Link.LinkID)
+	if isNotFound(err) {
+		return s.removeLink(event.Link.LinkID)
+	} else if err != nil {
+		return err
+	}
+
+	return s.syncLink(ctx, link)
+}
+
+// syncLink synchronises the local copy of the given link with its remote state.
+func (s *Syncer) syncLink(ctx context.Context, link proton.Link) error {
+	if link.LinkID == s.share.LinkID {
+		return nil
+	}
+
+	switch link.State {
+	case proton.LinkStateActive:
+		// Active links are synchronised below.
+
+	case proton.LinkStateTrashed, proton.LinkStateDeleted:
+		return s.removeLink(link.LinkID)
+
+	default:
+		// Drafts are synchronised once their first revision is committed.
+		return nil
+	}
+
+	// Links whose parent is not synchronised (e.g. because it was trashed) are not synchronised either.
+	parentPath, ok := s.getPath(link.ParentLinkID)
+	if !ok {
+		return s.removeLink(link.LinkID)
+	}
+
+	parentKR, err := s.keyRing(ctx, link.ParentLinkID)
+	if err != nil {
+		return err
+	}
+
+	name, err := link.GetName(parentKR, s.addrKR)
+	if err != nil {
+		return err
+	}
+
+	if !isValidName(name) {
+		return s.removeLink(link.LinkID)
+	}
+
+	rel := path.Join(parentPath, name)
+
+	entry, ok := s.state.Links[link.LinkID]
+	if ok && entry.Path != rel {
+		if err := s.moveEntry(entry, rel); err != nil {
+			return err
+		}
+	}
+
+	if link.Type == proton.LinkTypeFolder {
+		return s.syncFolder(link, rel)
+	}
+
+	return s.syncFile(ctx, link, rel)
+}
+
+func (s *Syncer) syncFolder(link proton.Link, rel string) error {
+	if err := os.MkdirAll(s.abs(rel), 0o700); err != nil {
+		return err
+	}
+
+	if entry, ok := s.state.Links[link.LinkID]; ok && entry.ParentLinkID == link.ParentLinkID {
+		return nil
+	}
+
+	s.state.Links[link.LinkID] = &Entry{
+		LinkID:       link.LinkID,
+		ParentLinkID: link.ParentLinkID,
+		Path:         rel,
+		IsDir:        true,
+	}
+
+	return s.save()
+}
+
+func (s *Syncer) syncFile(ctx context.Context, link proton.Link, rel string) error {
+	if link.FileProperties == nil || link.FileProperties.ActiveRevision.ID == "" {
+		return nil
+	}
+
+	revision := link.FileProperties.ActiveRevision
+
+	entry, ok := s.state.Links[link.LinkID]
+	if ok && entry.RevisionID == revision.ID {
+		if entry.ParentLinkID != link.ParentLinkID {
+			entry.ParentLinkID = link.ParentLinkID
+			return s.save()
+		}
+
+		return nil
+	}
+
+	nodeKR, err := s.keyRing(ctx, link.LinkID)
+	if err != nil {
+		return err
+	}
+
+	var digest string
+
+	// The extended attributes may be missing or signed by another address; the content is then compared after download.
+	if xAttr, err := revision.GetXAttr(nodeKR, s.addrKR); err == nil && xAttr != nil {
+		digest = xAttr.Common.Digests.SHA1
+	}
+
+	hash, err := hashFile(s.abs(rel))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case hash == "":
+		// There is no local file; it is downloaded below.
+
+	case hash == digest:
+		// The local file already has the remote content.
+		return s.setFileEntry(link, rel, revision.ID, hash)
+
+	case ok && hash == entry.Hash:
+		// The local file didn't change since it was last synchronised; it is overwritten below.
+
+	default:
+		// Both the local and the remote file changed: the local file is kept as a conflict copy.
+		if err := os.Rename(s.abs(rel), s.abs(s.conflictPath(rel))); err != nil {
+			return err
+		}
+	}
+
+	return s.download(ctx, link, rel)
+}
+
+// download downloads the active revision of the given file to the given local path.
+// The content is first downloaded to a temporary file, which then atomically replaces the local file.
+func (s *Syncer) download(ctx context.Context, link proton.Link, rel string) error {
+	nodeKR, err := s.keyRing(ctx, link.LinkID)
+	if err != nil {
+		return err
+	}
+
+	sessionKey, err := link.GetSessionKey(nodeKR)
+	if err != nil {
+		return err
+	}
+
+	revisionID := link.FileProperties.ActiveRevision.ID
+
+	// Blocks are verified with the keys of the address which uploaded them.
+	signerKR, err := s.c.GetSignerKeyRing(ctx, link.FileProperties.ActiveRevision.SignatureEmail, s.addr, s.addrKR)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.abs(rel)), tmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	hash := sha1.New() //nolint:gosec
+
+	if err := s.c.DownloadRevision(ctx, s.share.ShareID, link.LinkID, revisionID, signerKR, nodeKR, sessionKey, io.MultiWriter(tmp, hash)); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if xAttr, err := link.FileProperties.ActiveRevision.GetXAttr(nodeKR, s.addrKR); err == nil && xAttr != nil {
+		if err := os.Chtimes(tmp.Name(), xAttr.Common.ModificationTime, xAttr.Common.ModificationTime); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), s.abs(rel)); err != nil {
+		return err
+	}
+
+	return s.setFileEntry(link, rel, revisionID, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// setFileEntry records that the local file at the given path is synchronised with the given revision.
+func (s *Syncer) setFileEntry(link proton.Link, rel, revisionID, hash string) error {
+	info, err := os.Stat(s.abs(rel))
+	if err != nil {
+		return err
+	}
+
+	s.state.Links[link.LinkID] = &Entry{
+		LinkID:       link.LinkID,
+		ParentLinkID: link.ParentLinkID,
+		Path:         rel,
+		RevisionID:   revisionID,
+		Hash:         hash,
+		Size:         info.Size(),
+		ModTime:      info.ModTime().UnixNano(),
+	}
+
+	return s.save()
+}
+
+// moveEntry moves the local copy of the given link, and of its descendants, to the given path.
+// A local file that is in the way is kept as a conflict copy.
+func (s *Syncer) moveEntry(entry *Entry, rel string) error {
+	if err := os.MkdirAll(filepath.Dir(s.abs(rel)), 0o700); err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(s.abs(rel)); err == nil {
+		if err := os.Rename(s.abs(rel), s.abs(s.conflictPath(rel))); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(s.abs(entry.Path), s.abs(rel)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	s.state.setPath(entry, rel)
+
+	return s.save()
+}
+
+// removeLink removes the local copy of the given link and of its descendants.
+// Local files that changed since they were last synchronised are kept; they are uploaded again as new files.
+func (s *Syncer) removeLink(linkID string) error {
+	entry, ok := s.state.Links[linkID]
+	if !ok {
+		return nil
+	}
+
+	for _, child := range s.state.getChildren(linkID) {
+		if err := s.removeLink(child.LinkID); err != nil {
+			return err
+		}
+	}
+
+	if entry.IsDir {
+		// Folders that still contain local files are kept.
+		_ = os.Remove(s.abs(entry.Path))
+	} else {
+		hash, err := hashFile(s.abs(entry.Path))
+		if err != nil {
+			return err
+		}
+
+		if hash == entry.Hash {
+			if err := os.Remove(s.abs(entry.Path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	s.krs.Remove(linkID)
+
+	delete(s.state.Links, linkID)
+
+	return s.save()
+}
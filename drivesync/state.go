@@ -0,0 +1,122 @@
+package drivesync
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// State is the persistent state of a Syncer.
+// It records the last Drive event that was applied locally and the links that are synchronised.
+type State struct {
+	LastEventID string
+
+	Links map[string]*Entry // Keyed by link ID.
+}
+
+// Entry is a synchronised link.
+type Entry struct {
+	LinkID       string
+	ParentLinkID string
+
+	Path  string // Slash-separated path of the link, relative to the local directory.
+	IsDir bool
+
+	RevisionID string // The revision the local file was last synchronised with.
+	Draft      bool   // The file was created but the upload of its first revision did not complete.
+	Hash       string // Hex encoded SHA1 digest of the local file when it was last synchronised.
+	Size       int64  // Size of the local file when it was last synchronised.
+	ModTime    int64  // Modification time of the local file when it was last synchronised, in nanoseconds.
+}
+
+// loadState loads the state from the given path. If there is no such file, an empty state is returned.
+func loadState(path string) (*State, error) {
+	state := &State{Links: make(map[string]*Entry)}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+
+	if state.Links == nil {
+		state.Links = make(map[string]*Entry)
+	}
+
+	return state, nil
+}
+
+// save atomically writes the state to the given path, so that a crash never leaves a partially written state.
+func (state *State) save(path string) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// getByPath returns the entry with the given path.
+func (state *State) getByPath(path string) (*Entry, bool) {
+	for _, entry := range state.Links {
+		if entry.Path == path {
+			return entry, true
+		}
+	}
+
+	return nil, false
+}
+
+// getChildren returns the entries whose parent is the given link.
+func (state *State) getChildren(linkID string) []*Entry {
+	var children []*Entry
+
+	for _, entry := range state.Links {
+		if entry.ParentLinkID == linkID {
+			children = append(children, entry)
+		}
+	}
+
+	return children
+}
+
+// setPath changes the path of the given entry and of its descendants.
+func (state *State) setPath(entry *Entry, path string) {
+	oldPath := entry.Path
+
+	for _, other := range state.Links {
+		if strings.HasPrefix(other.Path, oldPath+"/") {
+			other.Path = path + strings.TrimPrefix(other.Path, oldPath)
+		}
+	}
+
+	entry.Path = path
+}
@@ -0,0 +1,244 @@
+// Package drivesync implements a two-way synchronisation engine between a local directory and a Drive share.
+package drivesync
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// tmpPrefix is the prefix of the temporary files in which content is downloaded. They are never synchronised.
+const tmpPrefix = ".drivesync-"
+
+// Config configures a Syncer.
+type Config struct {
+	Client *proton.Client
+
+	// The address used to sign uploaded content, and its unlocked keyring.
+	Address proton.Address
+	AddrKR  *crypto.KeyRing
+
+	// The share to synchronise, and its unlocked keyring. It must be the main share of its volume.
+	Share   proton.Share
+	ShareKR *crypto.KeyRing
+
+	// The local directory to synchronise, and the file in which the synchronisation state is persisted.
+	LocalDir  string
+	StatePath string
+}
+
+// Syncer synchronises a local directory with the root folder of a Drive share.
+// Its state is persisted after every change so that synchronisation can resume after a crash.
+// It is not safe for concurrent use.
+type Syncer struct {
+	c *proton.Client
+
+	addr   proton.Address
+	addrKR *crypto.KeyRing
+
+	share proton.Share
+	krs   *proton.LinkKeyRings
+
+	dir       string
+	statePath string
+	state     *State
+}
+
+// New returns a new Syncer, loading its state from the configured state path if it exists.
+func New(ctx context.Context, cfg Config) (*Syncer, error) {
+	state, err := loadState(cfg.StatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	root, err := cfg.Client.GetLink(ctx, cfg.Share.ShareID, cfg.Share.LinkID)
+	if err != nil {
+		return nil, err
+	}
+
+	rootKR, err := root.GetKeyRing(cfg.ShareKR, cfg.AddrKR)
+	if err != nil {
+		return nil, err
+	}
+
+	krs := proton.NewLinkKeyRings(cfg.AddrKR)
+
+	krs.Add(root.LinkID, rootKR)
+
+	if err := os.MkdirAll(cfg.LocalDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	dir, err := filepath.Abs(cfg.LocalDir)
+	if err != nil {
+		return nil, err
+	}
+
+	statePath, err := filepath.Abs(cfg.StatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Syncer{
+		c: cfg.Client,
+
+		addr:   cfg.Address,
+		addrKR: cfg.AddrKR,
+
+		share: cfg.Share,
+		krs:   krs,
+
+		dir:       dir,
+		statePath: statePath,
+		state:     state,
+	}, nil
+}
+
+// Sync performs a synchronisation pass: remote changes are applied to the local directory,
+// then local changes are uploaded. When both sides changed a file, the local version is kept as a conflict copy.
+func (s *Syncer) Sync(ctx context.Context) error {
+	if err := s.pull(ctx); err != nil {
+		return fmt.Errorf("failed to apply remote changes: %w", err)
+	}
+
+	if err := s.push(ctx); err != nil {
+		return fmt.Errorf("failed to upload local changes: %w", err)
+	}
+
+	return nil
+}
+
+// State returns a copy of the synchronisation state.
+func (s *Syncer) State() State {
+	state := State{
+		LastEventID: s.state.LastEventID,
+		Links:       make(map[string]*Entry, len(s.state.Links)),
+	}
+
+	for linkID, entry := range s.state.Links {
+		entry := *entry
+		state.Links[linkID] = &entry
+	}
+
+	return state
+}
+
+func (s *Syncer) save() error {
+	return s.state.save(s.statePath)
+}
+
+// keyRing returns the node keyring of the given link, fetching and decrypting its ancestors as needed.
+func (s *Syncer) keyRing(ctx context.Context, linkID string) (*crypto.KeyRing, error) {
+	if kr, ok := s.krs.Get(linkID); ok {
+		return kr, nil
+	}
+
+	link, err := s.c.GetLink(ctx, s.share.ShareID, linkID)
+	if err != nil {
+		return nil, err
+	}
+
+	parentKR, err := s.keyRing(ctx, link.ParentLinkID)
+	if err != nil {
+		return nil, err
+	}
+
+	kr, err := link.GetKeyRing(parentKR, s.addrKR)
+	if err != nil {
+		return nil, err
+	}
+
+	s.krs.Add(linkID, kr)
+
+	return kr, nil
+}
+
+// getPath returns the local path of the given folder, or false if it is not synchronised.
+func (s *Syncer) getPath(linkID string) (string, bool) {
+	if linkID == s.share.LinkID {
+		return "", true
+	}
+
+	entry, ok := s.state.Links[linkID]
+	if !ok || !entry.IsDir {
+		return "", false
+	}
+
+	return entry.Path, true
+}
+
+// abs returns the absolute local path of the given slash-separated relative path.
+func (s *Syncer) abs(rel string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(rel))
+}
+
+// conflictPath returns an unused path for a conflict copy of the file at the given relative path.
+func (s *Syncer) conflictPath(rel string) string {
+	ext := path.Ext(rel)
+	base := strings.TrimSuffix(rel, ext) + " (conflict " + time.Now().Format("2006-01-02 150405")
+
+	for i := 1; ; i++ {
+		conflict := base + ")" + ext
+
+		if i > 1 {
+			conflict = fmt.Sprintf("%v %v)%v", base, i, ext)
+		}
+
+		if _, err := os.Lstat(s.abs(conflict)); errors.Is(err, fs.ErrNotExist) {
+			return conflict
+		}
+	}
+}
+
+// hashFile returns the hex encoded SHA1 digest of the given file, or an empty string if there is no such file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path) //nolint:gosec
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close() //nolint:errcheck
+
+	hash := sha1.New() //nolint:gosec
+
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// isValidName returns whether the given decrypted link name can safely be used as a local file name.
+func isValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, tmpPrefix)
+}
+
+// isNotFound returns whether the given error means that the requested link does not exist anymore.
+// Other validation errors, which are also answered with 422, don't.
+func isNotFound(err error) bool {
+	if apiErr := new(proton.APIError); errors.As(err, &apiErr) {
+		switch apiErr.Status {
+		case http.StatusNotFound:
+			return true
+
+		case http.StatusUnprocessableEntity:
+			return apiErr.Code == proton.NotExistsCode
+		}
+	}
+
+	return false
+}
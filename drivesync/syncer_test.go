@@ -0,0 +1,346 @@
+package drivesync_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/gluon/async"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/go-proton-api/drivesync"
+	"github.com/ProtonMail/go-proton-api/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncer_TwoWay(t *testing.T) {
+	withDrive(t, func(ctx context.Context, s *server.Server, cfg drivesync.Config) {
+		a, dirA := newSyncer(ctx, t, cfg)
+		b, dirB := newSyncer(ctx, t, cfg)
+
+		// Local files and folders are uploaded and downloaded on the other side.
+		writeFile(t, dirA, "a.txt", "a")
+		writeFile(t, dirA, "dir/b.txt", "b")
+		writeFile(t, dirA, "dir/sub/c.txt", "c")
+
+		require.NoError(t, a.Sync(ctx))
+		require.NoError(t, b.Sync(ctx))
+
+		require.Equal(t, map[string]string{"a.txt": "a", "dir/b.txt": "b", "dir/sub/c.txt": "c"}, readDir(t, dirB))
+
+		// Changes are uploaded as new revisions.
+		writeFile(t, dirB, "a.txt", "a2")
+
+		require.NoError(t, b.Sync(ctx))
+		require.NoError(t, a.Sync(ctx))
+
+		require.Equal(t, map[string]string{"a.txt": "a2", "dir/b.txt": "b", "dir/sub/c.txt": "c"}, readDir(t, dirA))
+
+		// Removed files and folders are trashed.
+		require.NoError(t, os.RemoveAll(filepath.Join(dirA, "dir")))
+
+		require.NoError(t, a.Sync(ctx))
+		require.NoError(t, b.Sync(ctx))
+
+		require.Equal(t, map[string]string{"a.txt": "a2"}, readDir(t, dirB))
+		require.NoDirExists(t, filepath.Join(dirB, "dir"))
+
+		// Both sides converge on the same state.
+		require.NoError(t, a.Sync(ctx))
+		require.NoError(t, b.Sync(ctx))
+
+		require.Equal(t, readDir(t, dirA), readDir(t, dirB))
+		require.Len(t, a.State().Links, 1)
+		require.Len(t, b.State().Links, 1)
+	})
+}
+
+func TestSyncer_Conflict(t *testing.T) {
+	withDrive(t, func(ctx context.Context, s *server.Server, cfg drivesync.Config) {
+		a, dirA := newSyncer(ctx, t, cfg)
+		b, dirB := newSyncer(ctx, t, cfg)
+
+		writeFile(t, dirA, "file.txt", "original")
+
+		require.NoError(t, a.Sync(ctx))
+		require.NoError(t, b.Sync(ctx))
+
+		// Both sides change the same file.
+		writeFile(t, dirA, "file.txt", "changed by a")
+		writeFile(t, dirB, "file.txt", "changed by b")
+
+		require.NoError(t, a.Sync(ctx))
+		require.NoError(t, b.Sync(ctx))
+
+		// The remote version wins; the local version is kept as a conflict copy, which is uploaded too.
+		files := readDir(t, dirB)
+		require.Len(t, files, 2)
+		require.Equal(t, "changed by a", files["file.txt"])
+
+		var conflict string
+
+		for name, content := range files {
+			if name != "file.txt" {
+				require.True(t, strings.HasPrefix(name, "file (conflict "))
+				require.True(t, strings.HasSuffix(name, ").txt"))
+				require.Equal(t, "changed by b", content)
+
+				conflict = name
+			}
+		}
+
+		require.NoError(t, a.Sync(ctx))
+
+		require.Equal(t, map[string]string{"file.txt": "changed by a", conflict: "changed by b"}, readDir(t, dirA))
+	})
+}
+
+func TestSyncer_Resume(t *testing.T) {
+	withDrive(t, func(ctx context.Context, s *server.Server, cfg drivesync.Config) {
+		a, dirA := newSyncer(ctx, t, cfg)
+
+		writeFile(t, dirA, "file.txt", "content")
+
+		require.NoError(t, a.Sync(ctx))
+
+		state := a.State()
+
+		// A new syncer resumes from the persisted state: nothing is uploaded or downloaded again.
+		cfg.LocalDir, cfg.StatePath = dirA, filepath.Join(filepath.Dir(dirA), "state.json")
+
+		resumed, err := drivesync.New(ctx, cfg)
+		require.NoError(t, err)
+		require.Equal(t, state, resumed.State())
+
+		require.NoError(t, resumed.Sync(ctx))
+		require.Equal(t, state.Links, resumed.State().Links)
+
+		// Remote changes made while the syncer was stopped are applied.
+		b, dirB := newSyncer(ctx, t, cfg)
+
+		require.NoError(t, b.Sync(ctx))
+		writeFile(t, dirB, "other.txt", "other")
+		require.NoError(t, b.Sync(ctx))
+
+		require.NoError(t, resumed.Sync(ctx))
+		require.Equal(t, map[string]string{"file.txt": "content", "other.txt": "other"}, readDir(t, dirA))
+	})
+}
+
+func TestSyncer_InterruptedUpload(t *testing.T) {
+	withDrive(t, func(ctx context.Context, s *server.Server, cfg drivesync.Config) {
+		a, dirA := newSyncer(ctx, t, cfg)
+
+		// The first revision commit fails, leaving the new file as a draft.
+		var calls int32
+
+		s.AddStatusHook(func(req *http.Request) (int, bool) {
+			if req.Method == http.MethodPut && strings.Contains(req.URL.Path, "/revisions/") && atomic.AddInt32(&calls, 1) == 1 {
+				return http.StatusUnprocessableEntity, true
+			}
+
+			return 0, false
+		})
+
+		writeFile(t, dirA, "file.txt", "content")
+
+		require.Error(t, a.Sync(ctx))
+
+		state := a.State()
+		require.Len(t, state.Links, 1)
+
+		for _, entry := range state.Links {
+			require.True(t, entry.Draft)
+		}
+
+		// The draft holds the file's name.
+		root, err := cfg.Client.GetLink(ctx, cfg.Share.ShareID, cfg.Share.LinkID)
+		require.NoError(t, err)
+
+		rootKR, err := root.GetKeyRing(cfg.ShareKR, cfg.AddrKR)
+		require.NoError(t, err)
+
+		rootHashKey, err := root.GetHashKey(rootKR)
+		require.NoError(t, err)
+
+		_, err = cfg.Client.UploadFile(ctx, cfg.Share.ShareID, root.LinkID, "file.txt", "text/plain", rootKR, rootHashKey, cfg.Address, cfg.AddrKR, time.Now(), strings.NewReader("other"))
+		require.Error(t, err)
+
+		// The next pass deletes the draft and creates the file again.
+		require.NoError(t, a.Sync(ctx))
+
+		children, err := cfg.Client.ListChildren(ctx, cfg.Share.ShareID, root.LinkID, true)
+		require.NoError(t, err)
+		require.Len(t, children, 1)
+		require.Equal(t, proton.LinkStateActive, children[0].State)
+
+		b, dirB := newSyncer(ctx, t, cfg)
+
+		require.NoError(t, b.Sync(ctx))
+		require.Equal(t, map[string]string{"file.txt": "content"}, readDir(t, dirB))
+	})
+}
+
+func TestSyncer_ValidationError(t *testing.T) {
+	withDrive(t, func(ctx context.Context, s *server.Server, cfg drivesync.Config) {
+		a, dirA := newSyncer(ctx, t, cfg)
+		b, dirB := newSyncer(ctx, t, cfg)
+
+		writeFile(t, dirA, "dir/file.txt", "content")
+
+		require.NoError(t, a.Sync(ctx))
+		require.NoError(t, b.Sync(ctx))
+
+		writeFile(t, dirA, "dir/file.txt", "changed")
+
+		require.NoError(t, a.Sync(ctx))
+
+		// Fetching the changed links fails with a validation error which doesn't mean they were removed.
+		var failing atomic.Bool
+
+		failing.Store(true)
+
+		s.AddStatusHook(func(req *http.Request) (int, bool) {
+			if failing.Load() && req.Method == http.MethodGet && strings.Contains(req.URL.Path, "/links/") {
+				return http.StatusUnprocessableEntity, true
+			}
+
+			return 0, false
+		})
+
+		require.Error(t, b.Sync(ctx))
+		require.Equal(t, map[string]string{"dir/file.txt": "content"}, readDir(t, dirB))
+
+		// Once the links can be fetched again, the changes are applied.
+		failing.Store(false)
+
+		require.NoError(t, b.Sync(ctx))
+		require.Equal(t, map[string]string{"dir/file.txt": "changed"}, readDir(t, dirB))
+	})
+}
+
+func TestSyncer_StateInLocalDir(t *testing.T) {
+	withDrive(t, func(ctx context.Context, s *server.Server, cfg drivesync.Config) {
+		dirA := t.TempDir()
+
+		cfg.LocalDir, cfg.StatePath = dirA, filepath.Join(dirA, "state.json")
+
+		a, err := drivesync.New(ctx, cfg)
+		require.NoError(t, err)
+
+		// The state file is not synchronised, but files whose name starts with its name are.
+		writeFile(t, dirA, "state.json.txt", "not the state")
+
+		require.NoError(t, a.Sync(ctx))
+
+		b, dirB := newSyncer(ctx, t, cfg)
+
+		require.NoError(t, b.Sync(ctx))
+		require.Equal(t, map[string]string{"state.json.txt": "not the state"}, readDir(t, dirB))
+	})
+}
+
+func withDrive(t *testing.T, fn func(ctx context.Context, s *server.Server, cfg drivesync.Config)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := server.New()
+	defer s.Close()
+
+	_, _, err := s.CreateUser("user", []byte("pass"))
+	require.NoError(t, err)
+
+	m := proton.New(
+		proton.WithHostURL(s.GetHostURL()),
+		proton.WithTransport(proton.InsecureTransport()),
+	)
+	defer m.Close()
+
+	c, _, err := m.NewClientWithLogin(ctx, "user", []byte("pass"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	user, err := c.GetUser(ctx)
+	require.NoError(t, err)
+
+	addr, err := c.GetAddresses(ctx)
+	require.NoError(t, err)
+
+	salt, err := c.GetSalts(ctx)
+	require.NoError(t, err)
+
+	keyPass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+	require.NoError(t, err)
+
+	_, addrKRs, err := proton.Unlock(user, addr, keyPass, async.NoopPanicHandler{})
+	require.NoError(t, err)
+
+	req, err := proton.NewCreateVolumeReq(addr[0].ID, addrKRs[addr[0].ID])
+	require.NoError(t, err)
+
+	volume, err := c.CreateVolume(ctx, req)
+	require.NoError(t, err)
+
+	share, err := c.GetShare(ctx, volume.Share.ShareID)
+	require.NoError(t, err)
+
+	shareKR, err := share.GetKeyRing(addrKRs[addr[0].ID])
+	require.NoError(t, err)
+
+	fn(ctx, s, drivesync.Config{
+		Client:  c,
+		Address: addr[0],
+		AddrKR:  addrKRs[addr[0].ID],
+		Share:   share,
+		ShareKR: shareKR,
+	})
+}
+
+// newSyncer returns a new syncer of a new local directory.
+func newSyncer(ctx context.Context, t *testing.T, cfg drivesync.Config) (*drivesync.Syncer, string) {
+	dir := t.TempDir()
+
+	cfg.LocalDir = filepath.Join(dir, "drive")
+	cfg.StatePath = filepath.Join(dir, "state.json")
+
+	syncer, err := drivesync.New(ctx, cfg)
+	require.NoError(t, err)
+
+	return syncer, cfg.LocalDir
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+}
+
+// readDir returns the content of the files in the given directory, keyed by their slash-separated relative path.
+func readDir(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+
+	require.NoError(t, filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		files[filepath.ToSlash(rel)] = string(b)
+
+		return nil
+	}))
+
+	return files
+}
@@ -0,0 +1,59 @@
+package proton
+
+import (
+	"context"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func (c *Client) GetLatestCalendarModelEventID(ctx context.Context, calendarID string) (string, error) {
+	var res struct {
+		CalendarModelEventID string
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Get("/calendar/v1/" + calendarID + "/modelevents/latest")
+	}); err != nil {
+		return "", err
+	}
+
+	return res.CalendarModelEventID, nil
+}
+
+// GetCalendarModelEvent returns the changes to the events of the calendar since the given model event.
+func (c *Client) GetCalendarModelEvent(ctx context.Context, calendarID, eventID string) (CalendarModelEvent, error) {
+	event, more, err := c.getCalendarModelEvent(ctx, calendarID, eventID)
+	if err != nil {
+		return CalendarModelEvent{}, err
+	}
+
+	for more {
+		var next CalendarModelEvent
+
+		next, more, err = c.getCalendarModelEvent(ctx, calendarID, event.CalendarModelEventID)
+		if err != nil {
+			return CalendarModelEvent{}, err
+		}
+
+		event.CalendarModelEventID = next.CalendarModelEventID
+		event.Events = append(event.Events, next.Events...)
+	}
+
+	return event, nil
+}
+
+func (c *Client) getCalendarModelEvent(ctx context.Context, calendarID, eventID string) (CalendarModelEvent, bool, error) {
+	var res struct {
+		CalendarModelEvent
+
+		More Bool
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Get("/calendar/v1/" + calendarID + "/modelevents/" + eventID)
+	}); err != nil {
+		return CalendarModelEvent{}, false, err
+	}
+
+	return res.CalendarModelEvent, bool(res.More), nil
+}
@@ -0,0 +1,16 @@
+package proton
+
+// CalendarModelEvent holds the changes to the events of a calendar.
+type CalendarModelEvent struct {
+	CalendarModelEventID string
+
+	Events []CalendarEventItem
+
+	Refresh Bool
+}
+
+type CalendarEventItem struct {
+	EventItem
+
+	Event CalendarEvent
+}
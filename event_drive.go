@@ -2,7 +2,9 @@ package proton
 
 import (
 	"context"
+	"time"
 
+	"github.com/ProtonMail/gluon/async"
 	"github.com/go-resty/resty/v2"
 )
 
@@ -48,7 +50,9 @@ func (c *Client) GetVolumeEvent(ctx context.Context, volumeID, eventID string) (
 			return DriveEvent{}, err
 		}
 
+		event.EventID = next.EventID
 		event.Events = append(event.Events, next.Events...)
+		event.Refresh = event.Refresh || next.Refresh
 	}
 
 	return event, nil
@@ -68,12 +72,80 @@ func (c *Client) GetShareEvent(ctx context.Context, shareID, eventID string) (Dr
 			return DriveEvent{}, err
 		}
 
+		event.EventID = next.EventID
 		event.Events = append(event.Events, next.Events...)
+		event.Refresh = event.Refresh || next.Refresh
 	}
 
 	return event, nil
 }
 
+// NewVolumeEventStream returns a new stream of changes to the links of the given volume.
+// It polls the API for new events at random intervals between `period` and `period+jitter`,
+// starting after `lastEventID`. If krs is not nil, it is used to decrypt the changed links.
+func (c *Client) NewVolumeEventStream(ctx context.Context, period, jitter time.Duration, volumeID, lastEventID string, krs *LinkKeyRings) <-chan DriveChanges {
+	return c.newDriveEventStream(ctx, period, jitter, lastEventID, krs, func(ctx context.Context, eventID string) (DriveEvent, error) {
+		return c.GetVolumeEvent(ctx, volumeID, eventID)
+	})
+}
+
+// NewShareEventStream returns a new stream of changes to the links of the given share.
+// It polls the API for new events at random intervals between `period` and `period+jitter`,
+// starting after `lastEventID`. If krs is not nil, it is used to decrypt the changed links.
+func (c *Client) NewShareEventStream(ctx context.Context, period, jitter time.Duration, shareID, lastEventID string, krs *LinkKeyRings) <-chan DriveChanges {
+	return c.newDriveEventStream(ctx, period, jitter, lastEventID, krs, func(ctx context.Context, eventID string) (DriveEvent, error) {
+		return c.GetShareEvent(ctx, shareID, eventID)
+	})
+}
+
+func (c *Client) newDriveEventStream(
+	ctx context.Context,
+	period, jitter time.Duration,
+	lastEventID string,
+	krs *LinkKeyRings,
+	getEvent func(context.Context, string) (DriveEvent, error),
+) <-chan DriveChanges {
+	changesCh := make(chan DriveChanges)
+
+	go func() {
+		defer async.HandlePanic(c.m.panicHandler)
+
+		defer close(changesCh)
+
+		ticker := NewTicker(period, jitter, c.m.panicHandler)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				// ...
+			}
+
+			event, err := getEvent(ctx, lastEventID)
+			if err != nil {
+				continue
+			}
+
+			if event.EventID == lastEventID {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case changesCh <- event.Changes(krs):
+				lastEventID = event.EventID
+			}
+		}
+	}()
+
+	return changesCh
+}
+
 func (c *Client) getVolumeEvent(ctx context.Context, volumeID, eventID string) (DriveEvent, bool, error) {
 	var res struct {
 		DriveEvent
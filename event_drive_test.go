@@ -0,0 +1,125 @@
+package proton_test
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriveEvent_Changes(t *testing.T) {
+	addrKR := newTestKeyRing(t)
+	shareKR := newTestKeyRing(t)
+
+	root := newTestLink(t, shareKR, addrKR, "root", "", "root", proton.LinkStateActive)
+	folder := newTestLink(t, mustLinkKeyRing(t, root, shareKR, addrKR), addrKR, "folder", "root", "folder", proton.LinkStateActive)
+	file := newTestLink(t, mustLinkKeyRing(t, folder, mustLinkKeyRing(t, root, shareKR, addrKR), addrKR), addrKR, "file", "folder", "file.txt", proton.LinkStateTrashed)
+
+	event := proton.DriveEvent{
+		EventID: "eventID",
+		Events: []proton.LinkEvent{
+			{EventType: proton.LinkEventCreate, Link: root},
+			{EventType: proton.LinkEventCreate, Link: folder},
+			{EventType: proton.LinkEventUpdateMetadata, Link: file},
+			{EventType: proton.LinkEventDelete, Link: proton.Link{LinkID: "other"}},
+		},
+		Refresh: true,
+	}
+
+	// Without keyrings, the changes are typed but not decrypted.
+	changes := event.Changes(nil)
+	require.Equal(t, "eventID", changes.EventID)
+	require.True(t, changes.Refresh)
+	require.Equal(t, []proton.LinkChangeType{
+		proton.LinkChangeCreate,
+		proton.LinkChangeCreate,
+		proton.LinkChangeTrash,
+		proton.LinkChangeDelete,
+	}, changeTypes(changes))
+	require.Empty(t, changes.Changes[0].Name)
+
+	// With the share keyring, every link in the tree can be decrypted.
+	krs := proton.NewLinkKeyRings(addrKR)
+	krs.Add("", shareKR)
+
+	changes = event.Changes(krs)
+	require.Equal(t, "root", changes.Changes[0].Name)
+	require.Equal(t, "folder", changes.Changes[1].Name)
+	require.Equal(t, "file.txt", changes.Changes[2].Name)
+	require.NotNil(t, changes.Changes[2].KeyRing)
+	require.NoError(t, changes.Changes[2].Err)
+
+	_, ok := krs.Get("file")
+	require.True(t, ok)
+}
+
+func changeTypes(changes proton.DriveChanges) []proton.LinkChangeType {
+	var types []proton.LinkChangeType
+
+	for _, change := range changes.Changes {
+		types = append(types, change.Type)
+	}
+
+	return types
+}
+
+func newTestKeyRing(t *testing.T) *crypto.KeyRing {
+	key, err := crypto.GenerateKey("name", "email", "x25519", 0)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	return kr
+}
+
+func newTestLink(t *testing.T, parentKR, addrKR *crypto.KeyRing, linkID, parentLinkID, name string, state proton.LinkState) proton.Link {
+	encName, err := parentKR.Encrypt(crypto.NewPlainMessageFromString(name), addrKR)
+	require.NoError(t, err)
+
+	armName, err := encName.GetArmored()
+	require.NoError(t, err)
+
+	passphrase, err := crypto.RandomToken(32)
+	require.NoError(t, err)
+
+	nodeKey, err := crypto.GenerateKey("node", "node", "x25519", 0)
+	require.NoError(t, err)
+
+	lockedKey, err := nodeKey.Lock(passphrase)
+	require.NoError(t, err)
+
+	armKey, err := lockedKey.Armor()
+	require.NoError(t, err)
+
+	encPass, err := parentKR.Encrypt(crypto.NewPlainMessage(passphrase), nil)
+	require.NoError(t, err)
+
+	armPass, err := encPass.GetArmored()
+	require.NoError(t, err)
+
+	sigPass, err := addrKR.SignDetached(crypto.NewPlainMessage(passphrase))
+	require.NoError(t, err)
+
+	armSig, err := sigPass.GetArmored()
+	require.NoError(t, err)
+
+	return proton.Link{
+		LinkID:                  linkID,
+		ParentLinkID:            parentLinkID,
+		Type:                    proton.LinkTypeFolder,
+		Name:                    armName,
+		State:                   state,
+		NodeKey:                 armKey,
+		NodePassphrase:          armPass,
+		NodePassphraseSignature: armSig,
+	}
+}
+
+func mustLinkKeyRing(t *testing.T, link proton.Link, parentKR, addrKR *crypto.KeyRing) *crypto.KeyRing {
+	kr, err := link.GetKeyRing(parentKR, addrKR)
+	require.NoError(t, err)
+
+	return kr
+}
@@ -1,5 +1,7 @@
 package proton
 
+import "github.com/ProtonMail/gopenpgp/v2/crypto"
+
 type DriveEvent struct {
 	EventID string
 
@@ -8,6 +10,21 @@ type DriveEvent struct {
 	Refresh Bool
 }
 
+// Changes returns the typed link changes of the event.
+// If krs is not nil, it is used to decrypt the changed links and is updated with their node keyrings.
+func (event DriveEvent) Changes(krs *LinkKeyRings) DriveChanges {
+	changes := DriveChanges{
+		EventID: event.EventID,
+		Refresh: bool(event.Refresh),
+	}
+
+	for _, linkEvent := range event.Events {
+		changes.Changes = append(changes.Changes, linkEvent.Change(krs))
+	}
+
+	return changes
+}
+
 type LinkEvent struct {
 	EventID string
 
@@ -20,6 +37,66 @@ type LinkEvent struct {
 	Data any
 }
 
+// Change returns the typed link change of the event.
+// If krs is not nil, it is used to decrypt the changed link and is updated with its node keyring.
+func (event LinkEvent) Change(krs *LinkKeyRings) LinkChange {
+	change := LinkChange{
+		LinkEvent: event,
+		Type:      event.changeType(),
+	}
+
+	if krs == nil {
+		return change
+	}
+
+	if change.Type == LinkChangeDelete {
+		krs.Remove(event.Link.LinkID)
+		return change
+	}
+
+	parentKR, ok := krs.Get(event.Link.ParentLinkID)
+	if !ok {
+		return change
+	}
+
+	if name, err := event.Link.GetName(parentKR, krs.addrKR); err != nil {
+		change.Err = err
+	} else {
+		change.Name = name
+	}
+
+	if kr, err := event.Link.GetKeyRing(parentKR, krs.addrKR); err != nil {
+		change.Err = err
+	} else {
+		change.KeyRing = kr
+		krs.Add(event.Link.LinkID, kr)
+	}
+
+	return change
+}
+
+func (event LinkEvent) changeType() LinkChangeType {
+	switch event.EventType {
+	case LinkEventDelete:
+		return LinkChangeDelete
+
+	case LinkEventCreate:
+		return LinkChangeCreate
+
+	default:
+		switch event.Link.State {
+		case LinkStateTrashed:
+			return LinkChangeTrash
+
+		case LinkStateDeleted:
+			return LinkChangeDelete
+
+		default:
+			return LinkChangeUpdate
+		}
+	}
+}
+
 type LinkEventType int
 
 const (
@@ -28,3 +105,50 @@ const (
 	LinkEventUpdate
 	LinkEventUpdateMetadata
 )
+
+// DriveChanges holds the typed link changes of a drive event.
+type DriveChanges struct {
+	EventID string // The ID of the event; streams can be resumed from it.
+	Refresh bool   // Whether the client must refresh its whole view of the volume/share.
+
+	Changes []LinkChange
+}
+
+// LinkChange is a typed change to a link.
+type LinkChange struct {
+	LinkEvent
+
+	Type LinkChangeType
+
+	Name    string          // Decrypted link name; empty if the parent keyring is unknown.
+	KeyRing *crypto.KeyRing // Node keyring of the link; nil if the parent keyring is unknown.
+	Err     error           // Error encountered while decrypting the link, if any.
+}
+
+type LinkChangeType int
+
+const (
+	LinkChangeCreate LinkChangeType = iota
+	LinkChangeUpdate
+	LinkChangeDelete
+	LinkChangeTrash
+)
+
+func (t LinkChangeType) String() string {
+	switch t {
+	case LinkChangeCreate:
+		return "create"
+
+	case LinkChangeUpdate:
+		return "update"
+
+	case LinkChangeDelete:
+		return "delete"
+
+	case LinkChangeTrash:
+		return "trash"
+
+	default:
+		return "unknown"
+	}
+}
@@ -24,6 +24,10 @@ type Event struct {
 
 	Addresses []AddressEvent
 
+	Contacts []ContactEvent
+
+	ContactEmails []ContactEmailEvent
+
 	UsedSpace *int64
 }
 
@@ -69,6 +73,24 @@ func (event Event) String() string {
 		))
 	}
 
+	if len(event.Contacts) > 0 {
+		parts = append(parts, fmt.Sprintf(
+			"contacts: created=%d, updated=%d, deleted=%d",
+			xslices.CountFunc(event.Contacts, func(e ContactEvent) bool { return e.Action == EventCreate }),
+			xslices.CountFunc(event.Contacts, func(e ContactEvent) bool { return e.Action == EventUpdate || e.Action == EventUpdateFlags }),
+			xslices.CountFunc(event.Contacts, func(e ContactEvent) bool { return e.Action == EventDelete }),
+		))
+	}
+
+	if len(event.ContactEmails) > 0 {
+		parts = append(parts, fmt.Sprintf(
+			"contact-emails: created=%d, updated=%d, deleted=%d",
+			xslices.CountFunc(event.ContactEmails, func(e ContactEmailEvent) bool { return e.Action == EventCreate }),
+			xslices.CountFunc(event.ContactEmails, func(e ContactEmailEvent) bool { return e.Action == EventUpdate || e.Action == EventUpdateFlags }),
+			xslices.CountFunc(event.ContactEmails, func(e ContactEmailEvent) bool { return e.Action == EventDelete }),
+		))
+	}
+
 	return fmt.Sprintf("Event %s: %s", event.EventID, strings.Join(parts, ", "))
 }
 
@@ -117,3 +139,15 @@ type AddressEvent struct {
 
 	Address Address
 }
+
+type ContactEvent struct {
+	EventItem
+
+	Contact Contact
+}
+
+type ContactEmailEvent struct {
+	EventItem
+
+	ContactEmail ContactEmail
+}
@@ -88,3 +88,22 @@ type CreateAddressKeyReq struct {
 type MakeAddressKeyPrimaryReq struct {
 	SignedKeyList KeyList
 }
+
+type UpdatePrivateKeyReq struct {
+	ID         string
+	PrivateKey string
+}
+
+type UpdatePrivateKeysReq struct {
+	// KeySalt is the new salt of the passphrase of the user's keys.
+	KeySalt string
+
+	// Keys are the user's keys and the legacy address keys, locked with the new passphrase.
+	Keys []UpdatePrivateKeyReq
+
+	// SignedKeyLists are the key lists of the addresses whose keys are updated, keyed by address ID.
+	SignedKeyLists map[string]KeyList `json:",omitempty"`
+
+	// Auth is the new login verifier, if the login password changes too.
+	Auth *AuthVerifier `json:",omitempty"`
+}
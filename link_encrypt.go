@@ -0,0 +1,166 @@
+package proton
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// nodeKeys holds a freshly generated node key, locked with a random passphrase.
+type nodeKeys struct {
+	Key                 string // The armored, locked node key.
+	Passphrase          string // The node key passphrase, encrypted with the parent keyring.
+	PassphraseSignature string // The signature of the passphrase, signed with the address keyring.
+
+	kr *crypto.KeyRing
+}
+
+// generateNodeKeys generates a new node key locked with a random passphrase.
+// The passphrase is encrypted with parentKR and signed with addrKR.
+func generateNodeKeys(parentKR, addrKR *crypto.KeyRing) (nodeKeys, error) {
+	passphrase, err := crypto.RandomToken(32)
+	if err != nil {
+		return nodeKeys{}, err
+	}
+
+	// The passphrase is used as a string by other clients, so we armor it in base64.
+	passphrase = []byte(base64.StdEncoding.EncodeToString(passphrase))
+
+	key, err := crypto.GenerateKey("Drive key", "no-reply@proton.me", "x25519", 0)
+	if err != nil {
+		return nodeKeys{}, err
+	}
+
+	kr, err := crypto.NewKeyRing(key)
+	if err != nil {
+		return nodeKeys{}, err
+	}
+
+	lockedKey, err := key.Lock(passphrase)
+	if err != nil {
+		return nodeKeys{}, err
+	}
+
+	armKey, err := lockedKey.Armor()
+	if err != nil {
+		return nodeKeys{}, err
+	}
+
+	encPassphrase, err := parentKR.Encrypt(crypto.NewPlainMessage(passphrase), nil)
+	if err != nil {
+		return nodeKeys{}, err
+	}
+
+	armPassphrase, err := encPassphrase.GetArmored()
+	if err != nil {
+		return nodeKeys{}, err
+	}
+
+	sigPassphrase, err := addrKR.SignDetached(crypto.NewPlainMessage(passphrase))
+	if err != nil {
+		return nodeKeys{}, err
+	}
+
+	armSigPassphrase, err := sigPassphrase.GetArmored()
+	if err != nil {
+		return nodeKeys{}, err
+	}
+
+	return nodeKeys{
+		Key:                 armKey,
+		Passphrase:          armPassphrase,
+		PassphraseSignature: armSigPassphrase,
+		kr:                  kr,
+	}, nil
+}
+
+// encryptLinkName encrypts the name of a link with its parent keyring and signs it with the address keyring.
+func encryptLinkName(name string, parentKR, addrKR *crypto.KeyRing) (string, error) {
+	enc, err := parentKR.Encrypt(crypto.NewPlainMessageFromString(name), addrKR)
+	if err != nil {
+		return "", err
+	}
+
+	return enc.GetArmored()
+}
+
+// hashLinkName returns the HMAC of a link name, keyed with its parent folder's hash key.
+func hashLinkName(name string, parentHashKey []byte) string {
+	mac := hmac.New(sha256.New, parentHashKey)
+
+	if _, err := mac.Write([]byte(name)); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// generateHashKey generates a new folder hash key, encrypted and signed with the folder's node keyring.
+func generateHashKey(nodeKR *crypto.KeyRing) (string, error) {
+	hashKey, err := crypto.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	enc, err := nodeKR.Encrypt(crypto.NewPlainMessage([]byte(base64.StdEncoding.EncodeToString(hashKey))), nodeKR)
+	if err != nil {
+		return "", err
+	}
+
+	return enc.GetArmored()
+}
+
+// reencryptKeyPacket decrypts the session key of the given armored message with fromKR
+// and returns it encrypted with toKR, encoded in base64.
+func reencryptKeyPacket(armored string, fromKR, toKR *crypto.KeyRing) (string, error) {
+	msg, err := crypto.NewPGPMessageFromArmored(armored)
+	if err != nil {
+		return "", err
+	}
+
+	split, err := msg.SplitMessage()
+	if err != nil {
+		return "", err
+	}
+
+	sk, err := fromKR.DecryptSessionKey(split.GetBinaryKeyPacket())
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt session key: %w", err)
+	}
+
+	kp, err := toKR.EncryptSessionKey(sk)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt session key: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(kp), nil
+}
+
+// reencryptMessage returns the given armored message with its session key re-encrypted from fromKR to toKR.
+func reencryptMessage(armored string, fromKR, toKR *crypto.KeyRing) (string, error) {
+	msg, err := crypto.NewPGPMessageFromArmored(armored)
+	if err != nil {
+		return "", err
+	}
+
+	split, err := msg.SplitMessage()
+	if err != nil {
+		return "", err
+	}
+
+	sk, err := fromKR.DecryptSessionKey(split.GetBinaryKeyPacket())
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt session key: %w", err)
+	}
+
+	kp, err := toKR.EncryptSessionKey(sk)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt session key: %w", err)
+	}
+
+	return crypto.NewPGPSplitMessage(kp, split.GetBinaryDataPacket()).GetPGPMessage().GetArmored()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/go-resty/resty/v2"
 )
 
@@ -53,3 +54,75 @@ func (c *Client) UpdateRevision(ctx context.Context, shareID, linkID, revisionID
 		return r.SetBody(req).Put("/drive/shares/" + shareID + "/files/" + linkID + "/revisions/" + revisionID)
 	})
 }
+
+// GetRevisionAllBlocks returns the revision with all of its blocks, fetching them page by page.
+func (c *Client) GetRevisionAllBlocks(ctx context.Context, shareID, linkID, revisionID string) (Revision, error) {
+	var revision Revision
+
+	for fromBlock := 1; ; fromBlock += maxPageSize {
+		page, err := c.GetRevision(ctx, shareID, linkID, revisionID, fromBlock, maxPageSize)
+		if err != nil {
+			return Revision{}, err
+		}
+
+		revision.RevisionMetadata = page.RevisionMetadata
+		revision.Blocks = append(revision.Blocks, page.Blocks...)
+
+		if len(page.Blocks) < maxPageSize {
+			break
+		}
+	}
+
+	return revision, nil
+}
+
+func (c *Client) CreateRevision(ctx context.Context, shareID, linkID string) (CreateRevisionRes, error) {
+	var res struct {
+		Revision CreateRevisionRes
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Post("/drive/shares/" + shareID + "/files/" + linkID + "/revisions")
+	}); err != nil {
+		return CreateRevisionRes{}, err
+	}
+
+	return res.Revision, nil
+}
+
+// RestoreRevision makes the given obsolete revision the active revision of the file.
+// The previously active revision becomes obsolete.
+func (c *Client) RestoreRevision(ctx context.Context, shareID, linkID, revisionID string) error {
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.Post("/drive/shares/" + shareID + "/files/" + linkID + "/revisions/" + revisionID + "/restore")
+	})
+}
+
+// DeleteRevision deletes the given revision. The active revision of a file cannot be deleted.
+func (c *Client) DeleteRevision(ctx context.Context, shareID, linkID, revisionID string) error {
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.Delete("/drive/shares/" + shareID + "/files/" + linkID + "/revisions/" + revisionID)
+	})
+}
+
+// CompareRevisions returns the indexes of the blocks which differ between the two given revisions of a file.
+// Encrypting the same content twice gives different blocks, so the blocks of both revisions are downloaded and
+// compared by the digest of their decrypted content. If addrKR is not nil, it is used to verify each block.
+func (c *Client) CompareRevisions(
+	ctx context.Context,
+	shareID, linkID, oldRevisionID, newRevisionID string,
+	addrKR, nodeKR *crypto.KeyRing,
+	sessionKey *crypto.SessionKey,
+) (RevisionDiff, error) {
+	oldDigests, err := c.getRevisionBlockDigests(ctx, shareID, linkID, oldRevisionID, addrKR, nodeKR, sessionKey)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+
+	newDigests, err := c.getRevisionBlockDigests(ctx, shareID, linkID, newRevisionID, addrKR, nodeKR, sessionKey)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+
+	return diffRevisionBlocks(oldDigests, newDigests), nil
+}
@@ -0,0 +1,121 @@
+package proton
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// DownloadRevision downloads the blocks of the given revision, decrypts them with the file's session key and
+// writes them in order to w. If addrKR is not nil, it is used to verify the signature of each block.
+func (c *Client) DownloadRevision(
+	ctx context.Context,
+	shareID, linkID, revisionID string,
+	addrKR, nodeKR *crypto.KeyRing,
+	sessionKey *crypto.SessionKey,
+	w io.Writer,
+) error {
+	revision, err := c.GetRevisionAllBlocks(ctx, shareID, linkID, revisionID)
+	if err != nil {
+		return err
+	}
+
+	for _, block := range revision.Blocks {
+		dec, err := c.DownloadBlock(ctx, block, addrKR, nodeKR, sessionKey)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(dec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getRevisionBlockDigests returns the hex encoded SHA256 digest of the decrypted content of each block of the
+// given revision, by block index.
+func (c *Client) getRevisionBlockDigests(
+	ctx context.Context,
+	shareID, linkID, revisionID string,
+	addrKR, nodeKR *crypto.KeyRing,
+	sessionKey *crypto.SessionKey,
+) (map[int]string, error) {
+	revision, err := c.GetRevisionAllBlocks(ctx, shareID, linkID, revisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make(map[int]string, len(revision.Blocks))
+
+	for _, block := range revision.Blocks {
+		dec, err := c.DownloadBlock(ctx, block, addrKR, nodeKR, sessionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		digest := sha256.Sum256(dec)
+
+		digests[block.Index] = hex.EncodeToString(digest[:])
+	}
+
+	return digests, nil
+}
+
+// DownloadBlock downloads the given block and decrypts it with the file's session key.
+// If addrKR is not nil, it is used to verify the block's signature, which is decrypted with the node keyring.
+func (c *Client) DownloadBlock(ctx context.Context, block Block, addrKR, nodeKR *crypto.KeyRing, sessionKey *crypto.SessionKey) ([]byte, error) {
+	rc, err := c.GetBlock(ctx, block.BareURL, block.Token)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	enc, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := sessionKey.Decrypt(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	if addrKR != nil {
+		encSig, err := crypto.NewPGPMessageFromArmored(block.EncSignature)
+		if err != nil {
+			return nil, err
+		}
+
+		sig, err := nodeKR.Decrypt(encSig, nil, crypto.GetUnixTime())
+		if err != nil {
+			return nil, err
+		}
+
+		if err := addrKR.VerifyDetached(dec, crypto.NewPGPSignature(sig.GetBinary()), crypto.GetUnixTime()); err != nil {
+			return nil, err
+		}
+	}
+
+	return dec.GetBinary(), nil
+}
+
+// GetSignerKeyRing returns the keyring with which to verify the content signed by the given email address.
+// The given address keyring is used if the content was signed by that address; otherwise, the public keys
+// of the signer are fetched.
+func (c *Client) GetSignerKeyRing(ctx context.Context, email string, addr Address, addrKR *crypto.KeyRing) (*crypto.KeyRing, error) {
+	if email == "" || email == addr.Email {
+		return addrKR, nil
+	}
+
+	keys, _, err := c.GetPublicKeys(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys.GetKeyRing()
+}
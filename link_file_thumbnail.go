@@ -0,0 +1,239 @@
+package proton
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Register GIF decoding for thumbnails.
+	"image/jpeg"
+	_ "image/png" // Register PNG decoding for thumbnails.
+	"io"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	// ThumbnailMaxDimension is the maximum width and height of a thumbnail in pixels.
+	ThumbnailMaxDimension = 512
+
+	// ThumbnailMaxSize is the maximum size of an unencrypted thumbnail in bytes.
+	ThumbnailMaxSize = 64 * 1024
+
+	// ThumbnailMaxSourceSize is the maximum size in bytes of the images thumbnails are made of.
+	ThumbnailMaxSourceSize = 32 * 1024 * 1024
+
+	// ThumbnailMaxSourcePixels is the maximum number of pixels of the images thumbnails are made of.
+	ThumbnailMaxSourcePixels = 64 * 1024 * 1024
+)
+
+var (
+	ErrThumbnailTooLarge = errors.New("thumbnail is too large")
+	ErrImageTooLarge     = errors.New("image is too large for a thumbnail")
+)
+
+// NewThumbnail decodes the given PNG, JPEG or GIF image and returns a JPEG thumbnail of it,
+// scaled down to fit within ThumbnailMaxDimension and encoded to at most ThumbnailMaxSize bytes.
+// Images larger than ThumbnailMaxSourceSize bytes or ThumbnailMaxSourcePixels pixels are refused
+// with ErrImageTooLarge before they are decoded.
+func NewThumbnail(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, ThumbnailMaxSourceSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > ThumbnailMaxSourceSize {
+		return nil, ErrImageTooLarge
+	}
+
+	if err := checkThumbnailSource(b); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := resizeImage(img, ThumbnailMaxDimension)
+
+	for quality := 90; quality > 0; quality -= 10 {
+		buf := new(bytes.Buffer)
+
+		if err := jpeg.Encode(buf, thumb, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+
+		if buf.Len() <= ThumbnailMaxSize {
+			return buf.Bytes(), nil
+		}
+	}
+
+	return nil, ErrThumbnailTooLarge
+}
+
+// UploadThumbnail encrypts the given thumbnail with the file's session key and uploads it to the given draft revision.
+// It must be called before the revision is committed.
+func (c *Client) UploadThumbnail(
+	ctx context.Context,
+	shareID, linkID, revisionID string,
+	addr Address,
+	addrKR, nodeKR *crypto.KeyRing,
+	sessionKey *crypto.SessionKey,
+	thumbnail []byte,
+) error {
+	enc, info, err := encryptBlock(0, thumbnail, addrKR, nodeKR, sessionKey)
+	if err != nil {
+		return err
+	}
+
+	links, err := c.RequestThumbnailUpload(ctx, BlockUploadReq{
+		AddressID:  addr.ID,
+		ShareID:    shareID,
+		LinkID:     linkID,
+		RevisionID: revisionID,
+		ThumbnailList: []ThumbnailUploadInfo{{
+			Size:         info.Size,
+			EncSignature: info.EncSignature,
+			Hash:         info.Hash,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(links) != 1 {
+		return fmt.Errorf("expected 1 thumbnail upload link, got %d", len(links))
+	}
+
+	return c.UploadBlock(ctx, links[0].BareURL, links[0].Token, blockStream(enc))
+}
+
+// GetThumbnail downloads the thumbnail of the given revision and decrypts it with the file's session key.
+// If addrKR is not nil, it is used to verify the thumbnail's signature, which is decrypted with the node keyring.
+func (c *Client) GetThumbnail(
+	ctx context.Context,
+	shareID, linkID, revisionID string,
+	addrKR, nodeKR *crypto.KeyRing,
+	sessionKey *crypto.SessionKey,
+) ([]byte, error) {
+	var res struct {
+		ThumbnailBareURL      string
+		ThumbnailToken        string
+		ThumbnailEncSignature string
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Get("/drive/shares/" + shareID + "/files/" + linkID + "/revisions/" + revisionID + "/thumbnail")
+	}); err != nil {
+		return nil, err
+	}
+
+	return c.DownloadBlock(ctx, Block{
+		BareURL:      res.ThumbnailBareURL,
+		Token:        res.ThumbnailToken,
+		EncSignature: res.ThumbnailEncSignature,
+	}, addrKR, nodeKR, sessionKey)
+}
+
+// checkThumbnailSource checks from its header that the image can be decoded and isn't too large to be.
+func checkThumbnailSource(b []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > ThumbnailMaxSourcePixels {
+		return ErrImageTooLarge
+	}
+
+	return nil
+}
+
+// thumbnailSource keeps a copy of the content written to it, as long as it is an image thumbnails can be made of.
+type thumbnailSource struct {
+	buf     []byte
+	invalid bool
+}
+
+func (src *thumbnailSource) Write(b []byte) (int, error) {
+	if src.invalid {
+		return len(b), nil
+	}
+
+	// The image header is in the first write, the first block of the content.
+	if src.buf == nil && checkThumbnailSource(b) != nil {
+		src.invalid = true
+	} else if len(src.buf)+len(b) > ThumbnailMaxSourceSize {
+		src.buf, src.invalid = nil, true
+	} else {
+		src.buf = append(src.buf, b...)
+	}
+
+	return len(b), nil
+}
+
+// getThumbnail returns the thumbnail of the content, if it is an image thumbnails can be made of.
+func (src *thumbnailSource) getThumbnail() ([]byte, bool) {
+	if src.invalid || len(src.buf) == 0 {
+		return nil, false
+	}
+
+	thumbnail, err := NewThumbnail(bytes.NewReader(src.buf))
+	if err != nil {
+		return nil, false
+	}
+
+	return thumbnail, true
+}
+
+// resizeImage scales the image down to fit within maxDim pixels, averaging the source pixels covered by each
+// destination pixel. Transparent pixels are composed over a white background.
+func resizeImage(src image.Image, maxDim int) image.Image {
+	bounds := src.Bounds()
+
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dstW, dstH := srcW, srcH
+
+	if srcW > maxDim || srcH > maxDim {
+		if srcW >= srcH {
+			dstW, dstH = maxDim, max(1, srcH*maxDim/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*maxDim/srcH), maxDim
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		y0, y1 := bounds.Min.Y+y*srcH/dstH, bounds.Min.Y+max((y+1)*srcH/dstH, y*srcH/dstH+1)
+
+		for x := 0; x < dstW; x++ {
+			x0, x1 := bounds.Min.X+x*srcW/dstW, bounds.Min.X+max((x+1)*srcW/dstW, x*srcW/dstW+1)
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+
+			white := 0xffff - a/n
+
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r/n + white),
+				G: uint16(g/n + white),
+				B: uint16(b/n + white),
+				A: 0xffff,
+			})
+		}
+	}
+
+	return dst
+}
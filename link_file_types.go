@@ -1,5 +1,14 @@
 package proton
 
+import (
+	"encoding/base64"
+	"mime"
+	"path"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"golang.org/x/exp/slices"
+)
+
 type CreateFileReq struct {
 	ParentLinkID string
 
@@ -17,6 +26,73 @@ type CreateFileReq struct {
 	SignatureAddress string // Signature email address used to sign passphrase and name
 }
 
+// NewCreateFileReq generates the keys of a new file with the given name in the given parent folder.
+// It returns the request along with the file's node keyring and content session key, needed to upload its revisions.
+func NewCreateFileReq(
+	parentLinkID, name, mimeType string,
+	parentKR *crypto.KeyRing,
+	parentHashKey []byte,
+	addrKR *crypto.KeyRing,
+	addrEmail string,
+) (CreateFileReq, *crypto.KeyRing, *crypto.SessionKey, error) {
+	keys, err := generateNodeKeys(parentKR, addrKR)
+	if err != nil {
+		return CreateFileReq{}, nil, nil, err
+	}
+
+	encName, err := encryptLinkName(name, parentKR, addrKR)
+	if err != nil {
+		return CreateFileReq{}, nil, nil, err
+	}
+
+	sessionKey, err := crypto.GenerateSessionKey()
+	if err != nil {
+		return CreateFileReq{}, nil, nil, err
+	}
+
+	keyPacket, err := keys.kr.EncryptSessionKey(sessionKey)
+	if err != nil {
+		return CreateFileReq{}, nil, nil, err
+	}
+
+	keyPacketSig, err := keys.kr.SignDetached(crypto.NewPlainMessage(sessionKey.Key))
+	if err != nil {
+		return CreateFileReq{}, nil, nil, err
+	}
+
+	armKeyPacketSig, err := keyPacketSig.GetArmored()
+	if err != nil {
+		return CreateFileReq{}, nil, nil, err
+	}
+
+	return CreateFileReq{
+		ParentLinkID: parentLinkID,
+
+		Name:     encName,
+		Hash:     hashLinkName(name, parentHashKey),
+		MIMEType: mimeType,
+
+		ContentKeyPacket:          base64.StdEncoding.EncodeToString(keyPacket),
+		ContentKeyPacketSignature: armKeyPacketSig,
+
+		NodeKey:                 keys.Key,
+		NodePassphrase:          keys.Passphrase,
+		NodePassphraseSignature: keys.PassphraseSignature,
+
+		SignatureAddress: addrEmail,
+	}, keys.kr, sessionKey, nil
+}
+
+// GetMIMEType returns the MIME type of the given file name based on its extension.
+// Files with an unknown extension are given the application/octet-stream type.
+func GetMIMEType(name string) string {
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name))); err == nil {
+		return mediaType
+	}
+
+	return "application/octet-stream"
+}
+
 type CreateFileRes struct {
 	ID         string // Encrypted Link ID
 	RevisionID string // Encrypted Revision ID
@@ -27,9 +103,52 @@ type UpdateRevisionReq struct {
 	State             RevisionState
 	ManifestSignature string
 	SignatureAddress  string
+	XAttr             string `json:",omitempty"` // Extended attributes, encrypted with the node key and signed with the address key.
 }
 
 type BlockToken struct {
 	Index int
 	Token string
 }
+
+type CreateRevisionRes struct {
+	ID string // Encrypted Revision ID
+}
+
+// RevisionDiff lists the indexes of the blocks which differ between two revisions of a file.
+// Blocks are compared by the digest of their decrypted content.
+type RevisionDiff struct {
+	Added    []int // Blocks only present in the new revision
+	Removed  []int // Blocks only present in the old revision
+	Modified []int // Blocks present in both revisions but with different content
+}
+
+// Equal returns whether the two revisions have the same blocks.
+func (diff RevisionDiff) Equal() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Modified) == 0
+}
+
+// diffRevisionBlocks compares the block digests of two revisions, given by block index.
+func diffRevisionBlocks(oldDigests, newDigests map[int]string) RevisionDiff {
+	var diff RevisionDiff
+
+	for index, newDigest := range newDigests {
+		if oldDigest, ok := oldDigests[index]; !ok {
+			diff.Added = append(diff.Added, index)
+		} else if oldDigest != newDigest {
+			diff.Modified = append(diff.Modified, index)
+		}
+	}
+
+	for index := range oldDigests {
+		if _, ok := newDigests[index]; !ok {
+			diff.Removed = append(diff.Removed, index)
+		}
+	}
+
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	slices.Sort(diff.Modified)
+
+	return diff
+}
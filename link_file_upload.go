@@ -0,0 +1,195 @@
+package proton
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// fileBlockSize is the size of the unencrypted blocks in which file contents are split.
+const fileBlockSize = 4 * 1024 * 1024
+
+// UploadFile creates a new file with the given name in the given parent folder and uploads its first revision.
+func (c *Client) UploadFile(
+	ctx context.Context,
+	shareID, parentLinkID, name, mimeType string,
+	parentKR *crypto.KeyRing,
+	parentHashKey []byte,
+	addr Address,
+	addrKR *crypto.KeyRing,
+	modTime time.Time,
+	r io.Reader,
+) (CreateFileRes, error) {
+	req, nodeKR, sessionKey, err := NewCreateFileReq(parentLinkID, name, mimeType, parentKR, parentHashKey, addrKR, addr.Email)
+	if err != nil {
+		return CreateFileRes{}, err
+	}
+
+	res, err := c.CreateFile(ctx, shareID, req)
+	if err != nil {
+		return CreateFileRes{}, err
+	}
+
+	if err := c.UploadRevision(ctx, shareID, res.ID, res.RevisionID, addr, addrKR, nodeKR, sessionKey, modTime, r); err != nil {
+		return CreateFileRes{}, err
+	}
+
+	return res, nil
+}
+
+// UploadRevision splits the given content into blocks, encrypts them with the file's session key and uploads them
+// to the given draft revision, which is then committed as the active revision of the file.
+// Blocks are signed with the address keyring; their signatures are encrypted with the node keyring.
+// The revision's extended attributes carry the given modification time along with the content's size and digest.
+// If the content is an image which can be decoded, its thumbnail is uploaded along with it.
+func (c *Client) UploadRevision(
+	ctx context.Context,
+	shareID, linkID, revisionID string,
+	addr Address,
+	addrKR, nodeKR *crypto.KeyRing,
+	sessionKey *crypto.SessionKey,
+	modTime time.Time,
+	r io.Reader,
+) error {
+	var (
+		tokens   []BlockToken
+		manifest []byte
+	)
+
+	xAttr := XAttr{Common: XAttrCommon{ModificationTime: modTime.UTC()}}
+
+	digest := sha1.New()
+
+	var thumbnailSrc thumbnailSource
+
+	buf := make([]byte, fileBlockSize)
+
+	for index := 1; ; index++ {
+		n, err := io.ReadFull(r, buf)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		enc, info, err := encryptBlock(index, buf[:n], addrKR, nodeKR, sessionKey)
+		if err != nil {
+			return err
+		}
+
+		links, err := c.RequestBlockUpload(ctx, BlockUploadReq{
+			AddressID:  addr.ID,
+			ShareID:    shareID,
+			LinkID:     linkID,
+			RevisionID: revisionID,
+			BlockList:  []BlockUploadInfo{info},
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(links) != 1 {
+			return fmt.Errorf("expected 1 block upload link, got %d", len(links))
+		}
+
+		if err := c.UploadBlock(ctx, links[0].BareURL, links[0].Token, blockStream(enc)); err != nil {
+			return err
+		}
+
+		if _, err := io.MultiWriter(digest, &thumbnailSrc).Write(buf[:n]); err != nil {
+			return err
+		}
+
+		xAttr.Common.Size += int64(n)
+		xAttr.Common.BlockSizes = append(xAttr.Common.BlockSizes, int64(n))
+
+		hash := sha256.Sum256(enc)
+
+		tokens = append(tokens, BlockToken{Index: index, Token: links[0].Token})
+		manifest = append(manifest, hash[:]...)
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	// The thumbnail must be uploaded before the revision is committed.
+	if thumbnail, ok := thumbnailSrc.getThumbnail(); ok {
+		if err := c.UploadThumbnail(ctx, shareID, linkID, revisionID, addr, addrKR, nodeKR, sessionKey, thumbnail); err != nil {
+			return err
+		}
+	}
+
+	manifestSig, err := addrKR.SignDetached(crypto.NewPlainMessage(manifest))
+	if err != nil {
+		return err
+	}
+
+	armManifestSig, err := manifestSig.GetArmored()
+	if err != nil {
+		return err
+	}
+
+	xAttr.Common.Digests.SHA1 = hex.EncodeToString(digest.Sum(nil))
+
+	encXAttr, err := xAttr.Encrypt(nodeKR, addrKR)
+	if err != nil {
+		return err
+	}
+
+	return c.UpdateRevision(ctx, shareID, linkID, revisionID, UpdateRevisionReq{
+		BlockList:         tokens,
+		State:             RevisionStateActive,
+		ManifestSignature: armManifestSig,
+		SignatureAddress:  addr.Email,
+		XAttr:             encXAttr,
+	})
+}
+
+// encryptBlock encrypts the given block with the session key and returns it along with its upload info.
+func encryptBlock(index int, data []byte, addrKR, nodeKR *crypto.KeyRing, sessionKey *crypto.SessionKey) ([]byte, BlockUploadInfo, error) {
+	enc, err := sessionKey.Encrypt(crypto.NewPlainMessage(data))
+	if err != nil {
+		return nil, BlockUploadInfo{}, err
+	}
+
+	sig, err := addrKR.SignDetached(crypto.NewPlainMessage(data))
+	if err != nil {
+		return nil, BlockUploadInfo{}, err
+	}
+
+	encSig, err := nodeKR.Encrypt(crypto.NewPlainMessage(sig.GetBinary()), nil)
+	if err != nil {
+		return nil, BlockUploadInfo{}, err
+	}
+
+	armEncSig, err := encSig.GetArmored()
+	if err != nil {
+		return nil, BlockUploadInfo{}, err
+	}
+
+	hash := sha256.Sum256(enc)
+
+	return enc, BlockUploadInfo{
+		Index:        index,
+		Size:         int64(len(enc)),
+		EncSignature: armEncSig,
+		Hash:         base64.StdEncoding.EncodeToString(hash[:]),
+	}, nil
+}
+
+// blockStream is an encrypted block uploaded as a multipart field.
+type blockStream []byte
+
+func (b blockStream) GetMultipartReader() io.Reader {
+	return bytes.NewReader(b)
+}
@@ -0,0 +1,66 @@
+package proton
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// XAttr holds the extended attributes of a revision.
+// They are set by the client which uploaded the revision and carry the real file metadata.
+type XAttr struct {
+	Common XAttrCommon
+}
+
+type XAttrCommon struct {
+	ModificationTime time.Time    // The modification time of the file on the uploader's file system
+	Size             int64        // The size of the unencrypted file in bytes
+	BlockSizes       []int64      // The size of each unencrypted block in bytes
+	Digests          XAttrDigests // The digests of the unencrypted file
+}
+
+type XAttrDigests struct {
+	SHA1 string // Hex encoded SHA1 digest of the file
+}
+
+// Encrypt encrypts the extended attributes with the file's node keyring and signs them with the address keyring.
+func (xAttr XAttr) Encrypt(nodeKR, addrKR *crypto.KeyRing) (string, error) {
+	b, err := json.Marshal(xAttr)
+	if err != nil {
+		return "", err
+	}
+
+	enc, err := nodeKR.Encrypt(crypto.NewPlainMessage(b), addrKR)
+	if err != nil {
+		return "", err
+	}
+
+	return enc.GetArmored()
+}
+
+// GetXAttr decrypts the extended attributes of the revision with the file's node keyring and verifies their
+// signature with the given address keyring. It returns nil if the revision has no extended attributes.
+func (r RevisionMetadata) GetXAttr(nodeKR, addrKR *crypto.KeyRing) (*XAttr, error) {
+	if r.XAttr == "" {
+		return nil, nil
+	}
+
+	enc, err := crypto.NewPGPMessageFromArmored(r.XAttr)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := nodeKR.Decrypt(enc, addrKR, crypto.GetUnixTime())
+	if err != nil {
+		return nil, err
+	}
+
+	var xAttr XAttr
+
+	if err := json.Unmarshal(dec.GetBinary(), &xAttr); err != nil {
+		return nil, err
+	}
+
+	return &xAttr, nil
+}
@@ -1,5 +1,7 @@
 package proton
 
+import "github.com/ProtonMail/gopenpgp/v2/crypto"
+
 type CreateFolderReq struct {
 	ParentLinkID string
 
@@ -15,6 +17,46 @@ type CreateFolderReq struct {
 	SignatureAddress string
 }
 
+// NewCreateFolderReq generates the keys of a new folder with the given name in the given parent folder.
+// The parent hash key is used to hash the folder name; addrKR signs the folder's name and passphrase.
+func NewCreateFolderReq(
+	parentLinkID, name string,
+	parentKR *crypto.KeyRing,
+	parentHashKey []byte,
+	addrKR *crypto.KeyRing,
+	addrEmail string,
+) (CreateFolderReq, error) {
+	keys, err := generateNodeKeys(parentKR, addrKR)
+	if err != nil {
+		return CreateFolderReq{}, err
+	}
+
+	encName, err := encryptLinkName(name, parentKR, addrKR)
+	if err != nil {
+		return CreateFolderReq{}, err
+	}
+
+	hashKey, err := generateHashKey(keys.kr)
+	if err != nil {
+		return CreateFolderReq{}, err
+	}
+
+	return CreateFolderReq{
+		ParentLinkID: parentLinkID,
+
+		Name: encName,
+		Hash: hashLinkName(name, parentHashKey),
+
+		NodeKey:     keys.Key,
+		NodeHashKey: hashKey,
+
+		NodePassphrase:          keys.Passphrase,
+		NodePassphraseSignature: keys.PassphraseSignature,
+
+		SignatureAddress: addrEmail,
+	}, nil
+}
+
 type CreateFolderRes struct {
 	ID string // Encrypted Link ID
 }
@@ -3,12 +3,53 @@ package proton
 import (
 	"encoding/base64"
 	"errors"
+	"sync"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 )
 
 type LinkWalkFunc func([]string, Link, *crypto.KeyRing) error
 
+// LinkKeyRings caches the node keyrings of links, keyed by link ID, so that their children can be decrypted.
+// The share keyring may be added under the empty link ID to decrypt the share's root link.
+type LinkKeyRings struct {
+	addrKR *crypto.KeyRing
+
+	krs  map[string]*crypto.KeyRing
+	lock sync.RWMutex
+}
+
+// NewLinkKeyRings returns a new link keyring cache; addrKR is used to verify link signatures.
+func NewLinkKeyRings(addrKR *crypto.KeyRing) *LinkKeyRings {
+	return &LinkKeyRings{
+		addrKR: addrKR,
+		krs:    make(map[string]*crypto.KeyRing),
+	}
+}
+
+func (krs *LinkKeyRings) Get(linkID string) (*crypto.KeyRing, bool) {
+	krs.lock.RLock()
+	defer krs.lock.RUnlock()
+
+	kr, ok := krs.krs[linkID]
+
+	return kr, ok
+}
+
+func (krs *LinkKeyRings) Add(linkID string, kr *crypto.KeyRing) {
+	krs.lock.Lock()
+	defer krs.lock.Unlock()
+
+	krs.krs[linkID] = kr
+}
+
+func (krs *LinkKeyRings) Remove(linkID string) {
+	krs.lock.Lock()
+	defer krs.lock.Unlock()
+
+	delete(krs.krs, linkID)
+}
+
 // Link holds the tree structure, for the clients, they represent the files and folders of a given volume.
 // They have a ParentLinkID that points to parent folders.
 // Links also hold the file name (encrypted) and a hash of the name for name collisions.
@@ -164,6 +205,7 @@ type RevisionMetadata struct {
 	State             RevisionState // State of revision
 	Thumbnail         Bool          // Whether the revision has a thumbnail
 	ThumbnailHash     string        // Hash of the thumbnail
+	XAttr             string        // Extended attributes of the revision, encrypted with the node key and signed with the address key.
 }
 
 // Revisions are only for files, they represent “versions” of files.
@@ -182,3 +224,47 @@ const (
 	RevisionStateObsolete
 	RevisionStateDeleted
 )
+
+type MoveLinkReq struct {
+	ParentLinkID string
+
+	Name         string
+	Hash         string
+	OriginalHash string // Hash of the link's current name; the move fails if the link was renamed in the meantime.
+
+	NodePassphrase     string
+	NameSignatureEmail string
+}
+
+// NewMoveLinkReq returns a request moving the given link to the given parent folder with the given name.
+// The link's node passphrase is re-encrypted from its current parent's keyring to the new parent's keyring;
+// the name is encrypted with the new parent's keyring, hashed with its hash key and signed with addrKR.
+func NewMoveLinkReq(
+	link Link,
+	parentLinkID, name string,
+	oldParentKR, parentKR *crypto.KeyRing,
+	parentHashKey []byte,
+	addrKR *crypto.KeyRing,
+	addrEmail string,
+) (MoveLinkReq, error) {
+	passphrase, err := reencryptMessage(link.NodePassphrase, oldParentKR, parentKR)
+	if err != nil {
+		return MoveLinkReq{}, err
+	}
+
+	encName, err := encryptLinkName(name, parentKR, addrKR)
+	if err != nil {
+		return MoveLinkReq{}, err
+	}
+
+	return MoveLinkReq{
+		ParentLinkID: parentLinkID,
+
+		Name:         encName,
+		Hash:         hashLinkName(name, parentHashKey),
+		OriginalHash: link.Hash,
+
+		NodePassphrase:     passphrase,
+		NameSignatureEmail: addrEmail,
+	}, nil
+}
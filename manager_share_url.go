@@ -0,0 +1,65 @@
+package proton
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+
+	"github.com/ProtonMail/go-srp"
+)
+
+func (m *Manager) ShareURLInfo(ctx context.Context, token string) (ShareURLInfo, error) {
+	var res struct {
+		ShareURLInfo
+	}
+
+	if _, err := m.r(ctx).SetResult(&res).Post("/drive/urls/" + token + "/info"); err != nil {
+		return ShareURLInfo{}, err
+	}
+
+	return res.ShareURLInfo, nil
+}
+
+// NewClientWithShareURL authenticates anonymously to the share URL with the given token and password.
+// The returned client can only be used to access the content of that share URL.
+func (m *Manager) NewClientWithShareURL(ctx context.Context, token string, password []byte) (*Client, Auth, error) {
+	info, err := m.ShareURLInfo(ctx, token)
+	if err != nil {
+		return nil, Auth{}, err
+	}
+
+	srpAuth, err := srp.NewAuth(info.Version, "", password, info.URLPasswordSalt, info.Modulus, info.ServerEphemeral)
+	if err != nil {
+		return nil, Auth{}, err
+	}
+
+	proofs, err := srpAuth.GenerateProofs(2048)
+	if err != nil {
+		return nil, Auth{}, err
+	}
+
+	var res struct {
+		Auth
+	}
+
+	if _, err := m.r(ctx).SetBody(ShareURLAuthReq{
+		ClientEphemeral: base64.StdEncoding.EncodeToString(proofs.ClientEphemeral),
+		ClientProof:     base64.StdEncoding.EncodeToString(proofs.ClientProof),
+		SRPSession:      info.SRPSession,
+	}).SetResult(&res).Post("/drive/urls/" + token + "/auth"); err != nil {
+		return nil, Auth{}, err
+	}
+
+	serverProof, err := base64.StdEncoding.DecodeString(res.ServerProof)
+	if err != nil {
+		return nil, Auth{}, err
+	}
+
+	if m.verifyProofs {
+		if !bytes.Equal(serverProof, proofs.ExpectedServerProof) {
+			return nil, Auth{}, ErrInvalidProof
+		}
+	}
+
+	return newClient(m, res.UID).withAuth(res.AccessToken, res.RefreshToken), res.Auth, nil
+}
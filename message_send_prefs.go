@@ -0,0 +1,318 @@
+package proton
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ProtonMail/gluon/rfc822"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+)
+
+// ResolveSendPreferences returns the preferences with which a message should be sent to each of the recipients.
+// They combine the keys of the recipient returned by the API, the settings pinned in the recipient's contact,
+// whose signed card is verified with addrKR, and the user's mail settings.
+// The preferences can be given to BuildSendDraftReq.
+// If a recipient who gets encrypted messages has pinned keys but none of them is theirs, ErrPinnedKeyMismatch is returned.
+func (c *Client) ResolveSendPreferences(ctx context.Context, addrKR *crypto.KeyRing, recipients []string) (map[string]SendPreferences, error) {
+	mailSettings, err := c.GetMailSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := make(map[string]SendPreferences, len(recipients))
+
+	for _, recipient := range recipients {
+		pubKeys, recipientType, err := c.GetPublicKeys(ctx, recipient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get public keys of %v: %w", recipient, err)
+		}
+
+		settings, err := c.getRecipientSettings(ctx, addrKR, recipient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get contact settings of %v: %w", recipient, err)
+		}
+
+		if prefs[recipient], err = newSendPreferences(pubKeys, recipientType, settings, mailSettings); err != nil {
+			return nil, fmt.Errorf("failed to resolve send preferences of %v: %w", recipient, err)
+		}
+	}
+
+	return prefs, nil
+}
+
+// BuildSendDraftReq builds the request to send a draft to the recipients with the given preferences.
+// Recipients are grouped into one package per MIME type. bodies holds the body of the draft in each MIME type
+// the recipients need: the text/plain and text/html bodies for text packages, and the whole message,
+// attachments included, for multipart/mixed packages. attKeys holds the session keys of the attachments
+// of the draft, keyed by attachment ID.
+func BuildSendDraftReq(
+	kr *crypto.KeyRing,
+	prefs map[string]SendPreferences,
+	bodies map[rfc822.MIMEType]string,
+	attKeys map[string]*crypto.SessionKey,
+) (SendDraftReq, error) {
+	groups := make(map[rfc822.MIMEType]map[string]SendPreferences)
+
+	for recipient, prefs := range prefs {
+		if _, ok := groups[prefs.MIMEType]; !ok {
+			groups[prefs.MIMEType] = make(map[string]SendPreferences)
+		}
+
+		groups[prefs.MIMEType][recipient] = prefs
+	}
+
+	var req SendDraftReq
+
+	for _, mimeType := range []rfc822.MIMEType{rfc822.TextPlain, rfc822.TextHTML, rfc822.MultipartMixed} {
+		group, ok := groups[mimeType]
+		if !ok {
+			continue
+		}
+
+		delete(groups, mimeType)
+
+		body, ok := bodies[mimeType]
+		if !ok {
+			return SendDraftReq{}, fmt.Errorf("missing %v body", mimeType)
+		}
+
+		if mimeType == rfc822.MultipartMixed {
+			if err := req.AddMIMEPackage(kr, body, group); err != nil {
+				return SendDraftReq{}, err
+			}
+		} else {
+			if err := req.AddTextPackage(kr, body, mimeType, group, attKeys); err != nil {
+				return SendDraftReq{}, err
+			}
+		}
+	}
+
+	for mimeType := range groups {
+		return SendDraftReq{}, fmt.Errorf("invalid MIME type for package: %v", mimeType)
+	}
+
+	return req, nil
+}
+
+// getRecipientSettings returns the settings of the recipient pinned in the user's contacts, if any.
+func (c *Client) getRecipientSettings(ctx context.Context, kr *crypto.KeyRing, recipient string) (ContactSettings, error) {
+	emails, err := c.GetAllContactEmails(ctx, recipient)
+	if err != nil {
+		return ContactSettings{}, err
+	} else if len(emails) == 0 {
+		return ContactSettings{}, nil
+	}
+
+	contact, err := c.GetContact(ctx, emails[0].ContactID)
+	if err != nil {
+		return ContactSettings{}, err
+	}
+
+	return contact.GetSettings(kr, emails[0].Email, CardTypeSigned)
+}
+
+// newSendPreferences chooses how to send a message to a recipient:
+//   - internal recipients always get encrypted and signed messages in the user's draft format;
+//   - external recipients get encrypted messages if they have keys, either returned by the API or pinned
+//     in their contact, unless their contact disables encryption;
+//   - encrypted messages are always signed; otherwise messages are signed if the contact or, by default,
+//     the mail settings say so;
+//   - encrypted and signed messages use the PGP scheme of the contact or, by default, of the mail settings:
+//     PGP/Inline messages are plain text, PGP/MIME and signed-only messages are sent as MIME.
+func newSendPreferences(
+	pubKeys PublicKeys,
+	recipientType RecipientType,
+	settings ContactSettings,
+	mailSettings MailSettings,
+) (SendPreferences, error) {
+	var prefs SendPreferences
+
+	apiKeys, err := getSendKeys(pubKeys)
+	if err != nil {
+		return SendPreferences{}, err
+	}
+
+	pinnedKeys := xslices.Filter(settings.Keys, isSendKey)
+
+	if len(pinnedKeys) < len(settings.Keys) {
+		prefs.Warnings = append(prefs.Warnings, "some pinned keys can't be used for encryption")
+	}
+
+	// The draft MIME type is used unless the contact or the signature scheme asks for another one.
+	draftMIMEType := mailSettings.DraftMIMEType
+	if draftMIMEType == "" {
+		draftMIMEType = rfc822.TextHTML
+	}
+
+	if settings.MIMEType != nil {
+		draftMIMEType = *settings.MIMEType
+	}
+
+	var key *crypto.Key
+
+	switch {
+	case recipientType == RecipientTypeInternal:
+		if len(apiKeys) == 0 {
+			return SendPreferences{}, fmt.Errorf("internal recipient has no usable key")
+		}
+
+		if key, err = choosePinnedSendKey(&prefs, apiKeys, pinnedKeys); err != nil {
+			return SendPreferences{}, err
+		}
+
+		prefs.Encrypt = true
+		prefs.Reasons = append(prefs.Reasons, "internal recipients always get encrypted messages")
+
+	case len(apiKeys) > 0:
+		prefs.Encrypt = settings.Encrypt == nil || *settings.Encrypt
+
+		if prefs.Encrypt {
+			// The pinned keys only matter if the message is encrypted.
+			if key, err = choosePinnedSendKey(&prefs, apiKeys, pinnedKeys); err != nil {
+				return SendPreferences{}, err
+			}
+
+			prefs.Reasons = append(prefs.Reasons, "the recipient publishes encryption keys")
+		} else {
+			prefs.Reasons = append(prefs.Reasons, "the contact disables encryption")
+		}
+
+	case len(pinnedKeys) > 0:
+		key = pinnedKeys[0]
+
+		prefs.Encrypt = settings.Encrypt == nil || *settings.Encrypt
+
+		if prefs.Encrypt {
+			prefs.Reasons = append(prefs.Reasons, "the contact has pinned encryption keys")
+		} else {
+			prefs.Reasons = append(prefs.Reasons, "the contact disables encryption")
+		}
+
+	default:
+		if settings.Encrypt != nil && *settings.Encrypt {
+			prefs.Warnings = append(prefs.Warnings, "the contact enables encryption but has no usable key")
+		}
+
+		prefs.Reasons = append(prefs.Reasons, "the recipient has no encryption key")
+	}
+
+	prefs.Trust = getKeyTrust(apiKeys, pinnedKeys)
+
+	var sign bool
+
+	switch {
+	case prefs.Encrypt:
+		if settings.Sign != nil && !*settings.Sign {
+			prefs.Warnings = append(prefs.Warnings, "the contact disables signing but encrypted messages are always signed")
+		}
+
+		sign = true
+
+	case settings.Sign != nil:
+		sign = *settings.Sign
+
+		if sign {
+			prefs.Reasons = append(prefs.Reasons, "the contact enables signing")
+		}
+
+	default:
+		sign = mailSettings.Sign == SignExternalMessagesEnabled
+
+		if sign {
+			prefs.Reasons = append(prefs.Reasons, "the mail settings enable signing external messages")
+		}
+	}
+
+	scheme := mailSettings.PGPScheme
+	if settings.Scheme != nil {
+		scheme = *settings.Scheme
+	}
+
+	if scheme != PGPInlineScheme {
+		scheme = PGPMIMEScheme
+	}
+
+	switch {
+	case recipientType == RecipientTypeInternal:
+		prefs.EncryptionScheme = InternalScheme
+		prefs.MIMEType = draftMIMEType
+
+	case prefs.Encrypt && scheme == PGPInlineScheme:
+		prefs.EncryptionScheme = PGPInlineScheme
+		prefs.MIMEType = rfc822.TextPlain
+
+	case prefs.Encrypt:
+		prefs.EncryptionScheme = PGPMIMEScheme
+		prefs.MIMEType = rfc822.MultipartMixed
+
+	case sign:
+		prefs.EncryptionScheme = ClearMIMEScheme
+		prefs.MIMEType = rfc822.MultipartMixed
+
+	default:
+		prefs.EncryptionScheme = ClearScheme
+		prefs.MIMEType = draftMIMEType
+	}
+
+	if sign {
+		prefs.SignatureType = DetachedSignature
+	} else {
+		prefs.SignatureType = NoSignature
+	}
+
+	if prefs.Encrypt {
+		if prefs.PubKey, err = crypto.NewKeyRing(key); err != nil {
+			return SendPreferences{}, err
+		}
+	}
+
+	prefs.AttachPublicKey = bool(mailSettings.AttachPublicKey) && sign && recipientType != RecipientTypeInternal
+
+	return prefs, nil
+}
+
+// choosePinnedSendKey returns the first of the recipient's keys that is pinned, or the first key if no key is pinned.
+// If keys are pinned but none of them is the recipient's, ErrPinnedKeyMismatch is returned.
+func choosePinnedSendKey(prefs *SendPreferences, keys, pinnedKeys []*crypto.Key) (*crypto.Key, error) {
+	if len(pinnedKeys) == 0 {
+		return keys[0], nil
+	}
+
+	for _, key := range keys {
+		if xslices.IndexFunc(pinnedKeys, func(pinned *crypto.Key) bool {
+			return pinned.GetFingerprint() == key.GetFingerprint()
+		}) >= 0 {
+			prefs.Reasons = append(prefs.Reasons, "the contact has a pinned key")
+			return key, nil
+		}
+	}
+
+	return nil, ErrPinnedKeyMismatch
+}
+
+// getSendKeys returns the keys to which messages can be encrypted, in order of preference.
+func getSendKeys(pubKeys PublicKeys) ([]*crypto.Key, error) {
+	var keys []*crypto.Key
+
+	for _, pubKey := range pubKeys {
+		if pubKey.Flags&KeyStateActive == 0 {
+			continue
+		}
+
+		key, err := crypto.NewKeyFromArmored(pubKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if isSendKey(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func isSendKey(key *crypto.Key) bool {
+	return key.CanEncrypt() && !key.IsExpired()
+}
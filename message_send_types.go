@@ -2,12 +2,17 @@ package proton
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 
 	"github.com/ProtonMail/gluon/rfc822"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 )
 
+// ErrPinnedKeyMismatch is returned when none of the keys pinned in a recipient's contact is one of the recipient's keys.
+// Messages are not encrypted to the recipient's other keys, as the pinned keys are the only ones the user trusts.
+var ErrPinnedKeyMismatch = errors.New("the pinned keys don't match the recipient's keys")
+
 type EncryptionScheme int
 
 const (
@@ -93,6 +98,18 @@ type SendPreferences struct {
 	// for PGP/MIME encrypted emails, where attachments go into the body too.
 	// Because of this, this option is sometimes called MIME format.
 	MIMEType rfc822.MIMEType
+
+	// AttachPublicKey indicates whether the sender's public key should be attached to the message.
+	AttachPublicKey bool
+
+	// Trust is how much the key to which the message is encrypted can be trusted.
+	Trust KeyTrust
+
+	// Reasons explains, for information, how the preferences were chosen.
+	Reasons []string
+
+	// Warnings reports the problems found while choosing the preferences, e.g. pinned keys that can't be used.
+	Warnings []string
 }
 
 type SendDraftReq struct {
@@ -28,9 +28,14 @@ func (salts Salts) SaltForKey(keyPass []byte, keyID string) ([]byte, error) {
 		return nil, err
 	}
 
+	return saltKeyPass(keyPass, keySalt)
+}
+
+// saltKeyPass returns the passphrase of keys derived from the key password and the salt.
+func saltKeyPass(keyPass, keySalt []byte) ([]byte, error) {
 	saltedKeyPass, err := srp.MailboxPassword(keyPass, keySalt)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
 	return saltedKeyPass[len(saltedKeyPass)-31:], nil
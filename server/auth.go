@@ -127,3 +127,24 @@ func (s *Server) handleDeleteAuthSession() gin.HandlerFunc {
 		}
 	}
 }
+
+func (s *Server) handleGetAuthModulus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.b.GetModulus())
+	}
+}
+
+// decodeSRPProofs decodes the client ephemeral and proof with which an authenticated user is verified again.
+func decodeSRPProofs(req proton.AuthReq) ([]byte, []byte, error) {
+	ephemeral, err := base64.StdEncoding.DecodeString(req.ClientEphemeral)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	proof, err := base64.StdEncoding.DecodeString(req.ClientProof)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ephemeral, proof, nil
+}
@@ -1,6 +1,8 @@
 package backend
 
 import (
+	"errors"
+
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/bradenaw/juniper/xslices"
@@ -18,16 +20,27 @@ type account struct {
 
 	auth map[string]auth
 
-	keys     []key
-	salt     []byte
+	keys []key
+
+	// salt is the salt of the passphrase of the user's keys.
+	salt []byte
+
+	// srpSalt and verifier are the SRP salt and verifier of the login password.
+	srpSalt  []byte
 	verifier []byte
 
+	// passwordMode is whether the keys are unlocked with the login password or with a separate mailbox password.
+	passwordMode proton.PasswordMode
+
 	labelIDs   []string
 	messageIDs []string
 	updateIDs  []ID
+
+	volumeIDs []string
+	shareIDs  []string
 }
 
-func newAccount(userID, username string, armKey string, salt, verifier []byte) *account {
+func newAccount(userID, username string, armKey string, salt, srpSalt, verifier []byte, passwordMode proton.PasswordMode) *account {
 	return &account{
 		userID:       userID,
 		username:     username,
@@ -39,7 +52,10 @@ func newAccount(userID, username string, armKey string, salt, verifier []byte) *
 		auth:     make(map[string]auth),
 		keys:     []key{{keyID: uuid.NewString(), key: armKey}},
 		salt:     salt,
+		srpSalt:  srpSalt,
 		verifier: verifier,
+
+		passwordMode: passwordMode,
 	}
 }
 
@@ -89,3 +105,43 @@ func (acc *account) getAddr(email string) (*address, bool) {
 
 	return nil, false
 }
+
+// getCalendarMember returns the member of the calendar that is one of the account's addresses, if any.
+func (acc *account) getCalendarMember(cal *calendar) *calendarMember {
+	for _, member := range cal.members {
+		if _, ok := acc.addresses[member.addrID]; ok {
+			return member
+		}
+	}
+
+	return nil
+}
+
+// unlockAddrKey unlocks the primary key of the given address with the user's password.
+func (acc *account) unlockAddrKey(addr *address, password []byte) (*crypto.KeyRing, error) {
+	if len(addr.keys) == 0 {
+		return nil, errors.New("address has no key")
+	}
+
+	passphrase, err := hashPassword(password, acc.salt)
+	if err != nil {
+		return nil, err
+	}
+
+	userKR, err := acc.keys[0].unlock(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := crypto.NewPGPMessageFromArmored(addr.keys[0].tok)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := userKR.Decrypt(enc, nil, crypto.GetUnixTime())
+	if err != nil {
+		return nil, err
+	}
+
+	return addr.keys[0].unlock(token.GetBinary())
+}
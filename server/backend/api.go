@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ProtonMail/gluon/rfc822"
 	"github.com/ProtonMail/go-proton-api"
@@ -424,6 +425,12 @@ func (b *Backend) DeleteLabel(userID, labelID string) error {
 				}
 
 				for _, labelID := range getLabelIDsToDelete(labelID, labels) {
+					if labels[labelID].labelType == proton.LabelTypeContactGroup {
+						if err := b.unlabelContactGroup(acc, labelID); err != nil {
+							return err
+						}
+					}
+
 					delete(labels, labelID)
 
 					updateID, err := b.newUpdate(&labelDeleted{labelID: labelID})
@@ -803,6 +810,7 @@ func (b *Backend) SendMessage(userID, messageID string, packages []*proton.Messa
 					return withAtts(b, func(atts map[string]*attachment) (proton.Message, error) {
 						msg := messages[messageID]
 						msg.flags |= proton.MessageFlagSent
+						msg.date = time.Now()
 						msg.addLabel(proton.SentLabel, labels)
 
 						if parent, ok := messages[msg.internalParentID]; ok {
@@ -1059,7 +1067,7 @@ func (b *Backend) GetEvent(userID, rawEventID string) (event proton.Event, more
 
 						more = lastUpdate != len(acc.updateIDs)
 
-						return buildEvent(updates, acc.addresses, messages, labels, acc.updateIDs[lastUpdate-1].String(), b.attData, attachments, acc.toUser()), nil
+						return buildEvent(updates, acc.addresses, messages, labels, acc.contacts, acc.updateIDs[lastUpdate-1].String(), b.attData, attachments, acc.toUser()), nil
 					})
 				})
 			})
@@ -1227,7 +1235,13 @@ func (b *Backend) GetUserContacts(userID string, page int, pageSize int) (int, [
 			slices.SortFunc(values, func(i, j *proton.Contact) bool {
 				return strings.Compare(i.ID, j.ID) < 0
 			})
-			return xslices.Map(xslices.Chunk(values, pageSize)[page], func(c *proton.Contact) proton.Contact {
+
+			chunks := xslices.Chunk(values, pageSize)
+			if page < 0 || page >= len(chunks) {
+				return nil, nil
+			}
+
+			return xslices.Map(chunks[page], func(c *proton.Contact) proton.Contact {
 				return *c
 			}), nil
 		})
@@ -1236,7 +1250,7 @@ func (b *Backend) GetUserContacts(userID string, page int, pageSize int) (int, [
 	return total, contacts, err
 }
 
-func (b *Backend) GetUserContactEmails(userID, email string, page int, pageSize int) (int, []proton.ContactEmail, error) {
+func (b *Backend) GetUserContactEmails(userID, email, labelID string, page int, pageSize int) (int, []proton.ContactEmail, error) {
 	var total int
 
 	emails, err := readBackendRetErr(b, func(b *unsafeBackend) ([]proton.ContactEmail, error) {
@@ -1244,9 +1258,15 @@ func (b *Backend) GetUserContactEmails(userID, email string, page int, pageSize
 			var contacts []proton.ContactEmail
 			for _, contact := range acc.contacts {
 				for _, contactEmail := range contact.ContactEmails {
-					if email == "" || contactEmail.Email == email {
-						contacts = append(contacts, contactEmail)
+					if email != "" && contactEmail.Email != email {
+						continue
+					}
+
+					if labelID != "" && !slices.Contains(contactEmail.LabelIDs, labelID) {
+						continue
 					}
+
+					contacts = append(contacts, contactEmail)
 				}
 			}
 
@@ -1260,27 +1280,157 @@ func (b *Backend) GetUserContactEmails(userID, email string, page int, pageSize
 				return strings.Compare(a.ID, b.ID) < 0
 			})
 
-			return xslices.Chunk(contacts, pageSize)[page], nil
+			chunks := xslices.Chunk(contacts, pageSize)
+			if page < 0 || page >= len(chunks) {
+				return nil, nil
+			}
+
+			return chunks[page], nil
 		})
 	})
 
 	return total, emails, err
 }
 
+// LabelContactEmails adds the contact emails to the contact group.
+func (b *Backend) LabelContactEmails(userID, labelID string, contactEmailIDs ...string) error {
+	return b.setContactEmailsLabel(userID, labelID, true, contactEmailIDs...)
+}
+
+// UnlabelContactEmails removes the contact emails from the contact group.
+func (b *Backend) UnlabelContactEmails(userID, labelID string, contactEmailIDs ...string) error {
+	return b.setContactEmailsLabel(userID, labelID, false, contactEmailIDs...)
+}
+
+func (b *Backend) setContactEmailsLabel(userID, labelID string, labeled bool, contactEmailIDs ...string) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		return b.withAcc(userID, func(acc *account) error {
+			return b.withLabels(func(labels map[string]*label) error {
+				if !slices.Contains(acc.labelIDs, labelID) || labels[labelID].labelType != proton.LabelTypeContactGroup {
+					return fmt.Errorf("no such contact group: %s", labelID)
+				}
+
+				for _, contactEmailID := range contactEmailIDs {
+					if _, ok := getContactEmail(acc.contacts, contactEmailID); !ok {
+						return fmt.Errorf("no such contact email: %s", contactEmailID)
+					}
+				}
+
+				var updates []update
+
+				for _, contactEmailID := range contactEmailIDs {
+					contact, ok := setContactEmailLabel(acc.contacts, contactEmailID, labelID, labeled)
+					if !ok {
+						continue
+					}
+
+					updates = append(updates, &contactUpdated{contactID: contact.ID}, &contactEmailUpdated{contactEmailID: contactEmailID})
+				}
+
+				return b.addUpdates(acc, updates...)
+			})
+		})
+	})
+}
+
 func (b *Backend) AddUserContact(userID string, contact proton.Contact) (proton.Contact, error) {
 	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.Contact, error) {
 		return withAcc(b, userID, func(acc *account) (proton.Contact, error) {
 			acc.contacts[contact.ID] = &contact
+
+			updates := []update{&contactCreated{contactID: contact.ID}}
+
+			for _, email := range contact.ContactEmails {
+				updates = append(updates, &contactEmailCreated{contactEmailID: email.ID})
+			}
+
+			if err := b.addUpdates(acc, updates...); err != nil {
+				return proton.Contact{}, err
+			}
+
 			return *acc.contacts[contact.ID], nil
 		})
 	})
 }
 
-func (b *Backend) UpdateUserContact(userID, contactID string, cards proton.Cards) (proton.Contact, error) {
+// UpdateUserContact replaces the cards and the emails of the contact with those of the given contact.
+// Emails that the contact already had keep their ID.
+func (b *Backend) UpdateUserContact(userID string, contact proton.Contact) (proton.Contact, error) {
 	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.Contact, error) {
 		return withAcc(b, userID, func(acc *account) (proton.Contact, error) {
-			acc.contacts[contactID].Cards = cards
-			return *acc.contacts[contactID], nil
+			old, ok := acc.contacts[contact.ID]
+			if !ok {
+				return proton.Contact{}, errors.New("No such contact with ID" + contact.ID)
+			}
+
+			updates := []update{&contactUpdated{contactID: contact.ID}}
+
+			// Each old email gives its ID to a single new email, even if the contact has the same email twice.
+			used := make([]bool, len(old.ContactEmails))
+
+			for i, email := range contact.ContactEmails {
+				idx := -1
+
+				for j, other := range old.ContactEmails {
+					if !used[j] && other.Email == email.Email {
+						idx = j
+						break
+					}
+				}
+
+				if idx < 0 {
+					updates = append(updates, &contactEmailCreated{contactEmailID: email.ID})
+					continue
+				}
+
+				used[idx] = true
+
+				contact.ContactEmails[i].ID = old.ContactEmails[idx].ID
+				contact.ContactEmails[i].LabelIDs = old.ContactEmails[idx].LabelIDs
+
+				updates = append(updates, &contactEmailUpdated{contactEmailID: old.ContactEmails[idx].ID})
+			}
+
+			for _, email := range old.ContactEmails {
+				if xslices.IndexFunc(contact.ContactEmails, func(other proton.ContactEmail) bool {
+					return other.ID == email.ID
+				}) < 0 {
+					updates = append(updates, &contactEmailDeleted{contactEmailID: email.ID})
+				}
+			}
+
+			old.Name = contact.Name
+			old.UID = contact.UID
+			old.ContactEmails = contact.ContactEmails
+			old.Cards = contact.Cards
+
+			if err := b.addUpdates(acc, updates...); err != nil {
+				return proton.Contact{}, err
+			}
+
+			return *old, nil
+		})
+	})
+}
+
+func (b *Backend) DeleteUserContact(userID, contactID string) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		return b.withAcc(userID, func(acc *account) error {
+			return b.deleteContact(acc, contactID)
+		})
+	})
+}
+
+func (b *Backend) DeleteAllUserContacts(userID string) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		return b.withAcc(userID, func(acc *account) error {
+			for _, contactID := range maps.Keys(acc.contacts) {
+				if err := b.deleteContact(acc, contactID); err != nil {
+					return err
+				}
+			}
+
+			return nil
 		})
 	})
 }
@@ -1299,6 +1449,7 @@ func buildEvent(
 	addresses map[string]*address,
 	messages map[string]*message,
 	labels map[string]*label,
+	contacts map[string]*proton.Contact,
 	eventID string,
 	attachmentData map[string][]byte,
 	attachments map[string]*attachment,
@@ -1395,6 +1546,70 @@ func buildEvent(
 				},
 			})
 
+		case *contactCreated:
+			if contact, ok := contacts[update.contactID]; ok {
+				event.Contacts = append(event.Contacts, proton.ContactEvent{
+					EventItem: proton.EventItem{
+						ID:     update.contactID,
+						Action: proton.EventCreate,
+					},
+
+					Contact: *contact,
+				})
+			}
+
+		case *contactUpdated:
+			if contact, ok := contacts[update.contactID]; ok {
+				event.Contacts = append(event.Contacts, proton.ContactEvent{
+					EventItem: proton.EventItem{
+						ID:     update.contactID,
+						Action: proton.EventUpdate,
+					},
+
+					Contact: *contact,
+				})
+			}
+
+		case *contactDeleted:
+			event.Contacts = append(event.Contacts, proton.ContactEvent{
+				EventItem: proton.EventItem{
+					ID:     update.contactID,
+					Action: proton.EventDelete,
+				},
+			})
+
+		case *contactEmailCreated:
+			if email, ok := getContactEmail(contacts, update.contactEmailID); ok {
+				event.ContactEmails = append(event.ContactEmails, proton.ContactEmailEvent{
+					EventItem: proton.EventItem{
+						ID:     update.contactEmailID,
+						Action: proton.EventCreate,
+					},
+
+					ContactEmail: email,
+				})
+			}
+
+		case *contactEmailUpdated:
+			if email, ok := getContactEmail(contacts, update.contactEmailID); ok {
+				event.ContactEmails = append(event.ContactEmails, proton.ContactEmailEvent{
+					EventItem: proton.EventItem{
+						ID:     update.contactEmailID,
+						Action: proton.EventUpdate,
+					},
+
+					ContactEmail: email,
+				})
+			}
+
+		case *contactEmailDeleted:
+			event.ContactEmails = append(event.ContactEmails, proton.ContactEmailEvent{
+				EventItem: proton.EventItem{
+					ID:     update.contactEmailID,
+					Action: proton.EventDelete,
+				},
+			})
+
 		case *userSettingsUpdate:
 			event.UserSettings = &proton.UserSettings{
 				Telemetry:    update.settings.Telemetry,
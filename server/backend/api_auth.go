@@ -26,13 +26,13 @@ func (b *Backend) NewAuthInfo(username string) (proton.AuthInfo, error) {
 
 			session := uuid.NewString()
 
-			b.srp[session] = server
+			b.srp[session] = srpSession{server: server, username: acc.username}
 
 			return proton.AuthInfo{
 				Version:         4,
 				Modulus:         modulus,
 				ServerEphemeral: base64.StdEncoding.EncodeToString(challenge),
-				Salt:            base64.StdEncoding.EncodeToString(acc.salt),
+				Salt:            base64.StdEncoding.EncodeToString(acc.srpSalt),
 				SRPSession:      session,
 			}, nil
 		})
@@ -42,7 +42,7 @@ func (b *Backend) NewAuthInfo(username string) (proton.AuthInfo, error) {
 func (b *Backend) NewAuth(username string, ephemeral, proof []byte, session string) (proton.Auth, error) {
 	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.Auth, error) {
 		return withAccName(b, username, func(acc *account) (proton.Auth, error) {
-			server, ok := b.srp[session]
+			srpSession, ok := b.srp[session]
 			if !ok {
 				log.Errorf("Session '%v' not found for user='%v'", session, username)
 				return proton.Auth{}, fmt.Errorf("invalid session")
@@ -50,7 +50,11 @@ func (b *Backend) NewAuth(username string, ephemeral, proof []byte, session stri
 
 			delete(b.srp, session)
 
-			serverProof, err := server.VerifyProofs(ephemeral, proof)
+			if srpSession.shareURLToken != "" || srpSession.username != acc.username {
+				return proton.Auth{}, fmt.Errorf("invalid session")
+			}
+
+			serverProof, err := srpSession.server.VerifyProofs(ephemeral, proof)
 			if err != nil {
 				return proton.Auth{}, fmt.Errorf("invalid proof: %w", err)
 			}
@@ -59,7 +63,7 @@ func (b *Backend) NewAuth(username string, ephemeral, proof []byte, session stri
 
 			acc.auth[authUID] = auth
 
-			return auth.toAuth(acc.userID, authUID, serverProof), nil
+			return auth.toAuth(acc.userID, authUID, serverProof, acc.passwordMode), nil
 		})
 	})
 }
@@ -80,7 +84,7 @@ func (b *Backend) NewAuthRef(authUID, authRef string) (proton.Auth, error) {
 
 			acc.auth[authUID] = newAuth
 
-			return newAuth.toAuth(acc.userID, authUID, nil), nil
+			return newAuth.toAuth(acc.userID, authUID, nil, acc.passwordMode), nil
 		}
 
 		return proton.Auth{}, fmt.Errorf("invalid auth")
@@ -118,3 +122,10 @@ func (b *Backend) DeleteSession(userID, authUID string) error {
 		})
 	})
 }
+
+func (b *Backend) GetModulus() proton.AuthModulus {
+	return proton.AuthModulus{
+		Modulus:   modulus,
+		ModulusID: modulusID,
+	}
+}
@@ -0,0 +1,600 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/google/uuid"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// CreateCalendar creates a new calendar owned by the given address.
+// The calendar passphrase is encrypted and signed with the address key, which is unlocked with the user's password.
+func (b *Backend) CreateCalendar(userID, addrID string, password []byte, name string) (string, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (string, error) {
+		return withAcc(b, userID, func(acc *account) (string, error) {
+			addr, ok := acc.addresses[addrID]
+			if !ok {
+				return "", errors.New("no such address")
+			}
+
+			addrKR, err := acc.unlockAddrKey(addr, password)
+			if err != nil {
+				return "", err
+			}
+
+			passphrase, err := crypto.RandomToken(32)
+			if err != nil {
+				return "", err
+			}
+
+			armKey, err := GenerateKey(name, addr.email, passphrase, "x25519", 0)
+			if err != nil {
+				return "", err
+			}
+
+			encPassphrase, sigPassphrase, err := encryptWithSignature(addrKR, passphrase)
+			if err != nil {
+				return "", err
+			}
+
+			cal := newCalendar(name, "", "#8080FF")
+
+			cal.keys = []calendarKey{{
+				keyID: uuid.NewString(),
+				key:   armKey,
+				flags: proton.CalendarKeyFlagActive | proton.CalendarKeyFlagPrimary,
+			}}
+
+			cal.members = []*calendarMember{{
+				memberID:            uuid.NewString(),
+				addrID:              addrID,
+				email:               addr.email,
+				permissions:         proton.CalendarPermissionsOwner,
+				color:               cal.color,
+				display:             true,
+				passphrase:          encPassphrase,
+				passphraseSignature: sigPassphrase,
+			}}
+
+			b.calendars[cal.calendarID] = cal
+
+			return cal.calendarID, nil
+		})
+	})
+}
+
+func (b *Backend) GetCalendars(userID string) ([]proton.Calendar, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]proton.Calendar, error) {
+		return withAcc(b, userID, func(acc *account) ([]proton.Calendar, error) {
+			cals := xslices.Filter(maps.Values(b.calendars), func(cal *calendar) bool {
+				return acc.getCalendarMember(cal) != nil
+			})
+
+			slices.SortFunc(cals, func(a, b *calendar) bool {
+				if a.createTime != b.createTime {
+					return a.createTime < b.createTime
+				}
+
+				return a.calendarID < b.calendarID
+			})
+
+			return xslices.Map(cals, func(cal *calendar) proton.Calendar {
+				return cal.toCalendar()
+			}), nil
+		})
+	})
+}
+
+func (b *Backend) GetCalendar(userID, calendarID string) (proton.Calendar, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.Calendar, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (proton.Calendar, error) {
+			return cal.toCalendar(), nil
+		})
+	})
+}
+
+func (b *Backend) GetCalendarKeys(userID, calendarID string) (proton.CalendarKeys, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarKeys, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (proton.CalendarKeys, error) {
+			return cal.toCalendarKeys(), nil
+		})
+	})
+}
+
+func (b *Backend) GetCalendarMembers(userID, calendarID string) ([]proton.CalendarMember, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]proton.CalendarMember, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) ([]proton.CalendarMember, error) {
+			return xslices.Map(cal.members, func(member *calendarMember) proton.CalendarMember {
+				return member.toCalendarMember(cal.calendarID)
+			}), nil
+		})
+	})
+}
+
+func (b *Backend) GetCalendarPassphrase(userID, calendarID string) (proton.CalendarPassphrase, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarPassphrase, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (proton.CalendarPassphrase, error) {
+			return cal.toCalendarPassphrase(), nil
+		})
+	})
+}
+
+// CreateCalendarInvitation invites the given address to become a member of the calendar.
+// The invited address's passphrase is made of the given key packet and the data packet of the inviting member's passphrase.
+func (b *Backend) CreateCalendarInvitation(userID, calendarID string, req proton.CreateCalendarInvitationReq) (proton.CalendarInvitation, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarInvitation, error) {
+		return withAccCalendarMember(b, userID, calendarID, req.MemberID, func(cal *calendar, member *calendarMember) (proton.CalendarInvitation, error) {
+			if !member.canAdmin() {
+				return proton.CalendarInvitation{}, errors.New("calendar member cannot invite members")
+			}
+
+			if req.Permissions&calendarOwnerPermissions != 0 {
+				return proton.CalendarInvitation{}, errors.New("invited members cannot own the calendar")
+			}
+
+			addrID, err := b.getAddressID(req.Email)
+			if err != nil {
+				return proton.CalendarInvitation{}, err
+			}
+
+			if slices.ContainsFunc(cal.members, func(other *calendarMember) bool { return other.addrID == addrID }) {
+				return proton.CalendarInvitation{}, errors.New("address is already a member of the calendar")
+			}
+
+			if slices.ContainsFunc(cal.invitations, func(other *calendarInvitation) bool {
+				return other.addrID == addrID && other.status == proton.CalendarInvitationStatusPending
+			}) {
+				return proton.CalendarInvitation{}, errors.New("address is already invited to the calendar")
+			}
+
+			passphrase, err := getInvitationPassphrase(member.passphrase, req.PassphraseKeyPacket)
+			if err != nil {
+				return proton.CalendarInvitation{}, err
+			}
+
+			invitation := &calendarInvitation{
+				invitationID: uuid.NewString(),
+				addrID:       addrID,
+				email:        req.Email,
+				permissions:  req.Permissions,
+				status:       proton.CalendarInvitationStatusPending,
+				createTime:   time.Now().Unix(),
+				passphrase:   passphrase,
+			}
+
+			cal.invitations = append(cal.invitations, invitation)
+
+			return invitation.toCalendarInvitation(cal.calendarID), nil
+		})
+	})
+}
+
+func (b *Backend) GetCalendarInvitations(userID, calendarID string) ([]proton.CalendarInvitation, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]proton.CalendarInvitation, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) ([]proton.CalendarInvitation, error) {
+			return xslices.Map(cal.invitations, func(invitation *calendarInvitation) proton.CalendarInvitation {
+				return invitation.toCalendarInvitation(cal.calendarID)
+			}), nil
+		})
+	})
+}
+
+// GetPendingCalendarInvitations returns the pending invitations of the user's addresses, sorted by creation time.
+func (b *Backend) GetPendingCalendarInvitations(userID string) ([]proton.CalendarInvitation, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]proton.CalendarInvitation, error) {
+		return withAcc(b, userID, func(acc *account) ([]proton.CalendarInvitation, error) {
+			invitations := []proton.CalendarInvitation{}
+
+			for _, cal := range b.calendars {
+				for _, invitation := range cal.invitations {
+					if _, ok := acc.addresses[invitation.addrID]; ok && invitation.status == proton.CalendarInvitationStatusPending {
+						invitations = append(invitations, invitation.toCalendarInvitation(cal.calendarID))
+					}
+				}
+			}
+
+			slices.SortFunc(invitations, func(a, b proton.CalendarInvitation) bool {
+				if a.CreateTime != b.CreateTime {
+					return a.CreateTime < b.CreateTime
+				}
+
+				return a.ID < b.ID
+			})
+
+			return invitations, nil
+		})
+	})
+}
+
+// AcceptCalendarInvitation makes the invited address a member of the calendar, with the passphrase of the invitation.
+func (b *Backend) AcceptCalendarInvitation(userID, calendarID, invitationID, signature string) (proton.CalendarMember, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarMember, error) {
+		return withAccCalendarInvitation(b, userID, calendarID, invitationID, func(acc *account, cal *calendar, invitation *calendarInvitation) (proton.CalendarMember, error) {
+			member := &calendarMember{
+				memberID:            uuid.NewString(),
+				addrID:              invitation.addrID,
+				email:               invitation.email,
+				permissions:         invitation.permissions,
+				color:               cal.color,
+				display:             true,
+				passphrase:          invitation.passphrase,
+				passphraseSignature: signature,
+			}
+
+			cal.members = append(cal.members, member)
+
+			invitation.status = proton.CalendarInvitationStatusAccepted
+
+			return member.toCalendarMember(cal.calendarID), nil
+		})
+	})
+}
+
+func (b *Backend) DeclineCalendarInvitation(userID, calendarID, invitationID string) error {
+	_, err := writeBackendRetErr(b, func(b *unsafeBackend) (struct{}, error) {
+		return withAccCalendarInvitation(b, userID, calendarID, invitationID, func(acc *account, cal *calendar, invitation *calendarInvitation) (struct{}, error) {
+			invitation.status = proton.CalendarInvitationStatusDeclined
+
+			return struct{}{}, nil
+		})
+	})
+
+	return err
+}
+
+func (b *Backend) DeleteCalendarInvitation(userID, calendarID, invitationID string) error {
+	_, err := writeBackendRetErr(b, func(b *unsafeBackend) (struct{}, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (struct{}, error) {
+			if !acc.getCalendarMember(cal).canAdmin() {
+				return struct{}{}, errors.New("calendar member cannot manage invitations")
+			}
+
+			if _, err := cal.getInvitation(invitationID); err != nil {
+				return struct{}{}, err
+			}
+
+			cal.invitations = xslices.Filter(cal.invitations, func(invitation *calendarInvitation) bool {
+				return invitation.invitationID != invitationID
+			})
+
+			return struct{}{}, nil
+		})
+	})
+
+	return err
+}
+
+func (b *Backend) UpdateCalendarMember(userID, calendarID, memberID string, permissions proton.CalendarPermissions) (proton.CalendarMember, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarMember, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (proton.CalendarMember, error) {
+			if !acc.getCalendarMember(cal).canAdmin() {
+				return proton.CalendarMember{}, errors.New("calendar member cannot manage members")
+			}
+
+			member, err := cal.getMember(memberID)
+			if err != nil {
+				return proton.CalendarMember{}, err
+			}
+
+			if member.permissions&proton.CalendarPermissionSuperOwner != 0 {
+				return proton.CalendarMember{}, errors.New("the permissions of the calendar owner cannot be changed")
+			}
+
+			if permissions&calendarOwnerPermissions != 0 {
+				return proton.CalendarMember{}, errors.New("members cannot be made owner of the calendar")
+			}
+
+			member.permissions = permissions
+
+			return member.toCalendarMember(cal.calendarID), nil
+		})
+	})
+}
+
+// DeleteCalendarMember removes the member from the calendar; it is done by a member managing the calendar or by the member itself.
+func (b *Backend) DeleteCalendarMember(userID, calendarID, memberID string) error {
+	_, err := writeBackendRetErr(b, func(b *unsafeBackend) (struct{}, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (struct{}, error) {
+			member, err := cal.getMember(memberID)
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			if _, ok := acc.addresses[member.addrID]; !ok && !acc.getCalendarMember(cal).canAdmin() {
+				return struct{}{}, errors.New("calendar member cannot manage members")
+			}
+
+			if member.permissions&proton.CalendarPermissionSuperOwner != 0 {
+				return struct{}{}, errors.New("the calendar owner cannot be removed")
+			}
+
+			cal.members = xslices.Filter(cal.members, func(other *calendarMember) bool {
+				return other.memberID != memberID
+			})
+
+			return struct{}{}, nil
+		})
+	})
+
+	return err
+}
+
+// GetCalendarEvents returns a page of the events of the calendar, sorted by start time.
+// If end is not zero, only the events that may happen between start and end are returned.
+// If uid is not empty, only the events with this UID are returned.
+func (b *Backend) GetCalendarEvents(userID, calendarID string, page, pageSize int, start, end int64, uid string) (int, []proton.CalendarEvent, error) {
+	if page < 0 {
+		return 0, nil, fmt.Errorf("invalid page %d", page)
+	}
+
+	var total int
+
+	events, err := readBackendRetErr(b, func(b *unsafeBackend) ([]proton.CalendarEvent, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) ([]proton.CalendarEvent, error) {
+			events := xslices.Filter(maps.Values(cal.events), func(event *calendarEvent) bool {
+				if end != 0 && !event.overlaps(start, end) {
+					return false
+				}
+
+				return uid == "" || event.uid == uid
+			})
+
+			slices.SortFunc(events, func(a, b *calendarEvent) bool {
+				if a.startTime != b.startTime {
+					return a.startTime < b.startTime
+				}
+
+				return a.eventID < b.eventID
+			})
+
+			total = len(events)
+
+			if pageSize <= 0 || page*pageSize >= len(events) {
+				return []proton.CalendarEvent{}, nil
+			}
+
+			return xslices.Map(xslices.Chunk(events, pageSize)[page], func(event *calendarEvent) proton.CalendarEvent {
+				return event.toCalendarEvent(cal.calendarID)
+			}), nil
+		})
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, events, nil
+}
+
+func (b *Backend) GetCalendarEvent(userID, calendarID, eventID string) (proton.CalendarEvent, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarEvent, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (proton.CalendarEvent, error) {
+			event, ok := cal.events[eventID]
+			if !ok {
+				return proton.CalendarEvent{}, errors.New("no such calendar event")
+			}
+
+			return event.toCalendarEvent(cal.calendarID), nil
+		})
+	})
+}
+
+func (b *Backend) CreateCalendarEvent(userID, calendarID, memberID string, data proton.CalendarEventData) (proton.CalendarEvent, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarEvent, error) {
+		return withAccCalendarMember(b, userID, calendarID, memberID, func(cal *calendar, member *calendarMember) (proton.CalendarEvent, error) {
+			if !member.canWrite() {
+				return proton.CalendarEvent{}, errors.New("calendar member cannot write events")
+			}
+
+			event := &calendarEvent{
+				eventID:       uuid.NewString(),
+				sharedEventID: uuid.NewString(),
+				createTime:    time.Now().Unix(),
+			}
+
+			if err := event.setData(member, data); err != nil {
+				return proton.CalendarEvent{}, err
+			}
+
+			for _, other := range cal.events {
+				if other.uid == event.uid && other.recurrenceID == event.recurrenceID {
+					return proton.CalendarEvent{}, fmt.Errorf("an event with UID %v already exists", event.uid)
+				}
+			}
+
+			cal.events[event.eventID] = event
+
+			cal.addModelEvent(proton.EventCreate, event)
+
+			return event.toCalendarEvent(cal.calendarID), nil
+		})
+	})
+}
+
+func (b *Backend) UpdateCalendarEvent(userID, calendarID, memberID, eventID string, data proton.CalendarEventData) (proton.CalendarEvent, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarEvent, error) {
+		return withAccCalendarMember(b, userID, calendarID, memberID, func(cal *calendar, member *calendarMember) (proton.CalendarEvent, error) {
+			if !member.canWrite() {
+				return proton.CalendarEvent{}, errors.New("calendar member cannot write events")
+			}
+
+			event, ok := cal.events[eventID]
+			if !ok {
+				return proton.CalendarEvent{}, errors.New("no such calendar event")
+			}
+
+			// The event is updated on a copy, so that it is left untouched if the new data is invalid.
+			updated := *event
+
+			if err := updated.setData(member, data); err != nil {
+				return proton.CalendarEvent{}, err
+			}
+
+			if updated.uid != event.uid {
+				return proton.CalendarEvent{}, errors.New("the UID of an event cannot be changed")
+			}
+
+			*event = updated
+
+			cal.addModelEvent(proton.EventUpdate, event)
+
+			return event.toCalendarEvent(cal.calendarID), nil
+		})
+	})
+}
+
+func (b *Backend) DeleteCalendarEvent(userID, calendarID, memberID, eventID string) error {
+	_, err := writeBackendRetErr(b, func(b *unsafeBackend) (struct{}, error) {
+		return withAccCalendarMember(b, userID, calendarID, memberID, func(cal *calendar, member *calendarMember) (struct{}, error) {
+			if !member.canWrite() {
+				return struct{}{}, errors.New("calendar member cannot write events")
+			}
+
+			event, ok := cal.events[eventID]
+			if !ok {
+				return struct{}{}, errors.New("no such calendar event")
+			}
+
+			delete(cal.events, eventID)
+
+			cal.addModelEvent(proton.EventDelete, event)
+
+			return struct{}{}, nil
+		})
+	})
+
+	return err
+}
+
+func (b *Backend) UpdateCalendarAttendee(userID, calendarID, eventID, attendeeID string, status proton.CalendarAttendeeStatus) (proton.CalendarAttendee, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarAttendee, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (proton.CalendarAttendee, error) {
+			if !acc.getCalendarMember(cal).canWrite() {
+				return proton.CalendarAttendee{}, errors.New("calendar member cannot write events")
+			}
+
+			event, ok := cal.events[eventID]
+			if !ok {
+				return proton.CalendarAttendee{}, errors.New("no such calendar event")
+			}
+
+			idx := xslices.IndexFunc(event.attendees, func(attendee proton.CalendarAttendee) bool {
+				return attendee.ID == attendeeID
+			})
+			if idx < 0 {
+				return proton.CalendarAttendee{}, errors.New("no such calendar attendee")
+			}
+
+			event.attendees[idx].Status = status
+
+			cal.addModelEvent(proton.EventUpdate, event)
+
+			return event.attendees[idx], nil
+		})
+	})
+}
+
+func (b *Backend) GetLatestCalendarModelEventID(userID, calendarID string) (string, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (string, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (string, error) {
+			return cal.modelEvents[len(cal.modelEvents)-1].eventID, nil
+		})
+	})
+}
+
+func (b *Backend) GetCalendarModelEvent(userID, calendarID, eventID string) (event proton.CalendarModelEvent, more bool, err error) {
+	event, err = readBackendRetErr(b, func(b *unsafeBackend) (proton.CalendarModelEvent, error) {
+		return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (proton.CalendarModelEvent, error) {
+			index := xslices.IndexFunc(cal.modelEvents, func(event *calendarModelEvent) bool {
+				return event.eventID == eventID
+			})
+			if index < 0 {
+				return proton.CalendarModelEvent{}, fmt.Errorf("invalid event ID: %s", eventID)
+			}
+
+			firstEvent := index + 1
+			lastEvent := getLastUpdateIndex(len(cal.modelEvents), firstEvent, b.maxUpdatesPerEvent)
+
+			res := proton.CalendarModelEvent{
+				CalendarModelEventID: eventID,
+				Events:               []proton.CalendarEventItem{},
+			}
+
+			for _, event := range cal.modelEvents[firstEvent:lastEvent] {
+				res.CalendarModelEventID = event.eventID
+				res.Events = append(res.Events, event.items...)
+			}
+
+			more = lastEvent != len(cal.modelEvents)
+
+			return res, nil
+		})
+	})
+	if err != nil {
+		return proton.CalendarModelEvent{}, false, err
+	}
+
+	return event, more, nil
+}
+
+// withAccCalendar calls fn with the given calendar, if one of the account's addresses is a member of it.
+func withAccCalendar[T any](b *unsafeBackend, userID, calendarID string, fn func(acc *account, cal *calendar) (T, error)) (T, error) {
+	return withAcc(b, userID, func(acc *account) (T, error) {
+		cal, ok := b.calendars[calendarID]
+		if !ok || acc.getCalendarMember(cal) == nil {
+			return *new(T), errors.New("no such calendar")
+		}
+
+		return fn(acc, cal)
+	})
+}
+
+// withAccCalendarInvitation calls fn with the given pending invitation of the calendar, if it is sent to one of the account's addresses.
+func withAccCalendarInvitation[T any](
+	b *unsafeBackend,
+	userID, calendarID, invitationID string,
+	fn func(acc *account, cal *calendar, invitation *calendarInvitation) (T, error),
+) (T, error) {
+	return withAcc(b, userID, func(acc *account) (T, error) {
+		cal, ok := b.calendars[calendarID]
+		if !ok {
+			return *new(T), errors.New("no such calendar")
+		}
+
+		invitation, err := cal.getInvitation(invitationID)
+		if err != nil {
+			return *new(T), err
+		}
+
+		if _, ok := acc.addresses[invitation.addrID]; !ok {
+			return *new(T), errors.New("no such calendar invitation")
+		}
+
+		if invitation.status != proton.CalendarInvitationStatusPending {
+			return *new(T), errors.New("calendar invitation was already answered")
+		}
+
+		return fn(acc, cal, invitation)
+	})
+}
+
+// withAccCalendarMember calls fn with the given calendar and member, if the member is one of the account's addresses.
+func withAccCalendarMember[T any](b *unsafeBackend, userID, calendarID, memberID string, fn func(cal *calendar, member *calendarMember) (T, error)) (T, error) {
+	return withAccCalendar(b, userID, calendarID, func(acc *account, cal *calendar) (T, error) {
+		member, err := cal.getMember(memberID)
+		if err != nil {
+			return *new(T), err
+		}
+
+		if _, ok := acc.addresses[member.addrID]; !ok {
+			return *new(T), errors.New("calendar member is not an address of the user")
+		}
+
+		return fn(cal, member)
+	})
+}
@@ -0,0 +1,1149 @@
+package backend
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/go-srp"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+func (b *Backend) ListVolumes(userID string) ([]proton.Volume, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]proton.Volume, error) {
+		return withAcc(b, userID, func(acc *account) ([]proton.Volume, error) {
+			return xslices.Map(acc.volumeIDs, func(volumeID string) proton.Volume {
+				return b.volumes[volumeID].toVolume()
+			}), nil
+		})
+	})
+}
+
+func (b *Backend) GetVolume(userID, volumeID string) (proton.Volume, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.Volume, error) {
+		return withAcc(b, userID, func(acc *account) (proton.Volume, error) {
+			if !slices.Contains(acc.volumeIDs, volumeID) {
+				return proton.Volume{}, errors.New("no such volume")
+			}
+
+			return b.volumes[volumeID].toVolume(), nil
+		})
+	})
+}
+
+func (b *Backend) CreateVolume(userID string, req proton.CreateVolumeReq) (proton.Volume, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.Volume, error) {
+		return withAcc(b, userID, func(acc *account) (proton.Volume, error) {
+			if len(acc.volumeIDs) > 0 {
+				return proton.Volume{}, errors.New("volume already exists")
+			}
+
+			addr, ok := acc.addresses[req.AddressID]
+			if !ok {
+				return proton.Volume{}, errors.New("no such address")
+			}
+
+			root := newFolderLink("", proton.CreateFolderReq{
+				Name:                    req.FolderName,
+				NodeKey:                 req.FolderKey,
+				NodeHashKey:             req.FolderHashKey,
+				NodePassphrase:          req.FolderPassphrase,
+				NodePassphraseSignature: req.FolderPassphraseSignature,
+				SignatureAddress:        addr.email,
+			})
+
+			share := &share{
+				shareID:   uuid.NewString(),
+				linkID:    root.linkID,
+				shareType: proton.ShareTypeMain,
+				flags:     proton.PrimaryShare,
+
+				addrID:    addr.addrID,
+				addrKeyID: addr.keys[0].keyID,
+				creator:   addr.email,
+
+				key:                 req.ShareKey,
+				passphrase:          req.SharePassphrase,
+				passphraseSignature: req.SharePassphraseSignature,
+
+				createTime: time.Now().Unix(),
+			}
+
+			vol := newVolume(share.shareID, root.linkID)
+
+			root.volumeID = vol.volumeID
+			share.volumeID = vol.volumeID
+
+			b.volumes[vol.volumeID] = vol
+			b.shares[share.shareID] = share
+			b.links[root.linkID] = root
+
+			acc.volumeIDs = append(acc.volumeIDs, vol.volumeID)
+			acc.shareIDs = append(acc.shareIDs, share.shareID)
+
+			return vol.toVolume(), nil
+		})
+	})
+}
+
+func (b *Backend) ListShares(userID string) ([]proton.ShareMetadata, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]proton.ShareMetadata, error) {
+		return withAcc(b, userID, func(acc *account) ([]proton.ShareMetadata, error) {
+			return xslices.Map(acc.shareIDs, func(shareID string) proton.ShareMetadata {
+				return b.shares[shareID].toShareMetadata()
+			}), nil
+		})
+	})
+}
+
+func (b *Backend) GetShare(userID, shareID string) (proton.Share, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.Share, error) {
+		return withAccShare(b, userID, shareID, func(acc *account, share *share) (proton.Share, error) {
+			return share.toShare(), nil
+		})
+	})
+}
+
+func (b *Backend) CreateShare(userID, volumeID string, req proton.CreateShareReq) (string, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (string, error) {
+		return withAcc(b, userID, func(acc *account) (string, error) {
+			if !slices.Contains(acc.volumeIDs, volumeID) {
+				return "", errors.New("no such volume")
+			}
+
+			addr, ok := acc.addresses[req.AddressID]
+			if !ok {
+				return "", errors.New("no such address")
+			}
+
+			link, ok := b.links[req.RootLinkID]
+			if !ok || link.volumeID != volumeID {
+				return "", ErrNoSuchLink
+			}
+
+			for _, shareID := range acc.shareIDs {
+				if b.shares[shareID].linkID == link.linkID {
+					return "", errors.New("link is already shared")
+				}
+			}
+
+			share := &share{
+				shareID:   uuid.NewString(),
+				volumeID:  volumeID,
+				linkID:    link.linkID,
+				shareType: proton.ShareTypeStandard,
+
+				addrID:    addr.addrID,
+				addrKeyID: addr.keys[0].keyID,
+				creator:   addr.email,
+
+				key:                 req.ShareKey,
+				passphrase:          req.SharePassphrase,
+				passphraseSignature: req.SharePassphraseSignature,
+
+				passphraseKeyPacket: req.PassphraseKeyPacket,
+				nameKeyPacket:       req.NameKeyPacket,
+
+				createTime: time.Now().Unix(),
+			}
+
+			b.shares[share.shareID] = share
+
+			acc.shareIDs = append(acc.shareIDs, share.shareID)
+
+			return share.shareID, nil
+		})
+	})
+}
+
+func (b *Backend) DeleteShare(userID, shareID string) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		_, err := withAccShare(b, userID, shareID, func(acc *account, share *share) (struct{}, error) {
+			if share.shareType == proton.ShareTypeMain {
+				return struct{}{}, errors.New("cannot delete main share")
+			}
+
+			for _, urlID := range share.urlIDs {
+				b.deleteShareURL(urlID)
+			}
+
+			delete(b.shares, shareID)
+
+			acc.shareIDs = xslices.Filter(acc.shareIDs, func(otherID string) bool {
+				return otherID != shareID
+			})
+
+			return struct{}{}, nil
+		})
+
+		return err
+	})
+}
+
+func (b *Backend) GetLink(userID, shareID, linkID string) (proton.Link, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.Link, error) {
+		return withAccShare(b, userID, shareID, func(acc *account, share *share) (proton.Link, error) {
+			link, err := b.getShareLink(share, linkID)
+			if err != nil {
+				return proton.Link{}, err
+			}
+
+			return link.toLink(b.revisions), nil
+		})
+	})
+}
+
+func (b *Backend) CreateFolder(userID, shareID string, req proton.CreateFolderReq) (string, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (string, error) {
+		return withAccShare(b, userID, shareID, func(acc *account, share *share) (string, error) {
+			parent, err := b.getShareLink(share, req.ParentLinkID)
+			if err != nil {
+				return "", err
+			}
+
+			if err := b.checkChildName(parent, req.Hash); err != nil {
+				return "", err
+			}
+
+			link := newFolderLink(share.volumeID, req)
+
+			b.links[link.linkID] = link
+
+			b.addDriveEvent(proton.LinkEventCreate, link)
+
+			return link.linkID, nil
+		})
+	})
+}
+
+func (b *Backend) ListChildren(userID, shareID, linkID string, page, pageSize int, showAll bool) ([]proton.Link, error) {
+	if page < 0 {
+		return nil, fmt.Errorf("invalid page %d", page)
+	}
+
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]proton.Link, error) {
+		return withAccShare(b, userID, shareID, func(acc *account, share *share) ([]proton.Link, error) {
+			parent, err := b.getShareLink(share, linkID)
+			if err != nil {
+				return nil, err
+			}
+
+			if parent.linkType != proton.LinkTypeFolder {
+				return nil, errors.New("link is not a folder")
+			}
+
+			children := b.getChildren(parent.linkID, showAll)
+
+			if pageSize <= 0 || page*pageSize >= len(children) {
+				return []proton.Link{}, nil
+			}
+
+			return xslices.Map(xslices.Chunk(children, pageSize)[page], func(link *link) proton.Link {
+				return link.toLink(b.revisions)
+			}), nil
+		})
+	})
+}
+
+func (b *Backend) CreateFile(userID, shareID string, req proton.CreateFileReq) (proton.CreateFileRes, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.CreateFileRes, error) {
+		return withAccShare(b, userID, shareID, func(acc *account, share *share) (proton.CreateFileRes, error) {
+			parent, err := b.getShareLink(share, req.ParentLinkID)
+			if err != nil {
+				return proton.CreateFileRes{}, err
+			}
+
+			if err := b.checkChildName(parent, req.Hash); err != nil {
+				return proton.CreateFileRes{}, err
+			}
+
+			link := newFileLink(share.volumeID, req)
+
+			rev := newRevision(link.linkID)
+
+			link.revisionIDs = append(link.revisionIDs, rev.revisionID)
+
+			b.links[link.linkID] = link
+			b.revisions[rev.revisionID] = rev
+
+			b.addDriveEvent(proton.LinkEventCreate, link)
+
+			return proton.CreateFileRes{
+				ID:         link.linkID,
+				RevisionID: rev.revisionID,
+			}, nil
+		})
+	})
+}
+
+func (b *Backend) CreateRevision(userID, shareID, linkID string) (string, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (string, error) {
+		return withAccShareFile(b, userID, shareID, linkID, func(link *link) (string, error) {
+			for _, revisionID := range link.revisionIDs {
+				if b.revisions[revisionID].state == proton.RevisionStateDraft {
+					return "", errors.New("file already has a draft revision")
+				}
+			}
+
+			rev := newRevision(link.linkID)
+
+			link.revisionIDs = append(link.revisionIDs, rev.revisionID)
+
+			b.revisions[rev.revisionID] = rev
+
+			return rev.revisionID, nil
+		})
+	})
+}
+
+func (b *Backend) ListRevisions(userID, shareID, linkID string) ([]proton.RevisionMetadata, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]proton.RevisionMetadata, error) {
+		return withAccShareFile(b, userID, shareID, linkID, func(link *link) ([]proton.RevisionMetadata, error) {
+			return xslices.Map(link.revisionIDs, func(revisionID string) proton.RevisionMetadata {
+				return b.revisions[revisionID].toRevisionMetadata()
+			}), nil
+		})
+	})
+}
+
+func (b *Backend) GetRevision(userID, shareID, linkID, revisionID string, fromBlock, pageSize int) (proton.Revision, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.Revision, error) {
+		return withAccShareFile(b, userID, shareID, linkID, func(link *link) (proton.Revision, error) {
+			if !slices.Contains(link.revisionIDs, revisionID) {
+				return proton.Revision{}, errors.New("no such revision")
+			}
+
+			return b.revisions[revisionID].toRevision(fromBlock, pageSize), nil
+		})
+	})
+}
+
+func (b *Backend) UpdateRevision(userID, shareID, linkID, revisionID string, req proton.UpdateRevisionReq) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		_, err := withAccShareFile(b, userID, shareID, linkID, func(link *link) (struct{}, error) {
+			if !slices.Contains(link.revisionIDs, revisionID) {
+				return struct{}{}, errors.New("no such revision")
+			}
+
+			rev := b.revisions[revisionID]
+
+			if rev.state != proton.RevisionStateDraft {
+				return struct{}{}, errors.New("revision is not a draft")
+			}
+
+			blocks := make([]*block, 0, len(req.BlockList))
+
+			for _, token := range req.BlockList {
+				block, ok := b.blocks[token.Token]
+				if !ok || block == rev.thumbnail || block.revisionID != revisionID || block.index != token.Index {
+					return struct{}{}, fmt.Errorf("invalid block token for block %v", token.Index)
+				}
+
+				if block.data == nil {
+					return struct{}{}, fmt.Errorf("block %v was not uploaded", token.Index)
+				}
+
+				blocks = append(blocks, block)
+			}
+
+			slices.SortFunc(blocks, func(a, b *block) bool {
+				return a.index < b.index
+			})
+
+			rev.blocks = blocks
+			rev.size = 0
+
+			for _, block := range blocks {
+				rev.size += int64(len(block.data))
+			}
+
+			rev.manifestSignature = req.ManifestSignature
+			rev.signatureEmail = req.SignatureAddress
+			rev.xAttr = req.XAttr
+
+			if req.State == proton.RevisionStateActive {
+				b.setActiveRevision(link, rev)
+				b.addDriveEvent(proton.LinkEventUpdate, link)
+			}
+
+			return struct{}{}, nil
+		})
+
+		return err
+	})
+}
+
+func (b *Backend) RestoreRevision(userID, shareID, linkID, revisionID string) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		_, err := withAccShareFile(b, userID, shareID, linkID, func(link *link) (struct{}, error) {
+			if !slices.Contains(link.revisionIDs, revisionID) {
+				return struct{}{}, errors.New("no such revision")
+			}
+
+			rev := b.revisions[revisionID]
+
+			if rev.state != proton.RevisionStateObsolete {
+				return struct{}{}, errors.New("only obsolete revisions can be restored")
+			}
+
+			b.setActiveRevision(link, rev)
+			b.addDriveEvent(proton.LinkEventUpdate, link)
+
+			return struct{}{}, nil
+		})
+
+		return err
+	})
+}
+
+func (b *Backend) DeleteRevision(userID, shareID, linkID, revisionID string) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		_, err := withAccShareFile(b, userID, shareID, linkID, func(link *link) (struct{}, error) {
+			if !slices.Contains(link.revisionIDs, revisionID) {
+				return struct{}{}, errors.New("no such revision")
+			}
+
+			if b.revisions[revisionID].state == proton.RevisionStateActive {
+				return struct{}{}, errors.New("the active revision cannot be deleted")
+			}
+
+			b.deleteRevision(revisionID)
+
+			link.revisionIDs = xslices.Filter(link.revisionIDs, func(otherID string) bool {
+				return otherID != revisionID
+			})
+
+			return struct{}{}, nil
+		})
+
+		return err
+	})
+}
+
+func (b *Backend) RequestBlockUpload(userID string, req proton.BlockUploadReq) ([]string, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) ([]string, error) {
+		return withAccShareFile(b, userID, req.ShareID, req.LinkID, func(link *link) ([]string, error) {
+			if !slices.Contains(link.revisionIDs, req.RevisionID) {
+				return nil, errors.New("no such revision")
+			}
+
+			if b.revisions[req.RevisionID].state != proton.RevisionStateDraft {
+				return nil, errors.New("revision is not a draft")
+			}
+
+			addr, ok := b.accounts[userID].addresses[req.AddressID]
+			if !ok {
+				return nil, errors.New("no such address")
+			}
+
+			return xslices.Map(req.BlockList, func(info proton.BlockUploadInfo) string {
+				block := &block{
+					token:          uuid.NewString(),
+					revisionID:     req.RevisionID,
+					index:          info.Index,
+					hash:           info.Hash,
+					encSignature:   info.EncSignature,
+					signatureEmail: addr.email,
+				}
+
+				b.blocks[block.token] = block
+
+				return block.token
+			}), nil
+		})
+	})
+}
+
+func (b *Backend) RequestThumbnailUpload(userID string, req proton.BlockUploadReq) ([]string, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) ([]string, error) {
+		return withAccShareFile(b, userID, req.ShareID, req.LinkID, func(link *link) ([]string, error) {
+			if !slices.Contains(link.revisionIDs, req.RevisionID) {
+				return nil, errors.New("no such revision")
+			}
+
+			rev := b.revisions[req.RevisionID]
+
+			if rev.state != proton.RevisionStateDraft {
+				return nil, errors.New("revision is not a draft")
+			}
+
+			if len(req.ThumbnailList) > 1 {
+				return nil, errors.New("a revision can have at most one thumbnail")
+			}
+
+			addr, ok := b.accounts[userID].addresses[req.AddressID]
+			if !ok {
+				return nil, errors.New("no such address")
+			}
+
+			return xslices.Map(req.ThumbnailList, func(info proton.ThumbnailUploadInfo) string {
+				if rev.thumbnail != nil {
+					delete(b.blocks, rev.thumbnail.token)
+				}
+
+				rev.thumbnail = &block{
+					token:          uuid.NewString(),
+					revisionID:     req.RevisionID,
+					hash:           info.Hash,
+					encSignature:   info.EncSignature,
+					signatureEmail: addr.email,
+				}
+
+				b.blocks[rev.thumbnail.token] = rev.thumbnail
+
+				return rev.thumbnail.token
+			}), nil
+		})
+	})
+}
+
+func (b *Backend) GetThumbnail(userID, shareID, linkID, revisionID string) (proton.Block, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.Block, error) {
+		return withAccShareFile(b, userID, shareID, linkID, func(link *link) (proton.Block, error) {
+			if !slices.Contains(link.revisionIDs, revisionID) {
+				return proton.Block{}, errors.New("no such revision")
+			}
+
+			rev := b.revisions[revisionID]
+
+			if !rev.hasThumbnail() {
+				return proton.Block{}, errors.New("revision has no thumbnail")
+			}
+
+			return rev.thumbnail.toBlock(), nil
+		})
+	})
+}
+
+func (b *Backend) UploadBlock(token string, data []byte) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		block, ok := b.blocks[token]
+		if !ok {
+			return errors.New("no such block")
+		}
+
+		if block.data != nil {
+			return errors.New("block already uploaded")
+		}
+
+		block.data = data
+
+		return nil
+	})
+}
+
+func (b *Backend) GetBlock(token string) ([]byte, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]byte, error) {
+		block, ok := b.blocks[token]
+		if !ok || block.data == nil {
+			return nil, errors.New("no such block")
+		}
+
+		return block.data, nil
+	})
+}
+
+func (b *Backend) MoveLink(userID, shareID, linkID string, req proton.MoveLinkReq) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		_, err := withAccShare(b, userID, shareID, func(acc *account, share *share) (struct{}, error) {
+			link, err := b.getShareLink(share, linkID)
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			if link.linkID == share.linkID {
+				return struct{}{}, errors.New("cannot move the share's root link")
+			}
+
+			if link.hash != req.OriginalHash {
+				return struct{}{}, errors.New("link was renamed")
+			}
+
+			parent, err := b.getShareLink(share, req.ParentLinkID)
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			for parentID := parent.linkID; parentID != ""; parentID = b.links[parentID].parentLinkID {
+				if parentID == link.linkID {
+					return struct{}{}, errors.New("cannot move a link into itself")
+				}
+			}
+
+			if err := b.checkChildName(parent, req.Hash); err != nil {
+				return struct{}{}, err
+			}
+
+			link.parentLinkID = parent.linkID
+			link.name = req.Name
+			link.hash = req.Hash
+			link.nodePassphrase = req.NodePassphrase
+			link.modifyTime = time.Now().Unix()
+
+			b.addDriveEvent(proton.LinkEventUpdateMetadata, link)
+
+			return struct{}{}, nil
+		})
+
+		return err
+	})
+}
+
+func (b *Backend) TrashChildren(userID, shareID, linkID string, childIDs ...string) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		_, err := withAccShare(b, userID, shareID, func(acc *account, share *share) (struct{}, error) {
+			children, err := b.getShareChildren(share, linkID, childIDs)
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			for _, child := range children {
+				child.state = proton.LinkStateTrashed
+				child.modifyTime = time.Now().Unix()
+			}
+
+			b.addDriveEvent(proton.LinkEventUpdate, children...)
+
+			return struct{}{}, nil
+		})
+
+		return err
+	})
+}
+
+func (b *Backend) DeleteChildren(userID, shareID, linkID string, childIDs ...string) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		_, err := withAccShare(b, userID, shareID, func(acc *account, share *share) (struct{}, error) {
+			children, err := b.getShareChildren(share, linkID, childIDs)
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			for _, child := range children {
+				if child.linkID == share.linkID {
+					return struct{}{}, errors.New("cannot delete the share's root link")
+				}
+			}
+
+			b.addDriveEvent(proton.LinkEventDelete, children...)
+
+			for _, child := range children {
+				b.deleteLink(child)
+			}
+
+			return struct{}{}, nil
+		})
+
+		return err
+	})
+}
+
+func (b *Backend) GetLatestVolumeEventID(userID, volumeID string) (string, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (string, error) {
+		return withAcc(b, userID, func(acc *account) (string, error) {
+			if !slices.Contains(acc.volumeIDs, volumeID) {
+				return "", errors.New("no such volume")
+			}
+
+			events := b.volumes[volumeID].events
+
+			return events[len(events)-1].eventID, nil
+		})
+	})
+}
+
+func (b *Backend) GetVolumeEvent(userID, volumeID, eventID string) (event proton.DriveEvent, more bool, err error) {
+	event, err = readBackendRetErr(b, func(b *unsafeBackend) (proton.DriveEvent, error) {
+		return withAcc(b, userID, func(acc *account) (proton.DriveEvent, error) {
+			if !slices.Contains(acc.volumeIDs, volumeID) {
+				return proton.DriveEvent{}, errors.New("no such volume")
+			}
+
+			events := b.volumes[volumeID].events
+
+			index := xslices.IndexFunc(events, func(event *driveEvent) bool {
+				return event.eventID == eventID
+			})
+			if index < 0 {
+				return proton.DriveEvent{}, fmt.Errorf("invalid event ID: %s", eventID)
+			}
+
+			firstEvent := index + 1
+			lastEvent := getLastUpdateIndex(len(events), firstEvent, b.maxUpdatesPerEvent)
+
+			res := proton.DriveEvent{
+				EventID: eventID,
+				Events:  []proton.LinkEvent{},
+			}
+
+			for _, event := range events[firstEvent:lastEvent] {
+				res.EventID = event.eventID
+				res.Events = append(res.Events, event.events...)
+			}
+
+			more = lastEvent != len(events)
+
+			return res, nil
+		})
+	})
+	if err != nil {
+		return proton.DriveEvent{}, false, err
+	}
+
+	return event, more, nil
+}
+
+func (b *Backend) ListShareURLs(userID, shareID string) ([]proton.ShareURL, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) ([]proton.ShareURL, error) {
+		return withAccShare(b, userID, shareID, func(acc *account, share *share) ([]proton.ShareURL, error) {
+			return xslices.Map(share.urlIDs, func(urlID string) proton.ShareURL {
+				return b.shareURLs[urlID].toShareURL()
+			}), nil
+		})
+	})
+}
+
+func (b *Backend) CreateShareURL(userID, shareID string, req proton.CreateShareURLReq) (proton.ShareURL, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.ShareURL, error) {
+		return withAccShare(b, userID, shareID, func(acc *account, share *share) (proton.ShareURL, error) {
+			if share.shareType == proton.ShareTypeMain {
+				return proton.ShareURL{}, errors.New("cannot create share URL for main share")
+			}
+
+			if err := checkShareURLPassword(req.ShareURLPassword); err != nil {
+				return proton.ShareURL{}, err
+			}
+
+			url := newShareURL(shareID, req)
+
+			b.shareURLs[url.shareURLID] = url
+
+			share.urlIDs = append(share.urlIDs, url.shareURLID)
+
+			return url.toShareURL(), nil
+		})
+	})
+}
+
+func (b *Backend) UpdateShareURL(userID, shareID, shareURLID string, req proton.UpdateShareURLReq) (proton.ShareURL, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.ShareURL, error) {
+		return withAccShare(b, userID, shareID, func(acc *account, share *share) (proton.ShareURL, error) {
+			if !slices.Contains(share.urlIDs, shareURLID) {
+				return proton.ShareURL{}, errors.New("no such share URL")
+			}
+
+			url := b.shareURLs[shareURLID]
+
+			if req.ShareURLPassword != nil {
+				if err := checkShareURLPassword(*req.ShareURLPassword); err != nil {
+					return proton.ShareURL{}, err
+				}
+
+				url.password = *req.ShareURLPassword
+
+				// Changing the password invalidates the anonymous sessions opened with the old one.
+				b.deleteShareURLAuth(url.token)
+			}
+
+			if req.Permissions != nil {
+				url.permissions = *req.Permissions
+			}
+
+			if req.ExpirationTime != nil {
+				url.expirationTime = *req.ExpirationTime
+			}
+
+			if req.MaxAccesses != nil {
+				url.maxAccesses = *req.MaxAccesses
+			}
+
+			return url.toShareURL(), nil
+		})
+	})
+}
+
+func (b *Backend) DeleteShareURL(userID, shareID, shareURLID string) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		_, err := withAccShare(b, userID, shareID, func(acc *account, share *share) (struct{}, error) {
+			if !slices.Contains(share.urlIDs, shareURLID) {
+				return struct{}{}, errors.New("no such share URL")
+			}
+
+			b.deleteShareURL(shareURLID)
+
+			share.urlIDs = xslices.Filter(share.urlIDs, func(otherID string) bool {
+				return otherID != shareURLID
+			})
+
+			return struct{}{}, nil
+		})
+
+		return err
+	})
+}
+
+func (b *Backend) NewShareURLAuthInfo(token string) (proton.ShareURLInfo, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.ShareURLInfo, error) {
+		url, err := b.getShareURL(token)
+		if err != nil {
+			return proton.ShareURLInfo{}, err
+		}
+
+		verifier, err := base64.StdEncoding.DecodeString(url.password.SRPVerifier)
+		if err != nil {
+			return proton.ShareURLInfo{}, err
+		}
+
+		server, err := srp.NewServerFromSigned(modulus, verifier, 2048)
+		if err != nil {
+			return proton.ShareURLInfo{}, fmt.Errorf("failed to create new srp server %w", err)
+		}
+
+		challenge, err := server.GenerateChallenge()
+		if err != nil {
+			return proton.ShareURLInfo{}, fmt.Errorf("failed to generate srp challenge %w", err)
+		}
+
+		session := uuid.NewString()
+
+		b.srp[session] = srpSession{server: server, shareURLToken: token}
+
+		return proton.ShareURLInfo{
+			Version:         4,
+			Modulus:         modulus,
+			ServerEphemeral: base64.StdEncoding.EncodeToString(challenge),
+			URLPasswordSalt: url.password.URLPasswordSalt,
+			SRPSession:      session,
+			Flags:           url.password.Flags,
+		}, nil
+	})
+}
+
+func (b *Backend) NewShareURLAuth(token string, ephemeral, proof []byte, session string) (proton.Auth, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.Auth, error) {
+		url, err := b.getShareURL(token)
+		if err != nil {
+			return proton.Auth{}, err
+		}
+
+		srpSession, ok := b.srp[session]
+		if !ok {
+			return proton.Auth{}, fmt.Errorf("invalid session")
+		}
+
+		delete(b.srp, session)
+
+		if srpSession.shareURLToken != token {
+			return proton.Auth{}, fmt.Errorf("invalid session")
+		}
+
+		serverProof, err := srpSession.server.VerifyProofs(ephemeral, proof)
+		if err != nil {
+			return proton.Auth{}, fmt.Errorf("invalid proof: %w", err)
+		}
+
+		url.numAccesses++
+		url.lastAccessTime = time.Now().Unix()
+
+		authUID, auth := uuid.NewString(), newAuth(b.authLife)
+
+		b.shareURLAuth[authUID] = shareURLAuth{auth: auth, token: token}
+
+		return auth.toAuth("", authUID, serverProof, proton.OnePasswordMode), nil
+	})
+}
+
+func (b *Backend) VerifyShareURLAuth(authUID, authAcc, token string) error {
+	return readBackendRet(b, func(b *unsafeBackend) error {
+		auth, ok := b.shareURLAuth[authUID]
+		if !ok || auth.acc != authAcc || auth.token != token {
+			return errors.New("invalid auth")
+		}
+
+		if time.Since(auth.creation) > b.authLife {
+			return errors.New("auth expired")
+		}
+
+		return nil
+	})
+}
+
+func (b *Backend) GetShareURLToken(token string) (proton.ShareURLToken, error) {
+	return readBackendRetErr(b, func(b *unsafeBackend) (proton.ShareURLToken, error) {
+		url, err := b.getShareURL(token)
+		if err != nil {
+			return proton.ShareURLToken{}, err
+		}
+
+		share := b.shares[url.shareID]
+		link := b.links[share.linkID]
+
+		sharePassphrase, err := replaceKeyPacket(url.password.SharePassphraseKeyPacket, share.passphrase)
+		if err != nil {
+			return proton.ShareURLToken{}, err
+		}
+
+		name, nodePassphrase := link.name, link.nodePassphrase
+
+		if share.passphraseKeyPacket != "" {
+			if nodePassphrase, err = replaceKeyPacket(share.passphraseKeyPacket, link.nodePassphrase); err != nil {
+				return proton.ShareURLToken{}, err
+			}
+		}
+
+		if share.nameKeyPacket != "" {
+			if name, err = replaceKeyPacket(share.nameKeyPacket, link.name); err != nil {
+				return proton.ShareURLToken{}, err
+			}
+		}
+
+		return proton.ShareURLToken{
+			Token: url.token,
+
+			LinkID:   link.linkID,
+			LinkType: link.linkType,
+			Name:     name,
+			Size:     link.toLink(b.revisions).Size,
+			MIMEType: link.mimeType,
+
+			NodeKey:          link.nodeKey,
+			NodePassphrase:   nodePassphrase,
+			ContentKeyPacket: link.contentKeyPacket,
+
+			ShareKey:          share.key,
+			SharePassphrase:   sharePassphrase,
+			SharePasswordSalt: url.password.SharePasswordSalt,
+
+			CreatorEmail:   url.creator,
+			Permissions:    url.permissions,
+			ExpirationTime: url.expirationTime,
+		}, nil
+	})
+}
+
+func withAccShare[T any](b *unsafeBackend, userID, shareID string, fn func(acc *account, share *share) (T, error)) (T, error) {
+	return withAcc(b, userID, func(acc *account) (T, error) {
+		if !slices.Contains(acc.shareIDs, shareID) {
+			return *new(T), errors.New("no such share")
+		}
+
+		return fn(acc, b.shares[shareID])
+	})
+}
+
+func withAccShareFile[T any](b *unsafeBackend, userID, shareID, linkID string, fn func(link *link) (T, error)) (T, error) {
+	return withAccShare(b, userID, shareID, func(acc *account, share *share) (T, error) {
+		link, err := b.getShareLink(share, linkID)
+		if err != nil {
+			return *new(T), err
+		}
+
+		if link.linkType != proton.LinkTypeFile {
+			return *new(T), errors.New("link is not a file")
+		}
+
+		return fn(link)
+	})
+}
+
+// setActiveRevision makes the given revision the active revision of the file; the previous one becomes obsolete.
+func (b *unsafeBackend) setActiveRevision(link *link, rev *revision) {
+	if active, ok := link.getActiveRevision(b.revisions); ok {
+		active.state = proton.RevisionStateObsolete
+	}
+
+	rev.state = proton.RevisionStateActive
+
+	link.state = proton.LinkStateActive
+	link.modifyTime = time.Now().Unix()
+}
+
+// addDriveEvent records an event of the given type for the given links in their volume's event stream.
+func (b *unsafeBackend) addDriveEvent(eventType proton.LinkEventType, links ...*link) {
+	if len(links) == 0 {
+		return
+	}
+
+	event := &driveEvent{eventID: uuid.NewString()}
+
+	for _, link := range links {
+		event.events = append(event.events, proton.LinkEvent{
+			EventID:    event.eventID,
+			EventType:  eventType,
+			CreateTime: int(time.Now().Unix()),
+			Link:       link.toLink(b.revisions),
+		})
+	}
+
+	vol := b.volumes[links[0].volumeID]
+
+	vol.events = append(vol.events, event)
+}
+
+// getShareChildren returns the links with the given IDs, which must all be children of the given link of the share.
+func (b *unsafeBackend) getShareChildren(share *share, linkID string, childIDs []string) ([]*link, error) {
+	if _, err := b.getShareLink(share, linkID); err != nil {
+		return nil, err
+	}
+
+	children := make([]*link, 0, len(childIDs))
+
+	for _, childID := range childIDs {
+		child, err := b.getShareLink(share, childID)
+		if err != nil {
+			return nil, err
+		}
+
+		if child.parentLinkID != linkID {
+			return nil, fmt.Errorf("link %v is not a child of link %v", childID, linkID)
+		}
+
+		children = append(children, child)
+	}
+
+	return children, nil
+}
+
+// deleteLink deletes the given link along with its descendants and their revisions.
+func (b *unsafeBackend) deleteLink(link *link) {
+	for _, child := range b.getChildren(link.linkID, true) {
+		b.deleteLink(child)
+	}
+
+	for _, revisionID := range link.revisionIDs {
+		b.deleteRevision(revisionID)
+	}
+
+	delete(b.links, link.linkID)
+}
+
+func (b *unsafeBackend) deleteRevision(revisionID string) {
+	for token, block := range b.blocks {
+		if block.revisionID == revisionID {
+			delete(b.blocks, token)
+		}
+	}
+
+	delete(b.revisions, revisionID)
+}
+
+// getShareLink returns the link with the given ID if it is the share's root link or one of its descendants.
+func (b *unsafeBackend) getShareLink(share *share, linkID string) (*link, error) {
+	link, ok := b.links[linkID]
+	if !ok || link.volumeID != share.volumeID {
+		return nil, ErrNoSuchLink
+	}
+
+	for parentID := linkID; parentID != ""; parentID = b.links[parentID].parentLinkID {
+		if parentID == share.linkID {
+			return link, nil
+		}
+	}
+
+	return nil, errors.New("link is not in share")
+}
+
+// getChildren returns the children of the given link, sorted by creation time.
+func (b *unsafeBackend) getChildren(linkID string, showAll bool) []*link {
+	var children []*link
+
+	for _, link := range b.links {
+		if link.parentLinkID != linkID {
+			continue
+		}
+
+		if !showAll && link.state != proton.LinkStateActive {
+			continue
+		}
+
+		children = append(children, link)
+	}
+
+	slices.SortFunc(children, func(a, b *link) bool {
+		if a.createTime != b.createTime {
+			return a.createTime < b.createTime
+		}
+
+		return a.linkID < b.linkID
+	})
+
+	return children
+}
+
+// checkChildName returns an error if the given parent can't have a new child with the given name hash.
+func (b *unsafeBackend) checkChildName(parent *link, hash string) error {
+	if parent.linkType != proton.LinkTypeFolder {
+		return errors.New("parent is not a folder")
+	}
+
+	// Drafts hold their name until they are committed or deleted.
+	for _, child := range b.getChildren(parent.linkID, true) {
+		if child.state != proton.LinkStateActive && child.state != proton.LinkStateDraft {
+			continue
+		}
+
+		if child.hash == hash {
+			return errors.New("a file or folder with that name already exists")
+		}
+	}
+
+	return nil
+}
+
+func (b *unsafeBackend) getShareURLByToken(token string) (*shareURL, bool) {
+	for _, url := range b.shareURLs {
+		if url.token == token {
+			return url, true
+		}
+	}
+
+	return nil, false
+}
+
+// getShareURL returns the share URL with the given token if it can still be opened by anonymous users.
+func (b *unsafeBackend) getShareURL(token string) (*shareURL, error) {
+	url, ok := b.getShareURLByToken(token)
+	if !ok {
+		return nil, errors.New("no such share URL")
+	}
+
+	if !url.isAccessible() {
+		return nil, errors.New("share URL is no longer accessible")
+	}
+
+	return url, nil
+}
+
+func (b *unsafeBackend) deleteShareURL(shareURLID string) {
+	b.deleteShareURLAuth(b.shareURLs[shareURLID].token)
+
+	delete(b.shareURLs, shareURLID)
+}
+
+func (b *unsafeBackend) deleteShareURLAuth(token string) {
+	for authUID, auth := range b.shareURLAuth {
+		if auth.token == token {
+			delete(b.shareURLAuth, authUID)
+		}
+	}
+}
+
+func checkShareURLPassword(password proton.ShareURLPassword) error {
+	if password.SRPModulusID != modulusID {
+		return errors.New("invalid modulus ID")
+	}
+
+	if password.SRPVerifier == "" || password.URLPasswordSalt == "" || password.SharePasswordSalt == "" {
+		return errors.New("missing password parameters")
+	}
+
+	if password.SharePassphraseKeyPacket == "" {
+		return errors.New("missing share passphrase key packet")
+	}
+
+	return nil
+}
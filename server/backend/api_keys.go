@@ -0,0 +1,260 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+// AddAddressKey adds the key generated by the user to the address.
+// The key must be locked: with a token encrypted and signed with the user's key for migrated keys,
+// or with the user's key passphrase for legacy keys. The signed key list must describe the keys
+// of the address once the key is added and be signed with its primary key.
+func (b *Backend) AddAddressKey(userID string, req proton.CreateAddressKeyReq, legacy bool) (proton.Key, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (proton.Key, error) {
+		return withAcc(b, userID, func(acc *account) (proton.Key, error) {
+			addr, ok := acc.addresses[req.AddressID]
+			if !ok {
+				return proton.Key{}, fmt.Errorf("address %s not found", req.AddressID)
+			}
+
+			privKey, err := crypto.NewKeyFromArmored(req.PrivateKey)
+			if err != nil {
+				return proton.Key{}, err
+			}
+
+			if locked, err := privKey.IsLocked(); err != nil {
+				return proton.Key{}, err
+			} else if !locked {
+				return proton.Key{}, errors.New("address key must be locked")
+			}
+
+			if legacy {
+				if req.Token != "" || req.Signature != "" {
+					return proton.Key{}, errors.New("legacy address keys have no token")
+				}
+			} else if err := verifyKeyToken(acc.keys, req.Token, req.Signature); err != nil {
+				return proton.Key{}, err
+			}
+
+			newKey := key{
+				keyID: uuid.NewString(),
+				key:   req.PrivateKey,
+				tok:   req.Token,
+				sig:   req.Signature,
+			}
+
+			var keys []key
+
+			if req.Primary {
+				keys = append([]key{newKey}, addr.keys...)
+			} else {
+				keys = append(slices.Clone(addr.keys), newKey)
+			}
+
+			if err := verifyKeyList(keys, req.SignedKeyList); err != nil {
+				return proton.Key{}, err
+			}
+
+			addr.keys = keys
+
+			if err := b.addAddressUpdate(acc, addr.addrID); err != nil {
+				return proton.Key{}, err
+			}
+
+			return addr.toAddress().Keys.ByID(newKey.keyID), nil
+		})
+	})
+}
+
+// MakeAddressKeyPrimary makes the key the primary key of its address.
+// The signed key list must describe the keys of the address once the key is primary and be signed with it.
+func (b *Backend) MakeAddressKeyPrimary(userID, keyID string, keyList proton.KeyList) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		return b.withAcc(userID, func(acc *account) error {
+			addr, idx, err := getAddressKey(acc, keyID)
+			if err != nil {
+				return err
+			}
+
+			keys := append([]key{addr.keys[idx]}, slices.Delete(slices.Clone(addr.keys), idx, idx+1)...)
+
+			if err := verifyKeyList(keys, keyList); err != nil {
+				return err
+			}
+
+			addr.keys = keys
+
+			return b.addAddressUpdate(acc, addr.addrID)
+		})
+	})
+}
+
+// DeleteAddressKey deletes the key from its address. The primary key can't be deleted.
+// The signed key list must describe the keys of the address once the key is deleted.
+func (b *Backend) DeleteAddressKey(userID, keyID string, keyList proton.KeyList) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		return b.withAcc(userID, func(acc *account) error {
+			addr, idx, err := getAddressKey(acc, keyID)
+			if err != nil {
+				return err
+			} else if idx == 0 {
+				return errors.New("the primary address key can't be deleted")
+			}
+
+			keys := slices.Delete(slices.Clone(addr.keys), idx, idx+1)
+
+			if err := verifyKeyList(keys, keyList); err != nil {
+				return err
+			}
+
+			addr.keys = keys
+
+			return b.addAddressUpdate(acc, addr.addrID)
+		})
+	})
+}
+
+func (b *unsafeBackend) addAddressUpdate(acc *account, addrID string) error {
+	updateID, err := b.newUpdate(&addressUpdated{addressID: addrID})
+	if err != nil {
+		return err
+	}
+
+	acc.updateIDs = append(acc.updateIDs, updateID)
+
+	return nil
+}
+
+// getAddressKey returns the address of the key and the index of the key in the address keys.
+func getAddressKey(acc *account, keyID string) (*address, int, error) {
+	for _, addr := range acc.addresses {
+		if idx := xslices.IndexFunc(addr.keys, func(key key) bool { return key.keyID == keyID }); idx >= 0 {
+			return addr, idx, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("key %s not found", keyID)
+}
+
+// verifyKeyToken checks that the token of an address key is encrypted to and signed by one of the user's keys.
+// The token itself can't be checked as the user's keys can't be unlocked.
+func verifyKeyToken(userKeys []key, token, signature string) error {
+	if token == "" || signature == "" {
+		return errors.New("address key token and signature are required")
+	}
+
+	msg, err := crypto.NewPGPMessageFromArmored(token)
+	if err != nil {
+		return fmt.Errorf("invalid address key token: %w", err)
+	}
+
+	sig, err := crypto.NewPGPSignatureFromArmored(signature)
+	if err != nil {
+		return fmt.Errorf("invalid address key token signature: %w", err)
+	}
+
+	encIDs, ok := msg.GetEncryptionKeyIDs()
+	if !ok {
+		return errors.New("address key token isn't encrypted")
+	}
+
+	sigIDs, ok := sig.GetSignatureKeyIDs()
+	if !ok {
+		return errors.New("address key token signature has no issuer")
+	}
+
+	var encrypted, signed bool
+
+	for _, userKey := range userKeys {
+		keyIDs, err := getKeyIDs(userKey.key)
+		if err != nil {
+			return err
+		}
+
+		encrypted = encrypted || xslices.Any(encIDs, func(id uint64) bool { return slices.Contains(keyIDs, id) })
+		signed = signed || xslices.Any(sigIDs, func(id uint64) bool { return slices.Contains(keyIDs, id) })
+	}
+
+	if !encrypted {
+		return errors.New("address key token isn't encrypted to the user's key")
+	}
+
+	if !signed {
+		return errors.New("address key token isn't signed by the user's key")
+	}
+
+	return nil
+}
+
+// verifyKeyList checks that the key list describes the keys, the first of which is primary, and is signed with it.
+func verifyKeyList(keys []key, keyList proton.KeyList) error {
+	var entries []proton.KeyListEntry
+
+	if err := json.Unmarshal([]byte(keyList.Data), &entries); err != nil {
+		return fmt.Errorf("invalid key list: %w", err)
+	}
+
+	if len(entries) != len(keys) {
+		return fmt.Errorf("key list has %v keys instead of %v", len(entries), len(keys))
+	}
+
+	pubKeys := make([]*crypto.Key, 0, len(keys))
+
+	for idx, key := range keys {
+		pubKey, err := key.getPubKey()
+		if err != nil {
+			return err
+		}
+
+		entryIdx := xslices.IndexFunc(entries, func(entry proton.KeyListEntry) bool {
+			return strings.EqualFold(entry.Fingerprint, pubKey.GetFingerprint())
+		})
+
+		if entryIdx < 0 {
+			return fmt.Errorf("key %v is missing from the key list", pubKey.GetFingerprint())
+		} else if bool(entries[entryIdx].Primary) != (idx == 0) {
+			return fmt.Errorf("key %v has the wrong primary flag in the key list", pubKey.GetFingerprint())
+		}
+
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	sig, err := crypto.NewPGPSignatureFromArmored(keyList.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid key list signature: %w", err)
+	}
+
+	kr, err := crypto.NewKeyRing(pubKeys[0])
+	if err != nil {
+		return err
+	}
+
+	if err := kr.VerifyDetached(crypto.NewPlainMessage([]byte(keyList.Data)), sig, crypto.GetUnixTime()); err != nil {
+		return fmt.Errorf("key list isn't signed by the primary key: %w", err)
+	}
+
+	return nil
+}
+
+func getKeyIDs(armKey string) ([]uint64, error) {
+	key, err := crypto.NewKeyFromArmored(armKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyIDs := []uint64{key.GetKeyID()}
+
+	for _, subkey := range key.GetEntity().Subkeys {
+		keyIDs = append(keyIDs, subkey.PublicKey.KeyId)
+	}
+
+	return keyIDs, nil
+}
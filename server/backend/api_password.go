@@ -0,0 +1,234 @@
+package backend
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// SetPassword replaces the login password of the user with the one of the verifier,
+// once the user is verified with the SRP proofs of the current login password.
+// Users in one-password mode unlock their keys with the login password, so it can only be changed along with their keys.
+func (b *Backend) SetPassword(userID string, ephemeral, proof []byte, session string, verifier proton.AuthVerifier) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		return b.withAcc(userID, func(acc *account) error {
+			if acc.passwordMode == proton.OnePasswordMode {
+				return errors.New("the login password of a user in one-password mode must be changed along with the keys")
+			}
+
+			if err := b.verifyProofs(acc, ephemeral, proof, session); err != nil {
+				return err
+			}
+
+			srpSalt, srpVerifier, err := decodeVerifier(verifier)
+			if err != nil {
+				return err
+			}
+
+			acc.srpSalt, acc.verifier = srpSalt, srpVerifier
+
+			return nil
+		})
+	})
+}
+
+// UpdatePrivateKeys replaces the user's keys and legacy address keys with the same keys locked with a new passphrase,
+// once the user is verified with the SRP proofs of the current login password. All the user's keys and legacy
+// address keys must be given, and the key lists of the addresses of legacy keys must be signed again.
+// The login password is replaced too if a verifier is given. Either all the changes are applied or none is.
+func (b *Backend) UpdatePrivateKeys(userID string, ephemeral, proof []byte, session string, req proton.UpdatePrivateKeysReq) error {
+	return writeBackendRet(b, func(b *unsafeBackend) error {
+		return b.withAcc(userID, func(acc *account) error {
+			if err := b.verifyProofs(acc, ephemeral, proof, session); err != nil {
+				return err
+			}
+
+			if acc.passwordMode == proton.OnePasswordMode && req.Auth == nil {
+				return errors.New("the keys of a user in one-password mode must be changed along with the login password")
+			}
+
+			keySalt, err := base64.StdEncoding.DecodeString(req.KeySalt)
+			if err != nil {
+				return fmt.Errorf("invalid key salt: %w", err)
+			} else if len(keySalt) == 0 {
+				return errors.New("key salt is required")
+			}
+
+			newKeys := make(map[string]string, len(req.Keys))
+
+			for _, keyReq := range req.Keys {
+				newKeys[keyReq.ID] = keyReq.PrivateKey
+			}
+
+			updateKey := func(oldKey key) (key, error) {
+				armKey, ok := newKeys[oldKey.keyID]
+				if !ok {
+					return key{}, fmt.Errorf("key %s is missing", oldKey.keyID)
+				}
+
+				delete(newKeys, oldKey.keyID)
+
+				if err := verifyUpdatedKey(oldKey.key, armKey); err != nil {
+					return key{}, fmt.Errorf("invalid key %s: %w", oldKey.keyID, err)
+				}
+
+				oldKey.key = armKey
+
+				return oldKey, nil
+			}
+
+			userKeys := make([]key, 0, len(acc.keys))
+
+			for _, userKey := range acc.keys {
+				newKey, err := updateKey(userKey)
+				if err != nil {
+					return err
+				}
+
+				userKeys = append(userKeys, newKey)
+			}
+
+			addrKeys := make(map[string][]key)
+
+			for addrID, addr := range acc.addresses {
+				var updated bool
+
+				keys := make([]key, 0, len(addr.keys))
+
+				for _, addrKey := range addr.keys {
+					if addrKey.tok != "" && addrKey.sig != "" {
+						keys = append(keys, addrKey)
+						continue
+					}
+
+					newKey, err := updateKey(addrKey)
+					if err != nil {
+						return err
+					}
+
+					keys, updated = append(keys, newKey), true
+				}
+
+				if !updated {
+					continue
+				}
+
+				keyList, ok := req.SignedKeyLists[addrID]
+				if !ok {
+					return fmt.Errorf("key list of address %s is missing", addrID)
+				}
+
+				if err := verifyKeyList(keys, keyList); err != nil {
+					return err
+				}
+
+				addrKeys[addrID] = keys
+			}
+
+			for keyID := range newKeys {
+				return fmt.Errorf("key %s not found", keyID)
+			}
+
+			var srpSalt, srpVerifier []byte
+
+			if req.Auth != nil {
+				if srpSalt, srpVerifier, err = decodeVerifier(*req.Auth); err != nil {
+					return err
+				}
+			}
+
+			acc.keys, acc.salt = userKeys, keySalt
+
+			if req.Auth != nil {
+				acc.srpSalt, acc.verifier = srpSalt, srpVerifier
+			}
+
+			updateID, err := b.newUpdate(&userInfoUpdate{})
+			if err != nil {
+				return err
+			}
+
+			acc.updateIDs = append(acc.updateIDs, updateID)
+
+			for addrID, keys := range addrKeys {
+				acc.addresses[addrID].keys = keys
+
+				if err := b.addAddressUpdate(acc, addrID); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+}
+
+// verifyProofs verifies the client's SRP proofs for the session of the account's user, which can't be used again.
+func (b *unsafeBackend) verifyProofs(acc *account, ephemeral, proof []byte, session string) error {
+	srpSession, ok := b.srp[session]
+	if !ok {
+		return errors.New("invalid session")
+	}
+
+	delete(b.srp, session)
+
+	if srpSession.shareURLToken != "" || srpSession.username != acc.username {
+		return errors.New("invalid session")
+	}
+
+	if _, err := srpSession.server.VerifyProofs(ephemeral, proof); err != nil {
+		return fmt.Errorf("invalid proof: %w", err)
+	}
+
+	return nil
+}
+
+func decodeVerifier(verifier proton.AuthVerifier) ([]byte, []byte, error) {
+	if verifier.Version != 4 {
+		return nil, nil, fmt.Errorf("unsupported auth version %v", verifier.Version)
+	}
+
+	if verifier.ModulusID != modulusID {
+		return nil, nil, errors.New("unknown modulus")
+	}
+
+	srpSalt, err := base64.StdEncoding.DecodeString(verifier.Salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid salt: %w", err)
+	}
+
+	srpVerifier, err := base64.StdEncoding.DecodeString(verifier.Verifier)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid verifier: %w", err)
+	}
+
+	return srpSalt, srpVerifier, nil
+}
+
+// verifyUpdatedKey checks that the new key is the old key, locked.
+func verifyUpdatedKey(oldArmKey, newArmKey string) error {
+	oldKey, err := crypto.NewKeyFromArmored(oldArmKey)
+	if err != nil {
+		return err
+	}
+
+	newKey, err := crypto.NewKeyFromArmored(newArmKey)
+	if err != nil {
+		return err
+	}
+
+	if newKey.GetFingerprint() != oldKey.GetFingerprint() {
+		return errors.New("key doesn't match")
+	}
+
+	if locked, err := newKey.IsLocked(); err != nil {
+		return err
+	} else if !locked {
+		return errors.New("key must be locked")
+	}
+
+	return nil
+}
@@ -39,10 +39,20 @@ type unsafeBackend struct {
 
 	labels map[string]*label
 
+	volumes      map[string]*volume
+	shares       map[string]*share
+	links        map[string]*link
+	revisions    map[string]*revision
+	blocks       map[string]*block
+	shareURLs    map[string]*shareURL
+	shareURLAuth map[string]shareURLAuth
+
+	calendars map[string]*calendar
+
 	updates            map[ID]update
 	maxUpdatesPerEvent int
 
-	srp map[string]*srp.Server
+	srp map[string]srpSession
 
 	authLife    time.Duration
 	enableDedup bool
@@ -94,9 +104,17 @@ func New(authLife time.Duration, domain string, enableDedup bool) *Backend {
 			attData:            make(map[string][]byte),
 			messages:           make(map[string]*message),
 			labels:             make(map[string]*label),
+			volumes:            make(map[string]*volume),
+			shares:             make(map[string]*share),
+			links:              make(map[string]*link),
+			revisions:          make(map[string]*revision),
+			blocks:             make(map[string]*block),
+			shareURLs:          make(map[string]*shareURL),
+			shareURLAuth:       make(map[string]shareURLAuth),
+			calendars:          make(map[string]*calendar),
 			updates:            make(map[ID]update),
 			maxUpdatesPerEvent: 0,
-			srp:                make(map[string]*srp.Server),
+			srp:                make(map[string]srpSession),
 			authLife:           authLife,
 			enableDedup:        enableDedup,
 		},
@@ -117,37 +135,54 @@ func (b *Backend) SetMaxUpdatesPerEvent(max int) {
 
 func (b *Backend) CreateUser(username string, password []byte) (string, error) {
 	return writeBackendRetErr(b, func(b *unsafeBackend) (string, error) {
-		salt, err := crypto.RandomToken(16)
-		if err != nil {
-			return "", err
-		}
+		return b.createUser(username, password, password, proton.OnePasswordMode)
+	})
+}
 
-		passphrase, err := hashPassword(password, salt)
-		if err != nil {
-			return "", err
-		}
+// CreateUserWithMailboxPassword creates a user in two-password mode: the user logs in with the password
+// and unlocks their keys with the mailbox password.
+func (b *Backend) CreateUserWithMailboxPassword(username string, password, mailboxPassword []byte) (string, error) {
+	return writeBackendRetErr(b, func(b *unsafeBackend) (string, error) {
+		return b.createUser(username, password, mailboxPassword, proton.TwoPasswordMode)
+	})
+}
 
-		srpAuth, err := srp.NewAuthForVerifier(password, modulus, salt)
-		if err != nil {
-			return "", err
-		}
+func (b *unsafeBackend) createUser(username string, password, mailboxPassword []byte, passwordMode proton.PasswordMode) (string, error) {
+	salt, err := crypto.RandomToken(16)
+	if err != nil {
+		return "", err
+	}
 
-		verifier, err := srpAuth.GenerateVerifier(2048)
-		if err != nil {
-			return "", err
-		}
+	passphrase, err := hashPassword(mailboxPassword, salt)
+	if err != nil {
+		return "", err
+	}
 
-		armKey, err := GenerateKey(username, username, passphrase, "rsa", 2048)
-		if err != nil {
-			return "", err
-		}
+	srpSalt, err := crypto.RandomToken(10)
+	if err != nil {
+		return "", err
+	}
 
-		userID := uuid.NewString()
+	srpAuth, err := srp.NewAuthForVerifier(password, modulus, srpSalt)
+	if err != nil {
+		return "", err
+	}
 
-		b.accounts[userID] = newAccount(userID, username, armKey, salt, verifier)
+	verifier, err := srpAuth.GenerateVerifier(2048)
+	if err != nil {
+		return "", err
+	}
 
-		return userID, nil
-	})
+	armKey, err := GenerateKey(username, username, passphrase, "rsa", 2048)
+	if err != nil {
+		return "", err
+	}
+
+	userID := uuid.NewString()
+
+	b.accounts[userID] = newAccount(userID, username, armKey, salt, srpSalt, verifier, passwordMode)
+
+	return userID, nil
 }
 
 func (b *Backend) RemoveUser(userID string) error {
@@ -684,6 +719,20 @@ func (b *unsafeBackend) newUpdate(event update) (ID, error) {
 	})
 }
 
+// addUpdates records the updates and appends them to the account's updates.
+func (b *unsafeBackend) addUpdates(acc *account, updates ...update) error {
+	for _, update := range updates {
+		updateID, err := b.newUpdate(update)
+		if err != nil {
+			return err
+		}
+
+		acc.updateIDs = append(acc.updateIDs, updateID)
+	}
+
+	return nil
+}
+
 func withUpdates[T any](b *unsafeBackend, fn func(map[ID]update) (T, error)) (T, error) {
 	return fn(b.updates)
 }
@@ -0,0 +1,389 @@
+package backend
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/emersion/go-ical"
+	"github.com/google/uuid"
+)
+
+type calendar struct {
+	calendarID  string
+	name        string
+	description string
+	color       string
+	display     bool
+	createTime  int64
+
+	keys         []calendarKey
+	passphraseID string
+	members      []*calendarMember
+	invitations  []*calendarInvitation
+
+	events      map[string]*calendarEvent
+	modelEvents []*calendarModelEvent
+}
+
+func newCalendar(name, description, color string) *calendar {
+	return &calendar{
+		calendarID:   uuid.NewString(),
+		name:         name,
+		description:  description,
+		color:        color,
+		display:      true,
+		createTime:   time.Now().Unix(),
+		passphraseID: uuid.NewString(),
+		events:       make(map[string]*calendarEvent),
+
+		// The calendar's event stream starts with an empty event, so that clients can be given a latest event ID.
+		modelEvents: []*calendarModelEvent{{eventID: uuid.NewString()}},
+	}
+}
+
+func (cal *calendar) toCalendar() proton.Calendar {
+	return proton.Calendar{
+		ID:          cal.calendarID,
+		Name:        cal.name,
+		Description: cal.description,
+		Color:       cal.color,
+		Display:     proton.Bool(cal.display),
+		Type:        proton.CalendarTypeNormal,
+		Flags:       proton.CalendarFlagActive,
+	}
+}
+
+func (cal *calendar) toCalendarKeys() proton.CalendarKeys {
+	return xslices.Map(cal.keys, func(key calendarKey) proton.CalendarKey {
+		return proton.CalendarKey{
+			ID:           key.keyID,
+			CalendarID:   cal.calendarID,
+			PassphraseID: cal.passphraseID,
+			PrivateKey:   key.key,
+			Flags:        key.flags,
+		}
+	})
+}
+
+func (cal *calendar) toCalendarPassphrase() proton.CalendarPassphrase {
+	return proton.CalendarPassphrase{
+		ID: cal.passphraseID,
+		MemberPassphrases: xslices.Map(cal.members, func(member *calendarMember) proton.MemberPassphrase {
+			return proton.MemberPassphrase{
+				MemberID:   member.memberID,
+				Passphrase: member.passphrase,
+				Signature:  member.passphraseSignature,
+			}
+		}),
+	}
+}
+
+func (cal *calendar) getMember(memberID string) (*calendarMember, error) {
+	for _, member := range cal.members {
+		if member.memberID == memberID {
+			return member, nil
+		}
+	}
+
+	return nil, errors.New("no such calendar member")
+}
+
+func (cal *calendar) getInvitation(invitationID string) (*calendarInvitation, error) {
+	for _, invitation := range cal.invitations {
+		if invitation.invitationID == invitationID {
+			return invitation, nil
+		}
+	}
+
+	return nil, errors.New("no such calendar invitation")
+}
+
+// addModelEvent records the current state of the given events in the calendar's event stream.
+func (cal *calendar) addModelEvent(action proton.EventAction, events ...*calendarEvent) {
+	modelEvent := &calendarModelEvent{eventID: uuid.NewString()}
+
+	for _, event := range events {
+		item := proton.CalendarEventItem{
+			EventItem: proton.EventItem{
+				ID:     event.eventID,
+				Action: action,
+			},
+		}
+
+		if action != proton.EventDelete {
+			item.Event = event.toCalendarEvent(cal.calendarID)
+		}
+
+		modelEvent.items = append(modelEvent.items, item)
+	}
+
+	cal.modelEvents = append(cal.modelEvents, modelEvent)
+}
+
+type calendarKey struct {
+	keyID string
+	key   string
+	flags proton.CalendarKeyFlag
+}
+
+type calendarMember struct {
+	memberID    string
+	addrID      string
+	email       string
+	permissions proton.CalendarPermissions
+	color       string
+	display     bool
+
+	// The calendar passphrase, encrypted and signed with the member's address key.
+	passphrase          string
+	passphraseSignature string
+}
+
+func (member *calendarMember) toCalendarMember(calendarID string) proton.CalendarMember {
+	return proton.CalendarMember{
+		ID:          member.memberID,
+		Permissions: member.permissions,
+		Email:       member.email,
+		Color:       member.color,
+		Display:     proton.Bool(member.display),
+		CalendarID:  calendarID,
+	}
+}
+
+// calendarOwnerPermissions are the permissions only the owner of a calendar has; they can't be given to other members.
+const calendarOwnerPermissions = proton.CalendarPermissionSuperOwner | proton.CalendarPermissionOwner
+
+func (member *calendarMember) canWrite() bool {
+	return member.permissions&proton.CalendarPermissionWrite != 0
+}
+
+func (member *calendarMember) canAdmin() bool {
+	return member.permissions&proton.CalendarPermissionAdmin != 0
+}
+
+type calendarInvitation struct {
+	invitationID string
+	addrID       string
+	email        string
+	permissions  proton.CalendarPermissions
+	status       proton.CalendarInvitationStatus
+	createTime   int64
+
+	// The calendar passphrase, encrypted to the invited address's key.
+	passphrase string
+}
+
+func (invitation *calendarInvitation) toCalendarInvitation(calendarID string) proton.CalendarInvitation {
+	return proton.CalendarInvitation{
+		ID:          invitation.invitationID,
+		CalendarID:  calendarID,
+		Email:       invitation.email,
+		Permissions: invitation.permissions,
+		Status:      invitation.status,
+		CreateTime:  invitation.createTime,
+		Passphrase:  invitation.passphrase,
+	}
+}
+
+type calendarEvent struct {
+	eventID       string
+	uid           string
+	recurrenceID  string
+	sharedEventID string
+	author        string
+	permissions   proton.CalendarPermissions
+
+	createTime   int64
+	lastEditTime int64
+
+	startTime     int64
+	startTimezone string
+	endTime       int64
+	endTimezone   string
+	fullDay       bool
+	recurring     bool
+
+	sharedKeyPacket   string
+	calendarKeyPacket string
+
+	sharedEvents    []proton.CalendarEventPart
+	calendarEvents  []proton.CalendarEventPart
+	attendeesEvents []proton.CalendarEventPart
+	personalEvents  []proton.CalendarEventPart
+	attendees       []proton.CalendarAttendee
+}
+
+func (event *calendarEvent) toCalendarEvent(calendarID string) proton.CalendarEvent {
+	return proton.CalendarEvent{
+		ID:            event.eventID,
+		UID:           event.uid,
+		CalendarID:    calendarID,
+		SharedEventID: event.sharedEventID,
+
+		CreateTime:    event.createTime,
+		LastEditTime:  event.lastEditTime,
+		StartTime:     event.startTime,
+		StartTimezone: event.startTimezone,
+		EndTime:       event.endTime,
+		EndTimezone:   event.endTimezone,
+		FullDay:       proton.Bool(event.fullDay),
+
+		Author:      event.author,
+		Permissions: event.permissions,
+		Attendees:   event.attendees,
+
+		SharedKeyPacket:   event.sharedKeyPacket,
+		CalendarKeyPacket: event.calendarKeyPacket,
+
+		SharedEvents:    event.sharedEvents,
+		CalendarEvents:  event.calendarEvents,
+		AttendeesEvents: event.attendeesEvents,
+		PersonalEvents:  event.personalEvents,
+	}
+}
+
+// setData sets the content of the event written by the given member.
+// The time of the event is read from its signed shared part, which is not encrypted.
+func (event *calendarEvent) setData(member *calendarMember, data proton.CalendarEventData) error {
+	var signed *ical.Event
+
+	for _, part := range data.SharedEventContent {
+		if part.Type&proton.CalendarEventTypeEncrypted != 0 {
+			continue
+		}
+
+		cal, err := ical.NewDecoder(strings.NewReader(part.Data)).Decode()
+		if err != nil {
+			return err
+		}
+
+		if events := cal.Events(); len(events) == 1 {
+			signed = &events[0]
+		}
+	}
+
+	if signed == nil {
+		return errors.New("missing signed shared event part")
+	}
+
+	uid, err := signed.Props.Text(ical.PropUID)
+	if err != nil {
+		return err
+	}
+
+	start, err := signed.DateTimeStart(nil)
+	if err != nil {
+		return err
+	}
+
+	end, err := signed.DateTimeEnd(nil)
+	if err != nil {
+		return err
+	}
+
+	event.uid = uid
+
+	if prop := signed.Props.Get(ical.PropRecurrenceID); prop != nil {
+		event.recurrenceID = prop.Value
+	}
+
+	event.author = member.email
+	event.permissions = member.permissions
+	event.lastEditTime = time.Now().Unix()
+
+	event.startTime, event.startTimezone = start.Unix(), getTimezone(signed.Props.Get(ical.PropDateTimeStart))
+	event.endTime, event.endTimezone = end.Unix(), event.startTimezone
+	event.fullDay = signed.Props.Get(ical.PropDateTimeStart).ValueType() == ical.ValueDate
+	event.recurring = signed.Props.Get(ical.PropRecurrenceRule) != nil || signed.Props.Get(ical.PropRecurrenceDates) != nil
+
+	if prop := signed.Props.Get(ical.PropDateTimeEnd); prop != nil {
+		event.endTimezone = getTimezone(prop)
+	}
+
+	event.sharedKeyPacket = data.SharedKeyPacket
+	event.calendarKeyPacket = data.CalendarKeyPacket
+	event.sharedEvents = getStoredParts(data.SharedEventContent, "", member.email)
+	event.calendarEvents = getStoredParts(data.CalendarEventContent, "", member.email)
+	event.attendeesEvents = getStoredParts(data.AttendeesEventContent, "", member.email)
+
+	// Only the personal part of the writing member is replaced.
+	event.personalEvents = xslices.Filter(event.personalEvents, func(part proton.CalendarEventPart) bool {
+		return part.MemberID != member.memberID
+	})
+
+	if data.PersonalEventContent != nil {
+		event.personalEvents = append(event.personalEvents, getStoredParts([]proton.CalendarEventPart{*data.PersonalEventContent}, member.memberID, member.email)...)
+	}
+
+	event.attendees = xslices.Map(data.Attendees, func(attendee proton.CalendarAttendeeData) proton.CalendarAttendee {
+		for _, other := range event.attendees {
+			if other.Token == attendee.Token {
+				return proton.CalendarAttendee{ID: other.ID, Token: attendee.Token, Status: attendee.Status, Permissions: other.Permissions}
+			}
+		}
+
+		return proton.CalendarAttendee{ID: uuid.NewString(), Token: attendee.Token, Status: attendee.Status}
+	})
+
+	return nil
+}
+
+// overlaps returns whether the event, or any of its occurrences, may happen between start and end.
+func (event *calendarEvent) overlaps(start, end int64) bool {
+	if event.startTime >= end {
+		return false
+	}
+
+	return event.recurring || event.endTime > start
+}
+
+// calendarModelEvent is an event of a calendar's event stream; it records the state of the changed events at the time of the change.
+type calendarModelEvent struct {
+	eventID string
+	items   []proton.CalendarEventItem
+}
+
+// getTimezone returns the timezone of the given date-time property; floating and UTC times are in UTC.
+func getTimezone(prop *ical.Prop) string {
+	if tzid := prop.Params.Get(ical.PropTimezoneID); tzid != "" {
+		return tzid
+	}
+
+	return "UTC"
+}
+
+// getStoredParts returns the given parts as stored by the API: with their author and, for personal parts, their member.
+func getStoredParts(parts []proton.CalendarEventPart, memberID, author string) []proton.CalendarEventPart {
+	return xslices.Map(parts, func(part proton.CalendarEventPart) proton.CalendarEventPart {
+		part.MemberID = memberID
+		part.Author = author
+
+		return part
+	})
+}
+
+// getInvitationPassphrase returns the passphrase of an invitation: the given key packet, encrypted to the invited address's key,
+// followed by the data packet of the inviting member's passphrase.
+func getInvitationPassphrase(memberPassphrase, keyPacket string) (string, error) {
+	msg, err := crypto.NewPGPMessageFromArmored(memberPassphrase)
+	if err != nil {
+		return "", err
+	}
+
+	split, err := msg.SplitMessage()
+	if err != nil {
+		return "", err
+	}
+
+	kp, err := base64.StdEncoding.DecodeString(keyPacket)
+	if err != nil {
+		return "", err
+	}
+
+	return crypto.NewPGPSplitMessage(kp, split.GetBinaryDataPacket()).GetPGPMessage().GetArmored()
+}
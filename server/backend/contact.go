@@ -1,29 +1,68 @@
 package backend
 
 import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/bradenaw/juniper/xslices"
 	"github.com/emersion/go-vcard"
-	"strconv"
-	"sync/atomic"
+	"golang.org/x/exp/slices"
 )
 
 var globalContactID int32
 
 func ContactCardToContact(card *proton.Card, contactID string, kr *crypto.KeyRing) (proton.Contact, error) {
-	emails, err := card.Get(kr, vcard.FieldEmail)
-	if err != nil {
-		return proton.Contact{}, err
+	return ContactCardsToContact(proton.Cards{card}, contactID, kr)
+}
+
+// ContactCardsToContact builds a contact from its cards. The metadata is read from the cards that aren't encrypted,
+// as the server can't decrypt the others.
+func ContactCardsToContact(cards proton.Cards, contactID string, kr *crypto.KeyRing) (proton.Contact, error) {
+	var names, emails, uids []*vcard.Field
+
+	for _, card := range cards {
+		if card.Type&proton.CardTypeEncrypted != 0 {
+			continue
+		}
+
+		dec, err := card.Get(kr, vcard.FieldFormattedName)
+		if err != nil {
+			return proton.Contact{}, err
+		}
+
+		names = append(names, dec...)
+
+		if dec, err = card.Get(kr, vcard.FieldEmail); err != nil {
+			return proton.Contact{}, err
+		}
+
+		emails = append(emails, dec...)
+
+		if dec, err = card.Get(kr, vcard.FieldUID); err != nil {
+			return proton.Contact{}, err
+		}
+
+		uids = append(uids, dec...)
+	}
+
+	if len(names) == 0 {
+		return proton.Contact{}, errors.New("contact has no name")
 	}
-	names, err := card.Get(kr, vcard.FieldFormattedName)
-	if err != nil {
-		return proton.Contact{}, err
+
+	var uid string
+
+	if len(uids) > 0 {
+		uid = uids[0].Value
 	}
+
 	return proton.Contact{
 		ContactMetadata: proton.ContactMetadata{
 			ID:   contactID,
 			Name: names[0].Value,
+			UID:  uid,
 			ContactEmails: xslices.Map(emails, func(email *vcard.Field) proton.ContactEmail {
 				id := atomic.AddInt32(&globalContactID, 1)
 				return proton.ContactEmail{
@@ -34,6 +73,89 @@ func ContactCardToContact(card *proton.Card, contactID string, kr *crypto.KeyRin
 				}
 			}),
 		},
-		ContactCards: proton.ContactCards{Cards: proton.Cards{card}},
+		ContactCards: proton.ContactCards{Cards: cards},
 	}, nil
 }
+
+func (b *unsafeBackend) deleteContact(acc *account, contactID string) error {
+	contact, ok := acc.contacts[contactID]
+	if !ok {
+		return errors.New("No such contact with ID" + contactID)
+	}
+
+	delete(acc.contacts, contactID)
+
+	updates := []update{&contactDeleted{contactID: contactID}}
+
+	for _, email := range contact.ContactEmails {
+		updates = append(updates, &contactEmailDeleted{contactEmailID: email.ID})
+	}
+
+	return b.addUpdates(acc, updates...)
+}
+
+func getContactEmail(contacts map[string]*proton.Contact, contactEmailID string) (proton.ContactEmail, bool) {
+	for _, contact := range contacts {
+		for _, email := range contact.ContactEmails {
+			if email.ID == contactEmailID {
+				return email, true
+			}
+		}
+	}
+
+	return proton.ContactEmail{}, false
+}
+
+// setContactEmailLabel adds the label to the contact email, or removes it, and returns the contact of the email
+// if it was changed. The labels of a contact are those of its emails.
+func setContactEmailLabel(contacts map[string]*proton.Contact, contactEmailID, labelID string, labeled bool) (*proton.Contact, bool) {
+	for _, contact := range contacts {
+		idx := xslices.IndexFunc(contact.ContactEmails, func(email proton.ContactEmail) bool {
+			return email.ID == contactEmailID
+		})
+		if idx < 0 {
+			continue
+		}
+
+		email := &contact.ContactEmails[idx]
+
+		if slices.Contains(email.LabelIDs, labelID) == labeled {
+			return nil, false
+		}
+
+		if labeled {
+			email.LabelIDs = append(slices.Clone(email.LabelIDs), labelID)
+		} else {
+			email.LabelIDs = xslices.Filter(email.LabelIDs, func(otherID string) bool { return otherID != labelID })
+		}
+
+		contact.LabelIDs = nil
+
+		for _, email := range contact.ContactEmails {
+			for _, labelID := range email.LabelIDs {
+				if !slices.Contains(contact.LabelIDs, labelID) {
+					contact.LabelIDs = append(contact.LabelIDs, labelID)
+				}
+			}
+		}
+
+		return contact, true
+	}
+
+	return nil, false
+}
+
+// unlabelContactGroup removes the contact emails from the contact group, which is being deleted.
+func (b *unsafeBackend) unlabelContactGroup(acc *account, labelID string) error {
+	var updates []update
+
+	for _, contact := range acc.contacts {
+		for _, email := range contact.ContactEmails {
+			if _, ok := setContactEmailLabel(acc.contacts, email.ID, labelID, false); ok {
+				updates = append(updates, &contactUpdated{contactID: contact.ID}, &contactEmailUpdated{contactEmailID: email.ID})
+			}
+		}
+	}
+
+	return b.addUpdates(acc, updates...)
+}
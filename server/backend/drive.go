@@ -0,0 +1,426 @@
+package backend
+
+import (
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/google/uuid"
+)
+
+// ErrNoSuchLink is returned when a link doesn't exist or isn't part of the share it is looked up in.
+var ErrNoSuchLink = errors.New("no such link")
+
+type volume struct {
+	volumeID   string
+	shareID    string
+	linkID     string
+	createTime int64
+
+	events []*driveEvent
+}
+
+func newVolume(shareID, linkID string) *volume {
+	return &volume{
+		volumeID:   uuid.NewString(),
+		shareID:    shareID,
+		linkID:     linkID,
+		createTime: time.Now().Unix(),
+
+		// The volume's event stream starts with an empty event, so that clients can be given a latest event ID.
+		events: []*driveEvent{{eventID: uuid.NewString()}},
+	}
+}
+
+func (vol *volume) toVolume() proton.Volume {
+	return proton.Volume{
+		VolumeID:     vol.volumeID,
+		CreationTime: vol.createTime,
+		ModifyTime:   vol.createTime,
+		State:        proton.VolumeStateActive,
+		Share: proton.VolumeShare{
+			ShareID: vol.shareID,
+			LinkID:  vol.linkID,
+		},
+	}
+}
+
+// driveEvent is an event of a volume's event stream; it records the state of the changed links at the time of the change.
+type driveEvent struct {
+	eventID string
+	events  []proton.LinkEvent
+}
+
+type share struct {
+	shareID   string
+	volumeID  string
+	linkID    string
+	shareType proton.ShareType
+	flags     proton.ShareFlags
+
+	addrID    string
+	addrKeyID string
+	creator   string
+
+	key                 string
+	passphrase          string
+	passphraseSignature string
+
+	// The key packets of the root link's passphrase and name, encrypted with the share key.
+	// They are empty for main shares, whose root link is already encrypted with the share key.
+	passphraseKeyPacket string
+	nameKeyPacket       string
+
+	createTime int64
+	urlIDs     []string
+}
+
+func (share *share) toShareMetadata() proton.ShareMetadata {
+	return proton.ShareMetadata{
+		ShareID:      share.shareID,
+		LinkID:       share.linkID,
+		VolumeID:     share.volumeID,
+		Type:         share.shareType,
+		State:        proton.ShareStateActive,
+		CreationTime: share.createTime,
+		ModifyTime:   share.createTime,
+		Creator:      share.creator,
+		Flags:        share.flags,
+	}
+}
+
+func (share *share) toShare() proton.Share {
+	return proton.Share{
+		ShareMetadata:       share.toShareMetadata(),
+		AddressID:           share.addrID,
+		AddressKeyID:        share.addrKeyID,
+		Key:                 share.key,
+		Passphrase:          share.passphrase,
+		PassphraseSignature: share.passphraseSignature,
+	}
+}
+
+type link struct {
+	linkID       string
+	parentLinkID string
+	volumeID     string
+	linkType     proton.LinkType
+	state        proton.LinkState
+
+	name     string
+	hash     string
+	mimeType string
+
+	nodeKey                 string
+	nodePassphrase          string
+	nodePassphraseSignature string
+	signatureEmail          string
+
+	nodeHashKey string
+
+	contentKeyPacket          string
+	contentKeyPacketSignature string
+	revisionIDs               []string
+
+	createTime int64
+	modifyTime int64
+}
+
+func newFolderLink(volumeID string, req proton.CreateFolderReq) *link {
+	now := time.Now().Unix()
+
+	return &link{
+		linkID:       uuid.NewString(),
+		parentLinkID: req.ParentLinkID,
+		volumeID:     volumeID,
+		linkType:     proton.LinkTypeFolder,
+		state:        proton.LinkStateActive,
+
+		name: req.Name,
+		hash: req.Hash,
+
+		nodeKey:                 req.NodeKey,
+		nodePassphrase:          req.NodePassphrase,
+		nodePassphraseSignature: req.NodePassphraseSignature,
+		signatureEmail:          req.SignatureAddress,
+
+		nodeHashKey: req.NodeHashKey,
+
+		createTime: now,
+		modifyTime: now,
+	}
+}
+
+func newFileLink(volumeID string, req proton.CreateFileReq) *link {
+	now := time.Now().Unix()
+
+	return &link{
+		linkID:       uuid.NewString(),
+		parentLinkID: req.ParentLinkID,
+		volumeID:     volumeID,
+		linkType:     proton.LinkTypeFile,
+		state:        proton.LinkStateDraft,
+
+		name:     req.Name,
+		hash:     req.Hash,
+		mimeType: req.MIMEType,
+
+		nodeKey:                 req.NodeKey,
+		nodePassphrase:          req.NodePassphrase,
+		nodePassphraseSignature: req.NodePassphraseSignature,
+		signatureEmail:          req.SignatureAddress,
+
+		contentKeyPacket:          req.ContentKeyPacket,
+		contentKeyPacketSignature: req.ContentKeyPacketSignature,
+
+		createTime: now,
+		modifyTime: now,
+	}
+}
+
+func (link *link) toLink(revisions map[string]*revision) proton.Link {
+	res := proton.Link{
+		LinkID:       link.linkID,
+		ParentLinkID: link.parentLinkID,
+
+		Type:     link.linkType,
+		Name:     link.name,
+		Hash:     link.hash,
+		State:    link.state,
+		MIMEType: link.mimeType,
+
+		CreateTime: link.createTime,
+		ModifyTime: link.modifyTime,
+
+		NodeKey:                 link.nodeKey,
+		NodePassphrase:          link.nodePassphrase,
+		NodePassphraseSignature: link.nodePassphraseSignature,
+	}
+
+	switch link.linkType {
+	case proton.LinkTypeFolder:
+		res.FolderProperties = &proton.FolderProperties{
+			NodeHashKey: link.nodeHashKey,
+		}
+
+	case proton.LinkTypeFile:
+		res.FileProperties = &proton.FileProperties{
+			ContentKeyPacket:          link.contentKeyPacket,
+			ContentKeyPacketSignature: link.contentKeyPacketSignature,
+		}
+
+		if rev, ok := link.getActiveRevision(revisions); ok {
+			res.Size = rev.size
+			res.FileProperties.ActiveRevision = rev.toRevisionMetadata()
+		}
+	}
+
+	return res
+}
+
+func (link *link) getActiveRevision(revisions map[string]*revision) (*revision, bool) {
+	for _, revisionID := range link.revisionIDs {
+		if rev := revisions[revisionID]; rev.state == proton.RevisionStateActive {
+			return rev, true
+		}
+	}
+
+	return nil, false
+}
+
+type revision struct {
+	revisionID string
+	linkID     string
+	state      proton.RevisionState
+	createTime int64
+
+	size              int64
+	manifestSignature string
+	signatureEmail    string
+	xAttr             string
+
+	blocks    []*block
+	thumbnail *block
+}
+
+func newRevision(linkID string) *revision {
+	return &revision{
+		revisionID: uuid.NewString(),
+		linkID:     linkID,
+		state:      proton.RevisionStateDraft,
+		createTime: time.Now().Unix(),
+	}
+}
+
+func (rev *revision) toRevisionMetadata() proton.RevisionMetadata {
+	res := proton.RevisionMetadata{
+		ID:                rev.revisionID,
+		CreateTime:        rev.createTime,
+		Size:              rev.size,
+		ManifestSignature: rev.manifestSignature,
+		SignatureEmail:    rev.signatureEmail,
+		State:             rev.state,
+		XAttr:             rev.xAttr,
+	}
+
+	if rev.hasThumbnail() {
+		res.Thumbnail = true
+		res.ThumbnailHash = rev.thumbnail.hash
+	}
+
+	return res
+}
+
+// hasThumbnail returns whether the revision's thumbnail has been uploaded.
+func (rev *revision) hasThumbnail() bool {
+	return rev.thumbnail != nil && rev.thumbnail.data != nil
+}
+
+// toRevision returns the revision with at most pageSize blocks, starting at the block with index fromBlock.
+func (rev *revision) toRevision(fromBlock, pageSize int) proton.Revision {
+	res := proton.Revision{
+		RevisionMetadata: rev.toRevisionMetadata(),
+		Blocks:           []proton.Block{},
+	}
+
+	for _, block := range rev.blocks {
+		if block.index < fromBlock {
+			continue
+		}
+
+		if len(res.Blocks) >= pageSize {
+			break
+		}
+
+		res.Blocks = append(res.Blocks, block.toBlock())
+	}
+
+	return res
+}
+
+// block is a block of file content. Blocks are identified by the token used to upload and download them.
+type block struct {
+	token      string
+	revisionID string
+	index      int
+
+	hash           string
+	encSignature   string
+	signatureEmail string
+
+	data []byte
+}
+
+func (block *block) toBlock() proton.Block {
+	return proton.Block{
+		Index:          block.index,
+		Token:          block.token,
+		Hash:           block.hash,
+		EncSignature:   block.encSignature,
+		SignatureEmail: block.signatureEmail,
+	}
+}
+
+type shareURL struct {
+	shareURLID string
+	shareID    string
+	token      string
+
+	createTime     int64
+	expirationTime int64
+	lastAccessTime int64
+
+	maxAccesses int
+	numAccesses int
+
+	creator     string
+	permissions proton.ShareURLPermissions
+
+	password proton.ShareURLPassword
+}
+
+func newShareURL(shareID string, req proton.CreateShareURLReq) *shareURL {
+	return &shareURL{
+		shareURLID: uuid.NewString(),
+		shareID:    shareID,
+		token:      uuid.NewString(),
+
+		createTime:     time.Now().Unix(),
+		expirationTime: req.ExpirationTime,
+
+		maxAccesses: req.MaxAccesses,
+
+		creator:     req.CreatorEmail,
+		permissions: req.Permissions,
+
+		password: req.ShareURLPassword,
+	}
+}
+
+func (url *shareURL) toShareURL() proton.ShareURL {
+	return proton.ShareURL{
+		ShareURLID: url.shareURLID,
+		ShareID:    url.shareID,
+		Token:      url.token,
+
+		CreateTime:     url.createTime,
+		ExpirationTime: url.expirationTime,
+		LastAccessTime: url.lastAccessTime,
+
+		MaxAccesses: url.maxAccesses,
+		NumAccesses: url.numAccesses,
+
+		CreatorEmail: url.creator,
+		Permissions:  url.permissions,
+		Flags:        url.password.Flags,
+
+		URLPasswordSalt:          url.password.URLPasswordSalt,
+		SharePasswordSalt:        url.password.SharePasswordSalt,
+		SRPVerifier:              url.password.SRPVerifier,
+		SRPModulusID:             url.password.SRPModulusID,
+		Password:                 url.password.Password,
+		SharePassphraseKeyPacket: url.password.SharePassphraseKeyPacket,
+	}
+}
+
+// isAccessible returns whether the share URL can still be opened by anonymous users.
+func (url *shareURL) isAccessible() bool {
+	if url.expirationTime > 0 && time.Now().Unix() > url.expirationTime {
+		return false
+	}
+
+	if url.maxAccesses > 0 && url.numAccesses >= url.maxAccesses {
+		return false
+	}
+
+	return true
+}
+
+// shareURLAuth is an anonymous session opened on a share URL.
+type shareURLAuth struct {
+	auth
+
+	token string
+}
+
+// replaceKeyPacket returns the given armored message with its key packet replaced by the given base64 key packet.
+func replaceKeyPacket(keyPacket, armored string) (string, error) {
+	kp, err := base64.StdEncoding.DecodeString(keyPacket)
+	if err != nil {
+		return "", err
+	}
+
+	msg, err := crypto.NewPGPMessageFromArmored(armored)
+	if err != nil {
+		return "", err
+	}
+
+	split, err := msg.SplitMessage()
+	if err != nil {
+		return "", err
+	}
+
+	return crypto.NewPGPSplitMessage(kp, split.GetBinaryDataPacket()).GetPGPMessage().GetArmored()
+}
@@ -116,6 +116,7 @@ func newMessageFromTemplate(
 		ccList:  template.CCList,
 		bccList: template.BCCList,
 		unread:  bool(template.Unread),
+		date:    time.Now(),
 
 		draftAction: action,
 
@@ -184,6 +185,12 @@ func (msg *message) toMetadata(attData map[string][]byte, att map[string]*attach
 		messageSize += len(attData[att[a].attDataID])
 	}
 
+	var messageTime int64
+
+	if !msg.date.IsZero() {
+		messageTime = msg.date.Unix()
+	}
+
 	return proton.MessageMetadata{
 		ID:         msg.messageID,
 		ExternalID: msg.externalID,
@@ -199,6 +206,7 @@ func (msg *message) toMetadata(attData map[string][]byte, att map[string]*attach
 		Size:     messageSize,
 
 		Flags:        msg.flags,
+		Time:         messageTime,
 		Unread:       proton.Bool(msg.unread),
 		IsForwarded:  msg.flags&proton.MessageFlagForwarded != 0,
 		IsReplied:    msg.flags&proton.MessageFlagReplied != 0,
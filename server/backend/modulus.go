@@ -4,10 +4,14 @@ import (
 	_ "embed"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/google/uuid"
 )
 
 var modulus string
 
+// modulusID identifies the single modulus served by the backend.
+var modulusID = uuid.NewString()
+
 func init() {
 	arm, err := crypto.NewClearTextMessage(asc, sig).GetArmored()
 	if err != nil {
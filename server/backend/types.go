@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/go-srp"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/google/uuid"
 )
@@ -51,7 +52,7 @@ func newAuth(authLife time.Duration) auth {
 	}
 }
 
-func (auth *auth) toAuth(userID, authUID string, proof []byte) proton.Auth {
+func (auth *auth) toAuth(userID, authUID string, proof []byte, passwordMode proton.PasswordMode) proton.Auth {
 	return proton.Auth{
 		UserID: userID,
 
@@ -60,10 +61,21 @@ func (auth *auth) toAuth(userID, authUID string, proof []byte) proton.Auth {
 		RefreshToken: auth.ref,
 		ServerProof:  base64.StdEncoding.EncodeToString(proof),
 
-		PasswordMode: proton.OnePasswordMode,
+		PasswordMode: passwordMode,
 	}
 }
 
+// srpSession is an SRP handshake started by a client, either to log in or to open a share URL.
+type srpSession struct {
+	server *srp.Server
+
+	// username is the name of the user logging in, or empty if the client is opening a share URL.
+	username string
+
+	// shareURLToken is the token of the share URL being opened, or empty if the client is logging in.
+	shareURLToken string
+}
+
 func (auth *auth) toAuthSession(authUID string) proton.AuthSession {
 	return proton.AuthSession{
 		UID:        authUID,
@@ -174,6 +174,96 @@ func (update *addressDeleted) replaces(other update) bool {
 	}
 }
 
+type contactCreated struct {
+	baseUpdate
+	contactID string
+}
+
+type contactUpdated struct {
+	baseUpdate
+	contactID string
+}
+
+func (update *contactUpdated) replaces(other update) bool {
+	switch other := other.(type) {
+	case *contactUpdated:
+		return update.contactID == other.contactID
+
+	default:
+		return false
+	}
+}
+
+type contactDeleted struct {
+	baseUpdate
+	contactID string
+}
+
+func (update *contactDeleted) replaces(other update) bool {
+	switch other := other.(type) {
+	case *contactCreated:
+		return update.contactID == other.contactID
+
+	case *contactUpdated:
+		return update.contactID == other.contactID
+
+	case *contactDeleted:
+		if update.contactID != other.contactID {
+			return false
+		}
+
+		panic("contact deleted twice")
+
+	default:
+		return false
+	}
+}
+
+type contactEmailCreated struct {
+	baseUpdate
+	contactEmailID string
+}
+
+type contactEmailUpdated struct {
+	baseUpdate
+	contactEmailID string
+}
+
+func (update *contactEmailUpdated) replaces(other update) bool {
+	switch other := other.(type) {
+	case *contactEmailUpdated:
+		return update.contactEmailID == other.contactEmailID
+
+	default:
+		return false
+	}
+}
+
+type contactEmailDeleted struct {
+	baseUpdate
+	contactEmailID string
+}
+
+func (update *contactEmailDeleted) replaces(other update) bool {
+	switch other := other.(type) {
+	case *contactEmailCreated:
+		return update.contactEmailID == other.contactEmailID
+
+	case *contactEmailUpdated:
+		return update.contactEmailID == other.contactEmailID
+
+	case *contactEmailDeleted:
+		if update.contactEmailID != other.contactEmailID {
+			return false
+		}
+
+		panic("contact email deleted twice")
+
+	default:
+		return false
+	}
+}
+
 type userSettingsUpdate struct {
 	baseUpdate
 	settings proton.UserSettings
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) handleGetCalendars() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		calendars, err := s.b.GetCalendars(c.GetString("UserID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Calendars": calendars,
+		})
+	}
+}
+
+func (s *Server) handleGetCalendar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		calendar, err := s.b.GetCalendar(c.GetString("UserID"), c.Param("calendarID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Calendar": calendar,
+		})
+	}
+}
+
+func (s *Server) handleGetCalendarKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys, err := s.b.GetCalendarKeys(c.GetString("UserID"), c.Param("calendarID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Keys": keys,
+		})
+	}
+}
+
+func (s *Server) handleGetCalendarMembers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		members, err := s.b.GetCalendarMembers(c.GetString("UserID"), c.Param("calendarID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Members": members,
+		})
+	}
+}
+
+func (s *Server) handleGetCalendarPassphrase() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		passphrase, err := s.b.GetCalendarPassphrase(c.GetString("UserID"), c.Param("calendarID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Passphrase": passphrase,
+		})
+	}
+}
+
+func (s *Server) handlePostCalendarInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.CreateCalendarInvitationReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		invitation, err := s.b.CreateCalendarInvitation(c.GetString("UserID"), c.Param("calendarID"), req)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Invitation": invitation,
+		})
+	}
+}
+
+func (s *Server) handleGetCalendarInvitations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitations, err := s.b.GetCalendarInvitations(c.GetString("UserID"), c.Param("calendarID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Invitations": invitations,
+		})
+	}
+}
+
+func (s *Server) handleGetPendingCalendarInvitations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitations, err := s.b.GetPendingCalendarInvitations(c.GetString("UserID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Invitations": invitations,
+		})
+	}
+}
+
+func (s *Server) handlePutCalendarInvitationAccept() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.AcceptCalendarInvitationReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		member, err := s.b.AcceptCalendarInvitation(c.GetString("UserID"), c.Param("calendarID"), c.Param("invitationID"), req.Signature)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Member": member,
+		})
+	}
+}
+
+func (s *Server) handlePutCalendarInvitationDecline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.b.DeclineCalendarInvitation(c.GetString("UserID"), c.Param("calendarID"), c.Param("invitationID")); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handleDeleteCalendarInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.b.DeleteCalendarInvitation(c.GetString("UserID"), c.Param("calendarID"), c.Param("invitationID")); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handlePutCalendarMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.UpdateCalendarMemberReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		member, err := s.b.UpdateCalendarMember(c.GetString("UserID"), c.Param("calendarID"), c.Param("memberID"), req.Permissions)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Member": member,
+		})
+	}
+}
+
+func (s *Server) handleDeleteCalendarMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.b.DeleteCalendarMember(c.GetString("UserID"), c.Param("calendarID"), c.Param("memberID")); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handleGetCalendarEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start, err := strconv.ParseInt(c.DefaultQuery("Start", "0"), 10, 64)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		end, err := strconv.ParseInt(c.DefaultQuery("End", "0"), 10, 64)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		total, events, err := s.b.GetCalendarEvents(c.GetString("UserID"), c.Param("calendarID"),
+			mustParseInt(c.DefaultQuery("Page", strconv.Itoa(defaultPage))),
+			mustParseInt(c.DefaultQuery("PageSize", strconv.Itoa(defaultPageSize))),
+			start, end, c.Query("UID"),
+		)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Events": events,
+			"Total":  total,
+		})
+	}
+}
+
+func (s *Server) handleGetCalendarEvent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		event, err := s.b.GetCalendarEvent(c.GetString("UserID"), c.Param("calendarID"), c.Param("eventID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Event": event,
+		})
+	}
+}
+
+func (s *Server) handlePutCalendarEventsSync() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			MemberID string
+			Events   []struct {
+				ID        string
+				Overwrite proton.Bool
+				Event     *proton.CalendarEventData
+			}
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		type response struct {
+			proton.APIError
+			Event *proton.CalendarEvent `json:",omitempty"`
+		}
+
+		type result struct {
+			Index    int
+			Response response
+		}
+
+		var res []result
+
+		for i, item := range req.Events {
+			var (
+				event proton.CalendarEvent
+				err   error
+			)
+
+			switch {
+			case item.ID == "" && item.Event != nil:
+				event, err = s.b.CreateCalendarEvent(c.GetString("UserID"), c.Param("calendarID"), req.MemberID, *item.Event)
+
+			case item.ID != "" && item.Event != nil:
+				event, err = s.b.UpdateCalendarEvent(c.GetString("UserID"), c.Param("calendarID"), req.MemberID, item.ID, *item.Event)
+
+			case item.ID != "":
+				err = s.b.DeleteCalendarEvent(c.GetString("UserID"), c.Param("calendarID"), req.MemberID, item.ID)
+
+			default:
+				err = errors.New("missing event ID or data")
+			}
+
+			if err != nil {
+				res = append(res, result{
+					Index:    i,
+					Response: response{APIError: proton.APIError{Code: proton.InvalidValue, Message: err.Error()}},
+				})
+
+				continue
+			}
+
+			var resEvent *proton.CalendarEvent
+
+			if item.Event != nil {
+				resEvent = &event
+			}
+
+			res = append(res, result{
+				Index:    i,
+				Response: response{APIError: proton.APIError{Code: proton.SuccessCode}, Event: resEvent},
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Code":      proton.MultiCode,
+			"Responses": res,
+		})
+	}
+}
+
+func (s *Server) handlePutCalendarEventAttendee() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.UpdateCalendarAttendeeReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		attendee, err := s.b.UpdateCalendarAttendee(c.GetString("UserID"), c.Param("calendarID"), c.Param("eventID"), c.Param("attendeeID"), req.Status)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Attendee": attendee,
+		})
+	}
+}
+
+func (s *Server) handleGetCalendarModelEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		event, more, err := s.b.GetCalendarModelEvent(c.GetString("UserID"), c.Param("calendarID"), c.Param("eventID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(
+			http.StatusOK,
+			struct {
+				proton.CalendarModelEvent
+				More proton.Bool
+			}{
+				event,
+				proton.Bool(more),
+			},
+		)
+	}
+}
+
+func (s *Server) handleGetCalendarModelEventsLatest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := s.b.GetLatestCalendarModelEventID(c.GetString("UserID"), c.Param("calendarID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"CalendarModelEventID": eventID,
+		})
+	}
+}
@@ -143,6 +143,37 @@ func main() {
 				},
 			},
 		},
+		{
+			Name: "calendar",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "create",
+					Action: createCalendarAction,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "userID",
+							Usage:    "ID of the user to create the calendar for",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "addressID",
+							Usage:    "ID of the address owning the calendar",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "password",
+							Usage:    "password of the account",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "name",
+							Usage:    "name of the calendar",
+							Required: true,
+						},
+					},
+				},
+			},
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -258,6 +289,25 @@ func createLabelAction(c *cli.Context) error {
 	return pretty(c.App.Writer, res)
 }
 
+func createCalendarAction(c *cli.Context) error {
+	client, err := newServerClient(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.CreateCalendar(c.Context, &proto.CreateCalendarRequest{
+		UserID:   c.String("userID"),
+		AddrID:   c.String("addressID"),
+		Password: []byte(c.String("password")),
+		Name:     c.String("name"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return pretty(c.App.Writer, res)
+}
+
 func newServerClient(c *cli.Context) (proto.ServerClient, error) {
 	cc, err := grpc.DialContext(
 		c.Context,
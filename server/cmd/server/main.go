@@ -130,6 +130,17 @@ func (s *service) CreateLabel(ctx context.Context, req *proto.CreateLabelRequest
 	}, nil
 }
 
+func (s *service) CreateCalendar(ctx context.Context, req *proto.CreateCalendarRequest) (*proto.CreateCalendarResponse, error) {
+	calendarID, err := s.server.CreateCalendar(req.UserID, req.AddrID, req.Password, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.CreateCalendarResponse{
+		CalendarID: calendarID,
+	}, nil
+}
+
 func (s *service) run(port int) error {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/gluon/async"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/go-proton-api/drivedav"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// passwordEnv holds the password to log in with. If it is not set, the password is read from stdin.
+	passwordEnv = "PROTON_PASSWORD"
+
+	// davPasswordEnv holds the password WebDAV clients must authenticate with, along with the username.
+	// If it is not set, WebDAV is only served on loopback addresses.
+	davPasswordEnv = "WEBDAV_PASSWORD"
+)
+
+func main() {
+	app := cli.NewApp()
+
+	app.Usage = "serve the main share of a drive volume over WebDAV"
+
+	app.Description = fmt.Sprintf(
+		"The password to log in with is read from %v, or from stdin if it is not set. "+
+			"WebDAV clients authenticate with the username and the password set in %v; "+
+			"without it, WebDAV can only be served on a loopback address.",
+		passwordEnv, davPasswordEnv,
+	)
+
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:     "host-url",
+			Usage:    "URL of the API to connect to",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "username",
+			Usage:    "username to log in with",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "addr",
+			Usage: "address to serve WebDAV on",
+			Value: "localhost:8081",
+		},
+		&cli.BoolFlag{
+			Name:  "insecure",
+			Usage: "skip TLS verification of the API",
+		},
+	}
+
+	app.Action = run
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(c *cli.Context) error {
+	davPassword := os.Getenv(davPasswordEnv)
+
+	if davPassword == "" {
+		if err := checkLoopback(c.String("addr")); err != nil {
+			return err
+		}
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	opts := []proton.Option{proton.WithHostURL(c.String("host-url"))}
+
+	if c.Bool("insecure") {
+		opts = append(opts, proton.WithTransport(proton.InsecureTransport()))
+	}
+
+	m := proton.New(opts...)
+	defer m.Close()
+
+	client, _, err := m.NewClientWithLogin(c.Context, c.String("username"), password)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	fs, err := newFileSystem(c.Context, client, password)
+	if err != nil {
+		return err
+	}
+
+	handler := fs.Handler()
+
+	if davPassword != "" {
+		handler = withBasicAuth(handler, c.String("username"), davPassword)
+	}
+
+	log.Printf("Serving WebDAV on http://%v", c.String("addr"))
+
+	return http.ListenAndServe(c.String("addr"), handler)
+}
+
+// readPassword returns the password to log in with, from the environment or from the first line of stdin.
+func readPassword() ([]byte, error) {
+	if password, ok := os.LookupEnv(passwordEnv); ok {
+		return []byte(password), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// checkLoopback returns an error if the given address to serve on is not a loopback address.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return errors.New("WebDAV without authentication can only be served on a loopback address; set " + davPasswordEnv)
+}
+
+// withBasicAuth returns a handler which requires the given basic auth credentials before calling the given handler.
+func withBasicAuth(handler http.Handler, username, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="drive"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// newFileSystem unlocks the user's keys and returns a file system serving the main share of the user's first volume.
+func newFileSystem(ctx context.Context, c *proton.Client, password []byte) (*drivedav.FileSystem, error) {
+	user, err := c.GetUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := c.GetAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	salts, err := c.GetSalts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPass, err := salts.SaltForKey(password, user.Keys.Primary().ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, addrKRs, err := proton.Unlock(user, addrs, keyPass, async.NoopPanicHandler{})
+	if err != nil {
+		return nil, err
+	}
+
+	volumes, err := c.ListVolumes(ctx)
+	if err != nil {
+		return nil, err
+	} else if len(volumes) == 0 {
+		return nil, fmt.Errorf("no drive volume")
+	}
+
+	share, err := c.GetShare(ctx, volumes[0].Share.ShareID)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := slices.IndexFunc(addrs, func(addr proton.Address) bool { return addr.ID == share.AddressID })
+	if idx < 0 {
+		return nil, fmt.Errorf("no address for share %v", share.ShareID)
+	}
+
+	shareKR, err := share.GetKeyRing(addrKRs[share.AddressID])
+	if err != nil {
+		return nil, err
+	}
+
+	return drivedav.New(ctx, c, addrs[idx], addrKRs[share.AddressID], share, shareKR)
+}
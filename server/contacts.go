@@ -6,6 +6,9 @@ import (
 
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/go-proton-api/server/backend"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/emersion/go-vcard"
 	"github.com/gin-gonic/gin"
 )
 
@@ -30,7 +33,7 @@ func (s *Server) handleGetContacts() gin.HandlerFunc {
 
 func (s *Server) handleGetContactsEmails() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		total, contacts, err := s.b.GetUserContactEmails(c.GetString("UserID"), c.Query("Email"),
+		total, contacts, err := s.b.GetUserContactEmails(c.GetString("UserID"), c.Query("Email"), c.Query("LabelID"),
 			mustParseInt(c.DefaultQuery("Page", strconv.Itoa(defaultPage))),
 			mustParseInt(c.DefaultQuery("PageSize", strconv.Itoa(defaultPageSize))),
 		)
@@ -46,34 +49,87 @@ func (s *Server) handleGetContactsEmails() gin.HandlerFunc {
 	}
 }
 
-func (s *Server) handlePostContacts() gin.HandlerFunc {
+func (s *Server) handlePutContactsEmailsLabel() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var req proton.CreateContactsReq
-		err := c.BindJSON(&req)
-		if err != nil {
+		var req proton.LabelContactEmailsReq
+
+		if err := c.BindJSON(&req); err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
-		var responses []proton.CreateContactsRes
 
-		userId := c.GetString("UserID")
-		user, err := s.b.GetUser(userId)
-		if err != nil {
+		if err := s.b.LabelContactEmails(c.GetString("UserID"), req.LabelID, req.ContactEmailIDs...); err != nil {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Code":      proton.MultiCode,
+			"Responses": getLabelContactEmailsRes(req.ContactEmailIDs),
+		})
+	}
+}
+
+func (s *Server) handlePutContactsEmailsUnlabel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.LabelContactEmailsReq
+
+		if err := c.BindJSON(&req); err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
-		pubKeys, err := s.b.GetPublicKeys(user.Email)
+
+		if err := s.b.UnlabelContactEmails(c.GetString("UserID"), req.LabelID, req.ContactEmailIDs...); err != nil {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Code":      proton.MultiCode,
+			"Responses": getLabelContactEmailsRes(req.ContactEmailIDs),
+		})
+	}
+}
+
+func getLabelContactEmailsRes(contactEmailIDs []string) []proton.LabelContactEmailRes {
+	return xslices.Map(contactEmailIDs, func(contactEmailID string) proton.LabelContactEmailRes {
+		return proton.LabelContactEmailRes{
+			ID:       contactEmailID,
+			Response: proton.APIError{Code: proton.SuccessCode},
+		}
+	})
+}
+
+func (s *Server) handlePostContacts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.CreateContactsReq
+		err := c.BindJSON(&req)
 		if err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
-		userKr, err := proton.PublicKeys(pubKeys).GetKeyRing()
+		var responses []proton.CreateContactsRes
+
+		userId := c.GetString("UserID")
+		userKr, err := s.getContactKeyRing(userId)
 		if err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
 		for i, v := range req.Contacts {
-			for _, card := range v.Cards {
+			groups, err := groupContactCards(v.Cards, userKr)
+			if err != nil {
+				responses = append(responses,
+					proton.CreateContactsRes{
+						Index: i,
+						Response: proton.CreateContactResp{
+							APIError: proton.APIError{Code: proton.InvalidValue, Message: err.Error()},
+						},
+					})
+				continue
+			}
+
+			for _, cards := range groups {
 				contactID, err := s.b.GenerateContactID(userId)
 				if err != nil {
 					responses = append(responses,
@@ -86,7 +142,7 @@ func (s *Server) handlePostContacts() gin.HandlerFunc {
 					continue
 				}
 
-				contact, err := backend.ContactCardToContact(card, contactID, userKr)
+				contact, err := backend.ContactCardsToContact(cards, contactID, userKr)
 				if err != nil {
 					responses = append(responses,
 						proton.CreateContactsRes{
@@ -149,7 +205,19 @@ func (s *Server) handlePutContact() gin.HandlerFunc {
 			return
 		}
 
-		contact, err := s.b.UpdateUserContact(c.GetString("UserID"), c.Param("contactID"), req.Cards)
+		userKr, err := s.getContactKeyRing(c.GetString("UserID"))
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		contact, err := backend.ContactCardsToContact(req.Cards, c.Param("contactID"), userKr)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		contact, err = s.b.UpdateUserContact(c.GetString("UserID"), contact)
 		if err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
@@ -160,3 +228,112 @@ func (s *Server) handlePutContact() gin.HandlerFunc {
 		})
 	}
 }
+
+func (s *Server) handlePutContactsDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.DeleteContactsReq
+		err := c.BindJSON(&req)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		type response struct {
+			ID       string
+			Response proton.APIError
+		}
+
+		var responses []response
+
+		for _, contactID := range req.IDs {
+			if err := s.b.DeleteUserContact(c.GetString("UserID"), contactID); err != nil {
+				responses = append(responses, response{
+					ID:       contactID,
+					Response: proton.APIError{Code: proton.InvalidValue, Message: err.Error()},
+				})
+				continue
+			}
+
+			responses = append(responses, response{
+				ID:       contactID,
+				Response: proton.APIError{Code: proton.SuccessCode},
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Code":      proton.MultiCode,
+			"Responses": responses,
+		})
+	}
+}
+
+func (s *Server) handleDeleteContacts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.b.DeleteAllUserContacts(c.GetString("UserID")); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Code": proton.SuccessCode,
+		})
+	}
+}
+
+// getContactKeyRing returns the keyring with which the cards of the user's contacts are signed.
+func (s *Server) getContactKeyRing(userID string) (*crypto.KeyRing, error) {
+	user, err := s.b.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeys, err := s.b.GetPublicKeys(user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return proton.PublicKeys(pubKeys).GetKeyRing()
+}
+
+// groupContactCards groups the cards of a contact by their UID; cards with different UIDs make different contacts.
+// Encrypted cards can't be read by the server and belong to the first contact.
+func groupContactCards(cards proton.Cards, kr *crypto.KeyRing) ([]proton.Cards, error) {
+	var (
+		groups    []proton.Cards
+		uids      []string
+		encrypted proton.Cards
+	)
+
+	for _, card := range cards {
+		if card.Type&proton.CardTypeEncrypted != 0 {
+			encrypted = append(encrypted, card)
+			continue
+		}
+
+		fields, err := card.Get(kr, vcard.FieldUID)
+		if err != nil {
+			return nil, err
+		}
+
+		var uid string
+
+		if len(fields) > 0 {
+			uid = fields[0].Value
+		}
+
+		if idx := xslices.Index(uids, uid); idx >= 0 && uid != "" {
+			groups[idx] = append(groups[idx], card)
+		} else {
+			groups = append(groups, proton.Cards{card})
+			uids = append(uids, uid)
+		}
+	}
+
+	if len(groups) == 0 {
+		return []proton.Cards{encrypted}, nil
+	}
+
+	groups[0] = append(groups[0], encrypted...)
+
+	return groups, nil
+}
@@ -62,3 +62,27 @@ func (s *Server) handlePutUserSettingsCrashReports() gin.HandlerFunc {
 		})
 	}
 }
+
+func (s *Server) handlePutUserSettingsPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			proton.SetPasswordReq
+			proton.AuthReq
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			return
+		}
+
+		ephemeral, proof, err := decodeSRPProofs(req.AuthReq)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.b.SetPassword(c.GetString("UserID"), ephemeral, proof, req.SRPSession, req.Auth); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/go-proton-api/server/backend"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) handleGetDriveVolumes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		volumes, err := s.b.ListVolumes(c.GetString("UserID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Volumes": volumes,
+		})
+	}
+}
+
+func (s *Server) handleGetDriveVolume() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		volume, err := s.b.GetVolume(c.GetString("UserID"), c.Param("volumeID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Volume": volume,
+		})
+	}
+}
+
+func (s *Server) handlePostDriveVolumes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.CreateVolumeReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		volume, err := s.b.CreateVolume(c.GetString("UserID"), req)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Volume": volume,
+		})
+	}
+}
+
+func (s *Server) handleGetDriveVolumeEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		event, more, err := s.b.GetVolumeEvent(c.GetString("UserID"), c.Param("volumeID"), c.Param("eventID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(
+			http.StatusOK,
+			struct {
+				proton.DriveEvent
+				More proton.Bool
+			}{
+				event,
+				proton.Bool(more),
+			},
+		)
+	}
+}
+
+func (s *Server) handleGetDriveVolumeEventsLatest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := s.b.GetLatestVolumeEventID(c.GetString("UserID"), c.Param("volumeID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"EventID": eventID,
+		})
+	}
+}
+
+func (s *Server) handlePostDriveVolumeShares() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.CreateShareReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		shareID, err := s.b.CreateShare(c.GetString("UserID"), c.Param("volumeID"), req)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Share": proton.CreateShareRes{ID: shareID},
+		})
+	}
+}
+
+func (s *Server) handleGetDriveShares() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		shares, err := s.b.ListShares(c.GetString("UserID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Shares": shares,
+		})
+	}
+}
+
+func (s *Server) handleGetDriveShare() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		share, err := s.b.GetShare(c.GetString("UserID"), c.Param("shareID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, share)
+	}
+}
+
+func (s *Server) handleDeleteDriveShare() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.b.DeleteShare(c.GetString("UserID"), c.Param("shareID")); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handleGetDriveLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link, err := s.b.GetLink(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Link": link,
+		})
+	}
+}
+
+func (s *Server) handlePutDriveLinkMove() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.MoveLinkReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if err := s.b.MoveLink(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"), req); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handlePostDriveFolders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.CreateFolderReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		linkID, err := s.b.CreateFolder(c.GetString("UserID"), c.Param("shareID"), req)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Folder": proton.CreateFolderRes{ID: linkID},
+		})
+	}
+}
+
+func (s *Server) handleGetDriveFolderChildren() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		links, err := s.b.ListChildren(
+			c.GetString("UserID"),
+			c.Param("shareID"),
+			c.Param("linkID"),
+			mustParseInt(c.DefaultQuery("Page", strconv.Itoa(defaultPage))),
+			mustParseInt(c.DefaultQuery("PageSize", strconv.Itoa(defaultPageSize))),
+			c.Query("ShowAll") == "1",
+		)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Links": links,
+		})
+	}
+}
+
+func (s *Server) handlePostDriveFolderTrashMultiple() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			LinkIDs []string
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if err := s.b.TrashChildren(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"), req.LinkIDs...); err != nil {
+			abortWithLinkError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Code":      proton.MultiCode,
+			"Responses": getLinkResponses(req.LinkIDs),
+		})
+	}
+}
+
+func (s *Server) handlePostDriveFolderDeleteMultiple() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			LinkIDs []string
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if err := s.b.DeleteChildren(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"), req.LinkIDs...); err != nil {
+			abortWithLinkError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Code":      proton.MultiCode,
+			"Responses": getLinkResponses(req.LinkIDs),
+		})
+	}
+}
+
+func (s *Server) handlePostDriveFiles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.CreateFileReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		res, err := s.b.CreateFile(c.GetString("UserID"), c.Param("shareID"), req)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"File": res,
+		})
+	}
+}
+
+func (s *Server) handleGetDriveFileRevisions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revisions, err := s.b.ListRevisions(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Revisions": revisions,
+		})
+	}
+}
+
+func (s *Server) handlePostDriveFileRevisions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revisionID, err := s.b.CreateRevision(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Revision": proton.CreateRevisionRes{ID: revisionID},
+		})
+	}
+}
+
+func (s *Server) handleGetDriveFileRevision() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revision, err := s.b.GetRevision(
+			c.GetString("UserID"),
+			c.Param("shareID"),
+			c.Param("linkID"),
+			c.Param("revisionID"),
+			mustParseInt(c.DefaultQuery("FromBlockIndex", "1")),
+			mustParseInt(c.DefaultQuery("PageSize", strconv.Itoa(defaultPageSize))),
+		)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		for i := range revision.Blocks {
+			revision.Blocks[i].BareURL = s.getBlockURL()
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Revision": revision,
+		})
+	}
+}
+
+func (s *Server) handlePutDriveFileRevision() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.UpdateRevisionReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if err := s.b.UpdateRevision(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"), c.Param("revisionID"), req); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handleDeleteDriveFileRevision() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.b.DeleteRevision(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"), c.Param("revisionID")); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handlePostDriveFileRevisionRestore() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.b.RestoreRevision(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"), c.Param("revisionID")); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"Code": proton.SuccessCode,
+		})
+	}
+}
+
+func (s *Server) handlePostDriveBlocks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.BlockUploadReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := s.b.RequestBlockUpload(c.GetString("UserID"), req)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		thumbnailTokens, err := s.b.RequestThumbnailUpload(c.GetString("UserID"), req)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"UploadLinks":    xslices.Map(tokens, s.getBlockUploadLink),
+			"ThumbnailLinks": xslices.Map(thumbnailTokens, s.getBlockUploadLink),
+		})
+	}
+}
+
+func (s *Server) handleGetDriveFileRevisionThumbnail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		thumbnail, err := s.b.GetThumbnail(c.GetString("UserID"), c.Param("shareID"), c.Param("linkID"), c.Param("revisionID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"ThumbnailBareURL":      s.getBlockURL(),
+			"ThumbnailToken":        thumbnail.Token,
+			"ThumbnailEncSignature": thumbnail.EncSignature,
+		})
+	}
+}
+
+func (s *Server) handlePostStorageBlocks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if len(form.File["Block"]) != 1 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if err := s.b.UploadBlock(c.GetHeader("pm-storage-token"), mustReadFileHeader(form.File["Block"][0])); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handleGetStorageBlocks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := s.b.GetBlock(c.GetHeader("pm-storage-token"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.Data(http.StatusOK, "application/octet-stream", data)
+	}
+}
+
+// getBlockURL returns the URL at which blocks are uploaded and downloaded; blocks are identified by their token.
+func (s *Server) getBlockURL() string {
+	return s.GetHostURL() + "/storage/blocks"
+}
+
+func (s *Server) getBlockUploadLink(token string) proton.BlockUploadLink {
+	return proton.BlockUploadLink{
+		Token:   token,
+		BareURL: s.getBlockURL(),
+	}
+}
+
+// getLinkResponses returns a successful response for each of the given links.
+func getLinkResponses(linkIDs []string) []gin.H {
+	return xslices.Map(linkIDs, func(linkID string) gin.H {
+		return gin.H{
+			"LinkID": linkID,
+			"Response": gin.H{
+				"Code": proton.SuccessCode,
+			},
+		}
+	})
+}
+
+// abortWithLinkError aborts the request with the given error, answering with the API's "does not exist" code
+// if the error is about a missing link.
+func abortWithLinkError(c *gin.Context, err error) {
+	if errors.Is(err, backend.ErrNoSuchLink) {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, proton.APIError{
+			Code:    proton.NotExistsCode,
+			Message: err.Error(),
+		})
+
+		return
+	}
+
+	_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+}
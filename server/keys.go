@@ -35,3 +35,89 @@ func (s *Server) handleGetKeySalts() gin.HandlerFunc {
 		})
 	}
 }
+
+func (s *Server) handlePostKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.handleCreateAddressKey(c, true)
+	}
+}
+
+func (s *Server) handlePostAddressKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.handleCreateAddressKey(c, false)
+	}
+}
+
+func (s *Server) handleCreateAddressKey(c *gin.Context, legacy bool) {
+	var req proton.CreateAddressKeyReq
+
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	key, err := s.b.AddAddressKey(c.GetString("UserID"), req, legacy)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"Key": key,
+	})
+}
+
+func (s *Server) handlePutKeyPrimary() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.MakeAddressKeyPrimaryReq
+
+		if err := c.BindJSON(&req); err != nil {
+			return
+		}
+
+		if err := s.b.MakeAddressKeyPrimary(c.GetString("UserID"), c.Param("keyID"), req.SignedKeyList); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handlePostKeyDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			SignedKeyList proton.KeyList
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			return
+		}
+
+		if err := s.b.DeleteAddressKey(c.GetString("UserID"), c.Param("keyID"), req.SignedKeyList); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handlePutKeysPrivate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			proton.UpdatePrivateKeysReq
+			proton.AuthReq
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			return
+		}
+
+		ephemeral, proof, err := decodeSRPProofs(req.AuthReq)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.b.UpdatePrivateKeys(c.GetString("UserID"), ephemeral, proof, req.SRPSession, req.UpdatePrivateKeysReq); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
@@ -28,6 +28,7 @@ type ServerClient interface {
 	CreateAddress(ctx context.Context, in *CreateAddressRequest, opts ...grpc.CallOption) (*CreateAddressResponse, error)
 	RemoveAddress(ctx context.Context, in *RemoveAddressRequest, opts ...grpc.CallOption) (*RemoveAddressResponse, error)
 	CreateLabel(ctx context.Context, in *CreateLabelRequest, opts ...grpc.CallOption) (*CreateLabelResponse, error)
+	CreateCalendar(ctx context.Context, in *CreateCalendarRequest, opts ...grpc.CallOption) (*CreateCalendarResponse, error)
 }
 
 type serverClient struct {
@@ -92,6 +93,15 @@ func (c *serverClient) CreateLabel(ctx context.Context, in *CreateLabelRequest,
 	return out, nil
 }
 
+func (c *serverClient) CreateCalendar(ctx context.Context, in *CreateCalendarRequest, opts ...grpc.CallOption) (*CreateCalendarResponse, error) {
+	out := new(CreateCalendarResponse)
+	err := c.cc.Invoke(ctx, "/proto.Server/CreateCalendar", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ServerServer is the server API for Server service.
 // All implementations must embed UnimplementedServerServer
 // for forward compatibility
@@ -102,6 +112,7 @@ type ServerServer interface {
 	CreateAddress(context.Context, *CreateAddressRequest) (*CreateAddressResponse, error)
 	RemoveAddress(context.Context, *RemoveAddressRequest) (*RemoveAddressResponse, error)
 	CreateLabel(context.Context, *CreateLabelRequest) (*CreateLabelResponse, error)
+	CreateCalendar(context.Context, *CreateCalendarRequest) (*CreateCalendarResponse, error)
 	mustEmbedUnimplementedServerServer()
 }
 
@@ -127,6 +138,9 @@ func (UnimplementedServerServer) RemoveAddress(context.Context, *RemoveAddressRe
 func (UnimplementedServerServer) CreateLabel(context.Context, *CreateLabelRequest) (*CreateLabelResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateLabel not implemented")
 }
+func (UnimplementedServerServer) CreateCalendar(context.Context, *CreateCalendarRequest) (*CreateCalendarResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCalendar not implemented")
+}
 func (UnimplementedServerServer) mustEmbedUnimplementedServerServer() {}
 
 // UnsafeServerServer may be embedded to opt out of forward compatibility for this service.
@@ -248,6 +262,24 @@ func _Server_CreateLabel_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Server_CreateCalendar_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCalendarRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerServer).CreateCalendar(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Server/CreateCalendar",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerServer).CreateCalendar(ctx, req.(*CreateCalendarRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Server_ServiceDesc is the grpc.ServiceDesc for Server service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -279,6 +311,10 @@ var Server_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CreateLabel",
 			Handler:    _Server_CreateLabel_Handler,
 		},
+		{
+			MethodName: "CreateCalendar",
+			Handler:    _Server_CreateCalendar_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "server.proto",
@@ -19,6 +19,14 @@ import (
 )
 
 func initRouter(s *Server) {
+	// Web key directory routes are served to any HTTP client, so they are registered before the API middleware.
+	if wkd := s.r.Group("/.well-known/openpgpkey"); wkd != nil {
+		wkd.GET("/policy", s.handleGetWKDPolicy())
+		wkd.GET("/hu/:hash", s.handleGetWKDKey())
+		wkd.GET("/:domain/policy", s.handleGetWKDPolicy())
+		wkd.GET("/:domain/hu/:hash", s.handleGetWKDKey())
+	}
+
 	s.r.Use(
 		s.requireValidAppVersion(),
 		s.setSessionCookie(),
@@ -63,6 +71,11 @@ func initRouter(s *Server) {
 			if keys := core.Group("/keys"); keys != nil {
 				keys.GET("", s.handleGetKeys())
 				keys.GET("/salts", s.handleGetKeySalts())
+				keys.POST("", s.handlePostKeys())
+				keys.POST("/address", s.handlePostAddressKeys())
+				keys.PUT("/:keyID/primary", s.handlePutKeyPrimary())
+				keys.POST("/:keyID/delete", s.handlePostKeyDelete())
+				keys.PUT("/private", s.handlePutKeysPrivate())
 			}
 
 			if events := core.Group("/events"); events != nil {
@@ -73,6 +86,7 @@ func initRouter(s *Server) {
 				settings.GET("", s.handleGetUserSettings())
 				settings.PUT("/telemetry", s.handlePutUserSettingsTelemetry())
 				settings.PUT("/crashreports", s.handlePutUserSettingsCrashReports())
+				settings.PUT("/password", s.handlePutUserSettingsPassword())
 			}
 		}
 	}
@@ -115,9 +129,13 @@ func initRouter(s *Server) {
 	if contacts := s.r.Group("/contacts/v4", s.requireAuth()); contacts != nil {
 		contacts.GET("", s.handleGetContacts())
 		contacts.POST("", s.handlePostContacts())
+		contacts.DELETE("", s.handleDeleteContacts())
+		contacts.PUT("/delete", s.handlePutContactsDelete())
 		contacts.GET("/:contactID", s.handleGetContact())
 		contacts.PUT("/:contactID", s.handlePutContact())
 		contacts.GET("/emails", s.handleGetContactsEmails())
+		contacts.PUT("/emails/label", s.handlePutContactsEmailsLabel())
+		contacts.PUT("/emails/unlabel", s.handlePutContactsEmailsUnlabel())
 	}
 
 	// All data routes need authentication.
@@ -128,11 +146,90 @@ func initRouter(s *Server) {
 		}
 	}
 
+	// All calendar routes need authentication.
+	if calendars := s.r.Group("/calendar/v1", s.requireAuth()); calendars != nil {
+		calendars.GET("", s.handleGetCalendars())
+		calendars.GET("/invitations", s.handleGetPendingCalendarInvitations())
+		calendars.GET("/:calendarID", s.handleGetCalendar())
+		calendars.GET("/:calendarID/keys", s.handleGetCalendarKeys())
+		calendars.GET("/:calendarID/members", s.handleGetCalendarMembers())
+		calendars.PUT("/:calendarID/members/:memberID", s.handlePutCalendarMember())
+		calendars.DELETE("/:calendarID/members/:memberID", s.handleDeleteCalendarMember())
+		calendars.GET("/:calendarID/invitations", s.handleGetCalendarInvitations())
+		calendars.POST("/:calendarID/invitations", s.handlePostCalendarInvitation())
+		calendars.PUT("/:calendarID/invitations/:invitationID/accept", s.handlePutCalendarInvitationAccept())
+		calendars.PUT("/:calendarID/invitations/:invitationID/decline", s.handlePutCalendarInvitationDecline())
+		calendars.DELETE("/:calendarID/invitations/:invitationID", s.handleDeleteCalendarInvitation())
+		calendars.GET("/:calendarID/passphrase", s.handleGetCalendarPassphrase())
+		calendars.GET("/:calendarID/events", s.handleGetCalendarEvents())
+		calendars.GET("/:calendarID/events/:eventID", s.handleGetCalendarEvent())
+		calendars.PUT("/:calendarID/events/sync", s.handlePutCalendarEventsSync())
+		calendars.PUT("/:calendarID/events/:eventID/attendees/:attendeeID", s.handlePutCalendarEventAttendee())
+		calendars.GET("/:calendarID/modelevents/latest", s.handleGetCalendarModelEventsLatest())
+		calendars.GET("/:calendarID/modelevents/:eventID", s.handleGetCalendarModelEvents())
+	}
+
+	if drive := s.r.Group("/drive"); drive != nil {
+		// Share URL routes are accessed anonymously with the share URL password.
+		if urls := drive.Group("/urls/:token"); urls != nil {
+			urls.POST("/info", s.handlePostDriveURLInfo())
+			urls.POST("/auth", s.handlePostDriveURLAuth())
+			urls.GET("", s.requireShareURLAuth(), s.handleGetDriveURL())
+		}
+
+		// All other drive routes need authentication.
+		if drive := drive.Group("", s.requireAuth()); drive != nil {
+			if volumes := drive.Group("/volumes"); volumes != nil {
+				volumes.GET("", s.handleGetDriveVolumes())
+				volumes.POST("", s.handlePostDriveVolumes())
+				volumes.GET("/:volumeID", s.handleGetDriveVolume())
+				volumes.POST("/:volumeID/shares", s.handlePostDriveVolumeShares())
+				volumes.GET("/:volumeID/events/latest", s.handleGetDriveVolumeEventsLatest())
+				volumes.GET("/:volumeID/events/:eventID", s.handleGetDriveVolumeEvents())
+			}
+
+			if shares := drive.Group("/shares"); shares != nil {
+				shares.GET("", s.handleGetDriveShares())
+				shares.GET("/:shareID", s.handleGetDriveShare())
+				shares.DELETE("/:shareID", s.handleDeleteDriveShare())
+				shares.GET("/:shareID/links/:linkID", s.handleGetDriveLink())
+				shares.PUT("/:shareID/links/:linkID/move", s.handlePutDriveLinkMove())
+				shares.POST("/:shareID/folders", s.handlePostDriveFolders())
+				shares.GET("/:shareID/folders/:linkID/children", s.handleGetDriveFolderChildren())
+				shares.POST("/:shareID/folders/:linkID/trash_multiple", s.handlePostDriveFolderTrashMultiple())
+				shares.POST("/:shareID/folders/:linkID/delete_multiple", s.handlePostDriveFolderDeleteMultiple())
+				shares.POST("/:shareID/files", s.handlePostDriveFiles())
+				shares.GET("/:shareID/files/:linkID/revisions", s.handleGetDriveFileRevisions())
+				shares.POST("/:shareID/files/:linkID/revisions", s.handlePostDriveFileRevisions())
+				shares.GET("/:shareID/files/:linkID/revisions/:revisionID", s.handleGetDriveFileRevision())
+				shares.PUT("/:shareID/files/:linkID/revisions/:revisionID", s.handlePutDriveFileRevision())
+				shares.DELETE("/:shareID/files/:linkID/revisions/:revisionID", s.handleDeleteDriveFileRevision())
+				shares.POST("/:shareID/files/:linkID/revisions/:revisionID/restore", s.handlePostDriveFileRevisionRestore())
+				shares.GET("/:shareID/files/:linkID/revisions/:revisionID/thumbnail", s.handleGetDriveFileRevisionThumbnail())
+				shares.GET("/:shareID/urls", s.handleGetDriveShareURLs())
+				shares.POST("/:shareID/urls", s.handlePostDriveShareURLs())
+				shares.PUT("/:shareID/urls/:shareURLID", s.handlePutDriveShareURL())
+				shares.DELETE("/:shareID/urls/:shareURLID", s.handleDeleteDriveShareURL())
+			}
+
+			if blocks := drive.Group("/blocks"); blocks != nil {
+				blocks.POST("", s.handlePostDriveBlocks())
+			}
+		}
+	}
+
+	// Storage routes are authenticated with the block token.
+	if storage := s.r.Group("/storage"); storage != nil {
+		storage.GET("/blocks", s.handleGetStorageBlocks())
+		storage.POST("/blocks", s.handlePostStorageBlocks())
+	}
+
 	// Top level auth routes don't need authentication.
 	if auth := s.r.Group("/auth/v4"); auth != nil {
 		auth.POST("", s.handlePostAuth())
 		auth.POST("/info", s.handlePostAuthInfo())
 		auth.POST("/refresh", s.handlePostAuthRefresh())
+		auth.GET("/modulus", s.handleGetAuthModulus())
 
 		// These routes require auth.
 		if auth := auth.Group("", s.requireAuth()); auth != nil {
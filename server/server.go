@@ -120,6 +120,22 @@ func (s *Server) CreateUser(username string, password []byte) (string, string, e
 	return userID, addrID, nil
 }
 
+// CreateUserWithMailboxPassword creates a new server user in two-password mode: the user logs in with the password
+// and unlocks their keys with the mailbox password. A single address is created for the user, as with CreateUser.
+func (s *Server) CreateUserWithMailboxPassword(username string, password, mailboxPassword []byte) (string, string, error) {
+	userID, err := s.b.CreateUserWithMailboxPassword(username, password, mailboxPassword)
+	if err != nil {
+		return "", "", err
+	}
+
+	addrID, err := s.b.CreateAddress(userID, username+"@"+s.domain, mailboxPassword, true, proton.AddressStatusEnabled, proton.AddressTypeOriginal)
+	if err != nil {
+		return "", "", err
+	}
+
+	return userID, addrID, nil
+}
+
 func (s *Server) RemoveUser(userID string) error {
 	return s.b.RemoveUser(userID)
 }
@@ -204,6 +220,10 @@ func (s *Server) UnlabelMessage(userID, msgID, labelID string) error {
 	return s.b.UnlabelMessages(userID, labelID, msgID)
 }
 
+func (s *Server) CreateCalendar(userID, addrID string, password []byte, name string) (string, error) {
+	return s.b.CreateCalendar(userID, addrID, password, name)
+}
+
 func (s *Server) AddAddressCreatedEvent(userID, addrID string) error {
 	return s.b.AddAddressCreatedUpdate(userID, addrID)
 }
@@ -1,19 +1,31 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net"
 	"net/http"
 	"net/mail"
 	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
@@ -21,15 +33,19 @@ import (
 	"github.com/ProtonMail/gluon/rfc822"
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/go-proton-api/server/backend"
+	"github.com/ProtonMail/go-srp"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/gopenpgp/v2/helper"
 	"github.com/bradenaw/juniper/iterator"
 	"github.com/bradenaw/juniper/parallel"
 	"github.com/bradenaw/juniper/stream"
 	"github.com/bradenaw/juniper/xslices"
+	"github.com/emersion/go-ical"
 	"github.com/emersion/go-vcard"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 )
 
@@ -1484,6 +1500,167 @@ func TestServer_PublicKeys(t *testing.T) {
 	})
 }
 
+func TestServer_SendPreferences(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		if _, _, err := s.CreateUser("other", []byte("pass")); err != nil {
+			t.Fatal(err)
+		}
+
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+
+			addrKR := addrKRs[addr[0].ID]
+
+			var (
+				internal = "other@" + s.GetDomain()
+				pinned   = "pinned@example.com"
+				signed   = "signed@example.com"
+				plain    = "plain@example.com"
+			)
+
+			pinnedKey, err := crypto.GenerateKey("pinned", pinned, "x25519", 0)
+			require.NoError(t, err)
+
+			otherKey, err := crypto.GenerateKey("other", internal, "x25519", 0)
+			require.NoError(t, err)
+
+			// Pin settings in the contacts of some recipients.
+			newContact := func(email string, settings proton.ContactSettings) proton.ContactCards {
+				contact := proton.Contact{ContactCards: proton.ContactCards{Cards: proton.Cards{createVCard(t, addrKR, email, email)}}}
+				require.NoError(t, contact.SetSettings(addrKR, email, proton.CardTypeSigned, settings))
+				return contact.ContactCards
+			}
+
+			// The internal recipient's contact pins one of their keys along with a key that isn't theirs.
+			pubKeys, _, err := c.GetPublicKeys(ctx, internal)
+			require.NoError(t, err)
+
+			apiKey, err := crypto.NewKeyFromArmored(pubKeys[0].PublicKey)
+			require.NoError(t, err)
+
+			var pinnedSettings, signedSettings, internalSettings proton.ContactSettings
+
+			pinnedSettings.SetScheme(proton.PGPInlineScheme)
+			pinnedSettings.AddKey(pinnedKey)
+			signedSettings.SetSign(true)
+			internalSettings.AddKey(otherKey)
+			internalSettings.AddKey(apiKey)
+
+			_, err = c.CreateContacts(ctx, proton.CreateContactsReq{Contacts: []proton.ContactCards{
+				newContact(pinned, pinnedSettings),
+				newContact(signed, signedSettings),
+				newContact(internal, internalSettings),
+			}})
+			require.NoError(t, err)
+
+			prefs, err := c.ResolveSendPreferences(ctx, addrKR, []string{internal, pinned, signed, plain})
+			require.NoError(t, err)
+			require.Len(t, prefs, 4)
+
+			// Internal recipients get encrypted messages in the draft format, to the key of theirs that is pinned.
+			require.True(t, prefs[internal].Encrypt)
+			require.Equal(t, proton.InternalScheme, prefs[internal].EncryptionScheme)
+			require.Equal(t, proton.DetachedSignature, prefs[internal].SignatureType)
+			require.Equal(t, rfc822.TextHTML, prefs[internal].MIMEType)
+			require.Equal(t, apiKey.GetFingerprint(), prefs[internal].PubKey.GetKeys()[0].GetFingerprint())
+
+			// External recipients with a pinned key get encrypted messages with the pinned scheme.
+			require.True(t, prefs[pinned].Encrypt)
+			require.Equal(t, proton.PGPInlineScheme, prefs[pinned].EncryptionScheme)
+			require.Equal(t, proton.DetachedSignature, prefs[pinned].SignatureType)
+			require.Equal(t, rfc822.TextPlain, prefs[pinned].MIMEType)
+			require.Equal(t, pinnedKey.GetFingerprint(), prefs[pinned].PubKey.GetKeys()[0].GetFingerprint())
+
+			// External recipients without keys get signed messages if their contact says so.
+			require.False(t, prefs[signed].Encrypt)
+			require.Equal(t, proton.ClearMIMEScheme, prefs[signed].EncryptionScheme)
+			require.Equal(t, proton.DetachedSignature, prefs[signed].SignatureType)
+			require.Equal(t, rfc822.MultipartMixed, prefs[signed].MIMEType)
+
+			// ... and clear messages otherwise.
+			require.False(t, prefs[plain].Encrypt)
+			require.Equal(t, proton.ClearScheme, prefs[plain].EncryptionScheme)
+			require.Equal(t, proton.NoSignature, prefs[plain].SignatureType)
+			require.Equal(t, rfc822.TextHTML, prefs[plain].MIMEType)
+
+			// The recipients are grouped into packages by MIME type.
+			draft, err := c.CreateDraft(ctx, addrKR, proton.CreateDraftReq{
+				Message: proton.DraftTemplate{
+					Subject:  "My subject",
+					Sender:   &mail.Address{Address: addr[0].Email},
+					ToList:   xslices.Map([]string{internal, pinned, signed, plain}, func(email string) *mail.Address { return &mail.Address{Address: email} }),
+					Body:     "<p>Hello</p>",
+					MIMEType: rfc822.TextHTML,
+				},
+			})
+			require.NoError(t, err)
+
+			req, err := proton.BuildSendDraftReq(addrKR, prefs, map[rfc822.MIMEType]string{
+				rfc822.TextHTML:       "<p>Hello</p>",
+				rfc822.TextPlain:      "Hello",
+				rfc822.MultipartMixed: "Content-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\nContent-Type: text/plain\r\n\r\nHello\r\n--x--\r\n",
+			}, nil)
+			require.NoError(t, err)
+			require.Len(t, req.Packages, 3)
+
+			for _, pkg := range req.Packages {
+				switch pkg.MIMEType {
+				case rfc822.TextPlain:
+					require.ElementsMatch(t, []string{pinned}, maps.Keys(pkg.Addresses))
+
+				case rfc822.TextHTML:
+					require.ElementsMatch(t, []string{internal, plain}, maps.Keys(pkg.Addresses))
+
+				case rfc822.MultipartMixed:
+					require.ElementsMatch(t, []string{signed}, maps.Keys(pkg.Addresses))
+				}
+			}
+
+			_, err = c.SendDraft(ctx, draft.ID, req)
+			require.NoError(t, err)
+
+			// A body is needed for each MIME type.
+			_, err = proton.BuildSendDraftReq(addrKR, prefs, map[rfc822.MIMEType]string{rfc822.TextHTML: "<p>Hello</p>"}, nil)
+			require.Error(t, err)
+
+			// The mail settings apply to recipients without contact settings.
+			_, err = c.SetSignExternalMessages(ctx, proton.SetSignExternalMessagesReq{Sign: proton.SignExternalMessagesEnabled})
+			require.NoError(t, err)
+
+			_, err = c.SetAttachPublicKey(ctx, proton.SetAttachPublicKeyReq{AttachPublicKey: true})
+			require.NoError(t, err)
+
+			prefs, err = c.ResolveSendPreferences(ctx, addrKR, []string{plain})
+			require.NoError(t, err)
+			require.Equal(t, proton.ClearMIMEScheme, prefs[plain].EncryptionScheme)
+			require.Equal(t, proton.DetachedSignature, prefs[plain].SignatureType)
+			require.True(t, prefs[plain].AttachPublicKey)
+		})
+
+		// The internal recipient received the message.
+		other, _, err := m.NewClientWithLogin(ctx, "other", []byte("pass"))
+		require.NoError(t, err)
+		defer other.Close()
+
+		count, err := other.CountMessages(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+}
+
 func TestServer_Proxy(t *testing.T) {
 	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
 		var calls []Call
@@ -2516,125 +2693,3325 @@ func TestServer_ContactEmailsRepeated(t *testing.T) {
 	})
 }
 
-func createVCard(t *testing.T, addrKR *crypto.KeyRing, name string, email ...string) *proton.Card {
-	card, err := proton.NewCard(addrKR, proton.CardTypeSigned)
-	require.NoError(t, err)
+func TestServer_ContactsImport_BatchError(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
 
-	require.NoError(t, card.Set(addrKR, vcard.FieldUID, &vcard.Field{Value: fmt.Sprintf("proton-legacy-%v", uuid.NewString()), Group: "test"}))
-	require.NoError(t, card.Set(addrKR, vcard.FieldFormattedName, &vcard.Field{Value: name, Group: "test"}))
-	for _, email := range email {
-		require.NoError(t, card.Add(addrKR, vcard.FieldEmail, &vcard.Field{Value: email, Group: "test"}))
-	}
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
 
-	return card
-}
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
 
-func withServer(t *testing.T, fn func(ctx context.Context, s *Server, m *proton.Manager), opts ...Option) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
 
-	s := New(opts...)
-	defer s.Close()
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
 
-	m := proton.New(
-		proton.WithHostURL(s.GetHostURL()),
-		proton.WithCookieJar(newTestCookieJar()),
-		proton.WithTransport(proton.InsecureTransport()),
-	)
-	defer m.Close()
+			// The request creating the second batch fails.
+			var calls int32
 
-	fn(ctx, s, m)
-}
+			s.AddStatusHook(func(req *http.Request) (int, bool) {
+				if req.Method == http.MethodPost && req.URL.Path == "/contacts/v4" && atomic.AddInt32(&calls, 1) == 2 {
+					return http.StatusUnprocessableEntity, true
+				}
 
-func withUser(ctx context.Context, t *testing.T, s *Server, m *proton.Manager, user, pass string, fn func(c *proton.Client)) {
-	_, _, err := s.CreateUser(user, []byte(pass))
-	require.NoError(t, err)
+				return 0, false
+			})
 
-	c, _, err := m.NewClientWithLogin(ctx, user, []byte(pass))
-	require.NoError(t, err)
-	defer c.Close()
+			buf := new(bytes.Buffer)
 
-	fn(c)
+			for i := 0; i < 12; i++ {
+				fmt.Fprintf(buf, "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Contact %v\r\nEMAIL:contact%v@example.com\r\nEND:VCARD\r\n", i, i)
+			}
+
+			// The contacts of the first batch are still returned, and those of the second batch hold the error.
+			res, err := c.ImportContacts(ctx, addrKRs[addr[0].ID], buf)
+			require.NoError(t, err)
+			require.Len(t, res, 12)
+
+			for i, item := range res {
+				if i < 10 {
+					require.NoError(t, item.Err)
+					require.NotEmpty(t, item.Contact.ID)
+				} else {
+					require.Error(t, item.Err)
+				}
+			}
+		})
+	})
 }
 
-func withMessages(ctx context.Context, t *testing.T, c *proton.Client, pass string, count int, fn func([]string)) {
-	user, err := c.GetUser(ctx)
-	require.NoError(t, err)
+func TestServer_ContactsImport_ReadError(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
 
-	addr, err := c.GetAddresses(ctx)
-	require.NoError(t, err)
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
 
-	salt, err := c.GetSalts(ctx)
-	require.NoError(t, err)
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
 
-	keyPass, err := salt.SaltForKey([]byte(pass), user.Keys.Primary().ID)
-	require.NoError(t, err)
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
 
-	_, addrKRs, err := proton.Unlock(user, addr, keyPass, async.NoopPanicHandler{})
-	require.NoError(t, err)
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
 
-	fn(xslices.Map(importMessages(ctx, t, c, addr[0].ID, addrKRs[addr[0].ID], []string{}, proton.MessageFlagReceived, count), func(res proton.ImportRes) string {
-		return res.MessageID
-	}))
-}
+			readErr := errors.New("read failed")
 
-func importMessagesWithSubjectGenerator(
-	ctx context.Context,
-	t *testing.T,
-	c *proton.Client,
-	addrID string,
-	addrKR *crypto.KeyRing,
-	labelIDs []string,
-	flags proton.MessageFlag,
-	count int,
-	subjectGenerator func() string,
-) []proton.ImportRes {
-	req := iterator.Collect(iterator.Map(iterator.Counter(count), func(int) proton.ImportReq {
-		return proton.ImportReq{
-			Metadata: proton.ImportMetadata{
-				AddressID: addrID,
-				LabelIDs:  labelIDs,
-				Flags:     flags,
-				Unread:    true,
-			},
-			Message: newMessageLiteralWithSubject("sender@example.com", "recipient@example.com", subjectGenerator()),
-		}
-	}))
+			// A reader which always fails stops the import instead of being read forever.
+			res, err := c.ImportContacts(ctx, addrKRs[addr[0].ID], iotest.ErrReader(readErr))
+			require.ErrorIs(t, err, readErr)
+			require.Empty(t, res)
 
-	str, err := c.ImportMessages(ctx, addrKR, runtime.NumCPU(), runtime.NumCPU(), req...)
-	require.NoError(t, err)
+			// The same goes for a reader which fails after some valid vCards.
+			r := io.MultiReader(
+				strings.NewReader("BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Contact\r\nEMAIL:contact@example.com\r\nEND:VCARD\r\n"),
+				iotest.ErrReader(readErr),
+			)
 
-	res, err := stream.Collect(ctx, str)
-	require.NoError(t, err)
+			_, err = c.ImportContacts(ctx, addrKRs[addr[0].ID], r)
+			require.ErrorIs(t, err, readErr)
 
-	return res
+			contacts, err := c.GetAllContacts(ctx)
+			require.NoError(t, err)
+			require.Empty(t, contacts)
+		})
+	})
 }
 
-func importMessages(
-	ctx context.Context,
-	t *testing.T,
-	c *proton.Client,
-	addrID string,
-	addrKR *crypto.KeyRing,
-	labelIDs []string,
-	flags proton.MessageFlag,
-	count int,
-) []proton.ImportRes {
-	return importMessagesWithSubjectGenerator(ctx, t, c, addrID, addrKR, labelIDs, flags, count, func() string {
-		return uuid.NewString()
+func TestServer_ContactsImport_Junk(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+
+			card := func(i int) string {
+				return fmt.Sprintf("BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Contact %v\r\nEMAIL:contact%v@example.com\r\nEND:VCARD\r\n", i, i)
+			}
+
+			// Stray text and a vCard with a bad BEGIN value each count as a single invalid vCard.
+			r := strings.NewReader(card(0) +
+				"\r\nsome junk\r\nmore junk\r\n" +
+				card(1) +
+				"BEGIN:VCRD\r\nVERSION:3.0\r\nFN:Bad\r\nEND:VCARD\r\n" +
+				card(2))
+
+			res, err := c.ImportContacts(ctx, addrKRs[addr[0].ID], r)
+			require.NoError(t, err)
+			require.Len(t, res, 5)
+
+			for i, item := range res {
+				require.Equal(t, i, item.Index)
+
+				if i%2 == 0 {
+					require.NoError(t, item.Err)
+					require.Equal(t, fmt.Sprintf("Contact %v", i/2), item.Contact.Name)
+				} else {
+					require.Error(t, item.Err)
+				}
+			}
+		})
 	})
 }
 
-func countBytesRead(ctl *proton.NetCtl, fn func()) uint64 {
-	var read uint64
+func TestServer_ContactsImportExport(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
 
-	ctl.OnRead(func(b []byte) {
-		atomic.AddUint64(&read, uint64(len(b)))
-	})
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
 
-	fn()
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
 
-	return read
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+
+			addrKR := addrKRs[addr[0].ID]
+
+			// More contacts than fit in a single request, one of which has neither a name nor an email.
+			buf := new(bytes.Buffer)
+
+			for i := 0; i < 12; i++ {
+				fmt.Fprintf(buf, "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Contact %v\r\nEMAIL:contact%v@example.com\r\nTEL:+41 22 000 00 %02d\r\nEND:VCARD\r\n", i, i, i)
+
+				if i == 5 {
+					buf.WriteString("BEGIN:VCARD\r\nVERSION:4.0\r\nNOTE:Nobody\r\nEND:VCARD\r\n")
+				}
+			}
+
+			res, err := c.ImportContacts(ctx, addrKR, buf)
+			require.NoError(t, err)
+			require.Len(t, res, 13)
+
+			for i, item := range res {
+				require.Equal(t, i, item.Index)
+
+				if i == 6 {
+					require.Error(t, item.Err)
+				} else {
+					require.NoError(t, item.Err)
+					require.NotEmpty(t, item.Contact.ID)
+				}
+			}
+
+			require.Equal(t, "Contact 6", res[7].Contact.Name)
+
+			contacts, err := c.GetAllContacts(ctx)
+			require.NoError(t, err)
+			require.Len(t, contacts, 12)
+
+			// The exported vCards hold the fields of both the signed and the encrypted cards.
+			out := new(bytes.Buffer)
+
+			require.NoError(t, c.ExportContacts(ctx, addrKR, out))
+
+			dec := vcard.NewDecoder(out)
+
+			var exported []vcard.Card
+
+			for {
+				card, err := dec.Decode()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				require.NoError(t, err)
+
+				exported = append(exported, card)
+			}
+
+			require.Len(t, exported, 12)
+
+			for i := 0; i < 12; i++ {
+				idx := xslices.IndexFunc(exported, func(card vcard.Card) bool {
+					return card.Value(vcard.FieldFormattedName) == fmt.Sprintf("Contact %v", i)
+				})
+				require.NotEqual(t, -1, idx)
+
+				card := exported[idx]
+
+				require.Equal(t, "4.0", card.Value(vcard.FieldVersion))
+				require.Equal(t, fmt.Sprintf("contact%v@example.com", i), card.Value(vcard.FieldEmail))
+				require.Equal(t, fmt.Sprintf("+41 22 000 00 %02d", i), card.Value(vcard.FieldTelephone))
+			}
+		})
+	})
+}
+
+func TestServer_ContactEvents(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+
+			addrKR := addrKRs[addr[0].ID]
+
+			// getEvents returns the contact events that happen during fn.
+			getEvents := func(fn func()) ([]proton.ContactEvent, []proton.ContactEmailEvent) {
+				eventID, err := c.GetLatestEventID(ctx)
+				require.NoError(t, err)
+
+				fn()
+
+				events, _, err := c.GetEvent(ctx, eventID)
+				require.NoError(t, err)
+
+				var (
+					contacts []proton.ContactEvent
+					emails   []proton.ContactEmailEvent
+				)
+
+				for _, event := range events {
+					contacts = append(contacts, event.Contacts...)
+					emails = append(emails, event.ContactEmails...)
+				}
+
+				return contacts, emails
+			}
+
+			var contact proton.Contact
+
+			// Creating a contact creates it and its emails.
+			contacts, emails := getEvents(func() {
+				res, err := c.CreateContacts(ctx, proton.CreateContactsReq{
+					Contacts: []proton.ContactCards{{Cards: proton.Cards{createVCard(t, addrKR, "foo", "a@bar.com", "b@bar.com")}}},
+				})
+				require.NoError(t, err)
+				require.Len(t, res, 1)
+
+				contact = res[0].Response.Contact
+			})
+
+			require.Len(t, contacts, 1)
+			require.Equal(t, proton.EventCreate, contacts[0].Action)
+			require.Equal(t, contact.ID, contacts[0].ID)
+			require.Equal(t, "foo", contacts[0].Contact.Name)
+
+			require.Len(t, emails, 2)
+			require.True(t, xslices.All(emails, func(event proton.ContactEmailEvent) bool {
+				return event.Action == proton.EventCreate && event.ContactEmail.ContactID == contact.ID
+			}))
+
+			emailIDs := make(map[string]string)
+
+			for _, event := range emails {
+				emailIDs[event.ContactEmail.Email] = event.ID
+			}
+
+			// Updating a contact updates the emails it keeps, creates the new ones and deletes the others.
+			contacts, emails = getEvents(func() {
+				_, err := c.UpdateContact(ctx, contact.ID, proton.UpdateContactReq{
+					Cards: proton.Cards{createVCard(t, addrKR, "bar", "b@bar.com", "c@bar.com")},
+				})
+				require.NoError(t, err)
+			})
+
+			require.Len(t, contacts, 1)
+			require.Equal(t, proton.EventUpdate, contacts[0].Action)
+			require.Equal(t, "bar", contacts[0].Contact.Name)
+
+			require.Len(t, emails, 3)
+
+			for _, event := range emails {
+				switch event.Action {
+				case proton.EventCreate:
+					require.Equal(t, "c@bar.com", event.ContactEmail.Email)
+
+				case proton.EventUpdate:
+					require.Equal(t, emailIDs["b@bar.com"], event.ID)
+					require.Equal(t, "bar", event.ContactEmail.Name)
+
+				case proton.EventDelete:
+					require.Equal(t, emailIDs["a@bar.com"], event.ID)
+
+				default:
+					t.Fatalf("unexpected action %v", event.Action)
+				}
+			}
+
+			// Deleting a contact deletes it and its emails.
+			contacts, emails = getEvents(func() {
+				require.NoError(t, c.DeleteContacts(ctx, proton.DeleteContactsReq{IDs: []string{contact.ID}}))
+			})
+
+			require.Len(t, contacts, 1)
+			require.Equal(t, proton.EventDelete, contacts[0].Action)
+			require.Equal(t, contact.ID, contacts[0].ID)
+
+			require.Len(t, emails, 2)
+			require.True(t, xslices.All(emails, func(event proton.ContactEmailEvent) bool {
+				return event.Action == proton.EventDelete
+			}))
+
+			// A contact can hold the same email twice; each one keeps its own ID.
+			res, err := c.CreateContacts(ctx, proton.CreateContactsReq{
+				Contacts: []proton.ContactCards{{Cards: proton.Cards{createVCard(t, addrKR, "twice", "d@bar.com")}}},
+			})
+			require.NoError(t, err)
+			require.Len(t, res, 1)
+
+			updated, err := c.UpdateContact(ctx, res[0].Response.Contact.ID, proton.UpdateContactReq{
+				Cards: proton.Cards{createVCard(t, addrKR, "twice", "d@bar.com", "d@bar.com")},
+			})
+			require.NoError(t, err)
+			require.Len(t, updated.ContactEmails, 2)
+			require.NotEqual(t, updated.ContactEmails[0].ID, updated.ContactEmails[1].ID)
+
+			_, emails = getEvents(func() {
+				require.NoError(t, c.DeleteContacts(ctx, proton.DeleteContactsReq{IDs: []string{updated.ID}}))
+			})
+
+			require.Len(t, emails, 2)
+			require.NotEqual(t, emails[0].ID, emails[1].ID)
+
+			// All contacts can be deleted at once.
+			_, err = c.CreateContacts(ctx, proton.CreateContactsReq{
+				Contacts: []proton.ContactCards{
+					{Cards: proton.Cards{createVCard(t, addrKR, "foo", "foo@bar.com")}},
+					{Cards: proton.Cards{createVCard(t, addrKR, "bar", "bar@bar.com")}},
+				},
+			})
+			require.NoError(t, err)
+
+			contacts, _ = getEvents(func() {
+				require.NoError(t, c.DeleteAllContacts(ctx))
+			})
+
+			require.Len(t, contacts, 2)
+
+			count, err := c.CountContacts(ctx)
+			require.NoError(t, err)
+			require.Zero(t, count)
+		})
+	})
+}
+
+func TestServer_ContactGroups(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+
+			addrKR := addrKRs[addr[0].ID]
+
+			res, err := c.CreateContacts(ctx, proton.CreateContactsReq{
+				Contacts: []proton.ContactCards{
+					{Cards: proton.Cards{createVCard(t, addrKR, "foo", "foo@bar.com", "foo@baz.com")}},
+					{Cards: proton.Cards{createVCard(t, addrKR, "bar", "bar@bar.com")}},
+				},
+			})
+			require.NoError(t, err)
+			require.Len(t, res, 2)
+
+			emailIDs := make(map[string]string)
+
+			for _, item := range res {
+				for _, email := range item.Response.Contact.ContactEmails {
+					emailIDs[email.Email] = email.ID
+				}
+			}
+
+			friends, err := c.CreateLabel(ctx, proton.CreateLabelReq{Name: "friends", Color: "#f66", Type: proton.LabelTypeContactGroup})
+			require.NoError(t, err)
+
+			family, err := c.CreateLabel(ctx, proton.CreateLabelReq{Name: "family", Color: "#f66", Type: proton.LabelTypeContactGroup})
+			require.NoError(t, err)
+
+			folder, err := c.CreateLabel(ctx, proton.CreateLabelReq{Name: "folder", Color: "#f66", Type: proton.LabelTypeFolder})
+			require.NoError(t, err)
+
+			eventID, err := c.GetLatestEventID(ctx)
+			require.NoError(t, err)
+
+			// Add emails to the groups.
+			require.NoError(t, c.LabelContactEmails(ctx, friends.ID, []string{emailIDs["foo@bar.com"], emailIDs["bar@bar.com"]}))
+			require.NoError(t, c.LabelContactEmails(ctx, family.ID, []string{emailIDs["bar@bar.com"]}))
+
+			// Only contact groups can be used.
+			require.Error(t, c.LabelContactEmails(ctx, folder.ID, []string{emailIDs["foo@baz.com"]}))
+
+			// The changes are notified by events.
+			events, _, err := c.GetEvent(ctx, eventID)
+			require.NoError(t, err)
+
+			var emailEvents []proton.ContactEmailEvent
+
+			for _, event := range events {
+				emailEvents = append(emailEvents, event.ContactEmails...)
+			}
+
+			require.Len(t, emailEvents, 2)
+
+			for _, event := range emailEvents {
+				require.Equal(t, proton.EventUpdate, event.Action)
+				require.Contains(t, event.ContactEmail.LabelIDs, friends.ID)
+			}
+
+			// The members of the groups can be listed.
+			members, err := c.GetContactGroupEmails(ctx, friends.ID)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{"foo@bar.com", "bar@bar.com"}, xslices.Map(members, func(email proton.ContactEmail) string {
+				return email.Email
+			}))
+
+			contact, err := c.GetContact(ctx, res[1].Response.Contact.ID)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{friends.ID, family.ID}, contact.LabelIDs)
+
+			// The groups can be expanded into recipients, each address once.
+			recipients, err := c.GetContactGroupRecipients(ctx, friends.ID, family.ID)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{"foo@bar.com", "bar@bar.com"}, xslices.Map(recipients, func(recipient *mail.Address) string {
+				return recipient.Address
+			}))
+
+			// Remove an email from a group.
+			require.NoError(t, c.UnlabelContactEmails(ctx, friends.ID, []string{emailIDs["foo@bar.com"]}))
+
+			members, err = c.GetContactGroupEmails(ctx, friends.ID)
+			require.NoError(t, err)
+			require.Len(t, members, 1)
+			require.Equal(t, "bar@bar.com", members[0].Email)
+
+			// Deleting a group removes its emails from it.
+			require.NoError(t, c.DeleteLabel(ctx, friends.ID))
+
+			contact, err = c.GetContact(ctx, res[1].Response.Contact.ID)
+			require.NoError(t, err)
+			require.Equal(t, []string{family.ID}, contact.LabelIDs)
+		})
+	})
+}
+
+func TestServer_ContactsMerge(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+
+			addrKR := addrKRs[addr[0].ID]
+
+			key, err := crypto.GenerateKey("foo", "foo@baz.com", "x25519", 0)
+			require.NoError(t, err)
+
+			pubKey, err := key.GetPublicKey()
+			require.NoError(t, err)
+
+			// Two duplicates, the second of which has settings for its emails, and an unrelated contact.
+			first := make(vcard.Card)
+			first.SetValue(vcard.FieldVersion, "4.0")
+			first.SetValue(vcard.FieldFormattedName, "Foo")
+			first.Add(vcard.FieldEmail, &vcard.Field{Value: "foo@bar.com", Group: "item1"})
+			first.SetValue(vcard.FieldTelephone, "1")
+
+			second := make(vcard.Card)
+			second.SetValue(vcard.FieldVersion, "4.0")
+			second.SetValue(vcard.FieldFormattedName, "foo")
+			second.Add(vcard.FieldEmail, &vcard.Field{Value: "FOO@bar.com", Group: "item1"})
+			second.Add(proton.FieldPMEncrypt, &vcard.Field{Value: "true", Group: "item1"})
+			second.Add(vcard.FieldEmail, &vcard.Field{Value: "foo@baz.com", Group: "item2"})
+			second.Add(proton.FieldPMSign, &vcard.Field{Value: "true", Group: "item2"})
+			second.Add(vcard.FieldKey, &vcard.Field{Value: "base64," + base64.StdEncoding.EncodeToString(pubKey), Group: "item2"})
+			second.SetValue(vcard.FieldTelephone, "2")
+			second.SetValue(vcard.FieldNote, "Note")
+
+			other := make(vcard.Card)
+			other.SetValue(vcard.FieldVersion, "4.0")
+			other.SetValue(vcard.FieldFormattedName, "Bar")
+			other.SetValue(vcard.FieldEmail, "bar@bar.com")
+
+			buf := new(bytes.Buffer)
+
+			for _, card := range []vcard.Card{first, second, other} {
+				require.NoError(t, vcard.NewEncoder(buf).Encode(card))
+			}
+
+			res, err := c.ImportContacts(ctx, addrKR, buf)
+			require.NoError(t, err)
+			require.Len(t, res, 3)
+
+			// Put the email of the second contact in a group.
+			group, err := c.CreateLabel(ctx, proton.CreateLabelReq{Name: "friends", Color: "#f66", Type: proton.LabelTypeContactGroup})
+			require.NoError(t, err)
+
+			idx := xslices.IndexFunc(res[1].Contact.ContactEmails, func(email proton.ContactEmail) bool { return email.Email == "foo@baz.com" })
+			require.NotEqual(t, -1, idx)
+			require.NoError(t, c.LabelContactEmails(ctx, group.ID, []string{res[1].Contact.ContactEmails[idx].ID}))
+
+			// The duplicates are found.
+			duplicates, err := c.GetDuplicateContacts(ctx, addrKR)
+			require.NoError(t, err)
+			require.Len(t, duplicates, 1)
+			require.ElementsMatch(t, []string{res[0].Contact.ID, res[1].Contact.ID}, xslices.Map(duplicates[0], func(contact proton.Contact) string {
+				return contact.ID
+			}))
+
+			// Merge them into the first one.
+			if duplicates[0][0].ID != res[0].Contact.ID {
+				duplicates[0][0], duplicates[0][1] = duplicates[0][1], duplicates[0][0]
+			}
+
+			// Contacts given without their cards are fetched, without modifying the given slice.
+			given := xslices.Map(duplicates[0], func(contact proton.Contact) proton.Contact {
+				return proton.Contact{ContactMetadata: contact.ContactMetadata}
+			})
+
+			merged, err := c.MergeContacts(ctx, addrKR, given)
+			require.NoError(t, err)
+			require.Empty(t, given[0].Cards)
+			require.Empty(t, given[1].Cards)
+			require.Equal(t, res[0].Contact.ID, merged.ID)
+			require.Equal(t, "Foo", merged.Name)
+			require.ElementsMatch(t, []string{"foo@bar.com", "foo@baz.com"}, xslices.Map(merged.ContactEmails, func(email proton.ContactEmail) string {
+				return email.Email
+			}))
+
+			// The emails stay in their groups.
+			members, err := c.GetContactGroupEmails(ctx, group.ID)
+			require.NoError(t, err)
+			require.Len(t, members, 1)
+			require.Equal(t, "foo@baz.com", members[0].Email)
+			require.Equal(t, merged.ID, members[0].ContactID)
+
+			// The fields of both contacts are kept.
+			card, err := proton.MergeContactCards(addrKR, merged.Cards)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{"1", "2"}, card.Values(vcard.FieldTelephone))
+			require.Equal(t, "Note", card.Value(vcard.FieldNote))
+			require.Len(t, card[vcard.FieldFormattedName], 1)
+
+			// The settings of the emails are kept.
+			settings, err := merged.GetSettings(addrKR, "foo@baz.com", proton.CardTypeSigned)
+			require.NoError(t, err)
+			require.NotNil(t, settings.Sign)
+			require.True(t, *settings.Sign)
+			require.Len(t, settings.Keys, 1)
+			require.Equal(t, key.GetFingerprint(), settings.Keys[0].GetFingerprint())
+
+			settings, err = merged.GetSettings(addrKR, "foo@bar.com", proton.CardTypeSigned)
+			require.NoError(t, err)
+			require.NotNil(t, settings.Encrypt)
+			require.True(t, *settings.Encrypt)
+
+			// The other duplicate is deleted.
+			contacts, err := c.GetAllContacts(ctx)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{res[0].Contact.ID, res[2].Contact.ID}, xslices.Map(contacts, func(contact proton.Contact) string {
+				return contact.ID
+			}))
+		})
+	})
+}
+
+func TestServer_ContactIndex(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+
+			addrKR := addrKRs[addr[0].ID]
+
+			res, err := c.CreateContacts(ctx, proton.CreateContactsReq{
+				Contacts: []proton.ContactCards{
+					{Cards: proton.Cards{createVCard(t, addrKR, "Alice Smith", "alice@example.com")}},
+					{Cards: proton.Cards{createVCard(t, addrKR, "Bob Jones", "bob@example.com")}},
+					{Cards: proton.Cards{createVCard(t, addrKR, "Bobby Tables", "bobby@example.com")}},
+				},
+			})
+			require.NoError(t, err)
+			require.Len(t, res, 3)
+
+			index, err := c.NewContactIndex(ctx)
+			require.NoError(t, err)
+
+			names := func(suggestions []proton.ContactSuggestion) []string {
+				return xslices.Map(suggestions, func(suggestion proton.ContactSuggestion) string { return suggestion.Name })
+			}
+
+			// Prefixes come first, then word prefixes, then fuzzy matches.
+			require.Equal(t, []string{"Bob Jones", "Bobby Tables"}, names(index.Suggest("bob", 0)))
+			require.Equal(t, []string{"Alice Smith"}, names(index.Suggest("SMI", 0)))
+			require.Equal(t, []string{"Alice Smith"}, names(index.Suggest("alsm", 0)))
+			require.Equal(t, []string{"Bob Jones"}, names(index.Suggest("bob", 1)))
+			require.Empty(t, index.Suggest("carol", 0))
+
+			eventID, err := c.GetLatestEventID(ctx)
+			require.NoError(t, err)
+
+			// Send a message to bobby; he is now ranked first.
+			draft, err := c.CreateDraft(ctx, addrKR, proton.CreateDraftReq{
+				Message: proton.DraftTemplate{
+					Subject: "Hello",
+					Sender:  &mail.Address{Address: addr[0].Email},
+					ToList:  []*mail.Address{{Address: "Bobby@example.com"}},
+				},
+			})
+			require.NoError(t, err)
+
+			_, err = c.SendDraft(ctx, draft.ID, proton.SendDraftReq{})
+			require.NoError(t, err)
+
+			// Put alice and bob in a group.
+			team, err := c.CreateLabel(ctx, proton.CreateLabelReq{Name: "Team", Color: "#f66", Type: proton.LabelTypeContactGroup})
+			require.NoError(t, err)
+
+			require.NoError(t, c.LabelContactEmails(ctx, team.ID, []string{
+				res[0].Response.Contact.ContactEmails[0].ID,
+				res[1].Response.Contact.ContactEmails[0].ID,
+			}))
+
+			events, _, err := c.GetEvent(ctx, eventID)
+			require.NoError(t, err)
+
+			for _, event := range events {
+				require.True(t, index.HandleEvent(event))
+			}
+
+			require.Equal(t, []string{"Bobby Tables", "Bob Jones"}, names(index.Suggest("bob", 0)))
+
+			// Groups expand to their emails.
+			suggestions := index.Suggest("tea", 0)
+			require.Len(t, suggestions, 1)
+			require.Equal(t, team.ID, suggestions[0].Group.ID)
+			require.Nil(t, suggestions[0].ContactEmail)
+			require.Equal(t, []*mail.Address{
+				{Name: "Alice Smith", Address: "alice@example.com"},
+				{Name: "Bob Jones", Address: "bob@example.com"},
+			}, suggestions[0].Recipients)
+
+			// Deleted contacts are removed from the suggestions and the groups.
+			eventID, err = c.GetLatestEventID(ctx)
+			require.NoError(t, err)
+
+			require.NoError(t, c.DeleteContacts(ctx, proton.DeleteContactsReq{IDs: []string{res[1].Response.Contact.ID}}))
+
+			events, _, err = c.GetEvent(ctx, eventID)
+			require.NoError(t, err)
+
+			for _, event := range events {
+				require.True(t, index.HandleEvent(event))
+			}
+
+			require.Equal(t, []string{"Bobby Tables"}, names(index.Suggest("bob", 0)))
+			require.Equal(t, []*mail.Address{{Name: "Alice Smith", Address: "alice@example.com"}}, index.Suggest("team", 0)[0].Recipients)
+		})
+	})
+}
+
+func TestServer_ContactKeyTrust(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		if _, _, err := s.CreateUser("other", []byte("pass")); err != nil {
+			t.Fatal(err)
+		}
+
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+
+			addrKR := addrKRs[addr[0].ID]
+
+			internal := "other@" + s.GetDomain()
+
+			pubKeys, _, err := c.GetPublicKeys(ctx, internal)
+			require.NoError(t, err)
+
+			apiKey, err := crypto.NewKeyFromArmored(pubKeys[0].PublicKey)
+			require.NoError(t, err)
+
+			getTrust := func(email string) proton.KeyTrust {
+				trust, err := c.GetKeyTrust(ctx, addrKR, email)
+				require.NoError(t, err)
+				return trust
+			}
+
+			// The API key isn't pinned yet.
+			require.Equal(t, proton.KeyTrustUnpinned, getTrust(internal))
+			require.Equal(t, proton.KeyTrustNone, getTrust("plain@example.com"))
+
+			// Pin the API key; the contact is created.
+			contact, err := c.PinPublicKey(ctx, addrKR, internal)
+			require.NoError(t, err)
+			require.Equal(t, internal, contact.Name)
+			require.Equal(t, proton.KeyTrustPinned, getTrust(internal))
+
+			// The key is stored in the group of the email, as the preferred key.
+			signedCard, ok := contact.Cards.Get(proton.CardTypeSigned)
+			require.True(t, ok)
+
+			emails, err := signedCard.Get(addrKR, vcard.FieldEmail)
+			require.NoError(t, err)
+			require.Len(t, emails, 1)
+			require.NotEmpty(t, emails[0].Group)
+
+			keys, err := signedCard.Get(addrKR, vcard.FieldKey)
+			require.NoError(t, err)
+			require.Len(t, keys, 1)
+			require.Equal(t, emails[0].Group, keys[0].Group)
+			require.Equal(t, "1", keys[0].Params.Get(vcard.ParamPreferred))
+
+			// Pin another key; it becomes the preferred key but the API key is still pinned.
+			otherKey, err := crypto.GenerateKey("other", internal, "x25519", 0)
+			require.NoError(t, err)
+
+			contact, err = c.PinContactKey(ctx, addrKR, internal, otherKey)
+			require.NoError(t, err)
+
+			settings, err := contact.GetSettings(addrKR, internal, proton.CardTypeSigned)
+			require.NoError(t, err)
+			require.Len(t, settings.Keys, 2)
+			require.Equal(t, otherKey.GetFingerprint(), settings.Keys[0].GetFingerprint())
+			require.Equal(t, apiKey.GetFingerprint(), settings.Keys[1].GetFingerprint())
+			require.False(t, settings.Keys[0].IsPrivate())
+			require.Equal(t, proton.KeyTrustPinned, getTrust(internal))
+
+			// Unpin the API key; the pinned key doesn't match the API key anymore.
+			_, err = c.UnpinContactKey(ctx, addrKR, internal, apiKey.GetFingerprint())
+			require.NoError(t, err)
+			require.Equal(t, proton.KeyTrustMismatch, getTrust(internal))
+
+			// Messages are not encrypted to a key the user didn't pin.
+			_, err = c.ResolveSendPreferences(ctx, addrKR, []string{internal})
+			require.ErrorIs(t, err, proton.ErrPinnedKeyMismatch)
+
+			// Unpin the other key too.
+			_, err = c.UnpinContactKey(ctx, addrKR, internal, otherKey.GetFingerprint())
+			require.NoError(t, err)
+			require.Equal(t, proton.KeyTrustUnpinned, getTrust(internal))
+
+			// Emails without contact can't be unpinned.
+			_, err = c.UnpinContactKey(ctx, addrKR, "plain@example.com", apiKey.GetFingerprint())
+			require.Error(t, err)
+
+			// Pin the key attached to a received message.
+			senderKey, err := crypto.GenerateKey("sender", "sender@example.com", "x25519", 0)
+			require.NoError(t, err)
+
+			armored, err := senderKey.GetArmoredPublicKey()
+			require.NoError(t, err)
+
+			str, err := c.ImportMessages(ctx, addrKR, 1, 1, proton.ImportReq{
+				Metadata: proton.ImportMetadata{
+					AddressID: addr[0].ID,
+					LabelIDs:  []string{proton.InboxLabel},
+				},
+				Message: []byte(fmt.Sprintf(
+					"From: sender@example.com\r\n"+
+						"To: %v\r\n"+
+						"Subject: My key\r\n"+
+						"Content-Type: multipart/mixed; boundary=boundary\r\n\r\n"+
+						"--boundary\r\n"+
+						"Content-Type: text/plain\r\n\r\n"+
+						"Here is my key.\r\n"+
+						"--boundary\r\n"+
+						"Content-Type: application/pgp-keys; name=\"publickey.asc\"\r\n"+
+						"Content-Disposition: attachment; filename=\"publickey.asc\"\r\n\r\n"+
+						"%v\r\n"+
+						"--boundary--\r\n",
+					addr[0].Email, armored,
+				)),
+			})
+			require.NoError(t, err)
+
+			res, err := stream.Collect(ctx, str)
+			require.NoError(t, err)
+			require.Equal(t, proton.SuccessCode, res[0].Code)
+
+			// The message isn't signed by the attached key, which is refused.
+			_, err = c.GetMessageAttachedKey(ctx, res[0].MessageID, addrKR)
+			require.Error(t, err)
+			require.NotErrorIs(t, err, proton.ErrNoAttachedKey)
+
+			_, err = c.PinAttachedKey(ctx, addrKR, addrKR, res[0].MessageID)
+			require.Error(t, err)
+			require.Equal(t, proton.KeyTrustNone, getTrust("sender@example.com"))
+
+			_, err = c.PinContactKey(ctx, addrKR, "sender@example.com", senderKey)
+			require.NoError(t, err)
+			require.Equal(t, proton.KeyTrustPinned, getTrust("sender@example.com"))
+
+			prefs, err := c.ResolveSendPreferences(ctx, addrKR, []string{"sender@example.com"})
+			require.NoError(t, err)
+			require.True(t, prefs["sender@example.com"].Encrypt)
+			require.Equal(t, proton.KeyTrustPinned, prefs["sender@example.com"].Trust)
+
+			// Messages without attached key have nothing to pin.
+			plain := importMessages(ctx, t, c, addr[0].ID, addrKR, []string{proton.InboxLabel}, 0, 1)
+
+			_, err = c.GetMessageAttachedKey(ctx, plain[0].MessageID, addrKR)
+			require.ErrorIs(t, err, proton.ErrNoAttachedKey)
+		})
+	})
+}
+
+func TestServer_WKD(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		// The directory is served by another server, in another domain, to which all hosts resolve.
+		// Its keys must be bound to the addresses they're served for, unlike the test keys.
+		wkdServer := New(WithDomain("other.local"))
+		defer wkdServer.Close()
+
+		backend.GenerateKey = func(name, email string, passphrase []byte, _ string, _ int) (string, error) {
+			return helper.GenerateKey(name, email, passphrase, "x25519", 0)
+		}
+
+		_, _, err := wkdServer.CreateUser("other", []byte("pass"))
+
+		backend.GenerateKey = backend.FastGenerateKey
+
+		require.NoError(t, err)
+
+		wkdURL, err := url.Parse(wkdServer.GetHostURL())
+		require.NoError(t, err)
+
+		var advanced bool
+
+		newTransport := func() *http.Transport {
+			transport := proton.InsecureTransport()
+
+			transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if !advanced && strings.HasPrefix(addr, "openpgpkey.") {
+					return nil, errors.New("no such host")
+				}
+
+				return (&net.Dialer{}).DialContext(ctx, network, wkdURL.Host)
+			}
+
+			return transport
+		}
+
+		var calls int32
+
+		wkdServer.AddCallWatcher(func(Call) {
+			atomic.AddInt32(&calls, 1)
+		})
+
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			apiKeys, recipientType, err := c.GetPublicKeys(ctx, "other@other.local")
+			require.NoError(t, err)
+			require.Equal(t, proton.RecipientTypeExternal, recipientType)
+			require.Empty(t, apiKeys)
+
+			otherKeys, err := wkdServer.b.GetPublicKeys("other@other.local")
+			require.NoError(t, err)
+			require.Len(t, otherKeys, 1)
+
+			otherKey, err := crypto.NewKeyFromArmored(otherKeys[0].PublicKey)
+			require.NoError(t, err)
+
+			for _, advanced = range []bool{true, false} {
+				wkd := proton.NewWKDClient(newTransport(), proton.WKDPolicy{}, time.Hour)
+
+				// The address is external to the API, its key is found in the directory of its domain.
+				keys, err := wkd.GetKeys(ctx, "Other@other.local")
+				require.NoError(t, err)
+				require.Len(t, keys, 1)
+				require.Equal(t, otherKey.GetFingerprint(), keys[0].GetFingerprint())
+
+				pubKeys, recipientType, err := c.GetPublicKeysWithWKD(ctx, wkd, "other@other.local")
+				require.NoError(t, err)
+				require.Equal(t, proton.RecipientTypeExternal, recipientType)
+				require.Len(t, pubKeys, 1)
+
+				key, err := crypto.NewKeyFromArmored(pubKeys[0].PublicKey)
+				require.NoError(t, err)
+				require.Equal(t, otherKey.GetFingerprint(), key.GetFingerprint())
+
+				// Unknown addresses have no key.
+				_, err = wkd.GetKeys(ctx, "unknown@other.local")
+				require.ErrorIs(t, err, proton.ErrNoWKDKey)
+
+				// Lookups are cached, including those which found no key.
+				before := atomic.LoadInt32(&calls)
+
+				_, err = wkd.GetKeys(ctx, "other@other.local")
+				require.NoError(t, err)
+
+				_, err = wkd.GetKeys(ctx, "unknown@other.local")
+				require.ErrorIs(t, err, proton.ErrNoWKDKey)
+
+				require.Equal(t, before, atomic.LoadInt32(&calls))
+
+				wkd.ClearCache()
+
+				_, err = wkd.GetKeys(ctx, "other@other.local")
+				require.NoError(t, err)
+				require.Greater(t, atomic.LoadInt32(&calls), before)
+			}
+
+			// Internal addresses keep the keys returned by the API.
+			pubKeys, recipientType, err := c.GetPublicKeysWithWKD(ctx, proton.NewWKDClient(newTransport(), proton.WKDPolicy{}, time.Hour), "user@"+s.GetDomain())
+			require.NoError(t, err)
+			require.Equal(t, proton.RecipientTypeInternal, recipientType)
+			require.Len(t, pubKeys, 1)
+
+			// Domains not allowed by the policy aren't looked up.
+			wkd := proton.NewWKDClient(newTransport(), proton.WKDPolicy{BlockedDomains: []string{"other.local"}}, time.Hour)
+
+			_, err = wkd.GetKeys(ctx, "other@other.local")
+			require.ErrorIs(t, err, proton.ErrWKDDomainNotAllowed)
+
+			pubKeys, recipientType, err = c.GetPublicKeysWithWKD(ctx, wkd, "other@other.local")
+			require.NoError(t, err)
+			require.Equal(t, proton.RecipientTypeExternal, recipientType)
+			require.Empty(t, pubKeys)
+
+			// Requests to other domains are rejected by the directory.
+			wkd = proton.NewWKDClient(newTransport(), proton.WKDPolicy{}, time.Hour)
+
+			_, err = wkd.GetKeys(ctx, "other@example.com")
+			require.ErrorIs(t, err, proton.ErrNoWKDKey)
+
+			// Domains whose directory can't be reached have no key, and don't fail the lookup.
+			unreachable := proton.InsecureTransport()
+
+			unreachable.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return nil, errors.New("connection refused")
+			}
+
+			pubKeys, recipientType, err = c.GetPublicKeysWithWKD(ctx, proton.NewWKDClient(unreachable, proton.WKDPolicy{}, time.Hour), "other@other.local")
+			require.NoError(t, err)
+			require.Equal(t, proton.RecipientTypeExternal, recipientType)
+			require.Empty(t, pubKeys)
+		})
+	})
+}
+
+func TestServer_AddressKeys(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			userKR, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+
+			oldKR := addrKRs[addr[0].ID]
+			oldKeyID := addr[0].Keys.Primary().ID
+
+			oldKey, err := crypto.NewKey(addr[0].Keys.Primary().PrivateKey)
+			require.NoError(t, err)
+
+			newKeyList := func(signer *crypto.KeyRing, keys []*crypto.Key, primary int) proton.KeyList {
+				var entries []proton.KeyListEntry
+
+				for idx, key := range keys {
+					entries = append(entries, proton.KeyListEntry{
+						Fingerprint:        key.GetFingerprint(),
+						SHA256Fingerprints: key.GetSHA256Fingerprints(),
+						Flags:              proton.KeyStateTrusted | proton.KeyStateActive,
+						Primary:            proton.Bool(idx == primary),
+					})
+				}
+
+				keyList, err := proton.NewKeyList(signer, entries)
+				require.NoError(t, err)
+
+				return keyList
+			}
+
+			// Generate a new address key, locked with a token encrypted and signed with the user's key.
+			token, err := crypto.RandomToken(32)
+			require.NoError(t, err)
+
+			newKey, err := crypto.GenerateKey("user", addr[0].Email, "x25519", 0)
+			require.NoError(t, err)
+
+			newKR, err := crypto.NewKeyRing(newKey)
+			require.NoError(t, err)
+
+			lockedKey, err := newKey.Lock(token)
+			require.NoError(t, err)
+
+			armKey, err := lockedKey.Armor()
+			require.NoError(t, err)
+
+			encToken, err := userKR.Encrypt(crypto.NewPlainMessage(token), nil)
+			require.NoError(t, err)
+
+			armToken, err := encToken.GetArmored()
+			require.NoError(t, err)
+
+			sigToken, err := userKR.SignDetached(crypto.NewPlainMessage(token))
+			require.NoError(t, err)
+
+			armSig, err := sigToken.GetArmored()
+			require.NoError(t, err)
+
+			req := proton.CreateAddressKeyReq{
+				AddressID:     addr[0].ID,
+				PrivateKey:    armKey,
+				SignedKeyList: newKeyList(oldKR, []*crypto.Key{oldKey, newKey}, 0),
+				Token:         armToken,
+				Signature:     armSig,
+			}
+
+			// The key list must describe the new keys and be signed with the primary key.
+			badReq := req
+			badReq.SignedKeyList = newKeyList(oldKR, []*crypto.Key{oldKey}, 0)
+
+			_, err = c.CreateAddressKey(ctx, badReq)
+			require.Error(t, err)
+
+			badReq.SignedKeyList = newKeyList(newKR, []*crypto.Key{oldKey, newKey}, 0)
+
+			_, err = c.CreateAddressKey(ctx, badReq)
+			require.Error(t, err)
+
+			// The token must be given.
+			badReq = req
+			badReq.Token, badReq.Signature = "", ""
+
+			_, err = c.CreateAddressKey(ctx, badReq)
+			require.Error(t, err)
+
+			eventID, err := c.GetLatestEventID(ctx)
+			require.NoError(t, err)
+
+			key, err := c.CreateAddressKey(ctx, req)
+			require.NoError(t, err)
+			require.False(t, bool(key.Primary))
+
+			// The address is updated and an event is emitted.
+			events, _, err := c.GetEvent(ctx, eventID)
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			require.Len(t, events[0].Addresses, 1)
+			require.Equal(t, proton.EventUpdate, events[0].Addresses[0].Action)
+			require.Len(t, events[0].Addresses[0].Address.Keys, 2)
+
+			// Both keys can be unlocked.
+			addr, err = c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			_, addrKRs, err = proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+			require.Equal(t, 2, addrKRs[addr[0].ID].CountEntities())
+
+			// Make the new key primary; the key list must be signed with it.
+			require.Error(t, c.MakeAddressKeyPrimary(ctx, key.ID, newKeyList(oldKR, []*crypto.Key{oldKey, newKey}, 1)))
+			require.NoError(t, c.MakeAddressKeyPrimary(ctx, key.ID, newKeyList(newKR, []*crypto.Key{oldKey, newKey}, 1)))
+
+			addr, err = c.GetAddresses(ctx)
+			require.NoError(t, err)
+			require.Equal(t, key.ID, addr[0].Keys.Primary().ID)
+
+			// The primary key can't be deleted, the old one can.
+			require.Error(t, c.DeleteAddressKey(ctx, key.ID, newKeyList(newKR, []*crypto.Key{oldKey}, 0)))
+			require.NoError(t, c.DeleteAddressKey(ctx, oldKeyID, newKeyList(newKR, []*crypto.Key{newKey}, 0)))
+
+			addr, err = c.GetAddresses(ctx)
+			require.NoError(t, err)
+			require.Len(t, addr[0].Keys, 1)
+
+			// Messages are now encrypted with the new key.
+			pubKeys, _, err := c.GetPublicKeys(ctx, addr[0].Email)
+			require.NoError(t, err)
+			require.Len(t, pubKeys, 1)
+
+			pubKey, err := crypto.NewKeyFromArmored(pubKeys[0].PublicKey)
+			require.NoError(t, err)
+			require.Equal(t, newKey.GetFingerprint(), pubKey.GetFingerprint())
+
+			// Legacy keys are locked with the user's key passphrase.
+			legacyKey, err := crypto.GenerateKey("user", addr[0].Email, "x25519", 0)
+			require.NoError(t, err)
+
+			lockedLegacyKey, err := legacyKey.Lock(pass)
+			require.NoError(t, err)
+
+			armLegacyKey, err := lockedLegacyKey.Armor()
+			require.NoError(t, err)
+
+			_, err = c.CreateLegacyAddressKey(ctx, proton.CreateAddressKeyReq{
+				AddressID:     addr[0].ID,
+				PrivateKey:    armLegacyKey,
+				SignedKeyList: newKeyList(newKR, []*crypto.Key{newKey, legacyKey}, 0),
+			})
+			require.NoError(t, err)
+
+			addr, err = c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			_, addrKRs, err = proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+			require.NoError(t, err)
+			require.Equal(t, 2, addrKRs[addr[0].ID].CountEntities())
+		})
+	})
+}
+
+func TestServer_ChangePassword(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		unlock := func(c *proton.Client, mailboxPassword string) (map[string]*crypto.KeyRing, error) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte(mailboxPassword), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			_, addrKRs, err := proton.Unlock(user, addr, pass, async.NoopPanicHandler{})
+
+			return addrKRs, err
+		}
+
+		login := func(username, password string) (*proton.Client, proton.Auth, error) {
+			c, auth, err := m.NewClientWithLogin(ctx, username, []byte(password))
+			if err != nil {
+				return nil, proton.Auth{}, err
+			}
+
+			t.Cleanup(c.Close)
+
+			return c, auth, nil
+		}
+
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			addr, err := c.GetAddresses(ctx)
+			require.NoError(t, err)
+
+			salt, err := c.GetSalts(ctx)
+			require.NoError(t, err)
+
+			pass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+			require.NoError(t, err)
+
+			addrKRs, err := unlock(c, "pass")
+			require.NoError(t, err)
+
+			// Add a legacy address key, locked with the user's key passphrase.
+			primaryKey, err := crypto.NewKey(addr[0].Keys.Primary().PrivateKey)
+			require.NoError(t, err)
+
+			legacyKey, err := crypto.GenerateKey("user", addr[0].Email, "x25519", 0)
+			require.NoError(t, err)
+
+			lockedLegacyKey, err := legacyKey.Lock(pass)
+			require.NoError(t, err)
+
+			armLegacyKey, err := lockedLegacyKey.Armor()
+			require.NoError(t, err)
+
+			keyList, err := proton.NewKeyList(addrKRs[addr[0].ID], []proton.KeyListEntry{
+				{Fingerprint: primaryKey.GetFingerprint(), Primary: true},
+				{Fingerprint: legacyKey.GetFingerprint()},
+			})
+			require.NoError(t, err)
+
+			_, err = c.CreateLegacyAddressKey(ctx, proton.CreateAddressKeyReq{
+				AddressID:     addr[0].ID,
+				PrivateKey:    armLegacyKey,
+				SignedKeyList: keyList,
+			})
+			require.NoError(t, err)
+
+			// The current password must be given.
+			require.Error(t, c.ChangePassword(ctx, proton.PasswordChange{
+				Mode:             proton.OnePasswordMode,
+				LoginPassword:    []byte("wrong"),
+				NewLoginPassword: []byte("new pass"),
+			}))
+
+			// The login password, which unlocks the keys, can't be changed without them.
+			require.Error(t, c.ChangePassword(ctx, proton.PasswordChange{
+				Mode:             proton.TwoPasswordMode,
+				LoginPassword:    []byte("pass"),
+				NewLoginPassword: []byte("new pass"),
+			}))
+
+			// Nor can the keys be locked with a new passphrase without changing the login password.
+			require.Error(t, c.ChangePassword(ctx, proton.PasswordChange{
+				Mode:               proton.TwoPasswordMode,
+				LoginPassword:      []byte("pass"),
+				MailboxPassword:    []byte("pass"),
+				NewMailboxPassword: []byte("new pass"),
+			}))
+
+			eventID, err := c.GetLatestEventID(ctx)
+			require.NoError(t, err)
+
+			require.NoError(t, c.ChangePassword(ctx, proton.PasswordChange{
+				Mode:             proton.OnePasswordMode,
+				LoginPassword:    []byte("pass"),
+				NewLoginPassword: []byte("new pass"),
+			}))
+
+			// The user and the address of the legacy key are updated.
+			events, _, err := c.GetEvent(ctx, eventID)
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			require.NotNil(t, events[0].User)
+			require.Len(t, events[0].Addresses, 1)
+
+			// The old password can't be used anymore, to log in or to unlock the keys.
+			_, _, err = login("user", "pass")
+			require.Error(t, err)
+
+			_, err = unlock(c, "pass")
+			require.Error(t, err)
+
+			// The new password unlocks all the keys, including the legacy one.
+			newC, auth, err := login("user", "new pass")
+			require.NoError(t, err)
+			require.Equal(t, proton.OnePasswordMode, auth.PasswordMode)
+
+			addrKRs, err = unlock(newC, "new pass")
+			require.NoError(t, err)
+			require.Equal(t, 2, addrKRs[addr[0].ID].CountEntities())
+		})
+
+		// In two-password mode, the login and mailbox passwords are changed separately.
+		_, _, err := s.CreateUserWithMailboxPassword("two", []byte("login"), []byte("mailbox"))
+		require.NoError(t, err)
+
+		c, auth, err := login("two", "login")
+		require.NoError(t, err)
+		require.Equal(t, proton.TwoPasswordMode, auth.PasswordMode)
+
+		_, err = unlock(c, "login")
+		require.Error(t, err)
+
+		_, err = unlock(c, "mailbox")
+		require.NoError(t, err)
+
+		require.NoError(t, c.ChangePassword(ctx, proton.PasswordChange{
+			Mode:               proton.TwoPasswordMode,
+			LoginPassword:      []byte("login"),
+			MailboxPassword:    []byte("mailbox"),
+			NewMailboxPassword: []byte("new mailbox"),
+		}))
+
+		_, err = unlock(c, "new mailbox")
+		require.NoError(t, err)
+
+		require.NoError(t, c.ChangePassword(ctx, proton.PasswordChange{
+			Mode:             proton.TwoPasswordMode,
+			LoginPassword:    []byte("login"),
+			NewLoginPassword: []byte("new login"),
+		}))
+
+		_, _, err = login("two", "login")
+		require.Error(t, err)
+
+		c, _, err = login("two", "new login")
+		require.NoError(t, err)
+
+		_, err = unlock(c, "new mailbox")
+		require.NoError(t, err)
+
+		// Both passwords can be changed at once.
+		require.NoError(t, c.ChangePassword(ctx, proton.PasswordChange{
+			Mode:               proton.TwoPasswordMode,
+			LoginPassword:      []byte("new login"),
+			MailboxPassword:    []byte("new mailbox"),
+			NewLoginPassword:   []byte("login"),
+			NewMailboxPassword: []byte("mailbox"),
+		}))
+
+		c, _, err = login("two", "login")
+		require.NoError(t, err)
+
+		_, err = unlock(c, "mailbox")
+		require.NoError(t, err)
+
+		// Nothing is changed if the mailbox password is wrong.
+		require.Error(t, c.ChangePassword(ctx, proton.PasswordChange{
+			Mode:               proton.TwoPasswordMode,
+			LoginPassword:      []byte("login"),
+			MailboxPassword:    []byte("wrong"),
+			NewLoginPassword:   []byte("new login"),
+			NewMailboxPassword: []byte("new mailbox"),
+		}))
+
+		_, _, err = login("two", "login")
+		require.NoError(t, err)
+	})
+}
+
+func TestServer_ChangePassword_OtherUserSession(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		_, _, err := s.CreateUser("other", []byte("other pass"))
+		require.NoError(t, err)
+
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			user, err := c.GetUser(ctx)
+			require.NoError(t, err)
+
+			// The proofs of another user's session can't change the user's password.
+			info, err := m.AuthInfo(ctx, proton.AuthInfoReq{Username: "other"})
+			require.NoError(t, err)
+
+			srpAuth, err := srp.NewAuth(info.Version, "other", []byte("other pass"), info.Salt, info.Modulus, info.ServerEphemeral)
+			require.NoError(t, err)
+
+			proofs, err := srpAuth.GenerateProofs(2048)
+			require.NoError(t, err)
+
+			modulus, err := m.AuthModulus(ctx)
+			require.NoError(t, err)
+
+			salt, err := crypto.RandomToken(10)
+			require.NoError(t, err)
+
+			verifierAuth, err := srp.NewAuthForVerifier([]byte("new pass"), modulus.Modulus, salt)
+			require.NoError(t, err)
+
+			verifier, err := verifierAuth.GenerateVerifier(2048)
+			require.NoError(t, err)
+
+			require.Error(t, s.b.SetPassword(user.ID, proofs.ClientEphemeral, proofs.ClientProof, info.SRPSession, proton.AuthVerifier{
+				Version:   4,
+				ModulusID: modulus.ModulusID,
+				Salt:      base64.StdEncoding.EncodeToString(salt),
+				Verifier:  base64.StdEncoding.EncodeToString(verifier),
+			}))
+
+			// The user still logs in with their password.
+			other, _, err := m.NewClientWithLogin(ctx, "user", []byte("pass"))
+			require.NoError(t, err)
+
+			other.Close()
+		})
+	})
+}
+
+func TestServer_DriveShares(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withVolume(ctx, t, c, "pass", func(addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing) {
+				root, err := c.GetLink(ctx, share.ShareID, share.LinkID)
+				require.NoError(t, err)
+
+				rootKR, err := root.GetKeyRing(shareKR, addrKR)
+				require.NoError(t, err)
+
+				rootHashKey, err := root.GetHashKey(rootKR)
+				require.NoError(t, err)
+
+				// Create a folder in the root folder.
+				folderReq, err := proton.NewCreateFolderReq(root.LinkID, "folder", rootKR, rootHashKey, addrKR, addr.Email)
+				require.NoError(t, err)
+
+				folderRes, err := c.CreateFolder(ctx, share.ShareID, folderReq)
+				require.NoError(t, err)
+
+				children, err := c.ListChildren(ctx, share.ShareID, root.LinkID, false)
+				require.NoError(t, err)
+				require.Len(t, children, 1)
+				require.Equal(t, folderRes.ID, children[0].LinkID)
+
+				// Negative pages are refused.
+				user, err := c.GetUser(ctx)
+				require.NoError(t, err)
+
+				_, err = s.b.ListChildren(user.ID, share.ShareID, root.LinkID, -1, 10, false)
+				require.Error(t, err)
+
+				name, err := children[0].GetName(rootKR, addrKR)
+				require.NoError(t, err)
+				require.Equal(t, "folder", name)
+
+				// A second folder with the same name is refused.
+				_, err = c.CreateFolder(ctx, share.ShareID, folderReq)
+				require.Error(t, err)
+
+				// Share the folder.
+				shareReq, err := proton.NewCreateShareReq(children[0], rootKR, addr.ID, addrKR)
+				require.NoError(t, err)
+
+				shareRes, err := c.CreateShare(ctx, share.VolumeID, shareReq)
+				require.NoError(t, err)
+
+				// The folder can only be shared once.
+				_, err = c.CreateShare(ctx, share.VolumeID, shareReq)
+				require.Error(t, err)
+
+				folderShare, err := c.GetShare(ctx, shareRes.ID)
+				require.NoError(t, err)
+				require.Equal(t, folderRes.ID, folderShare.LinkID)
+				require.Equal(t, proton.ShareTypeStandard, folderShare.Type)
+
+				_, err = folderShare.GetKeyRing(addrKR)
+				require.NoError(t, err)
+
+				shares, err := c.ListShares(ctx, false)
+				require.NoError(t, err)
+				require.Len(t, shares, 2)
+
+				// The main share can't be deleted; the folder share can.
+				require.Error(t, c.DeleteShare(ctx, share.ShareID))
+				require.NoError(t, c.DeleteShare(ctx, folderShare.ShareID))
+
+				shares, err = c.ListShares(ctx, false)
+				require.NoError(t, err)
+				require.Len(t, shares, 1)
+			})
+		})
+	})
+}
+
+func TestServer_ShareURLs(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withVolume(ctx, t, c, "pass", func(addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing) {
+				root, err := c.GetLink(ctx, share.ShareID, share.LinkID)
+				require.NoError(t, err)
+
+				rootKR, err := root.GetKeyRing(shareKR, addrKR)
+				require.NoError(t, err)
+
+				rootHashKey, err := root.GetHashKey(rootKR)
+				require.NoError(t, err)
+
+				// Create a folder to share.
+				folderReq, err := proton.NewCreateFolderReq(root.LinkID, "shared", rootKR, rootHashKey, addrKR, addr.Email)
+				require.NoError(t, err)
+
+				folderRes, err := c.CreateFolder(ctx, share.ShareID, folderReq)
+				require.NoError(t, err)
+
+				folder, err := c.GetLink(ctx, share.ShareID, folderRes.ID)
+				require.NoError(t, err)
+
+				// Share the folder.
+				shareReq, err := proton.NewCreateShareReq(folder, rootKR, addr.ID, addrKR)
+				require.NoError(t, err)
+
+				shareRes, err := c.CreateShare(ctx, share.VolumeID, shareReq)
+				require.NoError(t, err)
+
+				folderShare, err := c.GetShare(ctx, shareRes.ID)
+				require.NoError(t, err)
+
+				// Create a share URL protected by a custom password.
+				url, password, err := c.CreateShareURLWithPassword(ctx, folderShare, addrKR, addr.Email, []byte("custom"))
+				require.NoError(t, err)
+				require.True(t, bytes.HasSuffix(password, []byte("custom")))
+				require.Equal(t, proton.ShareURLFlagCustomPassword|proton.ShareURLFlagGeneratedPasswordIncluded, url.Flags)
+
+				decPassword, err := url.GetPassword(addrKR)
+				require.NoError(t, err)
+				require.Equal(t, password, decPassword)
+
+				urls, err := c.ListShareURLs(ctx, folderShare.ShareID)
+				require.NoError(t, err)
+				require.Len(t, urls, 1)
+				require.Equal(t, url.ShareURLID, urls[0].ShareURLID)
+
+				// The wrong password is refused.
+				_, _, err = m.NewClientWithShareURL(ctx, url.Token, []byte("wrong"))
+				require.Error(t, err)
+
+				// Open the share URL anonymously.
+				anon, _, err := m.NewClientWithShareURL(ctx, url.Token, password)
+				require.NoError(t, err)
+				defer anon.Close()
+
+				token, err := anon.GetShareURLToken(ctx, url.Token)
+				require.NoError(t, err)
+				require.Equal(t, folder.LinkID, token.LinkID)
+				require.Equal(t, proton.LinkTypeFolder, token.LinkType)
+
+				tokenShareKR, err := token.GetShareKeyRing(password)
+				require.NoError(t, err)
+
+				name, err := token.GetName(tokenShareKR)
+				require.NoError(t, err)
+				require.Equal(t, "shared", name)
+
+				tokenKR, err := token.GetKeyRing(tokenShareKR)
+				require.NoError(t, err)
+
+				hashKey, err := folder.GetHashKey(tokenKR)
+				require.NoError(t, err)
+				require.NotEmpty(t, hashKey)
+
+				// The anonymous session can't be used on other share URLs.
+				_, err = anon.GetShareURLToken(ctx, "other")
+				require.Error(t, err)
+
+				// Limit the number of accesses; the URL was already accessed once.
+				maxAccesses := 1
+
+				url, err = c.UpdateShareURL(ctx, folderShare.ShareID, url.ShareURLID, proton.UpdateShareURLReq{MaxAccesses: &maxAccesses})
+				require.NoError(t, err)
+				require.Equal(t, 1, url.NumAccesses)
+
+				_, _, err = m.NewClientWithShareURL(ctx, url.Token, password)
+				require.Error(t, err)
+
+				// The share URL can't be read anymore, even with an existing session.
+				_, err = anon.GetShareURLToken(ctx, url.Token)
+				require.Error(t, err)
+
+				// Delete the share URL.
+				require.NoError(t, c.DeleteShareURL(ctx, folderShare.ShareID, url.ShareURLID))
+
+				urls, err = c.ListShareURLs(ctx, folderShare.ShareID)
+				require.NoError(t, err)
+				require.Empty(t, urls)
+
+				_, err = m.ShareURLInfo(ctx, url.Token)
+				require.Error(t, err)
+			})
+		})
+	})
+}
+
+func TestServer_DriveRevisions(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withVolume(ctx, t, c, "pass", func(addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing) {
+				root, err := c.GetLink(ctx, share.ShareID, share.LinkID)
+				require.NoError(t, err)
+
+				rootKR, err := root.GetKeyRing(shareKR, addrKR)
+				require.NoError(t, err)
+
+				rootHashKey, err := root.GetHashKey(rootKR)
+				require.NoError(t, err)
+
+				// Create a file and upload its first revision.
+				fileReq, nodeKR, sessionKey, err := proton.NewCreateFileReq(root.LinkID, "file.txt", "text/plain", rootKR, rootHashKey, addrKR, addr.Email)
+				require.NoError(t, err)
+
+				file, err := c.CreateFile(ctx, share.ShareID, fileReq)
+				require.NoError(t, err)
+
+				require.NoError(t, c.UploadRevision(ctx, share.ShareID, file.ID, file.RevisionID, addr, addrKR, nodeKR, sessionKey, time.Now(), strings.NewReader("first")))
+
+				// Upload a second revision.
+				rev, err := c.CreateRevision(ctx, share.ShareID, file.ID)
+				require.NoError(t, err)
+
+				require.NoError(t, c.UploadRevision(ctx, share.ShareID, file.ID, rev.ID, addr, addrKR, nodeKR, sessionKey, time.Now(), strings.NewReader("second")))
+
+				revisions, err := c.ListRevisions(ctx, share.ShareID, file.ID)
+				require.NoError(t, err)
+				require.Len(t, revisions, 2)
+				require.Equal(t, proton.RevisionStateObsolete, revisions[0].State)
+				require.Equal(t, proton.RevisionStateActive, revisions[1].State)
+
+				// The file's session key can be recovered from the link.
+				link, err := c.GetLink(ctx, share.ShareID, file.ID)
+				require.NoError(t, err)
+				require.Equal(t, rev.ID, link.FileProperties.ActiveRevision.ID)
+
+				linkSessionKey, err := link.GetSessionKey(nodeKR)
+				require.NoError(t, err)
+				require.Equal(t, sessionKey.Key, linkSessionKey.Key)
+
+				// The two revisions differ in their only block.
+				diff, err := c.CompareRevisions(ctx, share.ShareID, file.ID, file.RevisionID, rev.ID, addrKR, nodeKR, sessionKey)
+				require.NoError(t, err)
+				require.Equal(t, []int{1}, diff.Modified)
+				require.Empty(t, diff.Added)
+				require.Empty(t, diff.Removed)
+
+				// A revision with the same content has the same blocks, even though they are encrypted anew.
+				same, err := c.CreateRevision(ctx, share.ShareID, file.ID)
+				require.NoError(t, err)
+
+				require.NoError(t, c.UploadRevision(ctx, share.ShareID, file.ID, same.ID, addr, addrKR, nodeKR, sessionKey, time.Now(), strings.NewReader("second")))
+
+				diff, err = c.CompareRevisions(ctx, share.ShareID, file.ID, rev.ID, same.ID, addrKR, nodeKR, sessionKey)
+				require.NoError(t, err)
+				require.True(t, diff.Equal())
+
+				// Restore the first revision.
+				require.NoError(t, c.RestoreRevision(ctx, share.ShareID, file.ID, file.RevisionID))
+
+				link, err = c.GetLink(ctx, share.ShareID, file.ID)
+				require.NoError(t, err)
+				require.Equal(t, file.RevisionID, link.FileProperties.ActiveRevision.ID)
+
+				buf := new(bytes.Buffer)
+				require.NoError(t, c.DownloadRevision(ctx, share.ShareID, file.ID, file.RevisionID, addrKR, nodeKR, sessionKey, buf))
+				require.Equal(t, "first", buf.String())
+
+				// The active revision can't be deleted, but the obsolete one can.
+				require.Error(t, c.DeleteRevision(ctx, share.ShareID, file.ID, file.RevisionID))
+				require.NoError(t, c.DeleteRevision(ctx, share.ShareID, file.ID, rev.ID))
+				require.NoError(t, c.DeleteRevision(ctx, share.ShareID, file.ID, same.ID))
+
+				revisions, err = c.ListRevisions(ctx, share.ShareID, file.ID)
+				require.NoError(t, err)
+				require.Len(t, revisions, 1)
+				require.Equal(t, file.RevisionID, revisions[0].ID)
+			})
+		})
+	})
+}
+
+func TestServer_DriveXAttr(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withVolume(ctx, t, c, "pass", func(addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing) {
+				root, err := c.GetLink(ctx, share.ShareID, share.LinkID)
+				require.NoError(t, err)
+
+				rootKR, err := root.GetKeyRing(shareKR, addrKR)
+				require.NoError(t, err)
+
+				rootHashKey, err := root.GetHashKey(rootKR)
+				require.NoError(t, err)
+
+				modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+				file, err := c.UploadFile(ctx, share.ShareID, root.LinkID, "file.txt", "text/plain", rootKR, rootHashKey, addr, addrKR, modTime, strings.NewReader("hello world"))
+				require.NoError(t, err)
+
+				link, err := c.GetLink(ctx, share.ShareID, file.ID)
+				require.NoError(t, err)
+
+				nodeKR, err := link.GetKeyRing(rootKR, addrKR)
+				require.NoError(t, err)
+
+				xAttr, err := link.FileProperties.ActiveRevision.GetXAttr(nodeKR, addrKR)
+				require.NoError(t, err)
+				require.NotNil(t, xAttr)
+
+				require.True(t, modTime.Equal(xAttr.Common.ModificationTime))
+				require.Equal(t, int64(11), xAttr.Common.Size)
+				require.Equal(t, []int64{11}, xAttr.Common.BlockSizes)
+				require.Equal(t, "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed", xAttr.Common.Digests.SHA1)
+
+				// The extended attributes must be signed by the given address keyring.
+				_, err = link.FileProperties.ActiveRevision.GetXAttr(nodeKR, nodeKR)
+				require.Error(t, err)
+			})
+		})
+	})
+}
+
+func TestServer_DriveThumbnails(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withVolume(ctx, t, c, "pass", func(addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing) {
+				root, err := c.GetLink(ctx, share.ShareID, share.LinkID)
+				require.NoError(t, err)
+
+				rootKR, err := root.GetKeyRing(shareKR, addrKR)
+				require.NoError(t, err)
+
+				rootHashKey, err := root.GetHashKey(rootKR)
+				require.NoError(t, err)
+
+				// Create a large, half transparent image.
+				img := image.NewNRGBA(image.Rect(0, 0, 2048, 1024))
+
+				for x := 0; x < 2048; x++ {
+					for y := 0; y < 1024; y++ {
+						img.SetNRGBA(x, y, color.NRGBA{R: 0xff, A: uint8(x % 2 * 0xff)})
+					}
+				}
+
+				buf := new(bytes.Buffer)
+				require.NoError(t, png.Encode(buf, img))
+
+				// Uploading an image creates its thumbnail.
+				file, err := c.UploadFile(ctx, share.ShareID, root.LinkID, "image.png", "image/png", rootKR, rootHashKey, addr, addrKR, time.Now(), buf)
+				require.NoError(t, err)
+
+				link, err := c.GetLink(ctx, share.ShareID, file.ID)
+				require.NoError(t, err)
+				require.True(t, bool(link.FileProperties.ActiveRevision.Thumbnail))
+				require.NotEmpty(t, link.FileProperties.ActiveRevision.ThumbnailHash)
+
+				nodeKR, err := link.GetKeyRing(rootKR, addrKR)
+				require.NoError(t, err)
+
+				sessionKey, err := link.GetSessionKey(nodeKR)
+				require.NoError(t, err)
+
+				data, err := c.GetThumbnail(ctx, share.ShareID, file.ID, file.RevisionID, addrKR, nodeKR, sessionKey)
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(data), proton.ThumbnailMaxSize)
+
+				// The thumbnail's signature is verified, unless no address keyring is given.
+				_, err = c.GetThumbnail(ctx, share.ShareID, file.ID, file.RevisionID, rootKR, nodeKR, sessionKey)
+				require.Error(t, err)
+
+				unverified, err := c.GetThumbnail(ctx, share.ShareID, file.ID, file.RevisionID, nil, nodeKR, sessionKey)
+				require.NoError(t, err)
+				require.Equal(t, data, unverified)
+
+				thumb, err := jpeg.Decode(bytes.NewReader(data))
+				require.NoError(t, err)
+				require.Equal(t, image.Rect(0, 0, proton.ThumbnailMaxDimension, proton.ThumbnailMaxDimension/2), thumb.Bounds())
+
+				// Transparent pixels are blended with a white background.
+				r, g, b, _ := thumb.At(100, 100).RGBA()
+				require.InDelta(t, 0xffff, r, 0x1000)
+				require.InDelta(t, 0x7fff, g, 0x1000)
+				require.InDelta(t, 0x7fff, b, 0x1000)
+
+				// Other files have no thumbnail.
+				text, err := c.UploadFile(ctx, share.ShareID, root.LinkID, "file.txt", "text/plain", rootKR, rootHashKey, addr, addrKR, time.Now(), strings.NewReader("hello"))
+				require.NoError(t, err)
+
+				_, err = c.GetThumbnail(ctx, share.ShareID, text.ID, text.RevisionID, addrKR, nodeKR, sessionKey)
+				require.Error(t, err)
+
+				// New revisions of images get a thumbnail too.
+				rev, err := c.CreateRevision(ctx, share.ShareID, file.ID)
+				require.NoError(t, err)
+
+				buf.Reset()
+				require.NoError(t, png.Encode(buf, img))
+
+				require.NoError(t, c.UploadRevision(ctx, share.ShareID, file.ID, rev.ID, addr, addrKR, nodeKR, sessionKey, time.Now(), buf))
+
+				data, err = c.GetThumbnail(ctx, share.ShareID, file.ID, rev.ID, addrKR, nodeKR, sessionKey)
+				require.NoError(t, err)
+				require.NotEmpty(t, data)
+
+				// Images with too many pixels are refused before they are decoded.
+				_, err = proton.NewThumbnail(bytes.NewReader([]byte("GIF89a\xff\xff\xff\xff\x00\x00\x00;")))
+				require.ErrorIs(t, err, proton.ErrImageTooLarge)
+			})
+		})
+	})
+}
+
+func TestServer_DriveEvents(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withVolume(ctx, t, c, "pass", func(addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing) {
+				root, err := c.GetLink(ctx, share.ShareID, share.LinkID)
+				require.NoError(t, err)
+
+				rootKR, err := root.GetKeyRing(shareKR, addrKR)
+				require.NoError(t, err)
+
+				rootHashKey, err := root.GetHashKey(rootKR)
+				require.NoError(t, err)
+
+				eventID, err := c.GetLatestVolumeEventID(ctx, share.VolumeID)
+				require.NoError(t, err)
+
+				// There are no events yet.
+				event, err := c.GetVolumeEvent(ctx, share.VolumeID, eventID)
+				require.NoError(t, err)
+				require.Equal(t, eventID, event.EventID)
+				require.Empty(t, event.Events)
+
+				// Creating and committing a file emits a create and an update event.
+				file, err := c.UploadFile(ctx, share.ShareID, root.LinkID, "file.txt", "text/plain", rootKR, rootHashKey, addr, addrKR, time.Now(), strings.NewReader("hello"))
+				require.NoError(t, err)
+
+				krs := proton.NewLinkKeyRings(addrKR)
+				krs.Add(root.LinkID, rootKR)
+
+				event, err = c.GetVolumeEvent(ctx, share.VolumeID, eventID)
+				require.NoError(t, err)
+
+				changes := event.Changes(krs)
+				require.Len(t, changes.Changes, 2)
+				require.Equal(t, proton.LinkChangeCreate, changes.Changes[0].Type)
+				require.Equal(t, proton.LinkChangeUpdate, changes.Changes[1].Type)
+				require.Equal(t, file.ID, changes.Changes[1].Link.LinkID)
+				require.Equal(t, "file.txt", changes.Changes[1].Name)
+
+				// Trashed children are hidden and emit a trash event.
+				require.NoError(t, c.TrashChildren(ctx, share.ShareID, root.LinkID, file.ID))
+
+				children, err := c.ListChildren(ctx, share.ShareID, root.LinkID, false)
+				require.NoError(t, err)
+				require.Empty(t, children)
+
+				trashEvent, err := c.GetVolumeEvent(ctx, share.VolumeID, event.EventID)
+				require.NoError(t, err)
+				require.Len(t, trashEvent.Events, 1)
+				require.Equal(t, proton.LinkChangeTrash, trashEvent.Changes(krs).Changes[0].Type)
+
+				// Deleted children are gone and emit a delete event.
+				require.NoError(t, c.DeleteChildren(ctx, share.ShareID, root.LinkID, file.ID))
+
+				_, err = c.GetLink(ctx, share.ShareID, file.ID)
+				require.Error(t, err)
+
+				deleteEvent, err := c.GetVolumeEvent(ctx, share.VolumeID, trashEvent.EventID)
+				require.NoError(t, err)
+				require.Len(t, deleteEvent.Events, 1)
+				require.Equal(t, proton.LinkChangeDelete, deleteEvent.Changes(krs).Changes[0].Type)
+
+				// Links of other folders can't be trashed through this one.
+				folderReq, err := proton.NewCreateFolderReq(root.LinkID, "folder", rootKR, rootHashKey, addrKR, addr.Email)
+				require.NoError(t, err)
+
+				folder, err := c.CreateFolder(ctx, share.ShareID, folderReq)
+				require.NoError(t, err)
+
+				require.Error(t, c.TrashChildren(ctx, share.ShareID, folder.ID, folder.ID))
+			})
+		})
+	})
+}
+
+func TestServer_DriveEventStream(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withVolume(ctx, t, c, "pass", func(addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing) {
+				root, err := c.GetLink(ctx, share.ShareID, share.LinkID)
+				require.NoError(t, err)
+
+				rootKR, err := root.GetKeyRing(shareKR, addrKR)
+				require.NoError(t, err)
+
+				rootHashKey, err := root.GetHashKey(rootKR)
+				require.NoError(t, err)
+
+				createFolder := func(name string) string {
+					req, err := proton.NewCreateFolderReq(root.LinkID, name, rootKR, rootHashKey, addrKR, addr.Email)
+					require.NoError(t, err)
+
+					folder, err := c.CreateFolder(ctx, share.ShareID, req)
+					require.NoError(t, err)
+
+					return folder.ID
+				}
+
+				eventID, err := c.GetLatestVolumeEventID(ctx, share.VolumeID)
+				require.NoError(t, err)
+
+				// The events of the two folders are split across pages.
+				s.SetMaxUpdatesPerEvent(1)
+
+				folderIDs := []string{createFolder("folder1"), createFolder("folder2")}
+
+				krs := proton.NewLinkKeyRings(addrKR)
+				krs.Add(root.LinkID, rootKR)
+
+				changesCh := c.NewVolumeEventStream(ctx, 100*time.Millisecond, 0, share.VolumeID, eventID, krs)
+
+				// All the pages are received at once, and the stream resumes from the last one.
+				changes := <-changesCh
+				require.Len(t, changes.Changes, 2)
+				require.Equal(t, folderIDs[0], changes.Changes[0].Link.LinkID)
+				require.Equal(t, folderIDs[1], changes.Changes[1].Link.LinkID)
+				require.Equal(t, "folder2", changes.Changes[1].Name)
+
+				latestEventID, err := c.GetLatestVolumeEventID(ctx, share.VolumeID)
+				require.NoError(t, err)
+				require.Equal(t, latestEventID, changes.EventID)
+
+				// Only the new folder is received next.
+				folderID := createFolder("folder3")
+
+				changes = <-changesCh
+				require.Len(t, changes.Changes, 1)
+				require.Equal(t, proton.LinkChangeCreate, changes.Changes[0].Type)
+				require.Equal(t, folderID, changes.Changes[0].Link.LinkID)
+			})
+		})
+	})
+}
+
+func TestServer_DriveEventStream_Paging(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withVolume(ctx, t, c, "pass", func(addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing) {
+				root, err := c.GetLink(ctx, share.ShareID, share.LinkID)
+				require.NoError(t, err)
+
+				rootKR, err := root.GetKeyRing(shareKR, addrKR)
+				require.NoError(t, err)
+
+				rootHashKey, err := root.GetHashKey(rootKR)
+				require.NoError(t, err)
+
+				eventID, err := c.GetLatestVolumeEventID(ctx, share.VolumeID)
+				require.NoError(t, err)
+
+				// The events of the folders span three pages.
+				s.SetMaxUpdatesPerEvent(2)
+
+				var folderIDs []string
+
+				for i := 0; i < 5; i++ {
+					req, err := proton.NewCreateFolderReq(root.LinkID, fmt.Sprintf("folder%v", i), rootKR, rootHashKey, addrKR, addr.Email)
+					require.NoError(t, err)
+
+					folder, err := c.CreateFolder(ctx, share.ShareID, req)
+					require.NoError(t, err)
+
+					folderIDs = append(folderIDs, folder.ID)
+				}
+
+				latestEventID, err := c.GetLatestVolumeEventID(ctx, share.VolumeID)
+				require.NoError(t, err)
+
+				// The pages are followed until the last one, without getting stuck on the same page.
+				pageCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				defer cancel()
+
+				event, err := c.GetVolumeEvent(pageCtx, share.VolumeID, eventID)
+				require.NoError(t, err)
+				require.Equal(t, latestEventID, event.EventID)
+				require.Len(t, event.Events, 5)
+
+				krs := proton.NewLinkKeyRings(addrKR)
+				krs.Add(root.LinkID, rootKR)
+
+				streamCtx, cancel := context.WithCancel(ctx)
+				defer cancel()
+
+				changesCh := c.NewVolumeEventStream(streamCtx, 100*time.Millisecond, 0, share.VolumeID, eventID, krs)
+
+				// The stream delivers all the pages at once, in order.
+				select {
+				case changes := <-changesCh:
+					require.Equal(t, latestEventID, changes.EventID)
+					require.Equal(t, folderIDs, xslices.Map(changes.Changes, func(change proton.LinkChange) string {
+						return change.Link.LinkID
+					}))
+
+					for i, change := range changes.Changes {
+						require.Equal(t, proton.LinkChangeCreate, change.Type)
+						require.Equal(t, fmt.Sprintf("folder%v", i), change.Name)
+					}
+
+				case <-time.After(10 * time.Second):
+					require.Fail(t, "no changes received")
+				}
+
+				// The same changes aren't delivered again.
+				select {
+				case changes := <-changesCh:
+					require.Fail(t, "unexpected changes", "%v", changes)
+
+				case <-time.After(time.Second):
+					// ...
+				}
+			})
+		})
+	})
+}
+
+func TestServer_Calendar(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		var calendarID string
+
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				calendars, err := c.GetCalendars(ctx)
+				require.NoError(t, err)
+				require.Equal(t, []proton.Calendar{cal}, calendars)
+				require.Equal(t, "Personal", cal.Name)
+
+				// The calendar is owned by the address it was created for.
+				addr, err := c.GetAddresses(ctx)
+				require.NoError(t, err)
+				require.Equal(t, addr[0].Email, member.Email)
+				require.Equal(t, proton.CalendarPermissionsOwner, member.Permissions)
+
+				calendarID = cal.ID
+			})
+		})
+
+		// Other users can't see the calendar.
+		withUser(ctx, t, s, m, "other", "pass", func(c *proton.Client) {
+			calendars, err := c.GetCalendars(ctx)
+			require.NoError(t, err)
+			require.Empty(t, calendars)
+
+			_, err = c.GetCalendar(ctx, calendarID)
+			require.Error(t, err)
+
+			_, err = c.GetCalendarKeys(ctx, calendarID)
+			require.Error(t, err)
+		})
+	})
+}
+
+func TestServer_CalendarSharing(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, owner proton.CalendarMember, calKR, ownerKR *crypto.KeyRing) {
+				shared := newCalendarEvent("shared", time.Now())
+
+				attendee := ical.NewProp(ical.PropAttendee)
+				attendee.Value = "mailto:attendee@example.com"
+				shared.Props.Add(attendee)
+
+				req, err := proton.NewCreateCalendarEventReq(shared, owner.ID, calKR, ownerKR)
+				require.NoError(t, err)
+
+				_, err = c.CreateCalendarEvent(ctx, cal.ID, req)
+				require.NoError(t, err)
+
+				withUser(ctx, t, s, m, "other", "pass", func(other *proton.Client) {
+					user, err := other.GetUser(ctx)
+					require.NoError(t, err)
+
+					addr, err := other.GetAddresses(ctx)
+					require.NoError(t, err)
+
+					salt, err := other.GetSalts(ctx)
+					require.NoError(t, err)
+
+					keyPass, err := salt.SaltForKey([]byte("pass"), user.Keys.Primary().ID)
+					require.NoError(t, err)
+
+					_, addrKRs, err := proton.Unlock(user, addr, keyPass, async.NoopPanicHandler{})
+					require.NoError(t, err)
+
+					otherKR := addrKRs[addr[0].ID]
+
+					// Invited members can't own the calendar.
+					_, err = c.ShareCalendar(ctx, cal.ID, owner.ID, ownerKR, addr[0].Email, proton.CalendarPermissionsOwner)
+					require.Error(t, err)
+
+					_, err = c.ShareCalendar(ctx, cal.ID, owner.ID, ownerKR, addr[0].Email, proton.CalendarPermissionsView|proton.CalendarPermissionOwner)
+					require.Error(t, err)
+
+					// The owner shares the calendar in read-only mode.
+					invitation, err := c.ShareCalendar(ctx, cal.ID, owner.ID, ownerKR, addr[0].Email, proton.CalendarPermissionsView)
+					require.NoError(t, err)
+					require.Equal(t, proton.CalendarInvitationStatusPending, invitation.Status)
+
+					_, err = c.ShareCalendar(ctx, cal.ID, owner.ID, ownerKR, addr[0].Email, proton.CalendarPermissionsView)
+					require.Error(t, err)
+
+					_, err = c.ShareCalendar(ctx, cal.ID, owner.ID, ownerKR, "unknown@example.com", proton.CalendarPermissionsView)
+					require.Error(t, err)
+
+					// The invited user accepts the invitation.
+					invitations, err := other.GetPendingCalendarInvitations(ctx)
+					require.NoError(t, err)
+					require.Len(t, invitations, 1)
+					require.Equal(t, cal.ID, invitations[0].CalendarID)
+
+					accept, err := proton.NewAcceptCalendarInvitationReq(invitations[0], otherKR)
+					require.NoError(t, err)
+
+					member, err := other.AcceptCalendarInvitation(ctx, cal.ID, invitations[0].ID, accept)
+					require.NoError(t, err)
+					require.Equal(t, addr[0].Email, member.Email)
+					require.Equal(t, proton.CalendarPermissionsView, member.Permissions)
+
+					invitations, err = other.GetPendingCalendarInvitations(ctx)
+					require.NoError(t, err)
+					require.Empty(t, invitations)
+
+					// The member can unlock the calendar and read its events.
+					calendars, err := other.GetCalendars(ctx)
+					require.NoError(t, err)
+					require.Len(t, calendars, 1)
+
+					keys, err := other.GetCalendarKeys(ctx, cal.ID)
+					require.NoError(t, err)
+
+					passphrase, err := other.GetCalendarPassphrase(ctx, cal.ID)
+					require.NoError(t, err)
+
+					calPass, err := passphrase.Decrypt(member.ID, otherKR)
+					require.NoError(t, err)
+
+					sharedKR, err := keys.Unlock(calPass)
+					require.NoError(t, err)
+
+					events, err := other.GetAllCalendarEvents(ctx, cal.ID, nil)
+					require.NoError(t, err)
+					require.Len(t, events, 1)
+
+					// The event is written by the owner, whose keys are fetched to verify it.
+					_, err = events[0].Merge(member.ID, sharedKR, otherKR)
+					require.Error(t, err)
+
+					authorKRs, err := other.GetCalendarAuthorKeyRings(ctx, events, otherKR)
+					require.NoError(t, err)
+
+					event, err := events[0].MergeWithAuthors(member.ID, sharedKR, otherKR, authorKRs)
+					require.NoError(t, err)
+					require.Equal(t, "Event shared", event.Props.Get(ical.PropSummary).Value)
+
+					// The member can't write to the calendar until the owner lets it.
+					req, err := proton.NewCreateCalendarEventReq(newCalendarEvent("other", time.Now()), member.ID, sharedKR, otherKR)
+					require.NoError(t, err)
+
+					_, err = other.CreateCalendarEvent(ctx, cal.ID, req)
+					require.Error(t, err)
+
+					require.Len(t, events[0].Attendees, 1)
+
+					attendeeReq := proton.UpdateCalendarAttendeeReq{Status: proton.CalendarAttendeeStatusYes}
+
+					_, err = other.UpdateCalendarAttendee(ctx, cal.ID, events[0].ID, events[0].Attendees[0].ID, attendeeReq)
+					require.Error(t, err)
+
+					member, err = c.UpdateCalendarMember(ctx, cal.ID, member.ID, proton.UpdateCalendarMemberReq{Permissions: proton.CalendarPermissionsEdit})
+					require.NoError(t, err)
+					require.Equal(t, proton.CalendarPermissionsEdit, member.Permissions)
+
+					_, err = other.CreateCalendarEvent(ctx, cal.ID, req)
+					require.NoError(t, err)
+
+					updated, err := other.UpdateCalendarAttendee(ctx, cal.ID, events[0].ID, events[0].Attendees[0].ID, attendeeReq)
+					require.NoError(t, err)
+					require.Equal(t, proton.CalendarAttendeeStatusYes, updated.Status)
+
+					// Only the owner manages the members; the owner can't be removed.
+					_, err = other.UpdateCalendarMember(ctx, cal.ID, member.ID, proton.UpdateCalendarMemberReq{Permissions: proton.CalendarPermissionsOwner})
+					require.Error(t, err)
+
+					_, err = c.UpdateCalendarMember(ctx, cal.ID, member.ID, proton.UpdateCalendarMemberReq{Permissions: proton.CalendarPermissionsOwner})
+					require.Error(t, err)
+
+					_, err = c.UpdateCalendarMember(ctx, cal.ID, member.ID, proton.UpdateCalendarMemberReq{Permissions: proton.CalendarPermissionsEdit | proton.CalendarPermissionOwner})
+					require.Error(t, err)
+
+					require.Error(t, other.DeleteCalendarMember(ctx, cal.ID, owner.ID))
+					require.Error(t, c.DeleteCalendarMember(ctx, cal.ID, owner.ID))
+
+					// The member leaves the calendar.
+					require.NoError(t, other.DeleteCalendarMember(ctx, cal.ID, member.ID))
+
+					calendars, err = other.GetCalendars(ctx)
+					require.NoError(t, err)
+					require.Empty(t, calendars)
+
+					// Declined and deleted invitations are no longer pending.
+					invitation, err = c.ShareCalendar(ctx, cal.ID, owner.ID, ownerKR, addr[0].Email, proton.CalendarPermissionsView)
+					require.NoError(t, err)
+					require.NoError(t, other.DeclineCalendarInvitation(ctx, cal.ID, invitation.ID))
+
+					_, err = other.AcceptCalendarInvitation(ctx, cal.ID, invitation.ID, accept)
+					require.Error(t, err)
+
+					invitation, err = c.ShareCalendar(ctx, cal.ID, owner.ID, ownerKR, addr[0].Email, proton.CalendarPermissionsView)
+					require.NoError(t, err)
+					require.NoError(t, c.DeleteCalendarInvitation(ctx, cal.ID, invitation.ID))
+
+					invitations, err = other.GetPendingCalendarInvitations(ctx)
+					require.NoError(t, err)
+					require.Empty(t, invitations)
+
+					invitations, err = c.GetCalendarInvitations(ctx, cal.ID)
+					require.NoError(t, err)
+					require.Equal(t, []proton.CalendarInvitationStatus{
+						proton.CalendarInvitationStatusAccepted,
+						proton.CalendarInvitationStatusDeclined,
+					}, xslices.Map(invitations, func(invitation proton.CalendarInvitation) proton.CalendarInvitationStatus {
+						return invitation.Status
+					}))
+				})
+			})
+		})
+	})
+}
+
+func TestServer_CalendarEvents(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				day := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+				// Create an event on each of the first five days of the year.
+				for i := 0; i < 5; i++ {
+					req, err := proton.NewCreateCalendarEventReq(newCalendarEvent(fmt.Sprintf("event-%d", i), day.AddDate(0, 0, i)), member.ID, calKR, addrKR)
+					require.NoError(t, err)
+
+					event, err := c.CreateCalendarEvent(ctx, cal.ID, req)
+					require.NoError(t, err)
+					require.Equal(t, fmt.Sprintf("event-%d", i), event.UID)
+					require.Equal(t, day.AddDate(0, 0, i).Unix(), event.StartTime)
+					require.Equal(t, member.Email, event.Author)
+				}
+
+				// Events with the same UID are rejected.
+				req, err := proton.NewCreateCalendarEventReq(newCalendarEvent("event-0", day), member.ID, calKR, addrKR)
+				require.NoError(t, err)
+
+				_, err = c.CreateCalendarEvent(ctx, cal.ID, req)
+				require.Error(t, err)
+
+				// Events are listed by start time, page by page.
+				total, err := c.CountCalendarEvents(ctx, cal.ID)
+				require.NoError(t, err)
+				require.Equal(t, 5, total)
+
+				page, err := c.GetCalendarEvents(ctx, cal.ID, 1, 2, nil)
+				require.NoError(t, err)
+				require.Equal(t, []string{"event-2", "event-3"}, xslices.Map(page, func(event proton.CalendarEvent) string { return event.UID }))
+
+				// Negative pages are refused.
+				_, err = c.GetCalendarEvents(ctx, cal.ID, -1, 2, nil)
+				require.Error(t, err)
+
+				// Events can be filtered by time range and UID.
+				events, err := c.GetAllCalendarEvents(ctx, cal.ID, url.Values{
+					"Start": {strconv.FormatInt(day.AddDate(0, 0, 1).Unix(), 10)},
+					"End":   {strconv.FormatInt(day.AddDate(0, 0, 3).Unix(), 10)},
+				})
+				require.NoError(t, err)
+				require.Equal(t, []string{"event-1", "event-2"}, xslices.Map(events, func(event proton.CalendarEvent) string { return event.UID }))
+
+				events, err = c.GetAllCalendarEvents(ctx, cal.ID, url.Values{"UID": {"event-4"}})
+				require.NoError(t, err)
+				require.Len(t, events, 1)
+
+				// The event can be decrypted with the calendar keyring and verified with the keys of its author.
+				authorKRs, err := c.GetCalendarAuthorKeyRings(ctx, events, addrKR)
+				require.NoError(t, err)
+
+				merged, err := events[0].MergeWithAuthors(member.ID, calKR, addrKR, authorKRs)
+				require.NoError(t, err)
+				require.Equal(t, "Event event-4", merged.Props.Get(ical.PropSummary).Value)
+				require.Len(t, merged.Children, 1)
+
+				// The event can be moved and renamed.
+				update := newCalendarEvent("event-4", day.AddDate(0, 0, 10))
+				update.Props.SetText(ical.PropSummary, "Moved")
+
+				updateReq, err := proton.NewUpdateCalendarEventReq(update, events[0], member.ID, calKR, addrKR)
+				require.NoError(t, err)
+
+				updated, err := c.UpdateCalendarEvent(ctx, cal.ID, events[0].ID, updateReq)
+				require.NoError(t, err)
+				require.Equal(t, events[0].ID, updated.ID)
+				require.Equal(t, day.AddDate(0, 0, 10).Unix(), updated.StartTime)
+
+				// The session keys of the event are kept.
+				require.Equal(t, events[0].SharedKeyPacket, updated.SharedKeyPacket)
+
+				updated, err = c.GetCalendarEvent(ctx, cal.ID, events[0].ID)
+				require.NoError(t, err)
+
+				merged, err = updated.MergeWithAuthors(member.ID, calKR, addrKR, authorKRs)
+				require.NoError(t, err)
+				require.Equal(t, "Moved", merged.Props.Get(ical.PropSummary).Value)
+
+				// The event can be deleted.
+				require.NoError(t, c.DeleteCalendarEvent(ctx, cal.ID, events[0].ID, member.ID))
+
+				_, err = c.GetCalendarEvent(ctx, cal.ID, events[0].ID)
+				require.Error(t, err)
+
+				require.Error(t, c.DeleteCalendarEvent(ctx, cal.ID, events[0].ID, member.ID))
+			})
+		})
+	})
+}
+
+func TestServer_CalendarModelEvents(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				eventID, err := c.GetLatestCalendarModelEventID(ctx, cal.ID)
+				require.NoError(t, err)
+
+				// There are no events yet.
+				modelEvent, err := c.GetCalendarModelEvent(ctx, cal.ID, eventID)
+				require.NoError(t, err)
+				require.Equal(t, eventID, modelEvent.CalendarModelEventID)
+				require.Empty(t, modelEvent.Events)
+
+				// Creating and deleting events emits an event each.
+				var eventIDs []string
+
+				for i := 0; i < 3; i++ {
+					req, err := proton.NewCreateCalendarEventReq(newCalendarEvent(fmt.Sprintf("event-%d", i), time.Now()), member.ID, calKR, addrKR)
+					require.NoError(t, err)
+
+					event, err := c.CreateCalendarEvent(ctx, cal.ID, req)
+					require.NoError(t, err)
+
+					eventIDs = append(eventIDs, event.ID)
+				}
+
+				require.NoError(t, c.DeleteCalendarEvent(ctx, cal.ID, eventIDs[0], member.ID))
+
+				// The client follows the stream until there are no more events.
+				s.SetMaxUpdatesPerEvent(1)
+
+				modelEvent, err = c.GetCalendarModelEvent(ctx, cal.ID, eventID)
+				require.NoError(t, err)
+				require.Len(t, modelEvent.Events, 4)
+
+				for i, eventID := range eventIDs {
+					require.Equal(t, proton.EventCreate, modelEvent.Events[i].Action)
+					require.Equal(t, eventID, modelEvent.Events[i].Event.ID)
+				}
+
+				require.Equal(t, proton.EventDelete, modelEvent.Events[3].Action)
+				require.Equal(t, eventIDs[0], modelEvent.Events[3].ID)
+
+				latest, err := c.GetLatestCalendarModelEventID(ctx, cal.ID)
+				require.NoError(t, err)
+				require.Equal(t, latest, modelEvent.CalendarModelEventID)
+			})
+		})
+	})
+}
+
+func TestServer_CalendarOccurrences(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				day := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+
+				// A daily event for a week.
+				master := newCalendarEvent("daily", day)
+				master.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=DAILY;COUNT=7"})
+
+				// Its occurrence on the 4th is moved to the next month.
+				override := newCalendarEvent("daily", day.AddDate(0, 1, 0))
+				override.Props.SetDateTime(ical.PropRecurrenceID, day.AddDate(0, 0, 2))
+
+				for _, event := range []*ical.Event{master, override} {
+					req, err := proton.NewCreateCalendarEventReq(event, member.ID, calKR, addrKR)
+					require.NoError(t, err)
+
+					_, err = c.CreateCalendarEvent(ctx, cal.ID, req)
+					require.NoError(t, err)
+				}
+
+				// The moved occurrence is not returned, though the override is outside of the window.
+				occurrences, skipped, err := c.GetCalendarOccurrences(ctx, cal.ID, member.ID, calKR, addrKR, day, day.AddDate(0, 0, 4), time.UTC)
+				require.NoError(t, err)
+				require.Empty(t, skipped)
+
+				require.Equal(t, []time.Time{day, day.AddDate(0, 0, 1), day.AddDate(0, 0, 3)}, xslices.Map(occurrences, func(occurrence proton.CalendarOccurrence) time.Time {
+					return occurrence.Start
+				}))
+
+				// The moved occurrence is returned at its new time.
+				occurrences, skipped, err = c.GetCalendarOccurrences(ctx, cal.ID, member.ID, calKR, addrKR, day.AddDate(0, 1, 0), day.AddDate(0, 2, 0), time.UTC)
+				require.NoError(t, err)
+				require.Empty(t, skipped)
+				require.Len(t, occurrences, 1)
+				require.Equal(t, day.AddDate(0, 0, 2), occurrences[0].RecurrenceID)
+				require.Equal(t, "Event daily", occurrences[0].Event.Props.Get(ical.PropSummary).Value)
+			})
+		})
+	})
+}
+
+func TestServer_CalendarFreeBusy(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(work proton.Calendar, workMember proton.CalendarMember, workKR, addrKR *crypto.KeyRing) {
+				withCalendar(ctx, t, s, c, "pass", func(home proton.Calendar, homeMember proton.CalendarMember, homeKR, _ *crypto.KeyRing) {
+					day := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+
+					// A daily meeting for three days, whose second occurrence is cancelled.
+					daily := newCalendarEvent("daily", day)
+					daily.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=DAILY;COUNT=3"})
+
+					cancelled := newCalendarEvent("daily", day.AddDate(0, 0, 1))
+					cancelled.Props.SetDateTime(ical.PropRecurrenceID, day.AddDate(0, 0, 1))
+					cancelled.Props.SetText(ical.PropStatus, string(ical.EventCancelled))
+
+					// A meeting overlapping the first occurrence.
+					overlap := newCalendarEvent("overlap", day.Add(30*time.Minute))
+
+					// A reminder that doesn't take time.
+					transparent := newCalendarEvent("transparent", day.Add(3*time.Hour))
+					transparent.Props.SetText(ical.PropTransparency, "TRANSPARENT")
+
+					for _, event := range []*ical.Event{daily, cancelled, overlap, transparent} {
+						req, err := proton.NewCreateCalendarEventReq(event, workMember.ID, workKR, addrKR)
+						require.NoError(t, err)
+
+						_, err = c.CreateCalendarEvent(ctx, work.ID, req)
+						require.NoError(t, err)
+					}
+
+					// A personal appointment right after the third occurrence.
+					req, err := proton.NewCreateCalendarEventReq(newCalendarEvent("home", day.AddDate(0, 0, 2).Add(time.Hour)), homeMember.ID, homeKR, addrKR)
+					require.NoError(t, err)
+
+					_, err = c.CreateCalendarEvent(ctx, home.ID, req)
+					require.NoError(t, err)
+
+					addr, err := c.GetAddresses(ctx)
+					require.NoError(t, err)
+
+					freeBusy, err := c.GetFreeBusy(ctx, []string{work.ID, home.ID}, map[string]*crypto.KeyRing{addr[0].ID: addrKR}, day, day.AddDate(0, 0, 7))
+					require.NoError(t, err)
+
+					require.Equal(t, []proton.FreeBusyInterval{
+						{Start: day, End: day.Add(90 * time.Minute)},
+						{Start: day.AddDate(0, 0, 2), End: day.AddDate(0, 0, 2).Add(time.Hour)},
+					}, freeBusy.Calendars[work.ID])
+
+					require.Equal(t, []proton.FreeBusyInterval{
+						{Start: day.AddDate(0, 0, 2).Add(time.Hour), End: day.AddDate(0, 0, 2).Add(2 * time.Hour)},
+					}, freeBusy.Calendars[home.ID])
+
+					require.Equal(t, []proton.FreeBusyInterval{
+						{Start: day, End: day.Add(90 * time.Minute)},
+						{Start: day.AddDate(0, 0, 2), End: day.AddDate(0, 0, 2).Add(2 * time.Hour)},
+					}, freeBusy.Busy)
+
+					// Intervals are clipped to the requested window.
+					freeBusy, err = c.GetFreeBusy(ctx, []string{work.ID}, map[string]*crypto.KeyRing{addr[0].ID: addrKR}, day.Add(time.Hour), day.AddDate(0, 0, 1))
+					require.NoError(t, err)
+					require.Equal(t, []proton.FreeBusyInterval{{Start: day.Add(time.Hour), End: day.Add(90 * time.Minute)}}, freeBusy.Busy)
+					require.Empty(t, freeBusy.Skipped)
+
+					// An event whose recurrence rule can't be read is reported as skipped rather than as free time.
+					broken := newCalendarEvent("broken", day.AddDate(0, 0, 3))
+					broken.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=SOMETIMES"})
+
+					req, err = proton.NewCreateCalendarEventReq(broken, homeMember.ID, homeKR, addrKR)
+					require.NoError(t, err)
+
+					stored, err := c.CreateCalendarEvent(ctx, home.ID, req)
+					require.NoError(t, err)
+
+					freeBusy, err = c.GetFreeBusy(ctx, []string{work.ID, home.ID}, map[string]*crypto.KeyRing{addr[0].ID: addrKR}, day, day.AddDate(0, 0, 7))
+					require.NoError(t, err)
+					require.Equal(t, map[string][]string{home.ID: {stored.ID}}, freeBusy.Skipped)
+				})
+			})
+		})
+	})
+}
+
+func TestServer_CalendarAlarms(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				day := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+				createEvent := func(event *ical.Event) {
+					req, err := proton.NewCreateCalendarEventReq(event, member.ID, calKR, addrKR)
+					require.NoError(t, err)
+
+					_, err = c.CreateCalendarEvent(ctx, cal.ID, req)
+					require.NoError(t, err)
+				}
+
+				// A meeting with two alarms 15 minutes before it starts.
+				meeting := newCalendarEvent("meeting", day.Add(time.Hour))
+
+				sound := ical.NewComponent(ical.CompAlarm)
+				sound.Props.SetText(ical.PropAction, "AUDIO")
+				sound.Props.Set(&ical.Prop{Name: ical.PropTrigger, Params: ical.Params{}, Value: "-PT15M"})
+
+				meeting.Children = append(meeting.Children, sound)
+				createEvent(meeting)
+
+				// A recurring event with an alarm at its end and an absolute alarm, which is fired once.
+				recurring := newCalendarEvent("recurring", day.Add(2*time.Hour))
+				recurring.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=HOURLY;INTERVAL=2;COUNT=2"})
+				recurring.Children = nil
+
+				atEnd := ical.NewComponent(ical.CompAlarm)
+				atEnd.Props.SetText(ical.PropAction, "DISPLAY")
+				atEnd.Props.Set(&ical.Prop{Name: ical.PropTrigger, Params: ical.Params{ical.ParamRelated: {"END"}}, Value: "PT0S"})
+
+				absolute := ical.NewComponent(ical.CompAlarm)
+				absolute.Props.SetText(ical.PropAction, "DISPLAY")
+				absolute.Props.SetText(ical.PropDescription, "Absolute")
+				absolute.Props.SetDateTime(ical.PropTrigger, day.Add(90*time.Minute))
+
+				recurring.Children = append(recurring.Children, atEnd, absolute)
+				createEvent(recurring)
+
+				addr, err := c.GetAddresses(ctx)
+				require.NoError(t, err)
+
+				clock := newTestAlarmClock(day)
+				storePath := filepath.Join(t.TempDir(), "alarms")
+
+				store, err := proton.NewCalendarAlarmFileStore(storePath)
+				require.NoError(t, err)
+
+				streamCtx, cancel := context.WithCancel(ctx)
+				defer cancel()
+
+				// The period must be positive.
+				_, err = c.NewCalendarAlarmStream(streamCtx, map[string]*crypto.KeyRing{addr[0].ID: addrKR}, 0, clock, store)
+				require.Error(t, err)
+
+				alarmCh, err := c.NewCalendarAlarmStream(streamCtx, map[string]*crypto.KeyRing{addr[0].ID: addrKR}, time.Hour, clock, store)
+				require.NoError(t, err)
+
+				alarm := clock.waitAlarm(t, alarmCh)
+				require.Equal(t, "meeting", alarm.Occurrence.UID)
+				require.Equal(t, day.Add(45*time.Minute), alarm.Time)
+				require.Equal(t, "DISPLAY", alarm.Action)
+				require.Equal(t, "Event meeting", alarm.Description)
+				require.False(t, clock.Now().Before(alarm.Time))
+
+				// The second alarm with the same trigger is fired too.
+				sounded := clock.waitAlarm(t, alarmCh)
+				require.Equal(t, "meeting", sounded.Occurrence.UID)
+				require.Equal(t, alarm.Time, sounded.Time)
+				require.Equal(t, "AUDIO", sounded.Action)
+				require.NotEqual(t, alarm.ID, sounded.ID)
+
+				// An event created while the stream runs is followed.
+				createEvent(newCalendarEvent("later", day.Add(5*time.Hour)))
+
+				// So are the events of a calendar created while the stream runs.
+				withCalendar(ctx, t, s, c, "pass", func(other proton.Calendar, otherMember proton.CalendarMember, otherKR, addrKR *crypto.KeyRing) {
+					req, err := proton.NewCreateCalendarEventReq(newCalendarEvent("other", day.Add(4*time.Hour)), otherMember.ID, otherKR, addrKR)
+					require.NoError(t, err)
+
+					_, err = c.CreateCalendarEvent(ctx, other.ID, req)
+					require.NoError(t, err)
+				})
+
+				var got []string
+
+				for len(got) < 5 {
+					alarm := clock.waitAlarm(t, alarmCh)
+					got = append(got, fmt.Sprintf("%v %v", alarm.Occurrence.UID, alarm.Time.Sub(day)))
+				}
+
+				require.Equal(t, []string{"recurring 1h30m0s", "recurring 3h0m0s", "other 3h45m0s", "later 4h45m0s", "recurring 5h0m0s"}, got)
+
+				cancel()
+
+				// A stream started later with the same store, read again from its file, doesn't fire the alarms again,
+				// but fires the missed alarms of the occurrences that haven't ended yet.
+				clock = newTestAlarmClock(day.Add(5*time.Hour + 30*time.Minute))
+
+				store, err = proton.NewCalendarAlarmFileStore(storePath)
+				require.NoError(t, err)
+
+				fired, err := store.IsFired(alarm.ID)
+				require.NoError(t, err)
+				require.True(t, fired)
+
+				alarmCh, err = c.NewCalendarAlarmStream(ctx, map[string]*crypto.KeyRing{addr[0].ID: addrKR}, time.Hour, clock, store)
+				require.NoError(t, err)
+
+				select {
+				case alarm := <-alarmCh:
+					require.Fail(t, "unexpected alarm", alarm.ID)
+
+				case <-time.After(100 * time.Millisecond):
+					// ...
+				}
+
+				alarmCh, err = c.NewCalendarAlarmStream(ctx, map[string]*crypto.KeyRing{addr[0].ID: addrKR}, time.Hour, clock, proton.NewCalendarAlarmMemoryStore())
+				require.NoError(t, err)
+
+				alarm = clock.waitAlarm(t, alarmCh)
+				require.Equal(t, "later", alarm.Occurrence.UID)
+			})
+		})
+	})
+}
+
+func TestServer_CalendarImportExport(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				ics := ical.NewCalendar()
+				ics.Props.SetText(ical.PropVersion, "2.0")
+				ics.Props.SetText(ical.PropProductID, "-//test//EN")
+
+				for i := 0; i < 3; i++ {
+					ics.Children = append(ics.Children, newCalendarEvent(fmt.Sprintf("event-%d", i), time.Now().AddDate(0, 0, i)).Component)
+				}
+
+				created, err := c.ImportCalendar(ctx, cal.ID, member.ID, ics, calKR, addrKR)
+				require.NoError(t, err)
+				require.Len(t, created, 3)
+
+				// Importing the same events again creates nothing.
+				created, err = c.ImportCalendar(ctx, cal.ID, member.ID, ics, calKR, addrKR)
+				require.NoError(t, err)
+				require.Empty(t, created)
+
+				// The exported calendar holds the imported events.
+				export, err := c.ExportCalendar(ctx, cal.ID, member.ID, calKR, addrKR)
+				require.NoError(t, err)
+				require.Equal(t, cal.Name, export.Props.Get(proton.FieldWRCalName).Value)
+
+				uids := xslices.Map(export.Events(), func(event ical.Event) string {
+					return event.Props.Get(ical.PropUID).Value
+				})
+
+				require.ElementsMatch(t, []string{"event-0", "event-1", "event-2"}, uids)
+			})
+		})
+	})
+}
+
+func TestServer_CalendarImportTimezones(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				ics := ical.NewCalendar()
+				ics.Props.SetText(ical.PropVersion, "2.0")
+				ics.Props.SetText(ical.PropProductID, "-//test//EN")
+
+				// A custom timezone which only the calendar defines.
+				custom := ical.NewComponent(ical.CompTimezone)
+				custom.Props.SetText(ical.PropTimezoneID, "Custom Zurich")
+				custom.Children = append(custom.Children,
+					newCalendarTimezoneObservance(ical.CompTimezoneStandard, "19701025T030000", "+0200", "+0100", "FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU"),
+					newCalendarTimezoneObservance(ical.CompTimezoneDaylight, "19700329T020000", "+0100", "+0200", "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU"),
+				)
+
+				// A timezone named after an IANA timezone.
+				mozilla := ical.NewComponent(ical.CompTimezone)
+				mozilla.Props.SetText(ical.PropTimezoneID, "/mozilla.org/20050126_1/Europe/Berlin")
+				mozilla.Children = append(mozilla.Children,
+					newCalendarTimezoneObservance(ical.CompTimezoneStandard, "19701025T030000", "+0200", "+0100", "FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU"),
+				)
+
+				ics.Children = append(ics.Children, custom, mozilla)
+
+				for uid, tzid := range map[string]string{"custom": "Custom Zurich", "mozilla": "/mozilla.org/20050126_1/Europe/Berlin"} {
+					event := ical.NewEvent()
+					event.Props.SetText(ical.PropUID, uid)
+					event.Props.SetText(ical.PropSummary, "Event "+uid)
+
+					for name, value := range map[string]string{ical.PropDateTimeStart: "20230701T100000", ical.PropDateTimeEnd: "20230701T110000"} {
+						prop := ical.NewProp(name)
+						prop.Value = value
+						prop.Params.Set(ical.PropTimezoneID, tzid)
+						event.Props.Set(prop)
+					}
+
+					ics.Children = append(ics.Children, event.Component)
+				}
+
+				// A modified occurrence identified by its time in the custom timezone.
+				override := ical.NewEvent()
+				override.Props.SetText(ical.PropUID, "custom")
+				override.Props.SetText(ical.PropSummary, "Event custom, modified")
+
+				for _, name := range []string{ical.PropDateTimeStart, ical.PropRecurrenceID} {
+					prop := ical.NewProp(name)
+					prop.Value = "20230701T100000"
+					prop.Params.Set(ical.PropTimezoneID, "Custom Zurich")
+					override.Props.Set(prop)
+				}
+
+				ics.Children = append(ics.Children, override.Component)
+
+				created, err := c.ImportCalendar(ctx, cal.ID, member.ID, ics, calKR, addrKR)
+				require.NoError(t, err)
+				require.Len(t, created, 3)
+
+				// The events are imported at their time in summer time.
+				start := time.Date(2023, 7, 1, 8, 0, 0, 0, time.UTC).Unix()
+
+				for _, event := range created {
+					require.Equal(t, start, event.StartTime)
+
+					switch event.UID {
+					case "custom":
+						require.Equal(t, "UTC", event.StartTimezone)
+
+					case "mozilla":
+						require.Equal(t, "Europe/Berlin", event.StartTimezone)
+					}
+				}
+
+				// The import does not modify the given calendar.
+				require.Equal(t, "Custom Zurich", ics.Events()[0].Props.Get(ical.PropDateTimeStart).Params.Get(ical.PropTimezoneID))
+
+				// Importing the same events again creates nothing, though their times are stored resolved.
+				created, err = c.ImportCalendar(ctx, cal.ID, member.ID, ics, calKR, addrKR)
+				require.NoError(t, err)
+				require.Empty(t, created)
+			})
+		})
+	})
+}
+
+func TestServer_CalendarImportFailure(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				ics := ical.NewCalendar()
+				ics.Props.SetText(ical.PropVersion, "2.0")
+				ics.Props.SetText(ical.PropProductID, "-//test//EN")
+
+				for i := 0; i < 3; i++ {
+					ics.Children = append(ics.Children, newCalendarEvent(fmt.Sprintf("event-%d", i), time.Now().AddDate(0, 0, i)).Component)
+				}
+
+				// The request creating the second event fails.
+				var calls int32
+
+				s.AddStatusHook(func(req *http.Request) (int, bool) {
+					if req.Method == http.MethodPut && strings.HasSuffix(req.URL.Path, "/events/sync") && atomic.AddInt32(&calls, 1) == 2 {
+						return http.StatusUnprocessableEntity, true
+					}
+
+					return 0, false
+				})
+
+				// The event created before the failure is still returned.
+				created, err := c.ImportCalendar(ctx, cal.ID, member.ID, ics, calKR, addrKR)
+				require.Error(t, err)
+				require.Len(t, created, 1)
+				require.Equal(t, "event-0", created[0].UID)
+
+				// Importing again creates the remaining events.
+				created, err = c.ImportCalendar(ctx, cal.ID, member.ID, ics, calKR, addrKR)
+				require.NoError(t, err)
+				require.Len(t, created, 2)
+			})
+		})
+	})
+}
+
+func TestServer_CalendarInvite(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				event := newCalendarEvent("invite", time.Now())
+
+				organizer := ical.NewProp(ical.PropOrganizer)
+				organizer.Value = "mailto:" + member.Email
+				event.Props.Set(organizer)
+
+				attendee := ical.NewProp(ical.PropAttendee)
+				attendee.Value = "mailto:other@example.com"
+				event.Props.Add(attendee)
+
+				// The organizer sends the invitation and records the event.
+				request, err := proton.NewCalendarInviteRequest(event)
+				require.NoError(t, err)
+
+				var buf bytes.Buffer
+
+				require.NoError(t, ical.NewEncoder(&buf).Encode(request))
+
+				invite, err := proton.ReadCalendarInvite(&buf)
+				require.NoError(t, err)
+
+				// Requests that aren't sent by the organizer are refused.
+				require.Error(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, "other@example.com", invite, calKR, addrKR))
+				require.NoError(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, member.Email, invite, calKR, addrKR))
+
+				events, err := c.GetAllCalendarEvents(ctx, cal.ID, url.Values{"UID": {"invite"}})
+				require.NoError(t, err)
+				require.Len(t, events, 1)
+				require.Len(t, events[0].Attendees, 1)
+				require.Equal(t, proton.CalendarAttendeeStatusPending, events[0].Attendees[0].Status)
+
+				// The attendee's reply is attached to a message.
+				reply, err := proton.NewCalendarInviteReply(event, "other@example.com", proton.CalendarAttendeeStatusYes)
+				require.NoError(t, err)
+
+				draft, err := c.CreateDraft(ctx, addrKR, proton.CreateDraftReq{
+					Message: proton.DraftTemplate{
+						Subject: "Accepted: Event invite",
+						Sender:  &mail.Address{Address: member.Email},
+						ToList:  []*mail.Address{{Address: member.Email}},
+					},
+				})
+				require.NoError(t, err)
+
+				req, err := proton.NewCalendarInviteAttachmentReq(draft.ID, reply)
+				require.NoError(t, err)
+
+				_, err = c.UploadAttachment(ctx, addrKR, req)
+				require.NoError(t, err)
+
+				// Applying the reply read from the message updates the attendee's status.
+				invite, err = c.GetMessageCalendarInvite(ctx, draft.ID, addrKR)
+				require.NoError(t, err)
+				require.Equal(t, proton.CalendarInviteMethodReply, invite.Method)
+
+				// Only the sender of a reply can answer for themselves.
+				require.Error(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, "mallory@example.com", invite, calKR, addrKR))
+				require.NoError(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, "other@example.com", invite, calKR, addrKR))
+
+				events, err = c.GetAllCalendarEvents(ctx, cal.ID, url.Values{"UID": {"invite"}})
+				require.NoError(t, err)
+				require.Len(t, events, 1)
+				require.Equal(t, proton.CalendarAttendeeStatusYes, events[0].Attendees[0].Status)
+
+				// Requests older than the stored event are refused.
+				event.Props.Set(&ical.Prop{Name: ical.PropSequence, Params: ical.Params{}, Value: "1"})
+
+				update, err := proton.NewCalendarInviteRequest(event)
+				require.NoError(t, err)
+
+				require.NoError(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, member.Email, proton.CalendarInvite{
+					Method:   proton.CalendarInviteMethodRequest,
+					Calendar: update,
+					Event:    &update.Events()[0],
+				}, calKR, addrKR))
+				require.Error(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, member.Email, proton.CalendarInvite{
+					Method:   proton.CalendarInviteMethodRequest,
+					Calendar: request,
+					Event:    &request.Events()[0],
+				}, calKR, addrKR))
+
+				// Requests from another organizer for the same event are refused.
+				hijack, err := proton.NewCalendarInviteRequest(event)
+				require.NoError(t, err)
+
+				hijack.Events()[0].Props.Get(ical.PropOrganizer).Value = "mailto:other@example.com"
+
+				require.Error(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, "other@example.com", proton.CalendarInvite{
+					Method:   proton.CalendarInviteMethodRequest,
+					Calendar: hijack,
+					Event:    &hijack.Events()[0],
+				}, calKR, addrKR))
+
+				// Applying the cancellation deletes the event.
+				cancel, err := proton.NewCalendarInviteCancel(event)
+				require.NoError(t, err)
+
+				cancelInvite := proton.CalendarInvite{
+					Method:   proton.CalendarInviteMethodCancel,
+					Calendar: cancel,
+					Event:    &cancel.Events()[0],
+				}
+
+				// Cancellations that aren't sent by the organizer of the stored event are refused.
+				require.Error(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, "other@example.com", cancelInvite, calKR, addrKR))
+				require.NoError(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, member.Email, cancelInvite, calKR, addrKR))
+
+				events, err = c.GetAllCalendarEvents(ctx, cal.ID, url.Values{"UID": {"invite"}})
+				require.NoError(t, err)
+				require.Empty(t, events)
+			})
+		})
+	})
+}
+
+func TestServer_CalendarInviteOccurrences(t *testing.T) {
+	withServer(t, func(ctx context.Context, s *Server, m *proton.Manager) {
+		withUser(ctx, t, s, m, "user", "pass", func(c *proton.Client) {
+			withCalendar(ctx, t, s, c, "pass", func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing) {
+				day := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+
+				organizer := ical.NewProp(ical.PropOrganizer)
+				organizer.Value = "mailto:" + member.Email
+
+				// A daily event whose second occurrence is moved.
+				daily := newCalendarEvent("daily", day)
+				daily.Props.Set(organizer)
+				daily.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=DAILY;COUNT=3"})
+
+				moved := newCalendarEvent("daily", day.AddDate(0, 0, 1).Add(2*time.Hour))
+				moved.Props.Set(organizer)
+				moved.Props.SetDateTime(ical.PropRecurrenceID, day.AddDate(0, 0, 1))
+				moved.Props.SetDateTime(ical.PropDateTimeStamp, time.Now())
+
+				// The override is sent along with the recurring event.
+				request, err := proton.NewCalendarInviteRequest(daily)
+				require.NoError(t, err)
+
+				request.Children = append([]*ical.Component{moved.Component}, request.Children...)
+
+				var buf bytes.Buffer
+
+				require.NoError(t, ical.NewEncoder(&buf).Encode(request))
+
+				invite, err := proton.ReadCalendarInvite(&buf)
+				require.NoError(t, err)
+				require.Nil(t, invite.Event.Props.Get(ical.PropRecurrenceID))
+				require.Len(t, invite.Overrides, 1)
+
+				require.NoError(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, member.Email, invite, calKR, addrKR))
+
+				getStarts := func() []time.Time {
+					occurrences, skipped, err := c.GetCalendarOccurrences(ctx, cal.ID, member.ID, calKR, addrKR, day, day.AddDate(0, 0, 7), time.UTC)
+					require.NoError(t, err)
+					require.Empty(t, skipped)
+
+					return xslices.Map(occurrences, func(occurrence proton.CalendarOccurrence) time.Time {
+						return occurrence.Start
+					})
+				}
+
+				require.Equal(t, []time.Time{day, day.AddDate(0, 0, 1).Add(2 * time.Hour), day.AddDate(0, 0, 2)}, getStarts())
+
+				// Cancelling an occurrence that isn't modified excludes it from the recurring event.
+				occurrence := newCalendarEvent("daily", day.AddDate(0, 0, 2))
+				occurrence.Props.Set(organizer)
+				occurrence.Props.SetDateTime(ical.PropRecurrenceID, day.AddDate(0, 0, 2))
+
+				cancel, err := proton.NewCalendarInviteCancel(occurrence)
+				require.NoError(t, err)
+
+				cancelInvite := proton.CalendarInvite{
+					Method:   proton.CalendarInviteMethodCancel,
+					Calendar: cancel,
+					Event:    &cancel.Events()[0],
+				}
+
+				require.Error(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, "other@example.com", cancelInvite, calKR, addrKR))
+				require.NoError(t, c.ApplyCalendarInvite(ctx, cal.ID, member.ID, member.Email, cancelInvite, calKR, addrKR))
+
+				require.Equal(t, []time.Time{day, day.AddDate(0, 0, 1).Add(2 * time.Hour)}, getStarts())
+
+				events, err := c.GetAllCalendarEvents(ctx, cal.ID, url.Values{"UID": {"daily"}})
+				require.NoError(t, err)
+				require.Len(t, events, 2)
+			})
+		})
+	})
+}
+
+func createVCard(t *testing.T, addrKR *crypto.KeyRing, name string, email ...string) *proton.Card {
+	card, err := proton.NewCard(addrKR, proton.CardTypeSigned)
+	require.NoError(t, err)
+
+	require.NoError(t, card.Set(addrKR, vcard.FieldUID, &vcard.Field{Value: fmt.Sprintf("proton-legacy-%v", uuid.NewString()), Group: "test"}))
+	require.NoError(t, card.Set(addrKR, vcard.FieldFormattedName, &vcard.Field{Value: name, Group: "test"}))
+	for _, email := range email {
+		require.NoError(t, card.Add(addrKR, vcard.FieldEmail, &vcard.Field{Value: email, Group: "test"}))
+	}
+
+	return card
+}
+
+func withServer(t *testing.T, fn func(ctx context.Context, s *Server, m *proton.Manager), opts ...Option) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := New(opts...)
+	defer s.Close()
+
+	m := proton.New(
+		proton.WithHostURL(s.GetHostURL()),
+		proton.WithCookieJar(newTestCookieJar()),
+		proton.WithTransport(proton.InsecureTransport()),
+	)
+	defer m.Close()
+
+	fn(ctx, s, m)
+}
+
+func withUser(ctx context.Context, t *testing.T, s *Server, m *proton.Manager, user, pass string, fn func(c *proton.Client)) {
+	_, _, err := s.CreateUser(user, []byte(pass))
+	require.NoError(t, err)
+
+	c, _, err := m.NewClientWithLogin(ctx, user, []byte(pass))
+	require.NoError(t, err)
+	defer c.Close()
+
+	fn(c)
+}
+
+func withVolume(
+	ctx context.Context,
+	t *testing.T,
+	c *proton.Client,
+	pass string,
+	fn func(addr proton.Address, addrKR *crypto.KeyRing, share proton.Share, shareKR *crypto.KeyRing),
+) {
+	user, err := c.GetUser(ctx)
+	require.NoError(t, err)
+
+	addr, err := c.GetAddresses(ctx)
+	require.NoError(t, err)
+
+	salt, err := c.GetSalts(ctx)
+	require.NoError(t, err)
+
+	keyPass, err := salt.SaltForKey([]byte(pass), user.Keys.Primary().ID)
+	require.NoError(t, err)
+
+	_, addrKRs, err := proton.Unlock(user, addr, keyPass, async.NoopPanicHandler{})
+	require.NoError(t, err)
+
+	req, err := proton.NewCreateVolumeReq(addr[0].ID, addrKRs[addr[0].ID])
+	require.NoError(t, err)
+
+	volume, err := c.CreateVolume(ctx, req)
+	require.NoError(t, err)
+
+	share, err := c.GetShare(ctx, volume.Share.ShareID)
+	require.NoError(t, err)
+
+	shareKR, err := share.GetKeyRing(addrKRs[addr[0].ID])
+	require.NoError(t, err)
+
+	fn(addr[0], addrKRs[addr[0].ID], share, shareKR)
+}
+
+func withCalendar(
+	ctx context.Context,
+	t *testing.T,
+	s *Server,
+	c *proton.Client,
+	pass string,
+	fn func(cal proton.Calendar, member proton.CalendarMember, calKR, addrKR *crypto.KeyRing),
+) {
+	user, err := c.GetUser(ctx)
+	require.NoError(t, err)
+
+	addr, err := c.GetAddresses(ctx)
+	require.NoError(t, err)
+
+	salt, err := c.GetSalts(ctx)
+	require.NoError(t, err)
+
+	keyPass, err := salt.SaltForKey([]byte(pass), user.Keys.Primary().ID)
+	require.NoError(t, err)
+
+	_, addrKRs, err := proton.Unlock(user, addr, keyPass, async.NoopPanicHandler{})
+	require.NoError(t, err)
+
+	calendarID, err := s.CreateCalendar(user.ID, addr[0].ID, []byte(pass), "Personal")
+	require.NoError(t, err)
+
+	cal, err := c.GetCalendar(ctx, calendarID)
+	require.NoError(t, err)
+
+	members, err := c.GetCalendarMembers(ctx, calendarID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+
+	keys, err := c.GetCalendarKeys(ctx, calendarID)
+	require.NoError(t, err)
+
+	passphrase, err := c.GetCalendarPassphrase(ctx, calendarID)
+	require.NoError(t, err)
+
+	calPass, err := passphrase.Decrypt(members[0].ID, addrKRs[addr[0].ID])
+	require.NoError(t, err)
+
+	calKR, err := keys.Unlock(calPass)
+	require.NoError(t, err)
+	require.Equal(t, 1, calKR.CountDecryptionEntities())
+
+	fn(cal, members[0], calKR, addrKRs[addr[0].ID])
+}
+
+// newCalendarEvent returns an event with the given UID lasting one hour from start, with an alarm.
+func newCalendarEvent(uid string, start time.Time) *ical.Event {
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, uid)
+	event.Props.SetDateTime(ical.PropDateTimeStart, start)
+	event.Props.SetDateTime(ical.PropDateTimeEnd, start.Add(time.Hour))
+	event.Props.SetText(ical.PropSummary, "Event "+uid)
+
+	alarm := ical.NewComponent(ical.CompAlarm)
+	alarm.Props.SetText(ical.PropAction, "DISPLAY")
+	alarm.Props.Set(&ical.Prop{Name: ical.PropTrigger, Params: ical.Params{}, Value: "-PT15M"})
+	event.Children = append(event.Children, alarm)
+
+	return event
+}
+
+func newCalendarTimezoneObservance(name, start, offsetFrom, offsetTo, rrule string) *ical.Component {
+	observance := ical.NewComponent(name)
+	observance.Props.Set(&ical.Prop{Name: ical.PropDateTimeStart, Params: ical.Params{}, Value: start})
+	observance.Props.Set(&ical.Prop{Name: ical.PropTimezoneOffsetFrom, Params: ical.Params{}, Value: offsetFrom})
+	observance.Props.Set(&ical.Prop{Name: ical.PropTimezoneOffsetTo, Params: ical.Params{}, Value: offsetTo})
+	observance.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: rrule})
+
+	return observance
+}
+
+func withMessages(ctx context.Context, t *testing.T, c *proton.Client, pass string, count int, fn func([]string)) {
+	user, err := c.GetUser(ctx)
+	require.NoError(t, err)
+
+	addr, err := c.GetAddresses(ctx)
+	require.NoError(t, err)
+
+	salt, err := c.GetSalts(ctx)
+	require.NoError(t, err)
+
+	keyPass, err := salt.SaltForKey([]byte(pass), user.Keys.Primary().ID)
+	require.NoError(t, err)
+
+	_, addrKRs, err := proton.Unlock(user, addr, keyPass, async.NoopPanicHandler{})
+	require.NoError(t, err)
+
+	fn(xslices.Map(importMessages(ctx, t, c, addr[0].ID, addrKRs[addr[0].ID], []string{}, proton.MessageFlagReceived, count), func(res proton.ImportRes) string {
+		return res.MessageID
+	}))
+}
+
+func importMessagesWithSubjectGenerator(
+	ctx context.Context,
+	t *testing.T,
+	c *proton.Client,
+	addrID string,
+	addrKR *crypto.KeyRing,
+	labelIDs []string,
+	flags proton.MessageFlag,
+	count int,
+	subjectGenerator func() string,
+) []proton.ImportRes {
+	req := iterator.Collect(iterator.Map(iterator.Counter(count), func(int) proton.ImportReq {
+		return proton.ImportReq{
+			Metadata: proton.ImportMetadata{
+				AddressID: addrID,
+				LabelIDs:  labelIDs,
+				Flags:     flags,
+				Unread:    true,
+			},
+			Message: newMessageLiteralWithSubject("sender@example.com", "recipient@example.com", subjectGenerator()),
+		}
+	}))
+
+	str, err := c.ImportMessages(ctx, addrKR, runtime.NumCPU(), runtime.NumCPU(), req...)
+	require.NoError(t, err)
+
+	res, err := stream.Collect(ctx, str)
+	require.NoError(t, err)
+
+	return res
+}
+
+func importMessages(
+	ctx context.Context,
+	t *testing.T,
+	c *proton.Client,
+	addrID string,
+	addrKR *crypto.KeyRing,
+	labelIDs []string,
+	flags proton.MessageFlag,
+	count int,
+) []proton.ImportRes {
+	return importMessagesWithSubjectGenerator(ctx, t, c, addrID, addrKR, labelIDs, flags, count, func() string {
+		return uuid.NewString()
+	})
+}
+
+func countBytesRead(ctl *proton.NetCtl, fn func()) uint64 {
+	var read uint64
+
+	ctl.OnRead(func(b []byte) {
+		atomic.AddUint64(&read, uint64(len(b)))
+	})
+
+	fn()
+
+	return read
+}
+
+type testAlarmClock struct {
+	now     time.Time
+	waiters []testAlarmClockWaiter
+	lock    sync.Mutex
+}
+
+type testAlarmClockWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func newTestAlarmClock(now time.Time) *testAlarmClock {
+	return &testAlarmClock{now: now}
+}
+
+func (clock *testAlarmClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+
+	return clock.now
+}
+
+func (clock *testAlarmClock) After(d time.Duration) <-chan time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+
+	ch := make(chan time.Time, 1)
+
+	clock.waiters = append(clock.waiters, testAlarmClockWaiter{deadline: clock.now.Add(d), ch: ch})
+
+	return ch
+}
+
+func (clock *testAlarmClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+
+	clock.now = clock.now.Add(d)
+
+	clock.waiters = xslices.Filter(clock.waiters, func(waiter testAlarmClockWaiter) bool {
+		if waiter.deadline.After(clock.now) {
+			return true
+		}
+
+		waiter.ch <- clock.now
+
+		return false
+	})
+}
+
+// waitAlarm advances the clock until the next alarm is received.
+func (clock *testAlarmClock) waitAlarm(t *testing.T, alarmCh <-chan proton.CalendarAlarm) proton.CalendarAlarm {
+	for i := 0; i < 1000; i++ {
+		select {
+		case alarm := <-alarmCh:
+			return alarm
+
+		case <-time.After(10 * time.Millisecond):
+			clock.Advance(5 * time.Minute)
+		}
+	}
+
+	require.FailNow(t, "no alarm received")
+
+	return proton.CalendarAlarm{}
 }
 
 type testCookieJar struct {
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) handleGetDriveShareURLs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		urls, err := s.b.ListShareURLs(c.GetString("UserID"), c.Param("shareID"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"ShareURLs": urls,
+		})
+	}
+}
+
+func (s *Server) handlePostDriveShareURLs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.CreateShareURLReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		url, err := s.b.CreateShareURL(c.GetString("UserID"), c.Param("shareID"), req)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"ShareURL": url,
+		})
+	}
+}
+
+func (s *Server) handlePutDriveShareURL() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.UpdateShareURLReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		url, err := s.b.UpdateShareURL(c.GetString("UserID"), c.Param("shareID"), c.Param("shareURLID"), req)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"ShareURL": url,
+		})
+	}
+}
+
+func (s *Server) handleDeleteDriveShareURL() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.b.DeleteShareURL(c.GetString("UserID"), c.Param("shareID"), c.Param("shareURLID")); err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handlePostDriveURLInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info, err := s.b.NewShareURLAuthInfo(c.Param("token"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, info)
+	}
+}
+
+func (s *Server) handlePostDriveURLAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req proton.ShareURLAuthReq
+
+		if err := c.BindJSON(&req); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		clientEphemeral, err := base64.StdEncoding.DecodeString(req.ClientEphemeral)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		clientProof, err := base64.StdEncoding.DecodeString(req.ClientProof)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		auth, err := s.b.NewShareURLAuth(c.Param("token"), clientEphemeral, clientProof, req.SRPSession)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, auth)
+	}
+}
+
+func (s *Server) handleGetDriveURL() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := s.b.GetShareURLToken(c.Param("token"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"Token": token,
+		})
+	}
+}
+
+// requireShareURLAuth checks that the request is made with an anonymous session opened on the requested share URL.
+func (s *Server) requireShareURLAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authUID := c.Request.Header.Get("x-pm-uid")
+		if authUID == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		auth := c.Request.Header.Get("Authorization")
+		if auth == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if err := s.b.VerifyShareURLAuth(authUID, strings.Split(auth, " ")[1], c.Param("token")); err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+	}
+}
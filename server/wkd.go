@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetWKDPolicy serves the policy file of the server's web key directory, which is empty.
+// The advanced method is served if the domain is given, the direct method otherwise.
+func (s *Server) handleGetWKDPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.isWKDRequest(c) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// handleGetWKDKey serves the keys of the addresses of the server's domain in its web key directory.
+// The local part of the address must be given by the l query parameter, as it can't be recovered from the hash.
+func (s *Server) handleGetWKDKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		localPart := c.Query("l")
+
+		if !s.isWKDRequest(c) || localPart == "" || proton.WKDHash(localPart) != c.Param("hash") {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		pubKeys, err := s.b.GetPublicKeys(strings.ToLower(localPart) + "@" + s.domain)
+		if err != nil || len(pubKeys) == 0 {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		var buf bytes.Buffer
+
+		for _, pubKey := range pubKeys {
+			key, err := crypto.NewKeyFromArmored(pubKey.PublicKey)
+			if err != nil {
+				_ = c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+
+			data, err := key.GetPublicKey()
+			if err != nil {
+				_ = c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+
+			buf.Write(data)
+		}
+
+		c.Data(http.StatusOK, "application/octet-stream", buf.Bytes())
+	}
+}
+
+// isWKDRequest returns whether the request is made to the server's domain: to its openpgpkey subdomain
+// with the domain in the path for the advanced method, or to the domain itself for the direct method.
+func (s *Server) isWKDRequest(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request.Host)
+	if err != nil {
+		host = c.Request.Host
+	}
+
+	if domain := c.Param("domain"); domain != "" {
+		return strings.EqualFold(domain, s.domain) && strings.EqualFold(host, "openpgpkey."+s.domain)
+	}
+
+	return strings.EqualFold(host, s.domain)
+}
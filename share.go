@@ -37,3 +37,23 @@ func (c *Client) GetShare(ctx context.Context, shareID string) (Share, error) {
 
 	return res.Share, nil
 }
+
+func (c *Client) CreateShare(ctx context.Context, volumeID string, req CreateShareReq) (CreateShareRes, error) {
+	var res struct {
+		Share CreateShareRes
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).SetBody(req).Post("/drive/volumes/" + volumeID + "/shares")
+	}); err != nil {
+		return CreateShareRes{}, err
+	}
+
+	return res.Share, nil
+}
+
+func (c *Client) DeleteShare(ctx context.Context, shareID string) error {
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.Delete("/drive/shares/" + shareID)
+	})
+}
@@ -89,3 +89,50 @@ const (
 	NoFlags ShareFlags = iota
 	PrimaryShare
 )
+
+type CreateShareReq struct {
+	AddressID  string
+	RootLinkID string // The link to share
+
+	ShareKey                 string // The private ShareKey, encrypted with a passphrase
+	SharePassphrase          string // The share passphrase, encrypted with the address keyring
+	SharePassphraseSignature string // The signature of the share passphrase, signed with the address keyring
+
+	PassphraseKeyPacket string // The key packet of the root link's NodePassphrase, encrypted with the share keyring
+	NameKeyPacket       string // The key packet of the root link's Name, encrypted with the share keyring
+}
+
+// NewCreateShareReq generates the keys of a new share rooted at the given link.
+// The key packets of the link's name and passphrase are decrypted with parentKR and re-encrypted with the new share key.
+func NewCreateShareReq(link Link, parentKR *crypto.KeyRing, addrID string, addrKR *crypto.KeyRing) (CreateShareReq, error) {
+	keys, err := generateNodeKeys(addrKR, addrKR)
+	if err != nil {
+		return CreateShareReq{}, err
+	}
+
+	passphraseKP, err := reencryptKeyPacket(link.NodePassphrase, parentKR, keys.kr)
+	if err != nil {
+		return CreateShareReq{}, err
+	}
+
+	nameKP, err := reencryptKeyPacket(link.Name, parentKR, keys.kr)
+	if err != nil {
+		return CreateShareReq{}, err
+	}
+
+	return CreateShareReq{
+		AddressID:  addrID,
+		RootLinkID: link.LinkID,
+
+		ShareKey:                 keys.Key,
+		SharePassphrase:          keys.Passphrase,
+		SharePassphraseSignature: keys.PassphraseSignature,
+
+		PassphraseKeyPacket: passphraseKP,
+		NameKeyPacket:       nameKP,
+	}, nil
+}
+
+type CreateShareRes struct {
+	ID string // Encrypted share ID
+}
@@ -0,0 +1,116 @@
+package proton
+
+import (
+	"context"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/go-resty/resty/v2"
+)
+
+func (c *Client) ListShareURLs(ctx context.Context, shareID string) ([]ShareURL, error) {
+	var res struct {
+		ShareURLs []ShareURL
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Get("/drive/shares/" + shareID + "/urls")
+	}); err != nil {
+		return nil, err
+	}
+
+	return res.ShareURLs, nil
+}
+
+func (c *Client) CreateShareURL(ctx context.Context, shareID string, req CreateShareURLReq) (ShareURL, error) {
+	var res struct {
+		ShareURL ShareURL
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).SetBody(req).Post("/drive/shares/" + shareID + "/urls")
+	}); err != nil {
+		return ShareURL{}, err
+	}
+
+	return res.ShareURL, nil
+}
+
+// CreateShareURLWithPassword creates a read-only share URL protected by a random password.
+// If customPassword is not empty, it is appended to the random password.
+// It returns the created share URL and the full password needed to open it.
+func (c *Client) CreateShareURLWithPassword(
+	ctx context.Context,
+	share Share,
+	addrKR *crypto.KeyRing,
+	creatorEmail string,
+	customPassword []byte,
+) (ShareURL, []byte, error) {
+	modulus, err := c.m.AuthModulus(ctx)
+	if err != nil {
+		return ShareURL{}, nil, err
+	}
+
+	password, err := GenerateShareURLPassword()
+	if err != nil {
+		return ShareURL{}, nil, err
+	}
+
+	flags := ShareURLFlagGeneratedPasswordIncluded
+
+	if len(customPassword) > 0 {
+		password = append(password, customPassword...)
+		flags |= ShareURLFlagCustomPassword
+	}
+
+	urlPassword, err := NewShareURLPassword(share, addrKR, modulus, password, flags)
+	if err != nil {
+		return ShareURL{}, nil, err
+	}
+
+	shareURL, err := c.CreateShareURL(ctx, share.ShareID, CreateShareURLReq{
+		ShareURLPassword: urlPassword,
+		CreatorEmail:     creatorEmail,
+		Permissions:      ShareURLPermissionRead,
+	})
+	if err != nil {
+		return ShareURL{}, nil, err
+	}
+
+	return shareURL, password, nil
+}
+
+func (c *Client) UpdateShareURL(ctx context.Context, shareID, shareURLID string, req UpdateShareURLReq) (ShareURL, error) {
+	var res struct {
+		ShareURL ShareURL
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).SetBody(req).Put("/drive/shares/" + shareID + "/urls/" + shareURLID)
+	}); err != nil {
+		return ShareURL{}, err
+	}
+
+	return res.ShareURL, nil
+}
+
+func (c *Client) DeleteShareURL(ctx context.Context, shareID, shareURLID string) error {
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.Delete("/drive/shares/" + shareID + "/urls/" + shareURLID)
+	})
+}
+
+// GetShareURLToken returns the content of a share URL.
+// The client must have been created with Manager.NewClientWithShareURL.
+func (c *Client) GetShareURLToken(ctx context.Context, token string) (ShareURLToken, error) {
+	var res struct {
+		Token ShareURLToken
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Get("/drive/urls/" + token)
+	}); err != nil {
+		return ShareURLToken{}, err
+	}
+
+	return res.Token, nil
+}
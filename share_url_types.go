@@ -0,0 +1,314 @@
+package proton
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/ProtonMail/go-srp"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// shareURLPasswordLength is the length of the random part of a share URL password.
+const shareURLPasswordLength = 12
+
+// ShareURL is a public, password protected link to a share.
+type ShareURL struct {
+	ShareURLID string // Encrypted share URL ID
+	ShareID    string // Encrypted ID of the share the URL points to
+	Token      string // The public token identifying the URL
+
+	CreateTime     int64 // Creation time of the URL in Unix time
+	ExpirationTime int64 // Expiration time of the URL in Unix time, 0 if it never expires
+	LastAccessTime int64 // Last access time of the URL in Unix time
+
+	MaxAccesses int // Maximum number of accesses, 0 if unlimited
+	NumAccesses int // Number of times the URL was accessed
+
+	CreatorEmail string              // Email of the URL creator
+	Permissions  ShareURLPermissions // What anonymous users may do with the URL
+	Flags        ShareURLFlags       // How the URL password was created
+
+	URLPasswordSalt          string `json:"UrlPasswordSalt"` // Salt used to derive the SRP verifier from the URL password
+	SharePasswordSalt        string // Salt used to derive the share password from the URL password
+	SRPVerifier              string // SRP verifier of the URL password
+	SRPModulusID             string // ID of the SRP modulus used to derive the verifier
+	Password                 string // The URL password, encrypted with the creator's address keyring
+	SharePassphraseKeyPacket string // The key packet of the share passphrase, encrypted with the share password
+}
+
+// GetPassword decrypts the URL password with the creator's address keyring.
+func (u ShareURL) GetPassword(addrKR *crypto.KeyRing) ([]byte, error) {
+	enc, err := crypto.NewPGPMessageFromArmored(u.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := addrKR.Decrypt(enc, nil, crypto.GetUnixTime())
+	if err != nil {
+		return nil, err
+	}
+
+	return dec.GetBinary(), nil
+}
+
+type ShareURLPermissions int
+
+const ShareURLPermissionRead ShareURLPermissions = 4
+
+type ShareURLFlags int
+
+const (
+	ShareURLFlagCustomPassword ShareURLFlags = 1 << iota
+	ShareURLFlagGeneratedPasswordIncluded
+)
+
+// ShareURLPassword holds everything the API needs to know about the password of a share URL.
+type ShareURLPassword struct {
+	Flags ShareURLFlags
+
+	URLPasswordSalt          string `json:"UrlPasswordSalt"`
+	SharePasswordSalt        string
+	SRPVerifier              string
+	SRPModulusID             string
+	Password                 string
+	SharePassphraseKeyPacket string
+}
+
+// NewShareURLPassword derives the SRP verifier and the share passphrase key packet from the given URL password.
+// The share passphrase is decrypted with addrKR, which is also used to encrypt the password for later retrieval.
+func NewShareURLPassword(share Share, addrKR *crypto.KeyRing, modulus AuthModulus, password []byte, flags ShareURLFlags) (ShareURLPassword, error) {
+	urlSalt, err := crypto.RandomToken(16)
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	shareSalt, err := crypto.RandomToken(16)
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	srpAuth, err := srp.NewAuthForVerifier(password, modulus.Modulus, urlSalt)
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	verifier, err := srpAuth.GenerateVerifier(2048)
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	sharePassword, err := saltShareURLPassword(password, shareSalt)
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	enc, err := crypto.NewPGPMessageFromArmored(share.Passphrase)
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	split, err := enc.SplitMessage()
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	sk, err := addrKR.DecryptSessionKey(split.GetBinaryKeyPacket())
+	if err != nil {
+		return ShareURLPassword{}, fmt.Errorf("failed to decrypt share passphrase session key: %w", err)
+	}
+
+	kp, err := crypto.EncryptSessionKeyWithPassword(sk, sharePassword)
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	encPassword, err := addrKR.Encrypt(crypto.NewPlainMessage(password), nil)
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	armPassword, err := encPassword.GetArmored()
+	if err != nil {
+		return ShareURLPassword{}, err
+	}
+
+	return ShareURLPassword{
+		Flags: flags,
+
+		URLPasswordSalt:          base64.StdEncoding.EncodeToString(urlSalt),
+		SharePasswordSalt:        base64.StdEncoding.EncodeToString(shareSalt),
+		SRPVerifier:              base64.StdEncoding.EncodeToString(verifier),
+		SRPModulusID:             modulus.ModulusID,
+		Password:                 armPassword,
+		SharePassphraseKeyPacket: base64.StdEncoding.EncodeToString(kp),
+	}, nil
+}
+
+// GenerateShareURLPassword returns a random password suitable for a share URL.
+func GenerateShareURLPassword() ([]byte, error) {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	// Random bytes above the largest multiple of the alphabet's length are rejected, so each character is equally likely.
+	const maxByte = 256 - 256%len(alphabet)
+
+	password := make([]byte, 0, shareURLPasswordLength)
+
+	for len(password) < shareURLPasswordLength {
+		token, err := crypto.RandomToken(shareURLPasswordLength)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range token {
+			if int(b) >= maxByte || len(password) == shareURLPasswordLength {
+				continue
+			}
+
+			password = append(password, alphabet[int(b)%len(alphabet)])
+		}
+	}
+
+	return password, nil
+}
+
+func saltShareURLPassword(password, salt []byte) ([]byte, error) {
+	salted, err := srp.MailboxPassword(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return salted[len(salted)-31:], nil
+}
+
+type CreateShareURLReq struct {
+	ShareURLPassword
+
+	CreatorEmail   string
+	Permissions    ShareURLPermissions
+	ExpirationTime int64 // Expiration time of the URL in Unix time, 0 if it never expires
+	MaxAccesses    int   // Maximum number of accesses, 0 if unlimited
+}
+
+type UpdateShareURLReq struct {
+	*ShareURLPassword `json:",omitempty"`
+
+	Permissions    *ShareURLPermissions `json:",omitempty"`
+	ExpirationTime *int64               `json:",omitempty"`
+	MaxAccesses    *int                 `json:",omitempty"`
+}
+
+// ShareURLInfo holds the SRP parameters needed to authenticate to a share URL.
+type ShareURLInfo struct {
+	Version         int
+	Modulus         string
+	ServerEphemeral string
+	URLPasswordSalt string `json:"UrlPasswordSalt"`
+	SRPSession      string
+	Flags           ShareURLFlags
+}
+
+type ShareURLAuthReq struct {
+	ClientEphemeral string
+	ClientProof     string
+	SRPSession      string
+}
+
+// ShareURLToken is the content of a share URL, as seen by an anonymous user.
+// The share passphrase is encrypted with the share password, derived from the URL password.
+type ShareURLToken struct {
+	Token string
+
+	LinkID   string
+	LinkType LinkType
+	Name     string // The link name, encrypted with the share keyring
+	Size     int64
+	MIMEType string
+
+	NodeKey          string // The private NodeKey, encrypted with a passphrase
+	NodePassphrase   string // The NodeKey passphrase, encrypted with the share keyring
+	ContentKeyPacket string // The file's content key packet, encrypted with the NodeKey (files only)
+
+	ShareKey          string // The private ShareKey, encrypted with a passphrase
+	SharePassphrase   string // The ShareKey passphrase, encrypted with the share password
+	SharePasswordSalt string // Salt used to derive the share password from the URL password
+
+	CreatorEmail   string
+	Permissions    ShareURLPermissions
+	ExpirationTime int64
+}
+
+// GetShareKeyRing unlocks the share key with the URL password.
+func (t ShareURLToken) GetShareKeyRing(password []byte) (*crypto.KeyRing, error) {
+	salt, err := base64.StdEncoding.DecodeString(t.SharePasswordSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	sharePassword, err := saltShareURLPassword(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := crypto.NewPGPMessageFromArmored(t.SharePassphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := crypto.DecryptMessageWithPassword(enc, sharePassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt share passphrase: %w", err)
+	}
+
+	lockedKey, err := crypto.NewKeyFromArmored(t.ShareKey)
+	if err != nil {
+		return nil, err
+	}
+
+	unlockedKey, err := lockedKey.Unlock(dec.GetBinary())
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.NewKeyRing(unlockedKey)
+}
+
+// GetName decrypts the link name with the share keyring.
+// Anonymous users don't have the creator's public key so the name signature is not verified.
+func (t ShareURLToken) GetName(shareKR *crypto.KeyRing) (string, error) {
+	enc, err := crypto.NewPGPMessageFromArmored(t.Name)
+	if err != nil {
+		return "", err
+	}
+
+	dec, err := shareKR.Decrypt(enc, nil, 0)
+	if err != nil {
+		return "", err
+	}
+
+	return dec.GetString(), nil
+}
+
+// GetKeyRing unlocks the link's node key with the share keyring.
+func (t ShareURLToken) GetKeyRing(shareKR *crypto.KeyRing) (*crypto.KeyRing, error) {
+	enc, err := crypto.NewPGPMessageFromArmored(t.NodePassphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := shareKR.Decrypt(enc, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	lockedKey, err := crypto.NewKeyFromArmored(t.NodeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	unlockedKey, err := lockedKey.Unlock(dec.GetBinary())
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.NewKeyRing(unlockedKey)
+}
@@ -32,17 +32,7 @@ func (c *Client) DeleteUser(ctx context.Context, password []byte, req DeleteUser
 		return err
 	}
 
-	info, err := c.m.AuthInfo(ctx, AuthInfoReq{Username: user.Name})
-	if err != nil {
-		return err
-	}
-
-	srpAuth, err := srp.NewAuth(info.Version, user.Name, password, info.Salt, info.Modulus, info.ServerEphemeral)
-	if err != nil {
-		return err
-	}
-
-	proofs, err := srpAuth.GenerateProofs(2048)
+	authReq, err := c.newSRPProofs(ctx, user.Name, password)
 	if err != nil {
 		return err
 	}
@@ -53,11 +43,31 @@ func (c *Client) DeleteUser(ctx context.Context, password []byte, req DeleteUser
 			AuthReq
 		}{
 			DeleteUserReq: req,
-			AuthReq: AuthReq{
-				ClientProof:     base64.StdEncoding.EncodeToString(proofs.ClientProof),
-				ClientEphemeral: base64.StdEncoding.EncodeToString(proofs.ClientEphemeral),
-				SRPSession:      info.SRPSession,
-			},
+			AuthReq:       authReq,
 		}).Delete("/core/v4/users/delete")
 	})
 }
+
+// newSRPProofs returns the SRP proofs of the user's login password, with which the user is verified again.
+func (c *Client) newSRPProofs(ctx context.Context, username string, password []byte) (AuthReq, error) {
+	info, err := c.m.AuthInfo(ctx, AuthInfoReq{Username: username})
+	if err != nil {
+		return AuthReq{}, err
+	}
+
+	srpAuth, err := srp.NewAuth(info.Version, username, password, info.Salt, info.Modulus, info.ServerEphemeral)
+	if err != nil {
+		return AuthReq{}, err
+	}
+
+	proofs, err := srpAuth.GenerateProofs(2048)
+	if err != nil {
+		return AuthReq{}, err
+	}
+
+	return AuthReq{
+		ClientProof:     base64.StdEncoding.EncodeToString(proofs.ClientProof),
+		ClientEphemeral: base64.StdEncoding.EncodeToString(proofs.ClientEphemeral),
+		SRPSession:      info.SRPSession,
+	}, nil
+}
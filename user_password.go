@@ -0,0 +1,261 @@
+package proton
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/ProtonMail/go-srp"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/go-resty/resty/v2"
+)
+
+// ChangePassword changes the user's passwords, after verifying the user again with the current login password.
+// If the mailbox password changes, the user's keys and the legacy address keys, which are locked with it,
+// are locked again with a passphrase salted with a new salt, and the key lists of the addresses of legacy keys
+// are signed again. If the login password changes, a new SRP verifier is generated with a new salt.
+// All the changes are made with a single request, so either all of them or none of them are applied.
+func (c *Client) ChangePassword(ctx context.Context, change PasswordChange) error {
+	user, err := c.GetUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	mailboxPassword, newMailboxPassword := change.MailboxPassword, change.NewMailboxPassword
+
+	if change.Mode != TwoPasswordMode {
+		mailboxPassword, newMailboxPassword = change.LoginPassword, change.NewLoginPassword
+	}
+
+	var verifier *AuthVerifier
+
+	if len(change.NewLoginPassword) > 0 {
+		newVerifier, err := c.newAuthVerifier(ctx, change.NewLoginPassword)
+		if err != nil {
+			return err
+		}
+
+		verifier = &newVerifier
+	}
+
+	if len(newMailboxPassword) == 0 {
+		if verifier == nil {
+			return errors.New("no new password")
+		}
+
+		authReq, err := c.newSRPProofs(ctx, user.Name, change.LoginPassword)
+		if err != nil {
+			return err
+		}
+
+		return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+			return r.SetBody(struct {
+				SetPasswordReq
+				AuthReq
+			}{
+				SetPasswordReq: SetPasswordReq{Auth: *verifier},
+				AuthReq:        authReq,
+			}).Put("/core/v4/settings/password")
+		})
+	}
+
+	req, err := c.newUpdatePrivateKeysReq(ctx, user, mailboxPassword, newMailboxPassword)
+	if err != nil {
+		return err
+	}
+
+	req.Auth = verifier
+
+	authReq, err := c.newSRPProofs(ctx, user.Name, change.LoginPassword)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetBody(struct {
+			UpdatePrivateKeysReq
+			AuthReq
+		}{
+			UpdatePrivateKeysReq: req,
+			AuthReq:              authReq,
+		}).Put("/core/v4/keys/private")
+	})
+}
+
+// newUpdatePrivateKeysReq locks the user's keys and the legacy address keys, unlocked with the mailbox password,
+// with the new mailbox password salted with a new salt.
+func (c *Client) newUpdatePrivateKeysReq(ctx context.Context, user User, mailboxPassword, newMailboxPassword []byte) (UpdatePrivateKeysReq, error) {
+	salts, err := c.GetSalts(ctx)
+	if err != nil {
+		return UpdatePrivateKeysReq{}, err
+	}
+
+	addresses, err := c.GetAddresses(ctx)
+	if err != nil {
+		return UpdatePrivateKeysReq{}, err
+	}
+
+	keySalt, err := crypto.RandomToken(16)
+	if err != nil {
+		return UpdatePrivateKeysReq{}, err
+	}
+
+	newPassphrase, err := saltKeyPass(newMailboxPassword, keySalt)
+	if err != nil {
+		return UpdatePrivateKeysReq{}, err
+	}
+
+	req := UpdatePrivateKeysReq{
+		KeySalt:        base64.StdEncoding.EncodeToString(keySalt),
+		SignedKeyLists: make(map[string]KeyList),
+	}
+
+	userKR, err := crypto.NewKeyRing(nil)
+	if err != nil {
+		return UpdatePrivateKeysReq{}, err
+	}
+	defer userKR.ClearPrivateParams()
+
+	for _, key := range user.Keys {
+		passphrase, err := salts.SaltForKey(mailboxPassword, key.ID)
+		if err != nil {
+			return UpdatePrivateKeysReq{}, err
+		}
+
+		unlocked, err := key.unlock(passphrase)
+		if err != nil {
+			return UpdatePrivateKeysReq{}, fmt.Errorf("failed to unlock user key %v: %w", key.ID, err)
+		}
+
+		if err := userKR.AddKey(unlocked); err != nil {
+			return UpdatePrivateKeysReq{}, err
+		}
+
+		keyReq, err := newUpdatePrivateKeyReq(key.ID, unlocked, newPassphrase)
+		if err != nil {
+			return UpdatePrivateKeysReq{}, err
+		}
+
+		req.Keys = append(req.Keys, keyReq)
+	}
+
+	// Legacy address keys are locked with the passphrase of the primary user key.
+	passphrase, err := salts.SaltForKey(mailboxPassword, user.Keys.Primary().ID)
+	if err != nil {
+		return UpdatePrivateKeysReq{}, err
+	}
+
+	// All the unlocked address keys are kept here so that they are cleared, whether they sign a key list or not.
+	addrKR, err := crypto.NewKeyRing(nil)
+	if err != nil {
+		return UpdatePrivateKeysReq{}, err
+	}
+	defer addrKR.ClearPrivateParams()
+
+	for _, addr := range addresses {
+		if !xslices.Any(addr.Keys, isLegacyAddressKey) {
+			continue
+		}
+
+		var (
+			entries  []KeyListEntry
+			signerKR *crypto.KeyRing
+		)
+
+		for _, key := range addr.Keys {
+			unlocked, err := key.Unlock(passphrase, userKR)
+			if err != nil {
+				return UpdatePrivateKeysReq{}, fmt.Errorf("failed to unlock address key %v: %w", key.ID, err)
+			}
+
+			if err := addrKR.AddKey(unlocked); err != nil {
+				return UpdatePrivateKeysReq{}, err
+			}
+
+			if isLegacyAddressKey(key) {
+				keyReq, err := newUpdatePrivateKeyReq(key.ID, unlocked, newPassphrase)
+				if err != nil {
+					return UpdatePrivateKeysReq{}, err
+				}
+
+				req.Keys = append(req.Keys, keyReq)
+			}
+
+			entries = append(entries, KeyListEntry{
+				Fingerprint:        unlocked.GetFingerprint(),
+				SHA256Fingerprints: unlocked.GetSHA256Fingerprints(),
+				Flags:              key.Flags,
+				Primary:            key.Primary,
+			})
+
+			if key.Primary {
+				if signerKR, err = crypto.NewKeyRing(unlocked); err != nil {
+					return UpdatePrivateKeysReq{}, err
+				}
+			}
+		}
+
+		if signerKR == nil {
+			return UpdatePrivateKeysReq{}, fmt.Errorf("address %v has no primary key", addr.ID)
+		}
+
+		if req.SignedKeyLists[addr.ID], err = NewKeyList(signerKR, entries); err != nil {
+			return UpdatePrivateKeysReq{}, err
+		}
+	}
+
+	return req, nil
+}
+
+// newAuthVerifier generates the SRP verifier of the password, with a new salt.
+func (c *Client) newAuthVerifier(ctx context.Context, password []byte) (AuthVerifier, error) {
+	modulus, err := c.m.AuthModulus(ctx)
+	if err != nil {
+		return AuthVerifier{}, err
+	}
+
+	salt, err := crypto.RandomToken(10)
+	if err != nil {
+		return AuthVerifier{}, err
+	}
+
+	srpAuth, err := srp.NewAuthForVerifier(password, modulus.Modulus, salt)
+	if err != nil {
+		return AuthVerifier{}, err
+	}
+
+	verifier, err := srpAuth.GenerateVerifier(2048)
+	if err != nil {
+		return AuthVerifier{}, err
+	}
+
+	return AuthVerifier{
+		Version:   4,
+		ModulusID: modulus.ModulusID,
+		Salt:      base64.StdEncoding.EncodeToString(salt),
+		Verifier:  base64.StdEncoding.EncodeToString(verifier),
+	}, nil
+}
+
+func newUpdatePrivateKeyReq(keyID string, key *crypto.Key, passphrase []byte) (UpdatePrivateKeyReq, error) {
+	locked, err := key.Lock(passphrase)
+	if err != nil {
+		return UpdatePrivateKeyReq{}, err
+	}
+
+	arm, err := locked.Armor()
+	if err != nil {
+		return UpdatePrivateKeyReq{}, err
+	}
+
+	return UpdatePrivateKeyReq{
+		ID:         keyID,
+		PrivateKey: arm,
+	}, nil
+}
+
+func isLegacyAddressKey(key Key) bool {
+	return key.Token == "" || key.Signature == ""
+}
@@ -30,3 +30,23 @@ type ProductUsedSpace struct {
 	Mail     uint64
 	Pass     uint64
 }
+
+// PasswordChange describes a change of the user's passwords.
+type PasswordChange struct {
+	// Mode is the user's password mode, as returned on login.
+	Mode PasswordMode
+
+	// LoginPassword is the current login password, with which the user is verified again.
+	LoginPassword []byte
+
+	// MailboxPassword is the current mailbox password, which unlocks the user's keys in two-password mode.
+	// In one-password mode, the keys are unlocked with the login password.
+	MailboxPassword []byte
+
+	// NewLoginPassword is the new login password. In one-password mode, it is also the new mailbox password.
+	// In two-password mode, the login password is left unchanged if it is empty.
+	NewLoginPassword []byte
+
+	// NewMailboxPassword is the new mailbox password in two-password mode; it is left unchanged if it is empty.
+	NewMailboxPassword []byte
+}
@@ -33,3 +33,17 @@ func (c *Client) GetVolume(ctx context.Context, volumeID string) (Volume, error)
 
 	return res.Volume, nil
 }
+
+func (c *Client) CreateVolume(ctx context.Context, req CreateVolumeReq) (Volume, error) {
+	var res struct {
+		Volume Volume
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).SetBody(req).Post("/drive/volumes")
+	}); err != nil {
+		return Volume{}, err
+	}
+
+	return res.Volume, nil
+}
@@ -1,5 +1,7 @@
 package proton
 
+import "github.com/ProtonMail/gopenpgp/v2/crypto"
+
 // Volume is a Proton Drive volume.
 type Volume struct {
 	VolumeID string // Encrypted volume ID
@@ -38,3 +40,55 @@ const (
 	RestoreStatusInProgress VolumeRestoreStatus = 1
 	RestoreStatusFailed     VolumeRestoreStatus = -1
 )
+
+type CreateVolumeReq struct {
+	AddressID string
+
+	ShareKey                 string // The private ShareKey of the volume's main share, encrypted with a passphrase
+	SharePassphrase          string // The share passphrase, encrypted with the address keyring
+	SharePassphraseSignature string // The signature of the share passphrase, signed with the address keyring
+
+	FolderName                string // The name of the root folder, encrypted with the share keyring
+	FolderKey                 string // The private NodeKey of the root folder, encrypted with a passphrase
+	FolderPassphrase          string // The root folder passphrase, encrypted with the share keyring
+	FolderPassphraseSignature string // The signature of the root folder passphrase, signed with the address keyring
+	FolderHashKey             string // The HMAC key used to hash the root folder's children names
+}
+
+// NewCreateVolumeReq generates the keys of a new volume's main share and root folder.
+// The share key is encrypted and signed with the given address keyring.
+func NewCreateVolumeReq(addrID string, addrKR *crypto.KeyRing) (CreateVolumeReq, error) {
+	shareKeys, err := generateNodeKeys(addrKR, addrKR)
+	if err != nil {
+		return CreateVolumeReq{}, err
+	}
+
+	folderKeys, err := generateNodeKeys(shareKeys.kr, addrKR)
+	if err != nil {
+		return CreateVolumeReq{}, err
+	}
+
+	folderName, err := encryptLinkName("root", shareKeys.kr, addrKR)
+	if err != nil {
+		return CreateVolumeReq{}, err
+	}
+
+	folderHashKey, err := generateHashKey(folderKeys.kr)
+	if err != nil {
+		return CreateVolumeReq{}, err
+	}
+
+	return CreateVolumeReq{
+		AddressID: addrID,
+
+		ShareKey:                 shareKeys.Key,
+		SharePassphrase:          shareKeys.Passphrase,
+		SharePassphraseSignature: shareKeys.PassphraseSignature,
+
+		FolderName:                folderName,
+		FolderKey:                 folderKeys.Key,
+		FolderPassphrase:          folderKeys.Passphrase,
+		FolderPassphraseSignature: folderKeys.PassphraseSignature,
+		FolderHashKey:             folderHashKey,
+	}, nil
+}
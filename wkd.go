@@ -0,0 +1,287 @@
+package proton
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/bradenaw/juniper/xslices"
+)
+
+// wkdMaxResponseSize is the maximum size in bytes of the keys served by a web key directory for an address.
+const wkdMaxResponseSize = 1024 * 1024
+
+// wkdUnreachableTTL is how long lookups are cached when the web key directory of a domain can't be reached.
+const wkdUnreachableTTL = 5 * time.Minute
+
+// errWKDUnreachable is returned by lookups when neither method can reach the web key directory.
+var errWKDUnreachable = errors.New("web key directory unreachable")
+
+// zbase32Encoding is the z-base-32 encoding used to hash the local part of addresses in web key directories.
+var zbase32Encoding = base32.NewEncoding("ybndrfg8ejkmcpqxot1uwisza345h769").WithPadding(base32.NoPadding)
+
+// WKDClient looks the OpenPGP keys of addresses up in the web key directory (WKD) of their domain.
+// The advanced method, served by the openpgpkey subdomain, is tried first; the direct method,
+// served by the domain itself, is used if the subdomain can't be reached.
+// Lookups, including those which found no key, are cached; if neither method can reach the domain,
+// the address is considered to have no key and this is only cached for a short time.
+type WKDClient struct {
+	client *http.Client
+	policy WKDPolicy
+
+	// ttl is how long lookups are cached.
+	ttl time.Duration
+
+	// cache holds the lookups, keyed by normalized address.
+	cache     map[string]wkdCacheEntry
+	cacheLock sync.Mutex
+}
+
+type wkdCacheEntry struct {
+	keys    []*crypto.Key
+	expires time.Time
+}
+
+// NewWKDClient returns a client which makes its requests through the transport, or the default transport if nil.
+// Keys are only looked up in the domains allowed by the policy, and lookups are cached for ttl.
+func NewWKDClient(transport http.RoundTripper, policy WKDPolicy, ttl time.Duration) *WKDClient {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &WKDClient{
+		client: &http.Client{Transport: transport},
+		policy: policy,
+		ttl:    ttl,
+		cache:  make(map[string]wkdCacheEntry),
+	}
+}
+
+// GetKeys returns the public keys of the address which can be used for encryption.
+// It returns ErrNoWKDKey if the directory has none or can't be reached, and ErrWKDDomainNotAllowed if the policy
+// doesn't allow the domain.
+func (w *WKDClient) GetKeys(ctx context.Context, address string) ([]*crypto.Key, error) {
+	localPart, domain, ok := strings.Cut(address, "@")
+	if !ok || localPart == "" || domain == "" {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
+
+	if !w.policy.Allows(domain) {
+		return nil, ErrWKDDomainNotAllowed
+	}
+
+	if keys, ok := w.getCached(address); ok {
+		if len(keys) == 0 {
+			return nil, ErrNoWKDKey
+		}
+
+		return keys, nil
+	}
+
+	keys, err := w.lookup(ctx, localPart, strings.ToLower(domain))
+	if errors.Is(err, errWKDUnreachable) {
+		w.setCached(address, nil, min(w.ttl, wkdUnreachableTTL))
+
+		return nil, ErrNoWKDKey
+	} else if err != nil && !errors.Is(err, ErrNoWKDKey) {
+		return nil, err
+	}
+
+	keys = xslices.Filter(keys, func(key *crypto.Key) bool {
+		return !key.IsPrivate() && isSendKey(key) && hasKeyIdentity(key, address)
+	})
+
+	w.setCached(address, keys, w.ttl)
+
+	if len(keys) == 0 {
+		return nil, ErrNoWKDKey
+	}
+
+	return keys, nil
+}
+
+// GetPublicKeys returns the public keys of the address like GetKeys, in the form returned by the API.
+func (w *WKDClient) GetPublicKeys(ctx context.Context, address string) (PublicKeys, error) {
+	keys, err := w.GetKeys(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var pubKeys PublicKeys
+
+	for _, key := range keys {
+		armKey, err := key.GetArmoredPublicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		pubKeys = append(pubKeys, PublicKey{
+			Flags:     KeyStateTrusted | KeyStateActive,
+			PublicKey: armKey,
+		})
+	}
+
+	return pubKeys, nil
+}
+
+// ClearCache forgets all the cached lookups.
+func (w *WKDClient) ClearCache() {
+	w.cacheLock.Lock()
+	defer w.cacheLock.Unlock()
+
+	w.cache = make(map[string]wkdCacheEntry)
+}
+
+// GetPublicKeysWithWKD returns the keys of the address like GetPublicKeys. If the address is external and the API
+// returns no key for it, its keys are looked up in the web key directory of its domain, if the policy allows it.
+func (c *Client) GetPublicKeysWithWKD(ctx context.Context, wkd *WKDClient, address string) (PublicKeys, RecipientType, error) {
+	pubKeys, recipientType, err := c.GetPublicKeys(ctx, address)
+	if err != nil {
+		return nil, recipientType, err
+	}
+
+	if recipientType != RecipientTypeExternal || len(pubKeys) > 0 {
+		return pubKeys, recipientType, nil
+	}
+
+	if pubKeys, err = wkd.GetPublicKeys(ctx, address); err != nil {
+		if errors.Is(err, ErrNoWKDKey) || errors.Is(err, ErrWKDDomainNotAllowed) {
+			return nil, RecipientTypeExternal, nil
+		}
+
+		return nil, RecipientTypeExternal, err
+	}
+
+	return pubKeys, RecipientTypeExternal, nil
+}
+
+// WKDHash returns the hash identifying the local part of an address in a web key directory:
+// the z-base-32 encoded SHA-1 digest of the local part mapped to lowercase.
+func WKDHash(localPart string) string {
+	digest := sha1.Sum([]byte(strings.Map(func(r rune) rune { //nolint:gosec
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+
+		return r
+	}, localPart)))
+
+	return zbase32Encoding.EncodeToString(digest[:])
+}
+
+// lookup fetches the keys of the address with the advanced method, or with the direct method
+// if the openpgpkey subdomain can't be reached. It returns errWKDUnreachable if the domain can't be reached either.
+func (w *WKDClient) lookup(ctx context.Context, localPart, domain string) ([]*crypto.Key, error) {
+	query := url.Values{"l": {localPart}}.Encode()
+
+	keys, err := w.fetch(ctx, fmt.Sprintf("https://openpgpkey.%v/.well-known/openpgpkey/%v/hu/%v?%v", domain, domain, WKDHash(localPart), query))
+
+	var urlErr *url.Error
+
+	if !errors.As(err, &urlErr) {
+		return keys, err
+	}
+
+	keys, err = w.fetch(ctx, fmt.Sprintf("https://%v/.well-known/openpgpkey/hu/%v?%v", domain, WKDHash(localPart), query))
+	if !errors.As(err, &urlErr) {
+		return keys, err
+	}
+
+	// A cancelled lookup says nothing about the directory.
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	return nil, fmt.Errorf("%w: %v", errWKDUnreachable, err)
+}
+
+func (w *WKDClient) fetch(ctx context.Context, rawURL string) ([]*crypto.Key, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		// The keys are read below.
+
+	case http.StatusNotFound:
+		return nil, ErrNoWKDKey
+
+	default:
+		return nil, fmt.Errorf("unexpected web key directory response: %v", res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, wkdMaxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > wkdMaxResponseSize {
+		return nil, fmt.Errorf("web key directory response exceeds %v bytes", wkdMaxResponseSize)
+	}
+
+	var entities openpgp.EntityList
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read web key directory keys: %w", err)
+	}
+
+	var keys []*crypto.Key
+
+	for _, entity := range entities {
+		key, err := crypto.NewKeyFromEntity(entity)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (w *WKDClient) getCached(address string) ([]*crypto.Key, bool) {
+	w.cacheLock.Lock()
+	defer w.cacheLock.Unlock()
+
+	entry, ok := w.cache[normalizeContactEmail(address)]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.keys, true
+}
+
+func (w *WKDClient) setCached(address string, keys []*crypto.Key, ttl time.Duration) {
+	w.cacheLock.Lock()
+	defer w.cacheLock.Unlock()
+
+	w.cache[normalizeContactEmail(address)] = wkdCacheEntry{
+		keys:    keys,
+		expires: time.Now().Add(ttl),
+	}
+}
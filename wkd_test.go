@@ -0,0 +1,96 @@
+package proton_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWKDHash(t *testing.T) {
+	// The example of the web key directory specification.
+	require.Equal(t, "iy9q119eutrkn8s1mk4r39qejnbu3n5q", proton.WKDHash("Joe.Doe"))
+	require.Equal(t, proton.WKDHash("joe.doe"), proton.WKDHash("Joe.Doe"))
+}
+
+func TestWKDPolicy_Allows(t *testing.T) {
+	policy := proton.WKDPolicy{
+		AllowedDomains: []string{"example.com", "example.org"},
+		BlockedDomains: []string{"blocked.example.com"},
+	}
+
+	require.True(t, policy.Allows("example.com"))
+	require.True(t, policy.Allows("Mail.Example.com"))
+	require.True(t, policy.Allows("example.org"))
+	require.False(t, policy.Allows("blocked.example.com"))
+	require.False(t, policy.Allows("sub.blocked.example.com"))
+	require.False(t, policy.Allows("example.net"))
+	require.False(t, policy.Allows("notexample.com"))
+
+	require.True(t, proton.WKDPolicy{}.Allows("example.net"))
+}
+
+func TestWKDClient_GetKeys_TooLarge(t *testing.T) {
+	// The directory serves an endless response.
+	wkd := proton.NewWKDClient(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     http.StatusText(http.StatusOK),
+			Body:       io.NopCloser(io.MultiReader(bytes.NewReader([]byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n")), endlessReader{})),
+			Request:    req,
+		}, nil
+	}), proton.WKDPolicy{}, time.Hour)
+
+	_, err := wkd.GetKeys(context.Background(), "other@example.com")
+	require.ErrorContains(t, err, "exceeds")
+}
+
+func TestWKDClient_GetKeys_Unreachable(t *testing.T) {
+	var calls int32
+
+	// Neither the openpgpkey subdomain nor the domain itself can be reached.
+	wkd := proton.NewWKDClient(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+
+		return nil, errors.New("connection refused")
+	}), proton.WKDPolicy{}, time.Hour)
+
+	_, err := wkd.GetKeys(context.Background(), "other@example.com")
+	require.ErrorIs(t, err, proton.ErrNoWKDKey)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// The miss is cached.
+	_, err = wkd.GetKeys(context.Background(), "other@example.com")
+	require.ErrorIs(t, err, proton.ErrNoWKDKey)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Cancelled lookups aren't misses.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = wkd.GetKeys(ctx, "unknown@example.com")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'A'
+	}
+
+	return len(p), nil
+}
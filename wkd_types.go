@@ -0,0 +1,48 @@
+package proton
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNoWKDKey            = errors.New("no key in the web key directory")
+	ErrWKDDomainNotAllowed = errors.New("domain not allowed by the web key directory policy")
+)
+
+// WKDPolicy decides in which domains the keys of addresses are looked up.
+// A domain matches an entry if it is the entry itself or one of its subdomains.
+type WKDPolicy struct {
+	// AllowedDomains, if not empty, are the only domains in which keys are looked up.
+	AllowedDomains []string
+
+	// BlockedDomains are domains in which keys are never looked up.
+	BlockedDomains []string
+}
+
+// Allows returns whether keys can be looked up in the domain.
+func (policy WKDPolicy) Allows(domain string) bool {
+	for _, blocked := range policy.BlockedDomains {
+		if isWKDSubdomain(domain, blocked) {
+			return false
+		}
+	}
+
+	if len(policy.AllowedDomains) == 0 {
+		return true
+	}
+
+	for _, allowed := range policy.AllowedDomains {
+		if isWKDSubdomain(domain, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isWKDSubdomain(domain, parent string) bool {
+	domain, parent = strings.ToLower(strings.TrimSuffix(domain, ".")), strings.ToLower(strings.TrimSuffix(parent, "."))
+
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}